@@ -0,0 +1,13 @@
+package alerting
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAlerting(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alerting Suite")
+}
@@ -0,0 +1,167 @@
+// Package alerting checks the samples stored during collection against the
+// KPI thresholds and raises an alert as soon as a series crosses one. Alerts
+// resolve with hysteresis, so a value flapping around a threshold raises a
+// single alert instead of one per sample.
+package alerting
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultHysteresis is the default percentage of the threshold a value must
+// clear before a firing alert resolves
+const DefaultHysteresis = 5.0
+
+// Monitor tracks the alert state of every series and threshold. It is safe
+// for concurrent use by the query workers.
+type Monitor struct {
+	thresholds map[string]*config.Thresholds // by KPI ID
+	hysteresis float64                       // fraction of the threshold
+
+	mu     sync.Mutex
+	firing map[string]bool // by series and threshold
+	// checked is the time of the newest sample checked, by series and
+	// threshold, so that the samples range queries return again on every
+	// tick are only checked once
+	checked map[string]model.Time
+}
+
+// NewMonitor returns a monitor for the thresholds of the KPIs, or nil when no
+// KPI has thresholds. hysteresis is a percentage of the threshold, e.g. 5.
+func NewMonitor(kpis config.KPIs, hysteresis float64) *Monitor {
+	thresholds := make(map[string]*config.Thresholds)
+	for _, kpi := range kpis.Queries {
+		if kpi.Thresholds != nil {
+			thresholds[kpi.ID] = kpi.Thresholds
+		}
+	}
+	if len(thresholds) == 0 {
+		return nil
+	}
+
+	return &Monitor{
+		thresholds: thresholds,
+		hysteresis: hysteresis / 100,
+		firing:     make(map[string]bool),
+		checked:    make(map[string]model.Time),
+	}
+}
+
+// Check returns the alerts raised or resolved by the samples of a query
+// result, in sample order. Samples no newer than those already checked for a
+// series are skipped. Only the thresholds every sample must meet are
+// checked live: an upper bound of the max and a lower bound of the min.
+// Thresholds on other statistics are left to 'kpi-collector evaluate'.
+func (m *Monitor) Check(kpiID string, result model.Value) []database.AlertEvent {
+	thresholds, ok := m.thresholds[kpiID]
+	if !ok {
+		return nil
+	}
+
+	var events []database.AlertEvent
+	check := func(metric model.Metric, pairs ...model.SamplePair) {
+		labels := make(map[string]string, len(metric))
+		for name, value := range metric {
+			labels[string(name)] = string(value)
+		}
+
+		bounds, err := thresholds.BoundsFor(labels)
+		if err != nil {
+			// Thresholds are validated when the KPIs file is loaded
+			log.Printf("[%s] Skipped threshold alerts: %v", kpiID, err)
+			return
+		}
+
+		for _, bound := range bounds {
+			if !sampleBound(bound) {
+				continue
+			}
+			key := seriesKey(kpiID, metric, bound)
+			for _, pair := range pairs {
+				state, changed := m.transition(key, bound, pair)
+				if !changed {
+					continue
+				}
+				events = append(events, database.AlertEvent{
+					KPIID:      kpiID,
+					Labels:     labels,
+					Threshold:  bound.String(),
+					Limit:      bound.Value,
+					Value:      float64(pair.Value),
+					SampleTime: pair.Timestamp.Time(),
+					State:      state,
+				})
+			}
+		}
+	}
+
+	switch v := result.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			check(sample.Metric, model.SamplePair{Timestamp: sample.Timestamp, Value: sample.Value})
+		}
+	case model.Matrix:
+		for _, stream := range v {
+			check(stream.Metric, stream.Values...)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].SampleTime.Before(events[j].SampleTime)
+	})
+	return events
+}
+
+// transition updates the alert state of a series threshold with a sample,
+// and returns the new state when it changed. A sample no newer than the last
+// one checked changes nothing.
+func (m *Monitor) transition(key string, bound config.ThresholdBound, pair model.SamplePair) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.checked[key]; ok && !pair.Timestamp.After(last) {
+		return "", false
+	}
+	m.checked[key] = pair.Timestamp
+	value := float64(pair.Value)
+
+	if !m.firing[key] {
+		if bound.Passes(value) {
+			return "", false
+		}
+		m.firing[key] = true
+		return database.AlertFiring, true
+	}
+
+	// A firing alert only resolves once the value clears the threshold by
+	// the hysteresis margin
+	margin := math.Abs(bound.Value) * m.hysteresis
+	cleared := value >= bound.Value+margin
+	if bound.Upper {
+		cleared = value <= bound.Value-margin
+	}
+	if !cleared {
+		return "", false
+	}
+	delete(m.firing, key)
+	return database.AlertResolved, true
+}
+
+// sampleBound reports whether every sample must meet the bound
+func sampleBound(bound config.ThresholdBound) bool {
+	return (bound.Statistic == "max" && bound.Upper) || (bound.Statistic == "min" && !bound.Upper)
+}
+
+// seriesKey identifies a threshold of a series
+func seriesKey(kpiID string, metric model.Metric, bound config.ThresholdBound) string {
+	return strings.Join([]string{kpiID, metric.String(), bound.String()}, "\x00")
+}
@@ -0,0 +1,159 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+var _ = Describe("Monitor", func() {
+	var monitor *Monitor
+
+	metric := model.Metric{"__name__": "cpu", "cpu": "0"}
+	sample := func(value float64, second int64) *model.Sample {
+		return &model.Sample{Metric: metric, Value: model.SampleValue(value), Timestamp: model.TimeFromUnix(second)}
+	}
+	states := func(events []database.AlertEvent) []string {
+		var result []string
+		for _, event := range events {
+			result = append(result, event.State)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		monitor = NewMonitor(config.KPIs{Queries: []config.Query{
+			{ID: "cpu", Thresholds: &config.Thresholds{
+				Limits: map[string]float64{"max": 0.8, "min": 0.1, "avg-max": 0.2},
+			}},
+			{ID: "no-thresholds"},
+		}}, DefaultHysteresis)
+	})
+
+	It("should not monitor KPIs without thresholds", func() {
+		Expect(NewMonitor(config.KPIs{Queries: []config.Query{{ID: "cpu"}}}, DefaultHysteresis)).To(BeNil())
+		Expect(monitor.Check("no-thresholds", model.Vector{sample(5, 1)})).To(BeEmpty())
+	})
+
+	It("should fire once while a value flaps around the threshold, then resolve", func() {
+		events := monitor.Check("cpu", model.Vector{sample(0.9, 1)})
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(Equal(database.AlertEvent{
+			KPIID:      "cpu",
+			Labels:     map[string]string{"__name__": "cpu", "cpu": "0"},
+			Threshold:  "max <= 0.8",
+			Limit:      0.8,
+			Value:      0.9,
+			SampleTime: time.Unix(1, 0),
+			State:      database.AlertFiring,
+		}))
+
+		// Within 5% of the threshold, the alert keeps firing
+		Expect(monitor.Check("cpu", model.Vector{sample(0.78, 2)})).To(BeEmpty())
+		Expect(monitor.Check("cpu", model.Vector{sample(0.85, 3)})).To(BeEmpty())
+
+		events = monitor.Check("cpu", model.Vector{sample(0.5, 4)})
+		Expect(states(events)).To(Equal([]string{database.AlertResolved}))
+		Expect(events[0].Value).To(Equal(0.5))
+	})
+
+	It("should check lower bounds and every sample of a range result, in sample order", func() {
+		events := monitor.Check("cpu", model.Matrix{&model.SampleStream{
+			Metric: metric,
+			Values: []model.SamplePair{
+				{Timestamp: model.TimeFromUnix(1), Value: 0.05},
+				{Timestamp: model.TimeFromUnix(2), Value: 0.9},
+				{Timestamp: model.TimeFromUnix(3), Value: 0.3},
+			},
+		}})
+		Expect(events).To(HaveLen(4))
+		describe := func(event database.AlertEvent) string {
+			return event.Threshold + " " + event.State
+		}
+		Expect(describe(events[0])).To(Equal("min >= 0.1 firing"))
+		Expect(describe(events[1])).To(Equal("max <= 0.8 firing"))
+		Expect(describe(events[2])).To(Equal("min >= 0.1 resolved"))
+		Expect(describe(events[3])).To(Equal("max <= 0.8 resolved"))
+		Expect(events[3].SampleTime).To(Equal(time.Unix(3, 0)))
+	})
+
+	It("should only check the samples of overlapping range results once", func() {
+		window := func(seconds ...int64) model.Matrix {
+			stream := &model.SampleStream{Metric: metric}
+			for _, second := range seconds {
+				value := model.SampleValue(0.5)
+				if second == 2 {
+					value = 0.9
+				}
+				stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.TimeFromUnix(second), Value: value})
+			}
+			return model.Matrix{stream}
+		}
+
+		Expect(states(monitor.Check("cpu", window(1, 2, 3)))).To(Equal([]string{database.AlertFiring, database.AlertResolved}))
+		Expect(monitor.Check("cpu", window(1, 2, 3))).To(BeEmpty())
+
+		// Only the newer sample of the next window is checked
+		Expect(monitor.Check("cpu", window(2, 3, 4))).To(BeEmpty())
+		Expect(states(monitor.Check("cpu", model.Vector{sample(0.9, 5)}))).To(Equal([]string{database.AlertFiring}))
+	})
+
+	It("should track each series separately", func() {
+		other := &model.Sample{Metric: model.Metric{"cpu": "1"}, Value: 0.9, Timestamp: model.TimeFromUnix(1)}
+		Expect(monitor.Check("cpu", model.Vector{sample(0.9, 1), other})).To(HaveLen(2))
+		Expect(monitor.Check("cpu", model.Vector{sample(0.5, 2)})).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Webhook", func() {
+	event := database.AlertEvent{
+		KPIID:      "cpu",
+		Labels:     map[string]string{"cpu": "0"},
+		Threshold:  "max <= 0.8",
+		Limit:      0.8,
+		Value:      0.9,
+		SampleTime: time.Unix(1700000000, 0),
+		State:      database.AlertFiring,
+	}
+
+	It("should post the alert as JSON", func() {
+		var payload map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal(http.MethodPost))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(r.Body).Decode(&payload)).To(Succeed())
+		}))
+		defer server.Close()
+
+		Expect(NewWebhook(server.URL, "prod").Send(7, event)).To(Succeed())
+		Expect(payload).To(Equal(map[string]interface{}{
+			"cluster":     "prod",
+			"run_id":      7.0,
+			"kpi_id":      "cpu",
+			"labels":      map[string]interface{}{"cpu": "0"},
+			"threshold":   "max <= 0.8",
+			"limit":       0.8,
+			"value":       0.9,
+			"state":       "firing",
+			"sample_time": "2023-11-14T22:13:20Z",
+		}))
+	})
+
+	It("should fail on a non-2xx response", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		Expect(NewWebhook(server.URL, "prod").Send(7, event)).To(MatchError("alert webhook returned HTTP 503"))
+	})
+})
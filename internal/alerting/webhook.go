@@ -0,0 +1,73 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+// webhookTimeout bounds each webhook POST, so an unreachable endpoint cannot
+// hold up collection
+const webhookTimeout = 5 * time.Second
+
+// Webhook posts alerts as JSON to a URL
+type Webhook struct {
+	url         string
+	clusterName string
+	client      *http.Client
+}
+
+// webhookPayload is the JSON body posted for an alert
+type webhookPayload struct {
+	Cluster    string            `json:"cluster"`
+	RunID      int64             `json:"run_id,omitempty"`
+	KPIID      string            `json:"kpi_id"`
+	Labels     map[string]string `json:"labels"`
+	Threshold  string            `json:"threshold"`
+	Limit      float64           `json:"limit"`
+	Value      float64           `json:"value"`
+	State      string            `json:"state"`
+	SampleTime time.Time         `json:"sample_time"`
+}
+
+// NewWebhook returns a webhook posting the alerts of a cluster to url
+func NewWebhook(url, clusterName string) *Webhook {
+	return &Webhook{
+		url:         url,
+		clusterName: clusterName,
+		client:      &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Send posts the alert raised during the collection run runID
+func (w *Webhook) Send(runID int64, event database.AlertEvent) error {
+	body, err := json.Marshal(webhookPayload{
+		Cluster:    w.clusterName,
+		RunID:      runID,
+		KPIID:      event.KPIID,
+		Labels:     event.Labels,
+		Threshold:  event.Threshold,
+		Limit:      event.Limit,
+		Value:      event.Value,
+		State:      event.State,
+		SampleTime: event.SampleTime.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post alert: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package archive reads and writes the portable archives of collected data
+// made by 'kpi-collector db export'. An archive is a gzipped tar holding one
+// JSON Lines file per kind of record and a manifest.json that versions the
+// format and lists the files with their record counts and SHA-256 checksums.
+package archive
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// FormatName identifies kpi-collector archives in their manifest
+	FormatName = "kpi-collector-archive"
+	// FormatVersion is the version of the archive format written by this
+	// version of the tool; archives of a newer format cannot be imported
+	FormatVersion = 1
+)
+
+// Files of an archive
+const (
+	ManifestFile    = "manifest.json"
+	ClustersFile    = "clusters.jsonl"
+	RunsFile        = "runs.jsonl"
+	DefinitionsFile = "definitions.jsonl"
+	SamplesFile     = "samples.jsonl"
+)
+
+// DataFiles are the record files of an archive, in import order
+var DataFiles = []string{ClustersFile, RunsFile, DefinitionsFile, SamplesFile}
+
+// Manifest describes an archive
+type Manifest struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	ToolVersion   string    `json:"tool_version"`
+	SchemaVersion int       `json:"schema_version"` // of the exporting database
+	Filter        Filter    `json:"filter"`
+	Files         []File    `json:"files"`
+}
+
+// Filter records the selection of the exported samples
+type Filter struct {
+	ClusterName string     `json:"cluster_name,omitempty"` // empty for every cluster
+	Since       *time.Time `json:"since,omitempty"`
+	Until       *time.Time `json:"until,omitempty"`
+}
+
+// File describes a record file of an archive
+type File struct {
+	Name    string `json:"name"`
+	Records int64  `json:"records"`
+	SHA256  string `json:"sha256"` // of the uncompressed file
+}
+
+// file returns the description of the named file
+func (m Manifest) file(name string) (File, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return File{}, false
+}
+
+// validate checks that the manifest describes an archive this version of
+// the tool can read, with every data file
+func (m Manifest) validate() error {
+	if m.Format != FormatName {
+		return fmt.Errorf("not a kpi-collector archive (format %q)", m.Format)
+	}
+	if m.Version < 1 || m.Version > FormatVersion {
+		return fmt.Errorf("unsupported archive format version %d (this version of kpi-collector reads up to %d)",
+			m.Version, FormatVersion)
+	}
+	for _, name := range DataFiles {
+		if _, ok := m.file(name); !ok {
+			return fmt.Errorf("archive manifest does not list %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type record struct {
+	Name string `json:"name"`
+}
+
+// writeTar writes a gzipped tar with the entries, in order
+func writeTar(path string, entries [][2]string) {
+	file, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() { _ = file.Close() }()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		Expect(tw.WriteHeader(&tar.Header{Name: entry[0], Mode: 0644, Size: int64(len(entry[1]))})).To(Succeed())
+		_, err := tw.Write([]byte(entry[1]))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+}
+
+// readEntries returns the entries of a gzipped tar, in order
+func readEntries(path string) [][2]string {
+	file, err := os.Open(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() { _ = file.Close() }()
+
+	gz, err := gzip.NewReader(file)
+	Expect(err).NotTo(HaveOccurred())
+	tr := tar.NewReader(gz)
+	var entries [][2]string
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			return entries
+		}
+		content, err := io.ReadAll(tr)
+		Expect(err).NotTo(HaveOccurred())
+		entries = append(entries, [2]string{header.Name, string(content)})
+	}
+}
+
+var _ = Describe("Archive", func() {
+	var path string
+
+	BeforeEach(func() {
+		dir, err := os.MkdirTemp("", "archive-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+		path = filepath.Join(dir, "bundle.tar.gz")
+
+		w, err := NewWriter()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = w.Close() }()
+		Expect(w.Write(ClustersFile, record{Name: "prod"})).To(Succeed())
+		Expect(w.Write(ClustersFile, record{Name: "lab"})).To(Succeed())
+		manifest, err := w.Finish(path, Manifest{ToolVersion: "v1.2.3", Filter: Filter{ClusterName: "prod"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Files).To(HaveLen(len(DataFiles)))
+	})
+
+	It("should read back the records and manifest", func() {
+		r, err := Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = r.Close() }()
+
+		Expect(r.Manifest.Format).To(Equal(FormatName))
+		Expect(r.Manifest.Version).To(Equal(FormatVersion))
+		Expect(r.Manifest.ToolVersion).To(Equal("v1.2.3"))
+		Expect(r.Manifest.Filter.ClusterName).To(Equal("prod"))
+		clusters, _ := r.Manifest.file(ClustersFile)
+		Expect(clusters.Records).To(Equal(int64(2)))
+
+		var names []string
+		Expect(Each(r, ClustersFile, func(c record) error {
+			names = append(names, c.Name)
+			return nil
+		})).To(Succeed())
+		Expect(names).To(Equal([]string{"prod", "lab"}))
+
+		Expect(Each(r, SamplesFile, func(record) error {
+			Fail("samples.jsonl should be empty")
+			return nil
+		})).To(Succeed())
+	})
+
+	It("should reject a data file that does not match its checksum", func() {
+		entries := readEntries(path)
+		for i := range entries {
+			if entries[i][0] == ClustersFile {
+				entries[i][1] = strings.Replace(entries[i][1], "lab", "dev", 1)
+			}
+		}
+		writeTar(path, entries)
+
+		_, err := Open(path)
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch for clusters.jsonl")))
+	})
+
+	It("should reject a newer format version", func() {
+		entries := readEntries(path)
+		var manifest Manifest
+		Expect(json.Unmarshal([]byte(entries[0][1]), &manifest)).To(Succeed())
+		manifest.Version = FormatVersion + 1
+		content, err := json.Marshal(manifest)
+		Expect(err).NotTo(HaveOccurred())
+		entries[0][1] = string(content)
+		writeTar(path, entries)
+
+		_, err = Open(path)
+		Expect(err).To(MatchError(ContainSubstring("unsupported archive format version 2")))
+	})
+
+	It("should reject unexpected entries", func() {
+		writeTar(path, append(readEntries(path), [2]string{"../evil.jsonl", "{}"}))
+
+		_, err := Open(path)
+		Expect(err).To(MatchError(ContainSubstring(`unexpected entry "../evil.jsonl"`)))
+	})
+})
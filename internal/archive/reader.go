@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// maxRecordSize bounds one JSON line of a data file
+const maxRecordSize = 16 * 1024 * 1024
+
+// Reader reads an archive extracted to a temporary directory. It must be
+// closed to remove the temporary files.
+type Reader struct {
+	Manifest Manifest
+	dir      string
+}
+
+// Open extracts the archive at path and checks its manifest and checksums
+func Open(path string) (*Reader, error) {
+	dir, err := os.MkdirTemp("", "kpi-collector-import-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	r := &Reader{dir: dir}
+
+	checksums, err := extract(path, dir)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("invalid archive: missing %s", ManifestFile)
+	}
+	if err := json.Unmarshal(content, &r.Manifest); err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("invalid archive manifest: %w", err)
+	}
+	if err := r.Manifest.validate(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	for _, name := range DataFiles {
+		f, _ := r.Manifest.file(name)
+		if checksums[name] != f.SHA256 {
+			_ = r.Close()
+			return nil, fmt.Errorf("invalid archive: checksum mismatch for %s", name)
+		}
+	}
+	return r, nil
+}
+
+// Close removes the temporary files
+func (r *Reader) Close() error {
+	return os.RemoveAll(r.dir)
+}
+
+// Each decodes the records of the named data file in order and calls fn
+// with each of them
+func Each[T any](r *Reader, name string, fn func(record T) error) error {
+	file, err := os.Open(filepath.Join(r.dir, name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record T
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("invalid record at %s:%d: %w", name, line, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// extract writes the files of the archive at path to dir and returns the
+// SHA-256 checksums of the data files. Only the files of the format are
+// accepted, so entries cannot be written outside of dir.
+func extract(path, dir string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	checksums := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return checksums, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || (header.Name != ManifestFile && !slices.Contains(DataFiles, header.Name)) {
+			return nil, fmt.Errorf("invalid archive: unexpected entry %q", header.Name)
+		}
+
+		checksum, err := extractFile(tr, filepath.Join(dir, header.Name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+		checksums[header.Name] = checksum
+	}
+}
+
+// extractFile copies a tar entry to path and returns its SHA-256 checksum
+func extractFile(r io.Reader, path string) (string, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = out.Close() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), out.Close()
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Writer writes the records of an archive to temporary files, then packs
+// them with their manifest. It must be closed to remove the temporary files.
+type Writer struct {
+	dir   string
+	files map[string]*recordFile
+}
+
+// recordFile is a record file being written
+type recordFile struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	hash    hash.Hash
+	encoder *json.Encoder
+	records int64
+}
+
+// NewWriter returns a writer with a new temporary directory
+func NewWriter() (*Writer, error) {
+	dir, err := os.MkdirTemp("", "kpi-collector-export-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	return &Writer{dir: dir, files: make(map[string]*recordFile)}, nil
+}
+
+// Write appends a record to the named data file as one JSON line
+func (w *Writer) Write(name string, record any) error {
+	f, err := w.recordFile(name)
+	if err != nil {
+		return err
+	}
+	if err := f.encoder.Encode(record); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	f.records++
+	return nil
+}
+
+// recordFile returns the named data file, creating it on first use
+func (w *Writer) recordFile(name string) (*recordFile, error) {
+	if f, ok := w.files[name]; ok {
+		return f, nil
+	}
+
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	f := &recordFile{file: file, buffer: bufio.NewWriter(file), hash: sha256.New()}
+	f.encoder = json.NewEncoder(io.MultiWriter(f.buffer, f.hash))
+	w.files[name] = f
+	return f, nil
+}
+
+// Finish completes the manifest with the data files, writes the archive to
+// path and returns the completed manifest. The archive is written to a
+// temporary file first, so path is never left half written.
+func (w *Writer) Finish(path string, manifest Manifest) (Manifest, error) {
+	manifest.Format, manifest.Version = FormatName, FormatVersion
+	manifest.Files = nil
+	for _, name := range DataFiles {
+		f, err := w.recordFile(name)
+		if err != nil {
+			return manifest, err
+		}
+		if err := f.buffer.Flush(); err != nil {
+			return manifest, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		manifest.Files = append(manifest.Files, File{Name: name, Records: f.records, SHA256: hex.EncodeToString(f.hash.Sum(nil))})
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, ManifestFile), append(content, '\n'), 0644); err != nil {
+		return manifest, fmt.Errorf("failed to write %s: %w", ManifestFile, err)
+	}
+
+	partial := path + ".partial"
+	if err := pack(partial, w.dir, append([]string{ManifestFile}, DataFiles...)); err != nil {
+		_ = os.Remove(partial)
+		return manifest, err
+	}
+	return manifest, os.Rename(partial, path)
+}
+
+// Close removes the temporary files
+func (w *Writer) Close() error {
+	for _, f := range w.files {
+		_ = f.file.Close()
+	}
+	return os.RemoveAll(w.dir)
+}
+
+// pack writes the files of dir to a gzipped tar at path
+func pack(path string, dir string, names []string) (err error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write archive: %w", closeErr)
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		if err := addFile(tw, dir, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// addFile adds a file of dir to the tar
+func addFile(tw *tar.Writer, dir, name string) error {
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s to the archive: %w", name, err)
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("failed to write %s to the archive: %w", name, err)
+	}
+	return nil
+}
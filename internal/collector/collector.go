@@ -12,9 +12,11 @@ import (
 	"os/signal"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/prometheus"
 )
@@ -24,59 +26,103 @@ import (
 // collection completes before the timer expires.
 const durationBuffer = 100 * time.Millisecond
 
+// queryRunner executes a batch of KPI queries against a shared collection
+// session. It is satisfied by *prometheus.Session.
+type queryRunner interface {
+	RunQueries(ctx context.Context, kpis config.KPIs, sampleNumber int, totalSamples int, frequency time.Duration) error
+	RecordOverrun(event database.OverrunEvent) error
+}
+
 // RunOnce executes every KPI query exactly once and returns.
 // It ignores frequency and duration settings entirely.
-// Returns an error if any queries failed.
-func RunOnce(kpis config.KPIs, flags config.InputFlags) error {
+// Returns the ID of the collection run recorded, and an error if any queries failed.
+func RunOnce(kpis config.KPIs, flags config.InputFlags) (int64, error) {
 	fmt.Printf("\nKPI Collection Started - Single run mode\n")
 
+	// SIGINT or SIGTERM cancels the queries in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	log.Printf("Single run: executing %d KPIs", len(kpis.Queries))
 
-	err := prometheus.RunQueries(kpis, flags, 1, 1, 0)
+	session, err := prometheus.NewSession(flags)
+	if err != nil {
+		return 0, err
+	}
+	defer closeSession(session)
+
+	if err := startRun(session, kpis, flags); err != nil {
+		return 0, err
+	}
+
+	err = session.RunQueries(ctx, kpis, 1, 1, 0)
 	if err != nil {
 		log.Printf("RunQueries failed in single-run mode: %v", err)
 	}
 
+	if flags.Retention > 0 {
+		prune(session, time.Now(), flags)
+	}
+
+	finishRun(session, "Single run completed")
+	output.PrintSinkSummary(session.SinkSummary())
 	output.PrintShutdown("Single run completed")
-	return err
+	return session.RunID(), err
 }
 
 // Run executes the KPI collection loop until duration expires or interrupted.
-// Returns an error if any query failures occurred during collection.
-func Run(kpis config.KPIs, flags config.InputFlags) error {
+// Returns the ID of the collection run recorded, and an error if any query failures
+// occurred during collection.
+func Run(kpis config.KPIs, flags config.InputFlags) (int64, error) {
 	var hadFailures atomic.Bool
 
 	runOnceKPIs, repeatingKPIs := splitRunOnceQueries(kpis)
 
+	// SIGINT or SIGTERM stops collection and cancels the queries in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	session, err := prometheus.NewSession(flags)
+	if err != nil {
+		return 0, err
+	}
+	defer closeSession(session)
+
+	if err := startRun(session, kpis, flags); err != nil {
+		return 0, err
+	}
+
+	stopRetention := startRetention(session, flags)
+	defer stopRetention()
+
 	// Execute run-once queries immediately before starting the loop
 	if len(runOnceKPIs.Queries) > 0 {
 		fmt.Printf("Executing %d run-once KPI(s) before starting collection loop\n", len(runOnceKPIs.Queries))
 		log.Printf("Executing %d run-once KPIs", len(runOnceKPIs.Queries))
 
-		if err := prometheus.RunQueries(runOnceKPIs, flags, 1, 1, 0); err != nil {
+		if err := session.RunQueries(ctx, runOnceKPIs, 1, 1, 0); err != nil {
 			log.Printf("RunQueries failed for run-once KPIs: %v", err)
 			hadFailures.Store(true)
 		}
 	}
 
 	if len(repeatingKPIs.Queries) == 0 {
+		finishRun(session, "All queries are run-once, collection complete")
+		output.PrintSinkSummary(session.SinkSummary())
 		output.PrintShutdown("All queries are run-once, collection complete")
 		if hadFailures.Load() {
-			return fmt.Errorf("some queries failed during collection")
+			return session.RunID(), fmt.Errorf("some queries failed during collection")
 		}
-		return nil
+		return session.RunID(), nil
 	}
 
 	durationTimer := time.NewTimer(flags.Duration + durationBuffer)
 	defer durationTimer.Stop()
 
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt)
-
 	output.PrintStartup(flags.Duration.String(), time.Now().Add(flags.Duration).Format(time.RFC3339))
 
 	// Start repeating KPI goroutines grouped by frequency
-	cancel, wg := startKPIGoroutines(repeatingKPIs, flags, &hadFailures)
+	cancel, wg, groups := startKPIGoroutines(ctx, session, repeatingKPIs, flags, &hadFailures)
 	defer cancel()
 
 	// Main goroutine only handles duration timer and interrupts
@@ -86,19 +132,23 @@ func Run(kpis config.KPIs, flags config.InputFlags) error {
 		log.Printf("Duration timer expired")
 		shutdownReason = "Duration completed"
 
-	case <-interruptChan:
+	case <-ctx.Done():
 		log.Printf("Program interrupted")
 		shutdownReason = "Interrupted by user"
 	}
 
-	// Wait for all goroutines to finish, then print shutdown message
+	// Wait for all goroutines to finish, then print the summary and shutdown message
 	shutdown(cancel, wg)
+	sortGroupSummaries(groups)
+	output.PrintCollectionSummary(groups)
+	finishRun(session, shutdownReason)
+	output.PrintSinkSummary(session.SinkSummary())
 	output.PrintShutdown(shutdownReason)
 
 	if hadFailures.Load() {
-		return fmt.Errorf("some queries failed during collection")
+		return session.RunID(), fmt.Errorf("some queries failed during collection")
 	}
-	return nil
+	return session.RunID(), nil
 }
 
 // splitRunOnceQueries separates KPIs into run-once and repeating groups
@@ -137,24 +187,38 @@ func groupKPIsByFrequency(kpis config.KPIs, defaultFreq time.Duration) map[time.
 	return kpisByFreq
 }
 
-// startKPIGoroutines starts one goroutine per unique frequency for all KPIs
-func startKPIGoroutines(kpis config.KPIs, flags config.InputFlags, hadFailures *atomic.Bool) (context.CancelFunc, *sync.WaitGroup) {
-	ctx, cancel := context.WithCancel(context.Background())
+// startKPIGoroutines starts one goroutine per unique frequency and overrun policy for all KPIs.
+// The returned group summaries are owned by the goroutines and must only be read after the WaitGroup is done.
+// The returned cancel function stops the loops once their current sample is done; cancelling queryCtx
+// also aborts the queries in flight.
+func startKPIGoroutines(queryCtx context.Context, runner queryRunner, kpis config.KPIs, flags config.InputFlags, hadFailures *atomic.Bool) (context.CancelFunc, *sync.WaitGroup, []*output.GroupSummary) {
+	ctx, cancel := context.WithCancel(queryCtx)
 	var wg sync.WaitGroup
+	var groups []*output.GroupSummary
 
 	// Group ALL KPIs by their sampling frequency (including default frequency)
 	kpisByFreq := groupKPIsByFrequency(kpis, flags.SamplingFreq)
 
-	// Start one goroutine per unique frequency
+	// Start one goroutine per unique frequency and overrun policy
 	for freq, kpisForFreq := range kpisByFreq {
-		wg.Add(1)
-		go func(frequency time.Duration, kpiGroup config.KPIs) {
-			defer wg.Done()
-			runKPIGroupLoop(ctx, kpiGroup, frequency, flags, hadFailures)
-		}(freq, kpisForFreq)
+		for policy, kpiGroup := range splitByOverrunPolicy(kpisForFreq) {
+			group := &output.GroupSummary{
+				Frequency: freq,
+				Policy:    policy,
+				KPICount:  len(kpiGroup.Queries),
+				Planned:   calculateTotalSamples(freq, flags.Duration),
+			}
+			groups = append(groups, group)
+
+			wg.Add(1)
+			go func(kpiGroup config.KPIs) {
+				defer wg.Done()
+				runKPIGroupLoop(ctx, queryCtx, realClock{}, runner, kpiGroup, group, hadFailures)
+			}(kpiGroup)
+		}
 	}
 
-	return cancel, &wg
+	return cancel, &wg, groups
 }
 
 func shutdown(cancel context.CancelFunc, wg *sync.WaitGroup) {
@@ -162,40 +226,125 @@ func shutdown(cancel context.CancelFunc, wg *sync.WaitGroup) {
 	wg.Wait()
 }
 
-// runKPIGroupLoop runs a group of KPIs that share the same sampling frequency
-func runKPIGroupLoop(ctx context.Context, kpis config.KPIs, frequency time.Duration, flags config.InputFlags, hadFailures *atomic.Bool) {
-	ticker := time.NewTicker(frequency)
-	defer ticker.Stop()
-
-	sampleCount := 0
-	totalSamples := calculateTotalSamples(frequency, flags.Duration)
-	log.Printf("Starting goroutine for %d KPIs with frequency %s (total samples: %d)", len(kpis.Queries), frequency, totalSamples)
-	// Run immediately on start
-	sampleCount++
-	runKPIs(kpis, flags, sampleCount, totalSamples, frequency, hadFailures)
-
-	for {
-		select {
-		case <-ticker.C:
-			sampleCount++
-			runKPIs(kpis, flags, sampleCount, totalSamples, frequency, hadFailures)
-
-		case <-ctx.Done():
-			log.Printf("KPI group (frequency %s) stopped after %d samples", frequency, sampleCount)
+// startRun records the collection run and the definitions of its KPIs, in
+// the database and the sinks, and starts watching their thresholds, before
+// any query is executed
+func startRun(session *prometheus.Session, kpis config.KPIs, flags config.InputFlags) error {
+	run := newCollectionRun(flags)
+	if err := session.StartRun(run); err != nil {
+		return err
+	}
+	session.WatchThresholds(kpis, flags.AlertHysteresis)
+
+	definitions := newKPIDefinitions(kpis, flags)
+	if err := session.RecordDefinitions(definitions); err != nil {
+		return err
+	}
+	session.StartSinks(run, definitions)
+	return nil
+}
+
+// newKPIDefinitions describes what every KPI of the run measures
+func newKPIDefinitions(kpis config.KPIs, flags config.InputFlags) []database.KPIDefinition {
+	definitions := make([]database.KPIDefinition, 0, len(kpis.Queries))
+	for _, kpi := range kpis.Queries {
+		definition := database.KPIDefinition{
+			KPIID:             kpi.ID,
+			PromQuery:         kpi.GetRawPromQuery(),
+			ResolvedPromQuery: kpi.PromQuery,
+			QueryType:         kpi.GetEffectiveQueryType(),
+			RunOnce:           kpi.IsRunOnce(),
+		}
+		if !flags.SingleRun && !kpi.IsRunOnce() {
+			definition.Frequency = kpi.GetEffectiveFrequency(flags.SamplingFreq)
+		}
+		if kpi.Range != nil && definition.QueryType == "range" {
+			if kpi.Range.Step != nil {
+				definition.RangeStep = kpi.Range.Step.String()
+			}
+			if kpi.Range.Since != nil {
+				definition.RangeSince = kpi.Range.Since.String()
+			}
+			if kpi.Range.Until != nil {
+				definition.RangeUntil = kpi.Range.Until.String()
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions
+}
+
+// newCollectionRun describes the collection run started with the given flags
+func newCollectionRun(flags config.InputFlags) database.CollectionRun {
+	run := database.CollectionRun{ToolVersion: flags.ToolVersion}
+	if !flags.SingleRun {
+		run.Frequency = flags.SamplingFreq
+		run.Duration = flags.Duration
+	}
+
+	hash, err := config.HashKPIsFile(flags.KPIsFile)
+	if err != nil {
+		log.Printf("Failed to hash KPIs file, the run is recorded without it: %v", err)
+	}
+	run.KPIsFileHash = hash
+
+	return run
+}
+
+// finishRun records the end of the collection run, reporting (but not failing on) errors
+func finishRun(session *prometheus.Session, shutdownReason string) {
+	if err := session.FinishRun(shutdownReason); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record the end of the collection run: %v\n", err)
+	}
+}
+
+// closeSession releases the collection session, reporting (but not failing on) close errors
+func closeSession(session *prometheus.Session) {
+	if err := session.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to close database: %v\n", err)
+	}
+}
+
+// runKPIGroupLoop runs a group of KPIs that share the same sampling frequency and overrun policy.
+// Tick k is scheduled at start + k*frequency; when a sample runs past the next tick the
+// overrun is recorded and the group's policy decides which tick runs next. Cancelling ctx stops
+// the loop after the current sample; its queries run with queryCtx.
+func runKPIGroupLoop(ctx context.Context, queryCtx context.Context, clk clock, runner queryRunner, kpis config.KPIs, group *output.GroupSummary, hadFailures *atomic.Bool) {
+	frequency := group.Frequency
+	log.Printf("Starting goroutine for %d KPIs with frequency %s, overrun policy %s (total samples: %d)",
+		len(kpis.Queries), frequency, group.Policy, group.Planned)
+
+	start := clk.Now()
+	// The first sample runs immediately on start
+	for tick := 0; ; {
+		if tick > 0 && !clk.WaitUntil(ctx, start.Add(time.Duration(tick)*frequency)) {
+			log.Printf("KPI group (frequency %s) stopped after %d samples", frequency, group.Executed)
 			return
 		}
+
+		group.Executed++
+		runStart := clk.Now()
+		runKPIs(queryCtx, runner, kpis, group.Executed, group.Planned, frequency, hadFailures)
+		runEnd := clk.Now()
+
+		next, missed, skipped := nextTick(tick, runStart.Sub(start), runEnd.Sub(start), frequency, group.Policy)
+		group.Skipped += skipped
+		if missed > 0 {
+			recordOverrun(runner, group, group.Executed, runEnd.Sub(runStart), missed)
+		}
+		tick = next
 	}
 }
 
 // runKPIs executes a group of KPIs and logs the results
-func runKPIs(kpis config.KPIs, flags config.InputFlags, sampleNumber int, totalSamples int, frequency time.Duration, hadFailures *atomic.Bool) {
+func runKPIs(ctx context.Context, runner queryRunner, kpis config.KPIs, sampleNumber int, totalSamples int, frequency time.Duration, hadFailures *atomic.Bool) {
 	if len(kpis.Queries) == 0 {
 		return
 	}
 
 	log.Printf("Running sample %d/%d for %d KPIs with frequency %s", sampleNumber, totalSamples, len(kpis.Queries), frequency)
 
-	if err := prometheus.RunQueries(kpis, flags, sampleNumber, totalSamples, frequency); err != nil {
+	if err := runner.RunQueries(ctx, kpis, sampleNumber, totalSamples, frequency); err != nil {
 		log.Printf("RunQueries failed for frequency %s KPIs: %v", frequency, err)
 		hadFailures.Store(true)
 	}
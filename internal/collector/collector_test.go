@@ -1,6 +1,9 @@
 package collector
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"time"
 
@@ -8,8 +11,70 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
 )
 
+// fakeRunner records RunQueries calls instead of querying Prometheus.
+// When clock is set, each call advances it by the matching entry of durations
+// (or by defaultFakeRunDuration once durations is exhausted).
+type fakeRunner struct {
+	calls     atomic.Int32
+	lastCtx   atomic.Value // context.Context of the last call
+	overruns  atomic.Int32
+	clock     *fakeClock
+	durations []time.Duration
+}
+
+const defaultFakeRunDuration = 100 * time.Millisecond
+
+func (f *fakeRunner) RunQueries(ctx context.Context, kpis config.KPIs, sampleNumber int, totalSamples int, frequency time.Duration) error {
+	f.lastCtx.Store(ctx)
+	call := int(f.calls.Add(1)) - 1
+	if f.clock != nil {
+		runDuration := defaultFakeRunDuration
+		if call < len(f.durations) {
+			runDuration = f.durations[call]
+		}
+		f.clock.now = f.clock.now.Add(runDuration)
+	}
+	return nil
+}
+
+func (f *fakeRunner) RecordOverrun(event database.OverrunEvent) error {
+	f.overruns.Add(1)
+	return nil
+}
+
+// fakePruner records the retention policies it is asked to apply
+type fakePruner struct {
+	policies []database.RetentionPolicy
+}
+
+func (f *fakePruner) Prune(policy database.RetentionPolicy) (database.PruneResult, error) {
+	f.policies = append(f.policies, policy)
+	return database.PruneResult{Cutoff: policy.Before}, nil
+}
+
+// fakeClock is a virtual clock for runKPIGroupLoop: waiting jumps straight to
+// the deadline, and waits past end report cancellation to stop the loop.
+type fakeClock struct {
+	now time.Time
+	end time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) WaitUntil(ctx context.Context, deadline time.Time) bool {
+	if ctx.Err() != nil || deadline.After(c.end) {
+		return false
+	}
+	if deadline.After(c.now) {
+		c.now = deadline
+	}
+	return true
+}
+
 // Helper function to create a Duration pointer
 func durationPtr(d time.Duration) *config.Duration {
 	return &config.Duration{Duration: d}
@@ -126,6 +191,159 @@ var _ = Describe("Collector", func() {
 		})
 	})
 
+	Describe("nextTick", func() {
+		frequency := 10 * time.Second
+
+		DescribeTable("scheduling after a sample completes",
+			func(current int, started, finished time.Duration, policy string, expectedNext, expectedMissed, expectedSkipped int) {
+				next, missed, skipped := nextTick(current, started, finished, frequency, policy)
+				Expect(next).To(Equal(expectedNext))
+				Expect(missed).To(Equal(expectedMissed))
+				Expect(skipped).To(Equal(expectedSkipped))
+			},
+			Entry("no overrun", 2, 20*time.Second, 25*time.Second, config.OverrunPolicySkip, 3, 0, 0),
+			Entry("skip drops every missed tick", 2, 20*time.Second, 47*time.Second, config.OverrunPolicySkip, 5, 2, 2),
+			Entry("coalesce runs one sample for all missed ticks", 2, 20*time.Second, 47*time.Second, config.OverrunPolicyCoalesce, 4, 2, 1),
+			Entry("catch-up runs every missed tick", 2, 20*time.Second, 47*time.Second, config.OverrunPolicyCatchUp, 3, 2, 0),
+			Entry("a fast catch-up sample is not an overrun", 3, 47*time.Second, 47100*time.Millisecond, config.OverrunPolicyCatchUp, 4, 0, 0),
+			Entry("a slow catch-up sample only counts the ticks it missed itself", 3, 47*time.Second, 62*time.Second, config.OverrunPolicyCatchUp, 4, 2, 0),
+		)
+	})
+
+	Describe("runKPIGroupLoop", func() {
+		// Sample 2 (tick 1) runs from 10s to 47s, so ticks 2, 3 and 4 elapse meanwhile.
+		// Collection stops at 65s, leaving 7 planned ticks (0s, 10s, ... 60s).
+		DescribeTable("should record one overrun and account for every planned tick",
+			func(policy string, expectedExecuted, expectedSkipped int) {
+				start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+				clk := &fakeClock{now: start, end: start.Add(65 * time.Second)}
+				runner := &fakeRunner{clock: clk, durations: []time.Duration{time.Second, 37 * time.Second}}
+				kpis := config.KPIs{Queries: []config.Query{{ID: "kpi-1", PromQuery: "query1"}}}
+				group := &output.GroupSummary{Frequency: 10 * time.Second, Policy: policy, Planned: 7}
+
+				var hadFailures atomic.Bool
+				runKPIGroupLoop(context.Background(), context.Background(), clk, runner, kpis, group, &hadFailures)
+
+				Expect(runner.overruns.Load()).To(Equal(int32(1)))
+				Expect(group.Overruns).To(Equal(1))
+				Expect(group.Executed).To(Equal(int(runner.calls.Load())))
+				Expect(group.Executed).To(Equal(expectedExecuted))
+				Expect(group.Skipped).To(Equal(expectedSkipped))
+				Expect(group.Executed + group.Skipped).To(Equal(group.Planned))
+			},
+			Entry("skip", config.OverrunPolicySkip, 4, 3),
+			Entry("coalesce", config.OverrunPolicyCoalesce, 5, 2),
+			Entry("catch-up", config.OverrunPolicyCatchUp, 7, 0),
+		)
+
+		It("should stop when the context is cancelled", func() {
+			start := time.Now()
+			clk := &fakeClock{now: start, end: start.Add(time.Hour)}
+			runner := &fakeRunner{clock: clk}
+			group := &output.GroupSummary{Frequency: time.Second, Policy: config.OverrunPolicyCoalesce, Planned: 3600}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var hadFailures atomic.Bool
+			runKPIGroupLoop(ctx, context.Background(), clk, runner, config.KPIs{Queries: []config.Query{{ID: "kpi-1", PromQuery: "query1"}}}, group, &hadFailures)
+
+			Expect(group.Executed).To(Equal(1))
+		})
+	})
+
+	Describe("runRetentionLoop", func() {
+		It("should prune on start and then every retention interval", func() {
+			start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			clk := &fakeClock{now: start, end: start.Add(150 * time.Minute)}
+			p := &fakePruner{}
+			flags := config.InputFlags{Retention: 30 * 24 * time.Hour, RetentionDownsample: time.Hour, RetentionInterval: time.Hour}
+
+			runRetentionLoop(context.Background(), clk, p, flags)
+
+			Expect(p.policies).To(HaveLen(3))
+			for i, policy := range p.policies {
+				Expect(policy.Before).To(Equal(start.Add(time.Duration(i)*time.Hour - flags.Retention)))
+				Expect(policy.Downsample).To(Equal(time.Hour))
+			}
+		})
+
+		It("should not start without a retention", func() {
+			p := &fakePruner{}
+			startRetention(p, config.InputFlags{RetentionInterval: time.Hour})()
+			Expect(p.policies).To(BeEmpty())
+		})
+	})
+
+	Describe("newCollectionRun", func() {
+		var kpisFile string
+
+		BeforeEach(func() {
+			kpisFile = filepath.Join(GinkgoT().TempDir(), "kpis.yaml")
+			Expect(os.WriteFile(kpisFile, []byte("kpis: []\n"), 0644)).To(Succeed())
+		})
+
+		It("should describe a periodic collection with the KPIs file hash and tool version", func() {
+			run := newCollectionRun(config.InputFlags{
+				SamplingFreq: 30 * time.Second, Duration: time.Hour, KPIsFile: kpisFile, ToolVersion: "v1.2.3",
+			})
+			Expect(run.Frequency).To(Equal(30 * time.Second))
+			Expect(run.Duration).To(Equal(time.Hour))
+			Expect(run.KPIsFileHash).To(HaveLen(64))
+			Expect(run.ToolVersion).To(Equal("v1.2.3"))
+		})
+
+		It("should not record frequency and duration in single-run mode", func() {
+			run := newCollectionRun(config.InputFlags{
+				SamplingFreq: 30 * time.Second, Duration: time.Hour, KPIsFile: kpisFile, SingleRun: true,
+			})
+			Expect(run.Frequency).To(BeZero())
+			Expect(run.Duration).To(BeZero())
+		})
+	})
+
+	Describe("newKPIDefinitions", func() {
+		It("should record the raw and resolved query with the effective settings", func() {
+			runOnce := true
+			kpis := config.SubstituteCPUPlaceholders(config.KPIs{Queries: []config.Query{
+				{ID: "reserved", PromQuery: `rate(cpu{cpu=~"{{RESERVED_CPUS}}"}[5m])`, SampleFrequency: durationPtr(10 * time.Second)},
+				{ID: "range", PromQuery: "up", QueryType: "range", Range: &config.RangeWindow{Step: durationPtr(30 * time.Second)}},
+				{ID: "once", PromQuery: "up", RunOnce: &runOnce},
+			}}, &config.CPUPlaceholders{Reserved: "0|1"})
+
+			definitions := newKPIDefinitions(kpis, config.InputFlags{SamplingFreq: time.Minute})
+			Expect(definitions).To(HaveLen(3))
+
+			Expect(definitions[0].PromQuery).To(Equal(`rate(cpu{cpu=~"{{RESERVED_CPUS}}"}[5m])`))
+			Expect(definitions[0].ResolvedPromQuery).To(Equal(`rate(cpu{cpu=~"0|1"}[5m])`))
+			Expect(definitions[0].QueryType).To(Equal("instant"))
+			Expect(definitions[0].Frequency).To(Equal(10 * time.Second))
+
+			Expect(definitions[1].ResolvedPromQuery).To(Equal(definitions[1].PromQuery))
+			Expect(definitions[1].RangeStep).To(Equal("30s"))
+			Expect(definitions[1].Frequency).To(Equal(time.Minute))
+
+			Expect(definitions[2].RunOnce).To(BeTrue())
+			Expect(definitions[2].Frequency).To(BeZero())
+		})
+	})
+
+	Describe("splitByOverrunPolicy", func() {
+		It("should separate KPIs by their effective overrun policy", func() {
+			kpis := config.KPIs{Queries: []config.Query{
+				{ID: "kpi-1", PromQuery: "query1"},
+				{ID: "kpi-2", PromQuery: "query2", OverrunPolicy: config.OverrunPolicySkip},
+				{ID: "kpi-3", PromQuery: "query3", OverrunPolicy: config.OverrunPolicyCoalesce},
+			}}
+
+			byPolicy := splitByOverrunPolicy(kpis)
+
+			Expect(byPolicy).To(HaveLen(2))
+			Expect(byPolicy[config.OverrunPolicyCoalesce].Queries).To(HaveLen(2))
+			Expect(byPolicy[config.OverrunPolicySkip].Queries).To(HaveLen(1))
+		})
+	})
+
 	Describe("Query.GetEffectiveFrequency", func() {
 		var defaultFreq time.Duration
 
@@ -206,7 +424,7 @@ var _ = Describe("Collector", func() {
 			}
 
 				var hadFailures atomic.Bool
-				cancel, wg := startKPIGoroutines(kpis, flags, &hadFailures)
+				cancel, wg, _ := startKPIGoroutines(context.Background(), &fakeRunner{}, kpis, flags, &hadFailures)
 
 				Expect(cancel).NotTo(BeNil())
 				Expect(wg).NotTo(BeNil())
@@ -227,7 +445,7 @@ var _ = Describe("Collector", func() {
 				}
 
 				var hadFailures atomic.Bool
-				cancel, wg := startKPIGoroutines(kpis, flags, &hadFailures)
+				cancel, wg, _ := startKPIGoroutines(context.Background(), &fakeRunner{}, kpis, flags, &hadFailures)
 
 				Expect(cancel).NotTo(BeNil())
 				Expect(wg).NotTo(BeNil())
@@ -238,12 +456,51 @@ var _ = Describe("Collector", func() {
 			})
 		})
 
+		Context("when sharing one runner across frequency groups", func() {
+			It("should run every group immediately through the same runner", func() {
+				kpis := config.KPIs{
+					Queries: []config.Query{
+						{ID: "kpi-1", PromQuery: "query1", SampleFrequency: durationPtr(5 * time.Second)},
+						{ID: "kpi-2", PromQuery: "query2"},
+					},
+				}
+
+				runner := &fakeRunner{}
+				var hadFailures atomic.Bool
+				cancel, wg, _ := startKPIGoroutines(context.Background(), runner, kpis, flags, &hadFailures)
+
+				Eventually(runner.calls.Load).Should(BeNumerically(">=", 2))
+
+				cancel()
+				wg.Wait()
+				Expect(hadFailures.Load()).To(BeFalse())
+			})
+
+			It("should run the queries with the query context, which stopping the loops does not cancel", func() {
+				type ctxKey struct{}
+				queryCtx := context.WithValue(context.Background(), ctxKey{}, "query")
+				kpis := config.KPIs{Queries: []config.Query{{ID: "kpi-1", PromQuery: "query1"}}}
+
+				runner := &fakeRunner{}
+				var hadFailures atomic.Bool
+				cancel, wg, _ := startKPIGoroutines(queryCtx, runner, kpis, flags, &hadFailures)
+
+				Eventually(runner.calls.Load).Should(BeNumerically(">=", 1))
+				cancel()
+				wg.Wait()
+
+				ctx := runner.lastCtx.Load().(context.Context)
+				Expect(ctx.Value(ctxKey{})).To(Equal("query"))
+				Expect(ctx.Err()).NotTo(HaveOccurred())
+			})
+		})
+
 		Context("when there are no KPIs", func() {
 			It("should return cancel function and empty WaitGroup", func() {
 				kpis := config.KPIs{Queries: []config.Query{}}
 
 				var hadFailures atomic.Bool
-				cancel, wg := startKPIGoroutines(kpis, flags, &hadFailures)
+				cancel, wg, _ := startKPIGoroutines(context.Background(), &fakeRunner{}, kpis, flags, &hadFailures)
 
 				Expect(cancel).NotTo(BeNil())
 				Expect(wg).NotTo(BeNil())
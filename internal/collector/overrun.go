@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+// clock abstracts time for the sampling loop so tick scheduling can be tested
+// without real sleeps. It is satisfied by realClock.
+type clock interface {
+	Now() time.Time
+	// WaitUntil blocks until the deadline passes or the context is cancelled.
+	// Returns false if the context was cancelled first.
+	WaitUntil(ctx context.Context, deadline time.Time) bool
+}
+
+// realClock is the wall clock used during collection
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) WaitUntil(ctx context.Context, deadline time.Time) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// nextTick decides which scheduled tick runs next after the sample for tick
+// `current` ran from `started` to `finished` (both measured from the group
+// start). Tick k is scheduled at start + k*frequency.
+//
+// missed counts the ticks that became due while this sample was running; it
+// is 0 for a sample that started behind schedule (e.g. a catch-up sample) but
+// finished within its own slot, so only the slow sample is blamed for an
+// overrun. skipped counts the ticks that are dropped under the given policy.
+func nextTick(current int, started, finished, frequency time.Duration, policy string) (next int, missed int, skipped int) {
+	dueAtStart := max(current, int(started/frequency))
+	latestDue := int(finished / frequency)
+	missed = max(latestDue-dueAtStart, 0)
+
+	if latestDue <= current {
+		return current + 1, missed, 0
+	}
+
+	switch policy {
+	case config.OverrunPolicyCatchUp:
+		return current + 1, missed, 0
+	case config.OverrunPolicySkip:
+		return latestDue + 1, missed, latestDue - current
+	default: // config.OverrunPolicyCoalesce
+		return latestDue, missed, latestDue - current - 1
+	}
+}
+
+// recordOverrun logs an overrun and stores it through the runner
+func recordOverrun(runner queryRunner, group *output.GroupSummary, sampleNumber int, runDuration time.Duration, missed int) {
+	group.Overruns++
+	log.Printf("Overrun: sample %d of frequency group %s took %s, %d tick(s) elapsed meanwhile (policy: %s)",
+		sampleNumber, group.Frequency, runDuration.Round(time.Millisecond), missed, group.Policy)
+
+	event := database.OverrunEvent{
+		Frequency:    group.Frequency,
+		SampleNumber: sampleNumber,
+		RunDuration:  runDuration,
+		MissedTicks:  missed,
+		Policy:       group.Policy,
+	}
+	if err := runner.RecordOverrun(event); err != nil {
+		log.Printf("Failed to record overrun for frequency group %s: %v", group.Frequency, err)
+	}
+}
+
+// splitByOverrunPolicy splits a group of KPIs by their effective overrun policy
+func splitByOverrunPolicy(kpis config.KPIs) map[string]config.KPIs {
+	byPolicy := make(map[string]config.KPIs)
+	for _, kpi := range kpis.Queries {
+		policy := kpi.GetEffectiveOverrunPolicy()
+		group := byPolicy[policy]
+		group.Queries = append(group.Queries, kpi)
+		byPolicy[policy] = group
+	}
+	return byPolicy
+}
+
+// sortGroupSummaries orders group summaries by frequency, then overrun policy
+func sortGroupSummaries(groups []*output.GroupSummary) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Frequency != groups[j].Frequency {
+			return groups[i].Frequency < groups[j].Frequency
+		}
+		return groups[i].Policy < groups[j].Policy
+	})
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+// pruner applies a retention policy to the samples collected for the
+// cluster. It is satisfied by *prometheus.Session.
+type pruner interface {
+	Prune(policy database.RetentionPolicy) (database.PruneResult, error)
+}
+
+// startRetention starts the background retention task when --retention is
+// set. The task prunes right away and then every --retention-interval until
+// the returned stop function is called; stop waits for a running prune.
+func startRetention(p pruner, flags config.InputFlags) (stop func()) {
+	if flags.Retention <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runRetentionLoop(ctx, realClock{}, p, flags)
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// runRetentionLoop prunes on start and then every RetentionInterval until the context is cancelled
+func runRetentionLoop(ctx context.Context, clk clock, p pruner, flags config.InputFlags) {
+	log.Printf("Retention task started: pruning samples older than %s every %s", flags.Retention, flags.RetentionInterval)
+
+	for next := clk.Now(); clk.WaitUntil(ctx, next); next = next.Add(flags.RetentionInterval) {
+		prune(p, clk.Now(), flags)
+	}
+
+	log.Printf("Retention task stopped")
+}
+
+// prune applies the retention policy once, logging (but not failing on) errors
+func prune(p pruner, now time.Time, flags config.InputFlags) {
+	result, err := p.Prune(database.RetentionPolicy{
+		Before:     now.Add(-flags.Retention),
+		Downsample: flags.RetentionDownsample,
+	})
+	if err != nil {
+		log.Printf("Retention: failed to prune samples: %v", err)
+		return
+	}
+
+	if flags.RetentionDownsample > 0 {
+		log.Printf("Retention: rolled %d samples recorded before %s up into %d bucket(s) of %s",
+			result.Deleted, result.Cutoff.UTC().Format(time.RFC3339), result.Buckets, flags.RetentionDownsample)
+		return
+	}
+	log.Printf("Retention: deleted %d samples recorded before %s", result.Deleted, result.Cutoff.UTC().Format(time.RFC3339))
+}
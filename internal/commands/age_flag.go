@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+)
+
+// ageValue is a flag value for ages that may be given in days (e.g. "30d"),
+// which time.ParseDuration and the standard duration flags do not accept
+type ageValue time.Duration
+
+// newAgeValue sets p to the default value and returns it as a flag value
+func newAgeValue(value time.Duration, p *time.Duration) *ageValue {
+	*p = value
+	return (*ageValue)(p)
+}
+
+func (a *ageValue) Set(s string) error {
+	d, err := config.ParseAge(s)
+	if err != nil {
+		return err
+	}
+	*a = ageValue(d)
+	return nil
+}
+
+func (a *ageValue) String() string { return time.Duration(*a).String() }
+
+func (a *ageValue) Type() string { return "age" }
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -24,7 +25,9 @@ var dbCmd = &cobra.Command{
 	Long: `Direct access to query and manage the collected KPI data stored in the database.
 
 Supports querying KPI metrics, listing clusters, viewing errors, and cleaning up data.
-Works with both SQLite (default) and PostgreSQL databases.
+Works with both SQLite (default) and PostgreSQL databases. The sample files of
+'run --db-type file' are read with --db-type file, by the commands that do not
+modify the database.
 
 Database connection can be specified via:
   1. CLI flags: --db-type and --postgres-url
@@ -52,58 +55,113 @@ func init() {
 
 	// Add persistent flags that apply to all db subcommands
 	dbCmd.PersistentFlags().StringVar(&dbFlags.DatabaseType, "db-type", "",
-		"database type: sqlite (default) or postgres")
+		"database type: sqlite (default), postgres or file")
 	dbCmd.PersistentFlags().StringVar(&dbFlags.PostgresURL, "postgres-url", "",
 		"PostgreSQL connection string")
 }
 
-// connectToDB establishes a database connection using flags or environment variables
+// errFileReadOnly is returned by the commands that modify the database for a
+// file database
+var errFileReadOnly = errors.New("file databases are read-only: their sample files are only written by 'kpi-collector run'")
+
+// connectToDB establishes a database connection using flags or environment
+// variables and migrates it to the latest schema. It refuses databases with a
+// schema written by a newer kpi-collector.
+//
+// The sample files of a file database are loaded into an in-memory SQLite
+// database, which is returned as such.
 func connectToDB() (*sql.DB, database.Database, error) {
-	// Priority 1: CLI flags
-	dbType := dbFlags.DatabaseType
-	postgresURL := dbFlags.PostgresURL
+	return connectToDBWith(dbSettings())
+}
 
-	// Priority 2: Environment variables (if flags not provided)
-	if dbType == "" {
-		dbType = os.Getenv("KPI_COLLECTOR_DB_TYPE")
+// connectToDBWith is connectToDB for the given database type and PostgreSQL URL
+func connectToDBWith(dbType string, postgresURL string) (*sql.DB, database.Database, error) {
+	dbImpl, err := resolveDB(dbType, postgresURL)
+	if err != nil {
+		return nil, nil, err
 	}
-	if postgresURL == "" {
-		postgresURL = os.Getenv("KPI_COLLECTOR_DB_URL")
+
+	// Initialize database connection
+	db, err := dbImpl.InitDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Priority 3: Default to SQLite
-	if dbType == "" {
-		dbType = "sqlite"
+	if fileDB, ok := dbImpl.(*database.FileDB); ok {
+		if _, err := fileDB.Load(db); err != nil {
+			_ = db.Close()
+			return nil, nil, fmt.Errorf("failed to load sample files: %w", err)
+		}
+		return db, &fileDB.SQLiteDB, nil
+	}
+
+	return db, dbImpl, nil
+}
+
+// connectToWritableDB is connectToDB for the commands that modify the
+// database, which refuses file databases
+func connectToWritableDB() (*sql.DB, database.Database, error) {
+	if dbType, _ := dbSettings(); dbType == "file" {
+		return nil, nil, errFileReadOnly
 	}
+	return connectToDB()
+}
 
+// resolveDB selects the database implementation of a database type
+func resolveDB(dbType string, postgresURL string) (database.Database, error) {
 	// Validate PostgreSQL URL if needed
 	if dbType == "postgres" && postgresURL == "" {
-		return nil, nil, fmt.Errorf("PostgreSQL connection URL is required.\n" +
+		return nil, fmt.Errorf("PostgreSQL connection URL is required.\n" +
 			"Provide via --postgres-url flag or KPI_COLLECTOR_DB_URL environment variable")
 	}
 
 	// Create database implementation
-	var dbImpl database.Database
 	switch dbType {
 	case "postgres":
-		dbImpl = database.NewPostgresDB(postgresURL)
+		return database.NewPostgresDB(postgresURL), nil
 	case "sqlite":
 		dbPath := filepath.Join(database.OutputDir, database.DefaultDBFileName)
 		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-			return nil, nil, fmt.Errorf("SQLite database not found at %s\n"+
+			return nil, fmt.Errorf("SQLite database not found at %s\n"+
 				"Run 'kpi-collector run' first to collect data, or use --artifacts-dir to specify the artifacts directory",
 				dbPath)
 		}
-		dbImpl = database.NewSQLiteDB()
+		return database.NewSQLiteDB(), nil
+	case "file":
+		fileDB := database.NewFileDB()
+		files, err := fileDB.Files()
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no sample files found in %s\n"+
+				"Run 'kpi-collector run --db-type file' first to collect data, or use --artifacts-dir to specify the artifacts directory",
+				database.OutputDir)
+		}
+		return fileDB, nil
 	default:
-		return nil, nil, fmt.Errorf("invalid database type: %s (must be 'sqlite' or 'postgres')", dbType)
+		return nil, fmt.Errorf("invalid database type: %s (must be 'sqlite', 'postgres' or 'file')", dbType)
 	}
+}
 
-	// Initialize database connection
-	db, err := dbImpl.InitDB()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+// dbSettings returns the database type and PostgreSQL URL from flags or
+// environment variables
+func dbSettings() (dbType string, postgresURL string) {
+	// Priority 1: CLI flags
+	dbType = dbFlags.DatabaseType
+	postgresURL = dbFlags.PostgresURL
+
+	// Priority 2: Environment variables (if flags not provided)
+	if dbType == "" {
+		dbType = os.Getenv("KPI_COLLECTOR_DB_TYPE")
+	}
+	if postgresURL == "" {
+		postgresURL = os.Getenv("KPI_COLLECTOR_DB_URL")
 	}
 
-	return db, dbImpl, nil
+	// Priority 3: Default to SQLite
+	if dbType == "" {
+		dbType = "sqlite"
+	}
+	return dbType, postgresURL
 }
@@ -0,0 +1,416 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/spf13/cobra"
+)
+
+var compareFlags struct {
+	baseline       string
+	candidate      string
+	kpiName        string
+	labelsFilter   string
+	ignoreLabels   string
+	statistic      string
+	tolerance      float64
+	higherIsBetter bool
+	noTruncate     bool
+	outputFormat   string
+}
+
+// comparisonStatistics are the statistics --statistic can compare
+var comparisonStatistics = []string{"min", "avg", "p50", "p95", "p99", "max"}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare KPIs between two clusters or collection runs",
+	Long: `Compare the KPI samples of a baseline and a candidate, each either every sample
+of a cluster or the samples of one collection run (see 'db show runs').
+
+Series are aligned by KPI name and labels. Labels that differ between the two sides,
+such as node names when comparing two sites, can be left out with --ignore-labels;
+series that then share the same labels are merged. For each series, the count,
+min, avg, p50, p95, p99 and max of both sides are computed, and the --statistic
+of the candidate is compared with the baseline.
+
+A series regresses when the statistic gets worse by more than --tolerance percent
+of the baseline value: higher, or lower with --higher-is-better. The command exits
+with a non-zero status when any series regresses, for CI gating.`,
+	Example: `  # Compare a cluster before and after an upgrade, one collection run each
+  kpi-collector db compare --baseline run:3 --candidate run:7
+
+  # Compare two RAN sites, aligning series whatever their node
+  kpi-collector db compare --baseline cluster:site-a --candidate cluster:site-b \
+    --name="cpu-isolated" --ignore-labels=instance,node
+
+  # Gate on the p99 with a 5% tolerance and a Markdown report
+  kpi-collector db compare --baseline run:3 --candidate run:7 \
+    --statistic=p99 --tolerance=5 -o markdown > comparison.md`,
+	RunE: runCompare,
+}
+
+func init() {
+	dbCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().StringVar(&compareFlags.baseline, "baseline", "",
+		"baseline samples: cluster:<name>, run:<id>, or a cluster name (required)")
+	compareCmd.Flags().StringVar(&compareFlags.candidate, "candidate", "",
+		"candidate samples: cluster:<name>, run:<id>, or a cluster name (required)")
+	compareCmd.Flags().StringVar(&compareFlags.kpiName, "name", "",
+		"only compare this KPI")
+	compareCmd.Flags().StringVar(&compareFlags.labelsFilter, "labels-filter", "",
+		"only compare samples matching these labels, as in 'db show kpis'")
+	compareCmd.Flags().StringVar(&compareFlags.ignoreLabels, "ignore-labels", "",
+		"labels left out when aligning series (e.g. 'instance,node')")
+	compareCmd.Flags().StringVar(&compareFlags.statistic, "statistic", "avg",
+		"statistic compared for regressions: "+strings.Join(comparisonStatistics, ", "))
+	compareCmd.Flags().Float64Var(&compareFlags.tolerance, "tolerance", 10,
+		"allowed change of the statistic, in percent of the baseline value")
+	compareCmd.Flags().BoolVar(&compareFlags.higherIsBetter, "higher-is-better", false,
+		"treat a lower statistic as a regression (e.g. for throughput KPIs)")
+	compareCmd.Flags().BoolVar(&compareFlags.noTruncate, "no-truncate", false,
+		"show full labels without truncation")
+	compareCmd.Flags().StringVarP(&compareFlags.outputFormat, "output", "o", "table",
+		"output format: table, json, csv, or markdown")
+	_ = compareCmd.MarkFlagRequired("baseline")
+	_ = compareCmd.MarkFlagRequired("candidate")
+}
+
+func runCompare(cmd *cobra.Command, args []string) error {
+	format, err := output.ParseComparisonFormat(compareFlags.outputFormat)
+	if err != nil {
+		return err
+	}
+
+	options := comparisonOptions{
+		Statistic:      compareFlags.statistic,
+		Tolerance:      compareFlags.tolerance,
+		HigherIsBetter: compareFlags.higherIsBetter,
+	}
+	if err := options.validate(); err != nil {
+		return err
+	}
+
+	baseline, err := parseComparisonSide(compareFlags.baseline)
+	if err != nil {
+		return fmt.Errorf("invalid --baseline: %w", err)
+	}
+	candidate, err := parseComparisonSide(compareFlags.candidate)
+	if err != nil {
+		return fmt.Errorf("invalid --candidate: %w", err)
+	}
+
+	var labelFilters []*labels.Matcher
+	if compareFlags.labelsFilter != "" {
+		labelFilters, err = parseLabelFilters(compareFlags.labelsFilter)
+		if err != nil {
+			return fmt.Errorf("invalid --labels-filter: %w", err)
+		}
+	}
+
+	var ignoreLabels []string
+	if compareFlags.ignoreLabels != "" {
+		ignoreLabels, err = parseGroupBy(compareFlags.ignoreLabels)
+		if err != nil {
+			return fmt.Errorf("invalid --ignore-labels: %w", err)
+		}
+	}
+
+	db, dbImpl, err := connectToDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	report := output.ComparisonReport{
+		Statistic:      options.Statistic,
+		Tolerance:      options.Tolerance,
+		HigherIsBetter: options.HigherIsBetter,
+	}
+
+	sides := make([]map[string]*series, 2)
+	for i, side := range []comparisonSide{baseline, candidate} {
+		description, err := side.describe(db, dbImpl)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			report.Baseline = description
+		} else {
+			report.Candidate = description
+		}
+
+		params := KPIQueryParams{
+			KPIName:      compareFlags.kpiName,
+			ClusterName:  side.ClusterName,
+			RunID:        side.RunID,
+			LabelFilters: labelFilters,
+		}
+		sides[i], err = loadSeries(db, dbImpl, params, ignoreLabels)
+		if err != nil {
+			return fmt.Errorf("failed to load the samples of %s: %w", description, err)
+		}
+	}
+
+	report.Records, report.Regressions = compareSeries(sides[0], sides[1], options)
+	if len(report.Records) == 0 {
+		fmt.Println("No samples found.")
+		return nil
+	}
+
+	printer := output.NewPrinter(format).WithNoTruncate(compareFlags.noTruncate)
+	if err := printer.PrintComparison(report); err != nil {
+		return err
+	}
+
+	if report.Regressions > 0 {
+		// The report already explains the failure
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d series regressed beyond the %g%% tolerance", report.Regressions, options.Tolerance)
+	}
+	return nil
+}
+
+// comparisonSide is the baseline or candidate of a comparison: the samples
+// of a cluster, or of one collection run
+type comparisonSide struct {
+	ClusterName string
+	RunID       int64
+}
+
+// parseComparisonSide parses "cluster:<name>", "run:<id>" or a cluster name
+func parseComparisonSide(value string) (comparisonSide, error) {
+	if id, ok := strings.CutPrefix(value, "run:"); ok {
+		runID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || runID <= 0 {
+			return comparisonSide{}, fmt.Errorf("invalid run ID %q", id)
+		}
+		return comparisonSide{RunID: runID}, nil
+	}
+
+	name := strings.TrimPrefix(value, "cluster:")
+	if name == "" {
+		return comparisonSide{}, errors.New("use cluster:<name> or run:<id>")
+	}
+	return comparisonSide{ClusterName: name}, nil
+}
+
+// describe checks that the cluster or run exists and describes it for the report
+func (s comparisonSide) describe(db *sql.DB, dbImpl database.Database) (string, error) {
+	if s.RunID == 0 {
+		if _, err := findCluster(db, dbImpl, s.ClusterName); err != nil {
+			return "", err
+		}
+		return "cluster " + s.ClusterName, nil
+	}
+
+	query := `
+		SELECT c.cluster_name
+		FROM collection_runs r
+		JOIN clusters c ON r.cluster_id = c.id
+		WHERE r.id = $1`
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	var clusterName string
+	err := db.QueryRow(query, s.RunID).Scan(&clusterName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("run %d not found", s.RunID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query run %d: %w", s.RunID, err)
+	}
+	return fmt.Sprintf("run %d (cluster %s)", s.RunID, clusterName), nil
+}
+
+// series holds the sample values of one KPI and label set, in time order
+type series struct {
+	KPIName    string
+	Labels     map[string]string
+	Values     []float64
+	Timestamps []float64 // Unix seconds, one per value
+}
+
+// loadSeries loads the samples selected by params, keyed by KPI name and
+// labels without ignoreLabels
+func loadSeries(db *sql.DB, dbImpl database.Database, params KPIQueryParams, ignoreLabels []string) (map[string]*series, error) {
+	conditions, args := kpiFilterConditions(dbImpl, params)
+	query := `
+		SELECT qr.kpi_id, qr.metric_labels, qr.metric_value, qr.timestamp_value
+		FROM query_results qr
+		JOIN clusters c ON qr.cluster_id = c.id
+		WHERE qr.metric_value IS NOT NULL` + conditions + `
+		ORDER BY qr.timestamp_value, qr.id`
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	result := make(map[string]*series)
+	for rows.Next() {
+		var (
+			kpiName     string
+			labelsValue sql.NullString
+			value       float64
+			timestamp   float64
+		)
+		if err := rows.Scan(&kpiName, &labelsValue, &value, &timestamp); err != nil {
+			return nil, err
+		}
+
+		seriesLabels := make(map[string]string)
+		if labelsValue.Valid && labelsValue.String != "" {
+			if err := json.Unmarshal([]byte(labelsValue.String), &seriesLabels); err != nil {
+				return nil, fmt.Errorf("invalid labels of a %s sample: %w", kpiName, err)
+			}
+		}
+		for _, label := range ignoreLabels {
+			delete(seriesLabels, label)
+		}
+
+		// Marshalling a map of strings cannot fail, and sorts the keys
+		key, _ := json.Marshal(seriesLabels)
+		s, ok := result[kpiName+"\x00"+string(key)]
+		if !ok {
+			s = &series{KPIName: kpiName, Labels: seriesLabels}
+			result[kpiName+"\x00"+string(key)] = s
+		}
+		s.Values = append(s.Values, value)
+		s.Timestamps = append(s.Timestamps, timestamp)
+	}
+
+	return result, rows.Err()
+}
+
+// comparisonOptions decide which series changes are regressions
+type comparisonOptions struct {
+	Statistic      string
+	Tolerance      float64 // percent of the baseline value
+	HigherIsBetter bool
+}
+
+func (o comparisonOptions) validate() error {
+	if !slices.Contains(comparisonStatistics, o.Statistic) {
+		return fmt.Errorf("invalid --statistic %q: must be one of %s", o.Statistic, strings.Join(comparisonStatistics, ", "))
+	}
+	if o.Tolerance < 0 {
+		return fmt.Errorf("--tolerance must be >= 0")
+	}
+	return nil
+}
+
+// compareSeries compares the baseline and candidate series, ordered by KPI
+// name and labels, and returns the number of regressions
+func compareSeries(baseline, candidate map[string]*series, options comparisonOptions) ([]output.ComparisonRecord, int) {
+	keys := make([]string, 0, len(baseline)+len(candidate))
+	for key := range baseline {
+		keys = append(keys, key)
+	}
+	for key := range candidate {
+		if _, ok := baseline[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	records := make([]output.ComparisonRecord, 0, len(keys))
+	regressions := 0
+	for _, key := range keys {
+		base, cand := baseline[key], candidate[key]
+
+		var record output.ComparisonRecord
+		switch {
+		case cand == nil:
+			record = output.ComparisonRecord{KPIName: base.KPIName, Labels: base.Labels, Status: output.ComparisonMissing}
+			record.Baseline = summarize(base.Values)
+		case base == nil:
+			record = output.ComparisonRecord{KPIName: cand.KPIName, Labels: cand.Labels, Status: output.ComparisonNew}
+			record.Candidate = summarize(cand.Values)
+		default:
+			record = output.ComparisonRecord{KPIName: base.KPIName, Labels: base.Labels}
+			record.Baseline, record.Candidate = summarize(base.Values), summarize(cand.Values)
+			record.Status = options.judge(&record)
+		}
+
+		if record.Status == output.ComparisonRegression {
+			regressions++
+		}
+		records = append(records, record)
+	}
+	return records, regressions
+}
+
+// judge sets the deltas of a record with both sides and returns its status
+func (o comparisonOptions) judge(record *output.ComparisonRecord) string {
+	baseline := record.Baseline.Statistic(o.Statistic)
+	delta := record.Candidate.Statistic(o.Statistic) - baseline
+	record.Delta = &delta
+
+	// Any change from a zero baseline exceeds the tolerance
+	exceeds := delta != 0
+	if baseline != 0 {
+		relative := delta / math.Abs(baseline)
+		record.RelativeDelta = &relative
+		exceeds = math.Abs(relative)*100 > o.Tolerance
+	}
+
+	switch {
+	case !exceeds:
+		return output.ComparisonOK
+	case (delta > 0) != o.HigherIsBetter:
+		return output.ComparisonRegression
+	default:
+		return output.ComparisonImprovement
+	}
+}
+
+// summarize computes the statistics of the sample values
+func summarize(values []float64) *output.ComparisonStats {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, value := range sorted {
+		sum += value
+	}
+	return &output.ComparisonStats{
+		Count: int64(len(sorted)),
+		Min:   sorted[0],
+		Avg:   sum / float64(len(sorted)),
+		P50:   percentile(sorted, 0.50),
+		P95:   percentile(sorted, 0.95),
+		P99:   percentile(sorted, 0.99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile interpolates linearly between the two closest sorted values,
+// like the percentile aggregates of 'db show kpis'
+func percentile(sorted []float64, fraction float64) float64 {
+	rank := fraction * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower == len(sorted)-1 {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
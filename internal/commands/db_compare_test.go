@@ -0,0 +1,157 @@
+package commands
+
+import (
+	"database/sql"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+var _ = Describe("db compare", func() {
+	Describe("parseComparisonSide", func() {
+		DescribeTable("should parse clusters and runs",
+			func(value string, expected comparisonSide) {
+				side, err := parseComparisonSide(value)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(side).To(Equal(expected))
+			},
+			Entry("run", "run:3", comparisonSide{RunID: 3}),
+			Entry("cluster", "cluster:site-a", comparisonSide{ClusterName: "site-a"}),
+			Entry("bare cluster name", "site-a", comparisonSide{ClusterName: "site-a"}),
+		)
+
+		DescribeTable("should reject invalid sides",
+			func(value string) {
+				_, err := parseComparisonSide(value)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("run without ID", "run:"),
+			Entry("non-numeric run ID", "run:latest"),
+			Entry("cluster without name", "cluster:"),
+		)
+	})
+
+	Describe("loadSeries and compareSeries", func() {
+		var (
+			db                  *sql.DB
+			baseline, candidate map[string]*series
+			avgOptions          comparisonOptions
+		)
+
+		insert := func(clusterID int, kpiName string, value float64, labels string) {
+			_, err := db.Exec(
+				"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels) VALUES (?, ?, ?, ?, ?, ?)",
+				kpiName, value, 1700000000.0, clusterID, "2026-01-01 00:00:00", labels,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			var err error
+			db, err = newInMemoryKPIDB()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(db.Close)
+
+			_, err = db.Exec("INSERT INTO clusters (id, cluster_name) VALUES (1, 'site-a'), (2, 'site-b')")
+			Expect(err).NotTo(HaveOccurred())
+
+			// The sites have different node names
+			for _, value := range []float64{1, 2, 3} {
+				insert(1, "cpu", value, `{"cpu":"2","instance":"a-worker-1"}`)
+			}
+			insert(2, "cpu", 2, `{"cpu":"2","instance":"b-worker-1"}`)
+			insert(2, "cpu", 4, `{"cpu":"2","instance":"b-worker-2"}`)
+			insert(1, "memory", 100, `{"instance":"a-worker-1"}`)
+			insert(2, "latency", 0.5, `{"instance":"b-worker-1"}`)
+
+			load := func(clusterName string) map[string]*series {
+				s, err := loadSeries(db, &database.SQLiteDB{}, KPIQueryParams{ClusterName: clusterName}, []string{"instance"})
+				Expect(err).NotTo(HaveOccurred())
+				return s
+			}
+			baseline, candidate = load("site-a"), load("site-b")
+			avgOptions = comparisonOptions{Statistic: "avg", Tolerance: 10}
+		})
+
+		It("should merge the series that only differ by ignored labels", func() {
+			Expect(candidate).To(HaveLen(2))
+			cpu := candidate["cpu\x00"+`{"cpu":"2"}`]
+			Expect(cpu).NotTo(BeNil())
+			Expect(cpu.Labels).To(Equal(map[string]string{"cpu": "2"}))
+			Expect(cpu.Values).To(ConsistOf(2.0, 4.0))
+		})
+
+		It("should flag regressions beyond the tolerance and series on one side only", func() {
+			records, regressions := compareSeries(baseline, candidate, avgOptions)
+			Expect(regressions).To(Equal(1))
+			Expect(records).To(HaveLen(3))
+
+			cpu := records[0]
+			Expect(cpu.KPIName).To(Equal("cpu"))
+			Expect(cpu.Baseline.Avg).To(Equal(2.0))
+			Expect(cpu.Baseline.P50).To(Equal(2.0))
+			Expect(cpu.Candidate.Avg).To(Equal(3.0))
+			Expect(*cpu.Delta).To(Equal(1.0))
+			Expect(*cpu.RelativeDelta).To(Equal(0.5))
+			Expect(cpu.Status).To(Equal(output.ComparisonRegression))
+
+			Expect(records[1].KPIName).To(Equal("latency"))
+			Expect(records[1].Status).To(Equal(output.ComparisonNew))
+			Expect(records[1].Baseline).To(BeNil())
+			Expect(records[2].KPIName).To(Equal("memory"))
+			Expect(records[2].Status).To(Equal(output.ComparisonMissing))
+			Expect(records[2].Candidate).To(BeNil())
+		})
+
+		It("should honour the direction, tolerance and statistic", func() {
+			options := avgOptions
+			options.HigherIsBetter = true
+			records, regressions := compareSeries(baseline, candidate, options)
+			Expect(regressions).To(BeZero())
+			Expect(records[0].Status).To(Equal(output.ComparisonImprovement))
+
+			options = comparisonOptions{Statistic: "avg", Tolerance: 50}
+			records, _ = compareSeries(baseline, candidate, options)
+			Expect(records[0].Status).To(Equal(output.ComparisonOK))
+
+			// The minimum doubles from 1 to 2
+			options = comparisonOptions{Statistic: "min", Tolerance: 10}
+			records, _ = compareSeries(baseline, candidate, options)
+			Expect(*records[0].Delta).To(Equal(1.0))
+			Expect(records[0].Status).To(Equal(output.ComparisonRegression))
+		})
+
+		It("should report any change from a zero baseline as exceeding the tolerance", func() {
+			record := output.ComparisonRecord{
+				Baseline:  &output.ComparisonStats{Count: 1},
+				Candidate: &output.ComparisonStats{Count: 1, Avg: 0.1},
+			}
+			Expect(avgOptions.judge(&record)).To(Equal(output.ComparisonRegression))
+			Expect(record.RelativeDelta).To(BeNil())
+		})
+
+		It("should render a Markdown report", func() {
+			report := output.ComparisonReport{Baseline: "cluster site-a", Candidate: "cluster site-b", Statistic: "avg", Tolerance: 10}
+			report.Records, report.Regressions = compareSeries(baseline, candidate, avgOptions)
+
+			var out strings.Builder
+			Expect(output.NewPrinter(output.FormatMarkdown).WithWriter(&out).PrintComparison(report)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("| KPI | Labels | Baseline avg | Candidate avg | Delta | Delta % | Status |"))
+			Expect(out.String()).To(ContainSubstring("| cpu | `{cpu=\"2\"}` | 2.000000 | 3.000000 | 1.000000 | +50.00% | **regression** |"))
+			Expect(out.String()).To(ContainSubstring("3 series, 1 regression(s) beyond 10% on avg"))
+		})
+	})
+
+	It("should accept markdown only for comparisons", func() {
+		format, err := output.ParseComparisonFormat("markdown")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(format).To(Equal(output.FormatMarkdown))
+
+		_, err = output.ParseFormat("markdown")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+
+	"github.com/spf13/cobra"
+)
+
+var copyFlags struct {
+	from string
+	to   string
+}
+
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "Copy the collected data from one database to another",
+	Long: `Copy the clusters, collection runs, KPI definitions and samples of one database
+into another, e.g. from the SQLite file of a jump host into the team PostgreSQL.
+
+Databases are given as:
+  sqlite:<path>     a SQLite file, or an artifacts directory holding kpi_metrics.db
+                    ("sqlite:" alone is the database of --artifacts-dir)
+  postgres:<url>    a PostgreSQL connection string; postgresql://... is accepted as is
+
+The target database is created or migrated if needed. Rows are streamed in
+batches and merged as by 'db import': clusters are matched by name, collection
+runs by cluster and start time, and samples already stored are skipped by the
+same deduplication as collection, so copying twice does not duplicate data.`,
+	Example: `  # Copy the local SQLite database into PostgreSQL
+  kpi-collector db copy --from sqlite: --to postgres:postgresql://user:pass@db:5432/kpi
+
+  # Copy PostgreSQL back into a SQLite file
+  kpi-collector db copy --from postgresql://user:pass@db:5432/kpi --to sqlite:./kpi_metrics.db`,
+	RunE: runCopy,
+}
+
+func init() {
+	dbCmd.AddCommand(copyCmd)
+
+	copyCmd.Flags().StringVar(&copyFlags.from, "from", "",
+		"source database: sqlite:<path> or postgres:<url> (required)")
+	copyCmd.Flags().StringVar(&copyFlags.to, "to", "",
+		"target database: sqlite:<path> or postgres:<url> (required)")
+	_ = copyCmd.MarkFlagRequired("from")
+	_ = copyCmd.MarkFlagRequired("to")
+}
+
+func runCopy(cmd *cobra.Command, args []string) error {
+	source, err := database.ParseSpec(copyFlags.from)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	target, err := database.ParseSpec(copyFlags.to)
+	if err != nil {
+		return fmt.Errorf("invalid --to: %w", err)
+	}
+	if database.SameDatabase(source, target) {
+		return fmt.Errorf("--from and --to are the same database")
+	}
+	if sqliteDB, ok := source.(*database.SQLiteDB); ok {
+		if _, err := os.Stat(sqliteDB.Path); err != nil {
+			return fmt.Errorf("SQLite database not found at %s", sqliteDB.Path)
+		}
+	}
+
+	sourceDB, err := source.InitDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the source database: %w", err)
+	}
+	defer func() { _ = sourceDB.Close() }()
+
+	targetDB, err := target.InitDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the target database: %w", err)
+	}
+	defer func() { _ = targetDB.Close() }()
+
+	stats, err := importRecords(targetDB, target, &databaseSource{db: sourceDB, dbImpl: source})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Copied %s to %s\n", database.DisplayName(source), database.DisplayName(target))
+	printTypeConflicts(stats.TypeConflicts, "the source")
+	fmt.Println()
+	output.PrintTransferTable(stats.records())
+	return nil
+}
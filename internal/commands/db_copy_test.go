@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+var _ = Describe("db copy", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-copy-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, tmpDir)
+	})
+
+	It("should copy a database into another, once", func() {
+		source := &database.SQLiteDB{Path: filepath.Join(tmpDir, "source.db")}
+		sourceDB, err := source.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(sourceDB.Close)
+
+		clusterID, err := source.GetOrCreateCluster(sourceDB, "lab", "core")
+		Expect(err).NotTo(HaveOccurred())
+		runID, err := source.StartCollectionRun(sourceDB, clusterID, database.CollectionRun{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = sourceDB.Exec(
+			"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, metric_labels, run_id) VALUES (?, ?, ?, ?, ?, ?)",
+			"cpu", 0.5, 1700000000.0, clusterID, `{"node":"a"}`, runID,
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		target := &database.SQLiteDB{Path: filepath.Join(tmpDir, "target", "target.db")}
+		targetDB, err := target.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(targetDB.Close)
+		_, err = target.GetOrCreateCluster(targetDB, "lab", "core")
+		Expect(err).NotTo(HaveOccurred())
+
+		stats, err := importRecords(targetDB, target, &databaseSource{db: sourceDB, dbImpl: source})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(importStats{
+			Clusters: 1, Runs: 1, RunsWritten: 1, Samples: 1, SamplesWritten: 1,
+		}))
+
+		stats, err = importRecords(targetDB, target, &databaseSource{db: sourceDB, dbImpl: source})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.records()[3]).To(Equal(output.TransferRecord{Table: "query_results", Read: 1, Written: 0}))
+	})
+})
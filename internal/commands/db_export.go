@@ -0,0 +1,383 @@
+package commands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/archive"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var exportFlags struct {
+	clusterName string
+	since       string
+	until       string
+	outputFile  string
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export collected data to a portable archive",
+	Long: `Export the clusters, collection runs, KPI definitions and samples of the database
+to a portable archive, to move data collected in a disconnected lab to an analysis
+environment. Import the archive there with 'kpi-collector db import'.
+
+The archive is a gzipped tar of JSON Lines files with a versioned manifest that
+lists their record counts and SHA-256 checksums. With --since or --until, only the
+samples in the time window, and the runs that collected them, are exported.
+
+For the archive format, see https://github.com/redhat-best-practices-for-k8s/kpi-collection-tool/blob/main/docs/database-commands.md`,
+	Example: `  # Export everything collected on one cluster
+  kpi-collector db export --cluster-name prod -o prod.tar.gz
+
+  # Export the last day of every cluster
+  kpi-collector db export --since 24h -o last-day.tar.gz`,
+	RunE: runExport,
+}
+
+func init() {
+	dbCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVar(&exportFlags.clusterName, "cluster-name", "",
+		"only export this cluster (default: every cluster)")
+	exportCmd.Flags().StringVar(&exportFlags.since, "since", "",
+		"only export samples since this timestamp (Go duration or RFC3339)")
+	exportCmd.Flags().StringVar(&exportFlags.until, "until", "",
+		"only export samples until this timestamp (Go duration or RFC3339)")
+	exportCmd.Flags().StringVarP(&exportFlags.outputFile, "output", "o", "",
+		"archive file to write, e.g. bundle.tar.gz (required)")
+	_ = exportCmd.MarkFlagRequired("output")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	sinceTime, untilTime, err := parseKPIQueryTimeWindow(exportFlags.since, exportFlags.until, time.Now())
+	if err != nil {
+		return err
+	}
+
+	db, dbImpl, err := connectToDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if exportFlags.clusterName != "" {
+		if _, err := findCluster(db, dbImpl, exportFlags.clusterName); err != nil {
+			return err
+		}
+	}
+
+	params := KPIQueryParams{ClusterName: exportFlags.clusterName, Since: sinceTime, Until: untilTime}
+	manifest, err := exportArchive(db, dbImpl, params, exportFlags.outputFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Exported %s to %s\n", archiveSummary(manifest), exportFlags.outputFile)
+	return nil
+}
+
+// exportArchive writes the data selected by params to an archive at path
+func exportArchive(db *sql.DB, dbImpl database.Database, params KPIQueryParams, path string) (archive.Manifest, error) {
+	w, err := archive.NewWriter()
+	if err != nil {
+		return archive.Manifest{}, err
+	}
+	defer func() { _ = w.Close() }()
+
+	schemaVersion, err := dbImpl.SchemaVersion(db)
+	if err != nil {
+		return archive.Manifest{}, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	manifest := archive.Manifest{
+		CreatedAt:     time.Now().UTC(),
+		ToolVersion:   gitVersion(),
+		SchemaVersion: schemaVersion,
+		Filter:        archive.Filter{ClusterName: params.ClusterName, Since: params.Since, Until: params.Until},
+	}
+
+	src := &databaseSource{db: db, dbImpl: dbImpl, params: params}
+	err = src.eachCluster(func(c database.ArchiveCluster) error { return w.Write(archive.ClustersFile, c) })
+	if err != nil {
+		return manifest, err
+	}
+	err = src.eachRun(func(r database.ArchiveRun) error { return w.Write(archive.RunsFile, r) })
+	if err != nil {
+		return manifest, err
+	}
+	err = src.eachDefinition(func(d database.ArchiveDefinition) error { return w.Write(archive.DefinitionsFile, d) })
+	if err != nil {
+		return manifest, err
+	}
+	err = src.eachSample(func(sample database.ArchiveSample) error { return w.Write(archive.SamplesFile, sample) })
+	if err != nil {
+		return manifest, err
+	}
+
+	return w.Finish(path, manifest)
+}
+
+// databaseSource yields the records of a database selected by params, in
+// the order and format of an archive
+type databaseSource struct {
+	db     *sql.DB
+	dbImpl database.Database
+	params KPIQueryParams
+
+	runIDs map[int64]bool // the selected runs, once loaded
+}
+
+func (s *databaseSource) eachCluster(fn func(database.ArchiveCluster) error) error {
+	clusters, err := exportClusters(s.db, s.dbImpl, s.params.ClusterName)
+	if err != nil {
+		return fmt.Errorf("failed to export clusters: %w", err)
+	}
+	for _, c := range clusters {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *databaseSource) eachRun(fn func(database.ArchiveRun) error) error {
+	runs, err := exportRuns(s.db, s.dbImpl, s.params)
+	if err != nil {
+		return fmt.Errorf("failed to export collection runs: %w", err)
+	}
+	s.runIDs = make(map[int64]bool, len(runs))
+	for _, r := range runs {
+		s.runIDs[r.ID] = true
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *databaseSource) eachDefinition(fn func(database.ArchiveDefinition) error) error {
+	if s.runIDs == nil {
+		// The definitions are those of the selected runs
+		if err := s.eachRun(func(database.ArchiveRun) error { return nil }); err != nil {
+			return err
+		}
+	}
+	definitions, err := exportDefinitions(s.db, s.dbImpl, s.params.ClusterName, s.runIDs)
+	if err != nil {
+		return fmt.Errorf("failed to export KPI definitions: %w", err)
+	}
+	for _, d := range definitions {
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *databaseSource) eachSample(fn func(database.ArchiveSample) error) error {
+	if err := exportSamples(s.db, s.dbImpl, s.params, fn); err != nil {
+		return fmt.Errorf("failed to export samples: %w", err)
+	}
+	return nil
+}
+
+// exportClusters returns the cluster named clusterName, or every cluster
+func exportClusters(db *sql.DB, dbImpl database.Database, clusterName string) ([]database.ArchiveCluster, error) {
+	query := "SELECT id, cluster_name, COALESCE(cluster_type, ''), created_at FROM clusters"
+	args := []interface{}{}
+	if clusterName != "" {
+		query += " WHERE cluster_name = $1"
+		args = append(args, clusterName)
+	}
+	query += " ORDER BY id"
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var clusters []database.ArchiveCluster
+	for rows.Next() {
+		var c database.ArchiveCluster
+		if err := rows.Scan(&c.ID, &c.Name, &c.Type, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, rows.Err()
+}
+
+// exportRuns returns the runs of the selected clusters, only those that
+// collected a selected sample when params has a time window
+func exportRuns(db *sql.DB, dbImpl database.Database, params KPIQueryParams) ([]database.ArchiveRun, error) {
+	query := `
+		SELECT r.id, r.cluster_id, r.started_at, r.ended_at, r.frequency_seconds, r.duration_seconds,
+		       COALESCE(r.kpis_file_hash, ''), COALESCE(r.tool_version, ''), COALESCE(r.shutdown_reason, ''),
+		       r.failed_queries, r.retries
+		FROM collection_runs r
+		JOIN clusters c ON r.cluster_id = c.id
+		WHERE 1=1`
+	args := []interface{}{}
+
+	if params.Since != nil || params.Until != nil {
+		conditions, windowArgs := kpiFilterConditions(dbImpl, params)
+		query += `
+		AND r.id IN (
+			SELECT qr.run_id FROM query_results qr
+			JOIN clusters c ON qr.cluster_id = c.id
+			WHERE qr.run_id IS NOT NULL` + conditions + ")"
+		args = windowArgs
+	} else if params.ClusterName != "" {
+		query += " AND c.cluster_name = $1"
+		args = append(args, params.ClusterName)
+	}
+	query += " ORDER BY r.id"
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var runs []database.ArchiveRun
+	for rows.Next() {
+		var (
+			r       database.ArchiveRun
+			endedAt sql.NullTime
+		)
+		err := rows.Scan(&r.ID, &r.ClusterID, &r.StartedAt, &endedAt, &r.FrequencySeconds, &r.DurationSeconds,
+			&r.KPIsFileHash, &r.ToolVersion, &r.ShutdownReason, &r.FailedQueries, &r.Retries)
+		if err != nil {
+			return nil, err
+		}
+		if endedAt.Valid {
+			r.EndedAt = &endedAt.Time
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// exportDefinitions returns the KPI definitions used by the exported runs,
+// with the IDs of those runs
+func exportDefinitions(db *sql.DB, dbImpl database.Database, clusterName string, runIDs map[int64]bool) ([]database.ArchiveDefinition, error) {
+	query := `
+		SELECT d.id, d.kpi_id, d.definition_hash, d.promquery, d.resolved_promquery, d.query_type,
+		       COALESCE(d.range_step, ''), COALESCE(d.range_since, ''), COALESCE(d.range_until, ''),
+		       d.frequency_seconds, d.run_once, l.run_id
+		FROM collection_run_definitions l
+		JOIN kpi_definitions d ON l.definition_id = d.id
+		JOIN collection_runs r ON l.run_id = r.id
+		JOIN clusters c ON r.cluster_id = c.id`
+	args := []interface{}{}
+	if clusterName != "" {
+		query += " WHERE c.cluster_name = $1"
+		args = append(args, clusterName)
+	}
+	query += " ORDER BY d.id, l.run_id"
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var definitions []database.ArchiveDefinition
+	for rows.Next() {
+		var (
+			d     database.ArchiveDefinition
+			runID int64
+		)
+		err := rows.Scan(&d.ID, &d.KPIID, &d.Hash, &d.PromQuery, &d.ResolvedPromQuery, &d.QueryType,
+			&d.RangeStep, &d.RangeSince, &d.RangeUntil, &d.FrequencySeconds, &d.RunOnce, &runID)
+		if err != nil {
+			return nil, err
+		}
+		if !runIDs[runID] {
+			continue
+		}
+
+		if n := len(definitions); n > 0 && definitions[n-1].ID == d.ID {
+			definitions[n-1].RunIDs = append(definitions[n-1].RunIDs, runID)
+			continue
+		}
+		d.RunIDs = []int64{runID}
+		definitions = append(definitions, d)
+	}
+	return definitions, rows.Err()
+}
+
+// exportSamples calls write with each selected sample, in insertion order
+func exportSamples(db *sql.DB, dbImpl database.Database, params KPIQueryParams, write func(database.ArchiveSample) error) error {
+	conditions, args := kpiFilterConditions(dbImpl, params)
+	query := `
+		SELECT qr.cluster_id, qr.run_id, qr.kpi_id, qr.metric_value, qr.timestamp_value,
+		       qr.metric_labels, qr.execution_time
+		FROM query_results qr
+		JOIN clusters c ON qr.cluster_id = c.id
+		WHERE 1=1` + conditions + `
+		ORDER BY qr.id`
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			s      database.ArchiveSample
+			runID  sql.NullInt64
+			value  sql.NullFloat64
+			labels sql.NullString
+		)
+		if err := rows.Scan(&s.ClusterID, &runID, &s.KPIID, &value, &s.Timestamp, &labels, &s.ExecutionTime); err != nil {
+			return err
+		}
+		s.RunID = runID.Int64
+		if value.Valid {
+			s.Value = &value.Float64
+		}
+		s.Labels = json.RawMessage("null")
+		if labels.Valid && json.Valid([]byte(labels.String)) {
+			s.Labels = json.RawMessage(labels.String)
+		}
+		if err := write(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// archiveSummary describes the record counts of an archive
+func archiveSummary(manifest archive.Manifest) string {
+	counts := make(map[string]int64, len(manifest.Files))
+	for _, f := range manifest.Files {
+		counts[f.Name] = f.Records
+	}
+	return fmt.Sprintf("%s cluster(s), %s run(s), %s KPI definition(s) and %s samples",
+		humanize.Comma(counts[archive.ClustersFile]), humanize.Comma(counts[archive.RunsFile]),
+		humanize.Comma(counts[archive.DefinitionsFile]), humanize.Comma(counts[archive.SamplesFile]))
+}
@@ -0,0 +1,285 @@
+package commands
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/archive"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+
+	"github.com/spf13/cobra"
+)
+
+// importBatchSize is the number of samples imported per transaction
+const importBatchSize = 5000
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Import an archive written by 'db export'",
+	Long: `Merge an archive written by 'kpi-collector db export' into the database, which is
+created if it does not exist yet.
+
+Clusters are matched by name and collection runs by cluster and start time, so
+the archived IDs are remapped to those of the database. Samples already stored
+are skipped by the same deduplication as collection, so importing an archive
+twice, or overlapping archives, does not duplicate data.
+
+The archive checksums are verified before anything is imported.`,
+	Example: `  # Import into the default SQLite database
+  kpi-collector db import prod.tar.gz
+
+  # Import into PostgreSQL
+  kpi-collector db import prod.tar.gz --db-type postgres --postgres-url "postgresql://..."`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	dbCmd.AddCommand(importCmd)
+}
+
+// importStats counts the records read from an archive or source database,
+// and those written to the target database
+type importStats struct {
+	Clusters, ClustersWritten       int64
+	Runs, RunsWritten               int64
+	Definitions, DefinitionsWritten int64
+	Samples, SamplesWritten         int64
+
+	TypeConflicts []typeConflict
+}
+
+// typeConflict is a cluster whose type differs between the source and the
+// target database, which keeps its type
+type typeConflict struct {
+	Cluster    string
+	TargetType string
+	SourceType string
+}
+
+// records returns the counts of each table
+func (s importStats) records() []output.TransferRecord {
+	return []output.TransferRecord{
+		{Table: "clusters", Read: s.Clusters, Written: s.ClustersWritten},
+		{Table: "collection_runs", Read: s.Runs, Written: s.RunsWritten},
+		{Table: "kpi_definitions", Read: s.Definitions, Written: s.DefinitionsWritten},
+		{Table: "query_results", Read: s.Samples, Written: s.SamplesWritten},
+	}
+}
+
+// recordSource yields the records of an archive or database. Records
+// reference each other by the IDs of their source.
+type recordSource interface {
+	eachCluster(fn func(database.ArchiveCluster) error) error
+	eachRun(fn func(database.ArchiveRun) error) error
+	eachDefinition(fn func(database.ArchiveDefinition) error) error
+	eachSample(fn func(database.ArchiveSample) error) error
+}
+
+// archiveSource yields the records of an archive
+type archiveSource struct {
+	reader *archive.Reader
+}
+
+func (s archiveSource) eachCluster(fn func(database.ArchiveCluster) error) error {
+	return archive.Each(s.reader, archive.ClustersFile, fn)
+}
+
+func (s archiveSource) eachRun(fn func(database.ArchiveRun) error) error {
+	return archive.Each(s.reader, archive.RunsFile, fn)
+}
+
+func (s archiveSource) eachDefinition(fn func(database.ArchiveDefinition) error) error {
+	return archive.Each(s.reader, archive.DefinitionsFile, fn)
+}
+
+func (s archiveSource) eachSample(fn func(database.ArchiveSample) error) error {
+	return archive.Each(s.reader, archive.SamplesFile, fn)
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	r, err := archive.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	// Unlike the other db commands, import creates a missing SQLite database
+	dbType, postgresURL := dbSettings()
+	if dbType == "file" {
+		return errFileReadOnly
+	}
+	db, dbImpl, err := database.InitDatabaseWithConfig(dbType, postgresURL, 0)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	stats, err := importRecords(db, dbImpl, archiveSource{reader: r})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Imported %s\n", args[0])
+	printTypeConflicts(stats.TypeConflicts, args[0])
+	fmt.Println()
+	output.PrintTransferTable(stats.records())
+	return nil
+}
+
+// importRecords merges the records of src into the database, remapping their
+// cluster and run IDs to those of the database
+func importRecords(db *sql.DB, dbImpl database.Database, src recordSource) (importStats, error) {
+	var stats importStats
+
+	clusterIDs := make(map[int64]int64)
+	err := src.eachCluster(func(c database.ArchiveCluster) error {
+		exists, targetType, err := lookupClusterType(db, dbImpl, c.Name)
+		if err != nil {
+			return fmt.Errorf("failed to import cluster %s: %w", c.Name, err)
+		}
+		clusterType := c.Type
+		if targetType != "" && c.Type != "" && targetType != c.Type {
+			stats.TypeConflicts = append(stats.TypeConflicts,
+				typeConflict{Cluster: c.Name, TargetType: targetType, SourceType: c.Type})
+			clusterType = ""
+		}
+		id, err := dbImpl.GetOrCreateCluster(db, c.Name, clusterType)
+		if err != nil {
+			return fmt.Errorf("failed to import cluster %s: %w", c.Name, err)
+		}
+		clusterIDs[c.ID] = id
+		stats.Clusters++
+		if !exists {
+			stats.ClustersWritten++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	runIDs := make(map[int64]int64)
+	err = src.eachRun(func(run database.ArchiveRun) error {
+		clusterID, ok := clusterIDs[run.ClusterID]
+		if !ok {
+			return fmt.Errorf("run %d references cluster %d, which is not in the source", run.ID, run.ClusterID)
+		}
+		id, created, err := dbImpl.ImportCollectionRun(db, clusterID, run)
+		if err != nil {
+			return fmt.Errorf("failed to import run %d: %w", run.ID, err)
+		}
+		runIDs[run.ID] = id
+		stats.Runs++
+		if created {
+			stats.RunsWritten++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// Definitions are recorded per run, and deduplicated by hash
+	definitionsBefore, err := countRows(db, "kpi_definitions")
+	if err != nil {
+		return stats, err
+	}
+	runDefinitions := make(map[int64][]database.KPIDefinition)
+	var runOrder []int64
+	err = src.eachDefinition(func(d database.ArchiveDefinition) error {
+		for _, sourceRunID := range d.RunIDs {
+			runID, ok := runIDs[sourceRunID]
+			if !ok {
+				return fmt.Errorf("KPI definition %d references run %d, which is not in the source", d.ID, sourceRunID)
+			}
+			if _, seen := runDefinitions[runID]; !seen {
+				runOrder = append(runOrder, runID)
+			}
+			runDefinitions[runID] = append(runDefinitions[runID], d.KPIDefinition())
+		}
+		stats.Definitions++
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	for _, runID := range runOrder {
+		if err := dbImpl.RecordKPIDefinitions(db, runID, runDefinitions[runID]); err != nil {
+			return stats, fmt.Errorf("failed to import the KPI definitions of run %d: %w", runID, err)
+		}
+	}
+	definitionsAfter, err := countRows(db, "kpi_definitions")
+	if err != nil {
+		return stats, err
+	}
+	stats.DefinitionsWritten = definitionsAfter - definitionsBefore
+
+	batch := make([]database.ArchiveSample, 0, importBatchSize)
+	flush := func() error {
+		written, err := dbImpl.ImportQueryResults(db, batch)
+		if err != nil {
+			return fmt.Errorf("failed to import samples: %w", err)
+		}
+		stats.SamplesWritten += written
+		batch = batch[:0]
+		return nil
+	}
+	err = src.eachSample(func(s database.ArchiveSample) error {
+		clusterID, ok := clusterIDs[s.ClusterID]
+		if !ok {
+			return fmt.Errorf("sample of %s references cluster %d, which is not in the source", s.KPIID, s.ClusterID)
+		}
+		s.ClusterID = clusterID
+		if s.RunID != 0 {
+			runID, ok := runIDs[s.RunID]
+			if !ok {
+				return fmt.Errorf("sample of %s references run %d, which is not in the source", s.KPIID, s.RunID)
+			}
+			s.RunID = runID
+		}
+
+		batch = append(batch, s)
+		stats.Samples++
+		if len(batch) == importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, flush()
+}
+
+// lookupClusterType reports whether the database has a cluster of this name,
+// and its type
+func lookupClusterType(db *sql.DB, dbImpl database.Database, clusterName string) (bool, string, error) {
+	query := "SELECT COALESCE(cluster_type, '') FROM clusters WHERE cluster_name = $1"
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	var clusterType string
+	err := db.QueryRow(query, clusterName).Scan(&clusterType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, "", nil
+	}
+	return err == nil, clusterType, err
+}
+
+// printTypeConflicts warns about the clusters whose type was not imported
+func printTypeConflicts(conflicts []typeConflict, source string) {
+	for _, c := range conflicts {
+		fmt.Printf("⚠ Cluster %s: kept type %q, %s has %q\n", c.Cluster, c.TargetType, source, c.SourceType)
+	}
+}
+
+// countRows returns the number of rows of a table
+func countRows(db *sql.DB, table string) (int64, error) {
+	var count int64
+	err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count)
+	return count, err
+}
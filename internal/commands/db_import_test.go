@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/archive"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+var _ = Describe("db export and db import", func() {
+	var (
+		source      *sql.DB
+		sqliteDB    *database.SQLiteDB
+		archivePath string
+	)
+
+	// openDB initializes a SQLite database in a new temporary directory
+	openDB := func() *sql.DB {
+		tmpDir, err := os.MkdirTemp("", "db-import-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, tmpDir)
+
+		database.OutputDir = tmpDir
+		db, err := sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+		return db
+	}
+
+	BeforeEach(func() {
+		DeferCleanup(func() { database.OutputDir = database.DefaultOutputDir })
+		sqliteDB = database.NewSQLiteDB()
+		source = openDB()
+		archivePath = filepath.Join(database.OutputDir, "bundle.tar.gz")
+
+		// A cluster that is not exported, so that the exported IDs differ
+		// from those of the target database
+		_, err := sqliteDB.GetOrCreateCluster(source, "other", "")
+		Expect(err).NotTo(HaveOccurred())
+		clusterID, err := sqliteDB.GetOrCreateCluster(source, "prod", "ran")
+		Expect(err).NotTo(HaveOccurred())
+
+		runID, err := sqliteDB.StartCollectionRun(source, clusterID, database.CollectionRun{
+			Frequency: time.Minute, ToolVersion: "v1.2.3",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqliteDB.RecordKPIDefinitions(source, runID, []database.KPIDefinition{
+			{KPIID: "cpu", PromQuery: "rate(cpu[1m])", ResolvedPromQuery: "rate(cpu[1m])", QueryType: "instant", Frequency: time.Minute},
+		})).To(Succeed())
+
+		for i, value := range []float64{0.2, 0.4} {
+			_, err = source.Exec(
+				"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels, run_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				"cpu", value, 1700000000.0+60*float64(i), clusterID, "2026-01-01 00:00:00", `{"cpu":"0"}`, runID,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = source.Exec(
+			"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels) VALUES (?, ?, ?, ?, ?, ?)",
+			"memory", nil, 1690000000.0, clusterID, "2026-01-01 00:00:00", nil,
+		)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should export a cluster with a checksummed manifest", func() {
+		manifest, err := exportArchive(source, sqliteDB, KPIQueryParams{ClusterName: "prod"}, archivePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archiveSummary(manifest)).To(Equal("1 cluster(s), 1 run(s), 1 KPI definition(s) and 3 samples"))
+
+		r, err := archive.Open(archivePath)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = r.Close() }()
+		Expect(r.Manifest.Format).To(Equal(archive.FormatName))
+		Expect(r.Manifest.Filter.ClusterName).To(Equal("prod"))
+		Expect(r.Manifest.SchemaVersion).To(BeNumerically(">", 0))
+	})
+
+	It("should only export the samples of the time window and their runs", func() {
+		since := time.Unix(1695000000, 0)
+		manifest, err := exportArchive(source, sqliteDB, KPIQueryParams{Since: &since}, archivePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archiveSummary(manifest)).To(Equal("2 cluster(s), 1 run(s), 1 KPI definition(s) and 2 samples"))
+
+		until := time.Unix(1695000000, 0)
+		manifest, err = exportArchive(source, sqliteDB, KPIQueryParams{Until: &until}, archivePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archiveSummary(manifest)).To(Equal("2 cluster(s), 0 run(s), 0 KPI definition(s) and 1 samples"))
+	})
+
+	It("should import an archive with remapped IDs, once", func() {
+		_, err := exportArchive(source, sqliteDB, KPIQueryParams{ClusterName: "prod"}, archivePath)
+		Expect(err).NotTo(HaveOccurred())
+
+		target := openDB()
+		r, err := archive.Open(archivePath)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = r.Close() }()
+
+		stats, err := importRecords(target, sqliteDB, archiveSource{reader: r})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(importStats{
+			Clusters: 1, ClustersWritten: 1, Runs: 1, RunsWritten: 1,
+			Definitions: 1, DefinitionsWritten: 1, Samples: 3, SamplesWritten: 3,
+		}))
+
+		var clusterID int64
+		var clusterType string
+		Expect(target.QueryRow("SELECT id, cluster_type FROM clusters WHERE cluster_name = 'prod'").Scan(&clusterID, &clusterType)).To(Succeed())
+		Expect(clusterID).To(Equal(int64(1)))
+		Expect(clusterType).To(Equal("ran"))
+
+		var toolVersion string
+		Expect(target.QueryRow("SELECT tool_version FROM collection_runs WHERE cluster_id = ?", clusterID).Scan(&toolVersion)).To(Succeed())
+		Expect(toolVersion).To(Equal("v1.2.3"))
+
+		definitions, err := listDefinitions(target, sqliteDB, "cpu", 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(definitions).To(HaveLen(1))
+
+		var samples, unlabelled, runSamples int
+		Expect(target.QueryRow(
+			"SELECT COUNT(*), COUNT(*) - COUNT(metric_labels), COUNT(run_id) FROM query_results WHERE cluster_id = ?", clusterID,
+		).Scan(&samples, &unlabelled, &runSamples)).To(Succeed())
+		Expect(samples).To(Equal(3))
+		Expect(unlabelled).To(Equal(1))
+		Expect(runSamples).To(Equal(2))
+
+		By("importing the archive again")
+		stats, err = importRecords(target, sqliteDB, archiveSource{reader: r})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats).To(Equal(importStats{Clusters: 1, Runs: 1, Definitions: 1, Samples: 3}))
+	})
+
+	It("should export the samples of a file database, and refuse to modify it", func() {
+		DeferCleanup(func() { dbFlags.DatabaseType = "" })
+		dbFlags.DatabaseType = "file"
+
+		fileDB := database.NewFileDB()
+		collection, err := fileDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(collection.Close)
+		clusterID, err := fileDB.GetOrCreateCluster(collection, "lab", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileDB.StoreQueryResults(collection, clusterID, 0, "cpu", model.Vector{
+			{Metric: model.Metric{"cpu": "0"}, Value: 0.2, Timestamp: 1700000000000},
+			{Metric: model.Metric{"cpu": "1"}, Value: 0.4, Timestamp: 1700000000000},
+		})).To(Succeed())
+		Expect(fileDB.FinishCollectionRun(collection, 0, database.CollectionRunResult{})).To(Succeed())
+
+		db, dbImpl, err := connectToDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+		Expect(dbImpl).To(BeAssignableToTypeOf(&database.SQLiteDB{}))
+
+		manifest, err := exportArchive(db, dbImpl, KPIQueryParams{ClusterName: "lab"}, archivePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archiveSummary(manifest)).To(Equal("1 cluster(s), 0 run(s), 0 KPI definition(s) and 2 samples"))
+
+		_, _, err = connectToWritableDB()
+		Expect(err).To(MatchError(errFileReadOnly))
+	})
+})
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+
+	"github.com/spf13/cobra"
+)
+
+var mergeFlags struct {
+	into string
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <source>...",
+	Short: "Merge SQLite artifact databases into one",
+	Long: `Merge the SQLite databases of several artifact directories, e.g. collected by
+different engineers or sites, into one SQLite database, which is created if it
+does not exist yet. Sources are database files or artifacts directories holding
+kpi_metrics.db.
+
+Sources are merged in order as by 'db import': clusters are unified by name, the
+cluster and run IDs of every row are rewritten to those of the target, and
+samples already stored are skipped. The query error and retry counts of each
+cluster and KPI are added up.
+
+Unlike samples, runs and definitions, the error and retry counts are not
+deduplicated: merging a source again, e.g. after more data was collected into
+it, adds its counts again. Merge each source once into a fresh target to keep
+them exact.
+
+When sources disagree on the type of a cluster, the first type merged is kept
+and the conflict is reported.`,
+	Example: `  # Merge the artifacts of two sites
+  kpi-collector db merge --into merged.db site-a/kpi-collector-artifacts site-b/kpi-collector-artifacts
+
+  # Merge every artifacts database below the current directory
+  kpi-collector db merge --into merged.db */kpi-collector-artifacts/kpi_metrics.db`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runMerge,
+}
+
+func init() {
+	dbCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringVar(&mergeFlags.into, "into", "",
+		"SQLite database file to merge into (required)")
+	_ = mergeCmd.MarkFlagRequired("into")
+}
+
+// mergeStats counts the records merged from a source database
+type mergeStats struct {
+	importStats
+
+	Counters             int64 // error and retry counts of a cluster and KPI
+	UnattributedCounters int64 // counts of an unknown cluster, not merged
+}
+
+// add accumulates the counts of another source
+func (s *mergeStats) add(other mergeStats) {
+	s.Clusters += other.Clusters
+	s.ClustersWritten += other.ClustersWritten
+	s.Runs += other.Runs
+	s.RunsWritten += other.RunsWritten
+	s.Definitions += other.Definitions
+	s.DefinitionsWritten += other.DefinitionsWritten
+	s.Samples += other.Samples
+	s.SamplesWritten += other.SamplesWritten
+	s.TypeConflicts = append(s.TypeConflicts, other.TypeConflicts...)
+	s.Counters += other.Counters
+	s.UnattributedCounters += other.UnattributedCounters
+}
+
+// records returns the counts of each table
+func (s mergeStats) records() []output.TransferRecord {
+	return append(s.importStats.records(),
+		output.TransferRecord{Table: "query_errors/query_retries", Read: s.Counters + s.UnattributedCounters, Written: s.Counters})
+}
+
+func runMerge(cmd *cobra.Command, args []string) error {
+	target := database.SQLiteAt(mergeFlags.into)
+
+	sources := make([]*database.SQLiteDB, len(args))
+	for i, arg := range args {
+		sources[i] = database.SQLiteAt(arg)
+		if database.SameDatabase(sources[i], target) {
+			return fmt.Errorf("%s is the --into database", arg)
+		}
+		if _, err := os.Stat(sources[i].Path); err != nil {
+			return fmt.Errorf("SQLite database not found at %s", sources[i].Path)
+		}
+	}
+
+	targetDB, err := target.InitDB()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", target.Path, err)
+	}
+	defer func() { _ = targetDB.Close() }()
+
+	var total mergeStats
+	for _, source := range sources {
+		stats, err := mergeSource(targetDB, target, source)
+		if err != nil {
+			return fmt.Errorf("failed to merge %s: %w", source.Path, err)
+		}
+
+		fmt.Printf("✓ Merged %s\n", source.Path)
+		printTypeConflicts(stats.TypeConflicts, source.Path)
+		if stats.UnattributedCounters > 0 {
+			fmt.Printf("⚠ Skipped %d error or retry count(s) of an unknown cluster in %s\n",
+				stats.UnattributedCounters, source.Path)
+		}
+		total.add(stats)
+	}
+
+	fmt.Printf("\nMerged %d database(s) into %s", len(sources), target.Path)
+	if len(total.TypeConflicts) > 0 {
+		fmt.Printf(" with %d cluster type conflict(s)", len(total.TypeConflicts))
+	}
+	fmt.Print("\n\n")
+	output.PrintTransferTable(total.records())
+	return nil
+}
+
+// mergeSource merges the records and query error and retry counts of a
+// SQLite database into the target database
+func mergeSource(targetDB *sql.DB, target database.Database, source *database.SQLiteDB) (mergeStats, error) {
+	var stats mergeStats
+
+	sourceDB, err := source.InitDB()
+	if err != nil {
+		return stats, err
+	}
+	defer func() { _ = sourceDB.Close() }()
+
+	stats.importStats, err = importRecords(targetDB, target, &databaseSource{db: sourceDB, dbImpl: source})
+	if err != nil {
+		return stats, err
+	}
+
+	counts, err := listErrors(sourceDB, source, "")
+	if err != nil {
+		return stats, fmt.Errorf("failed to list query errors: %w", err)
+	}
+	for _, c := range counts {
+		if c.ClusterName == "" {
+			stats.UnattributedCounters++
+			continue
+		}
+		clusterID, err := target.GetOrCreateCluster(targetDB, c.ClusterName, "")
+		if err != nil {
+			return stats, err
+		}
+		if err := target.AddQueryCounts(targetDB, clusterID, c.KPIID, int64(c.ErrorCount), int64(c.RetryCount)); err != nil {
+			return stats, err
+		}
+		stats.Counters++
+	}
+	return stats, nil
+}
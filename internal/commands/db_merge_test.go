@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+var _ = Describe("db merge", func() {
+	var (
+		tmpDir   string
+		target   *database.SQLiteDB
+		targetDB *sql.DB
+	)
+
+	// newSource creates an artifacts directory whose database has one sample
+	// and query error of a cluster
+	newSource := func(name, clusterName, clusterType string, errors int) *database.SQLiteDB {
+		dir := filepath.Join(tmpDir, name)
+		Expect(os.Mkdir(dir, 0755)).To(Succeed())
+		source := database.SQLiteAt(dir)
+		db, err := source.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = db.Close() }()
+
+		// A cluster of its own, so that the IDs of the sources collide
+		_, err = source.GetOrCreateCluster(db, name, "")
+		Expect(err).NotTo(HaveOccurred())
+		clusterID, err := source.GetOrCreateCluster(db, clusterName, clusterType)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Exec(
+			"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, metric_labels) VALUES (?, ?, ?, ?, ?)",
+			"cpu", 0.5, 1700000000.0+float64(errors), clusterID, `{}`,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		for range errors {
+			Expect(source.IncrementQueryError(db, clusterID, "cpu")).To(Succeed())
+		}
+		Expect(source.IncrementQueryRetry(db, clusterID, "cpu")).To(Succeed())
+		return source
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-merge-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, tmpDir)
+
+		target = database.SQLiteAt(filepath.Join(tmpDir, "merged.db"))
+		targetDB, err = target.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(targetDB.Close)
+	})
+
+	It("should unify clusters by name and add up their query error counts", func() {
+		siteA := newSource("site-a", "prod", "ran", 2)
+		siteB := newSource("site-b", "prod", "core", 3)
+
+		var total mergeStats
+		for _, source := range []*database.SQLiteDB{siteA, siteB} {
+			stats, err := mergeSource(targetDB, target, source)
+			Expect(err).NotTo(HaveOccurred())
+			total.add(stats)
+		}
+
+		Expect(total.TypeConflicts).To(Equal([]typeConflict{{Cluster: "prod", TargetType: "ran", SourceType: "core"}}))
+		Expect(total.Clusters).To(Equal(int64(4)))
+		Expect(total.ClustersWritten).To(Equal(int64(3)))
+		Expect(total.SamplesWritten).To(Equal(int64(2)))
+		Expect(total.Counters).To(Equal(int64(2)))
+
+		clusterID, err := target.GetOrCreateCluster(targetDB, "prod", "")
+		Expect(err).NotTo(HaveOccurred())
+		var clusterType string
+		Expect(targetDB.QueryRow("SELECT cluster_type FROM clusters WHERE id = ?", clusterID).Scan(&clusterType)).To(Succeed())
+		Expect(clusterType).To(Equal("ran"))
+
+		var samples int
+		Expect(targetDB.QueryRow("SELECT COUNT(*) FROM query_results WHERE cluster_id = ?", clusterID).Scan(&samples)).To(Succeed())
+		Expect(samples).To(Equal(2))
+
+		errorCount, err := target.GetQueryErrorCount(targetDB, clusterID, "cpu")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errorCount).To(Equal(5))
+		retryCount, err := target.GetQueryRetryCount(targetDB, clusterID, "cpu")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retryCount).To(Equal(2))
+	})
+})
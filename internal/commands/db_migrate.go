@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateDryRun bool
+	migrateTo     int
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the database schema",
+	Long: `Apply pending schema migrations to the database.
+
+'kpi-collector run' and the other db commands migrate the database automatically.
+Use this command to inspect pending migrations, or to upgrade a shared database
+explicitly before pointing several collectors at it.
+
+Databases migrated by a newer kpi-collector are refused; upgrade kpi-collector instead.`,
+	Example: `  # Show the current schema version and the pending migrations
+  kpi-collector db migrate --dry-run
+
+  # Apply all pending migrations
+  kpi-collector db migrate
+
+  # Migrate up to a specific version
+  kpi-collector db migrate --to 3`,
+	RunE: runMigrate,
+}
+
+func init() {
+	dbCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false,
+		"list pending migrations without applying them")
+	migrateCmd.Flags().IntVar(&migrateTo, "to", 0,
+		"schema version to migrate to (default: latest)")
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	dbImpl, err := resolveDB(dbSettings())
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	if _, ok := dbImpl.(*database.FileDB); ok {
+		return errFileReadOnly
+	}
+
+	db, err := dbImpl.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	return migrateDB(db, dbImpl, migrateTo, migrateDryRun)
+}
+
+// migrateDB migrates the database to the target version (0 = latest), or only
+// lists the pending migrations when dryRun is set
+func migrateDB(db *sql.DB, dbImpl database.Database, target int, dryRun bool) error {
+	latest := database.LatestVersion(dbImpl)
+	if target == 0 {
+		target = latest
+	}
+
+	current, pending, err := database.PendingMigrations(db, dbImpl, target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version: %d (latest: %d)\n", current, latest)
+
+	if len(pending) == 0 {
+		fmt.Println("✓ Database schema is up to date.")
+		return nil
+	}
+
+	if dryRun {
+		fmt.Println("Pending migrations:")
+		for _, migration := range pending {
+			fmt.Printf("  %d: %s\n", migration.Version, migration.Description)
+		}
+		return nil
+	}
+
+	applied, err := database.Migrate(db, dbImpl, target)
+	for _, migration := range applied {
+		fmt.Printf("✓ Applied migration %d: %s\n", migration.Version, migration.Description)
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"database/sql"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+var _ = Describe("db migrate", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		tmpDir   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-migrate-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.Connect()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			_ = db.Close()
+		}
+		database.OutputDir = database.DefaultOutputDir
+		_ = os.RemoveAll(tmpDir)
+	})
+
+	schemaVersion := func() int {
+		version, err := sqliteDB.SchemaVersion(db)
+		Expect(err).NotTo(HaveOccurred())
+		return version
+	}
+
+	It("should only list pending migrations on a dry run", func() {
+		Expect(migrateDB(db, sqliteDB, 0, true)).To(Succeed())
+		Expect(schemaVersion()).To(Equal(0))
+	})
+
+	It("should migrate to the requested version and then to the latest", func() {
+		Expect(migrateDB(db, sqliteDB, 2, false)).To(Succeed())
+		Expect(schemaVersion()).To(Equal(2))
+
+		Expect(migrateDB(db, sqliteDB, 0, false)).To(Succeed())
+		Expect(schemaVersion()).To(Equal(database.LatestVersion(sqliteDB)))
+	})
+
+	It("should refuse a database migrated by a newer version", func() {
+		Expect(migrateDB(db, sqliteDB, 0, false)).To(Succeed())
+		_, err := db.Exec("INSERT INTO schema_migrations (version, description) VALUES (?, 'from the future')",
+			database.LatestVersion(sqliteDB)+1)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(migrateDB(db, sqliteDB, 0, true)).To(MatchError(database.ErrSchemaTooNew))
+	})
+})
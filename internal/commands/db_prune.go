@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var pruneFlags struct {
+	olderThan   time.Duration
+	downsample  time.Duration
+	clusterName string
+	dryRun      bool
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete or downsample old KPI samples",
+	Long: `Delete raw KPI samples whose Prometheus timestamp is older than --older-than.
+
+With --downsample the samples are first rolled up into buckets of the given size,
+keeping the minimum, maximum, average and count of each KPI and label set per bucket
+in the query_rollups table. Downsampling only removes whole buckets, so samples in
+the bucket that contains the cutoff are kept until a later prune.
+
+WARNING: Deleted raw samples cannot be restored. Use --dry-run to see what would be pruned.`,
+	Example: `  # Delete samples older than 30 days
+  kpi-collector db prune --older-than 30d
+
+  # Keep hourly min/max/avg/count of samples older than 7 days
+  kpi-collector db prune --older-than 7d --downsample 1h
+
+  # Preview pruning a single cluster
+  kpi-collector db prune --older-than 30d --cluster-name="mycluster1" --dry-run`,
+	RunE: runPrune,
+}
+
+func init() {
+	dbCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().Var(newAgeValue(0, &pruneFlags.olderThan), "older-than",
+		"prune samples older than this age, e.g. 30d or 12h (required)")
+	pruneCmd.Flags().Var(newAgeValue(0, &pruneFlags.downsample), "downsample",
+		"roll pruned samples up into buckets of this size before deleting them, e.g. 1h or 1d")
+	pruneCmd.Flags().StringVar(&pruneFlags.clusterName, "cluster-name", "",
+		"only prune the samples of this cluster")
+	pruneCmd.Flags().BoolVar(&pruneFlags.dryRun, "dry-run", false,
+		"show what would be pruned without changing the database")
+	_ = pruneCmd.MarkFlagRequired("older-than")
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	if pruneFlags.olderThan <= 0 {
+		return fmt.Errorf("--older-than must be greater than 0")
+	}
+	if pruneFlags.downsample < 0 || pruneFlags.downsample%time.Second != 0 {
+		return fmt.Errorf("--downsample must be a positive whole number of seconds")
+	}
+
+	db, dbImpl, err := connectToWritableDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	policy := database.RetentionPolicy{
+		Before:     time.Now().Add(-pruneFlags.olderThan),
+		Downsample: pruneFlags.downsample,
+		DryRun:     pruneFlags.dryRun,
+	}
+
+	if pruneFlags.clusterName != "" {
+		cluster, err := findCluster(db, dbImpl, pruneFlags.clusterName)
+		if err != nil {
+			return err
+		}
+		policy.ClusterID = cluster.ID
+	}
+
+	result, err := dbImpl.PruneQueryResults(db, policy)
+	if err != nil {
+		return fmt.Errorf("failed to prune samples: %w", err)
+	}
+
+	fmt.Println(pruneSummary(result, policy))
+	return nil
+}
+
+// pruneSummary describes the outcome of a prune for the user
+func pruneSummary(result database.PruneResult, policy database.RetentionPolicy) string {
+	cutoff := result.Cutoff.UTC().Format(time.RFC3339)
+	if result.Deleted == 0 {
+		return fmt.Sprintf("No samples recorded before %s.", cutoff)
+	}
+
+	samples := humanize.Comma(result.Deleted)
+	switch {
+	case policy.DryRun && policy.Downsample > 0:
+		return fmt.Sprintf("Dry run: would roll %s samples recorded before %s up into %s bucket(s) of %s and delete them.",
+			samples, cutoff, humanize.Comma(result.Buckets), policy.Downsample)
+	case policy.DryRun:
+		return fmt.Sprintf("Dry run: would delete %s samples recorded before %s.", samples, cutoff)
+	case policy.Downsample > 0:
+		return fmt.Sprintf("✓ Rolled %s samples recorded before %s up into %s bucket(s) of %s and deleted them.",
+			samples, cutoff, humanize.Comma(result.Buckets), policy.Downsample)
+	default:
+		return fmt.Sprintf("✓ Deleted %s samples recorded before %s.", samples, cutoff)
+	}
+}
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"database/sql"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+var _ = Describe("db prune", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		tmpDir   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-prune-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, name := range []string{"cluster-a", "cluster-b"} {
+			clusterID, err := sqliteDB.GetOrCreateCluster(db, name, "")
+			Expect(err).NotTo(HaveOccurred())
+			for _, age := range []time.Duration{40 * 24 * time.Hour, time.Hour} {
+				vector := model.Vector{&model.Sample{
+					Metric:    model.Metric{"__name__": "up"},
+					Value:     1,
+					Timestamp: model.TimeFromUnix(time.Now().Add(-age).Unix()),
+				}}
+				Expect(sqliteDB.StoreQueryResults(db, clusterID, 0, "kpi-1", vector)).To(Succeed())
+			}
+		}
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			_ = db.Close()
+		}
+		database.OutputDir = database.DefaultOutputDir
+		pruneFlags.olderThan = 0
+		pruneFlags.downsample = 0
+		pruneFlags.clusterName = ""
+		pruneFlags.dryRun = false
+		_ = os.RemoveAll(tmpDir)
+	})
+
+	countTable := func(table string) int {
+		var count int
+		Expect(db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count)).To(Succeed())
+		return count
+	}
+
+	It("should downsample and delete the old samples of one cluster", func() {
+		Expect(pruneCmd.Flags().Set("older-than", "30d")).To(Succeed())
+		Expect(pruneCmd.Flags().Set("downsample", "1d")).To(Succeed())
+		pruneFlags.clusterName = "cluster-a"
+
+		Expect(runPrune(nil, nil)).To(Succeed())
+
+		Expect(countTable("query_results")).To(Equal(3))
+		Expect(countTable("query_rollups")).To(Equal(1))
+	})
+
+	It("should not change the database on a dry run", func() {
+		pruneFlags.olderThan = 30 * 24 * time.Hour
+		pruneFlags.dryRun = true
+
+		Expect(runPrune(nil, nil)).To(Succeed())
+
+		Expect(countTable("query_results")).To(Equal(4))
+	})
+
+	It("should reject an unknown cluster", func() {
+		pruneFlags.olderThan = time.Hour
+		pruneFlags.clusterName = "missing-cluster"
+		Expect(runPrune(nil, nil)).To(MatchError("cluster 'missing-cluster' not found"))
+	})
+
+	It("should require a positive age", func() {
+		Expect(runPrune(nil, nil)).To(MatchError("--older-than must be greater than 0"))
+	})
+
+	Describe("pruneSummary", func() {
+		cutoff := time.Date(2026, 9, 16, 0, 0, 0, 0, time.UTC)
+
+		DescribeTable("should describe the outcome",
+			func(result database.PruneResult, policy database.RetentionPolicy, expected string) {
+				result.Cutoff = cutoff
+				Expect(pruneSummary(result, policy)).To(Equal(expected))
+			},
+			Entry("nothing to prune", database.PruneResult{}, database.RetentionPolicy{},
+				"No samples recorded before 2026-09-16T00:00:00Z."),
+			Entry("deletion", database.PruneResult{Deleted: 1234}, database.RetentionPolicy{},
+				"✓ Deleted 1,234 samples recorded before 2026-09-16T00:00:00Z."),
+			Entry("downsampling dry run", database.PruneResult{Deleted: 1234, Buckets: 56},
+				database.RetentionPolicy{Downsample: time.Hour, DryRun: true},
+				"Dry run: would roll 1,234 samples recorded before 2026-09-16T00:00:00Z up into 56 bucket(s) of 1h0m0s and delete them."),
+		)
+	})
+})
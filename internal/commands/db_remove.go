@@ -1,19 +1,37 @@
 package commands
 
 import (
+	"bufio"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 
 	"github.com/dustin/go-humanize"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/spf13/cobra"
 )
 
+// clusterScopedTables lists the tables (besides query_results) whose rows
+// reference a cluster and must be removed together with it
+var clusterScopedTables = []string{"alerts", "collection_overruns", "collection_runs", "query_error_events", "query_errors", "query_retries"}
+
 var (
-	removeClusterName string
-	removeKPIName     string
-	removeAll         bool
+	removeClusterName  string
+	removeKPIName      string
+	removeAll          bool
+	removeSince        string
+	removeUntil        string
+	removeLabelsFilter string
+	removeDryRun       bool
+	removeYes          bool
 )
 
 var removeCmd = &cobra.Command{
@@ -27,7 +45,7 @@ WARNING: All remove operations are immediate and cannot be undone.`,
 var removeClustersCmd = &cobra.Command{
 	Use:   "clusters",
 	Short: "Remove a cluster and all its data",
-	Long: `Delete a cluster record and all associated KPI metrics from the database.
+	Long: `Delete a cluster record with all associated KPI metrics, downsampled metrics, collection runs and error records from the database.
 
 WARNING: This operation cannot be undone. All metric samples for the cluster will be permanently deleted.`,
 	Example: `  # Remove a cluster
@@ -38,21 +56,38 @@ WARNING: This operation cannot be undone. All metric samples for the cluster wil
 var removeKPIsCmd = &cobra.Command{
 	Use:   "kpis",
 	Short: "Remove KPI metrics",
-	Long:  `Delete KPI metrics from the database, optionally filtered by cluster and KPI name.`,
+	Long: `Delete KPI metrics and their downsampled rollups from the database, optionally filtered by
+KPI name, time window and labels.
+
+The number of matching samples per KPI is printed and confirmation is asked before deleting.
+Use --dry-run to only print the counts, or --yes to delete without confirmation.
+With --since/--until, rollup buckets are only removed when they lie entirely inside the window.`,
 	Example: `  # Remove all KPIs from a cluster
   kpi-collector db remove kpis --cluster-name="mycluster1"
   
   # Remove specific KPI from a cluster
-  kpi-collector db remove kpis --cluster-name="mycluster1" --name="cpu-system"`,
+  kpi-collector db remove kpis --cluster-name="mycluster1" --name="cpu-system"
+
+  # Preview removing the samples of one node during a time window
+  kpi-collector db remove kpis --cluster-name="mycluster1" \
+    --since="2026-04-07T12:00:00Z" --until="2026-04-07T12:30:00Z" \
+    --labels-filter="instance=worker-1" --dry-run
+
+  # Remove the samples of the last 2 hours without confirmation
+  kpi-collector db remove kpis --cluster-name="mycluster1" --since=2h --yes`,
 	RunE: runRemoveKPIs,
 }
 
 var removeErrorsCmd = &cobra.Command{
 	Use:   "errors",
 	Short: "Clear error counts",
-	Long:  `Reset error counts for KPI queries.`,
+	Long:  `Reset error and retry counts for KPI queries and delete their error details.`,
 	Example: `  # Clear errors for a specific KPI
   kpi-collector db remove errors --name="cpu-system"
+
+  # Clear errors of one cluster, optionally for a single KPI
+  kpi-collector db remove errors --cluster-name="mycluster1"
+  kpi-collector db remove errors --cluster-name="mycluster1" --name="cpu-system"
   
   # Clear all errors
   kpi-collector db remove errors --all`,
@@ -75,35 +110,42 @@ func init() {
 		"cluster name (required)")
 	removeKPIsCmd.Flags().StringVar(&removeKPIName, "name", "",
 		"KPI name to remove (optional)")
+	removeKPIsCmd.Flags().StringVar(&removeSince, "since", "",
+		"only remove samples at or after this time (Go duration like 2h, or RFC3339)")
+	removeKPIsCmd.Flags().StringVar(&removeUntil, "until", "",
+		"only remove samples at or before this time (Go duration like 1h, or RFC3339)")
+	removeKPIsCmd.Flags().StringVar(&removeLabelsFilter, "labels-filter", "",
+		"only remove samples matching these labels (e.g. 'instance=worker-1,job=node-exporter' or '{instance=~\"worker-.*\"}')")
+	removeKPIsCmd.Flags().BoolVar(&removeDryRun, "dry-run", false,
+		"print the number of matching samples per KPI without deleting them")
+	removeKPIsCmd.Flags().BoolVar(&removeYes, "yes", false,
+		"delete without asking for confirmation")
 	_ = removeKPIsCmd.MarkFlagRequired("cluster-name")
 
 	// Flags for 'remove errors'
 	removeErrorsCmd.Flags().StringVar(&removeKPIName, "name", "",
 		"KPI name to clear errors for")
+	removeErrorsCmd.Flags().StringVar(&removeClusterName, "cluster-name", "",
+		"cluster name to clear errors for")
 	removeErrorsCmd.Flags().BoolVar(&removeAll, "all", false,
 		"clear all error records")
 }
 
 func runRemoveClusters(cmd *cobra.Command, args []string) error {
-	db, dbImpl, err := connectToDB()
+	db, dbImpl, err := connectToWritableDB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
 	defer func() { _ = db.Close() }()
 
-	cluster, metricsDeleted, err := deleteClusterMetrics(db, dbImpl, removeClusterName, "")
+	cluster, err := findCluster(db, dbImpl, removeClusterName)
 	if err != nil {
 		return err
 	}
 
-	deleteQuery := "DELETE FROM clusters WHERE id = $1"
-	if _, ok := dbImpl.(*database.SQLiteDB); ok {
-		deleteQuery = convertPostgresToSQLitePlaceholders(deleteQuery)
-	}
-
-	_, err = db.Exec(deleteQuery, cluster.ID)
+	metricsDeleted, err := deleteCluster(db, dbImpl, cluster.ID)
 	if err != nil {
-		return fmt.Errorf("failed to delete cluster: %w", err)
+		return err
 	}
 
 	fmt.Printf("✓ Deleted cluster '%s' and %s metric samples.\n",
@@ -112,55 +154,126 @@ func runRemoveClusters(cmd *cobra.Command, args []string) error {
 }
 
 func runRemoveKPIs(cmd *cobra.Command, args []string) error {
-	db, dbImpl, err := connectToDB()
+	since, until, err := parseKPIQueryTimeWindow(removeSince, removeUntil, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var labelFilters []*labels.Matcher
+	if removeLabelsFilter != "" {
+		labelFilters, err = parseLabelFilters(removeLabelsFilter)
+		if err != nil {
+			return err
+		}
+	}
+
+	db, dbImpl, err := connectToWritableDB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
 	defer func() { _ = db.Close() }()
 
-	_, deleted, err := deleteClusterMetrics(db, dbImpl, removeClusterName, removeKPIName)
+	cluster, err := findCluster(db, dbImpl, removeClusterName)
 	if err != nil {
 		return err
 	}
 
-	if deleted == 0 {
+	selection := metricSelection{
+		ClusterID:    cluster.ID,
+		KPIName:      removeKPIName,
+		Since:        since,
+		Until:        until,
+		LabelFilters: labelFilters,
+	}
+
+	counts, err := countSelectedMetrics(db, dbImpl, selection)
+	if err != nil {
+		return err
+	}
+	if len(counts) == 0 {
 		fmt.Println("No metrics found matching the criteria.")
 		return nil
 	}
 
+	total := printMetricCounts(os.Stdout, counts)
+	if removeDryRun {
+		fmt.Println("Dry run: no metrics were deleted.")
+		return nil
+	}
+
+	if !removeYes {
+		confirmed, err := confirm(bufio.NewReader(os.Stdin),
+			fmt.Sprintf("Delete %s metric samples from cluster '%s'?", humanize.Comma(total), cluster.Name))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Println("Aborted, no metrics were deleted.")
+			return nil
+		}
+	}
+
+	deleted, err := deleteSelectedMetrics(db, dbImpl, selection)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("✓ Deleted %s metric samples.\n", humanize.Comma(deleted))
 	return nil
 }
 
 func runRemoveErrors(cmd *cobra.Command, args []string) error {
-	db, dbImpl, err := connectToDB()
+	db, dbImpl, err := connectToWritableDB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
 	defer func() { _ = db.Close() }()
 
-	var query string
+	if removeAll && (removeKPIName != "" || removeClusterName != "") {
+		return fmt.Errorf("--all cannot be combined with --name or --cluster-name")
+	}
+	if !removeAll && removeKPIName == "" && removeClusterName == "" {
+		return fmt.Errorf("must specify --name, --cluster-name, or --all")
+	}
+
+	var conditions []string
 	var queryArgs []interface{}
 
-	if removeAll {
-		query = "DELETE FROM query_errors"
-	} else if removeKPIName != "" {
-		query = "DELETE FROM query_errors WHERE kpi_id = $1"
+	if removeClusterName != "" {
+		cluster, err := findCluster(db, dbImpl, removeClusterName)
+		if err != nil {
+			return err
+		}
+		queryArgs = append(queryArgs, cluster.ID)
+		conditions = append(conditions, fmt.Sprintf("cluster_id = $%d", len(queryArgs)))
+	}
+
+	if removeKPIName != "" {
 		queryArgs = append(queryArgs, removeKPIName)
-	} else {
-		return fmt.Errorf("must specify either --name or --all")
+		conditions = append(conditions, fmt.Sprintf("kpi_id = $%d", len(queryArgs)))
 	}
 
-	if _, ok := dbImpl.(*database.SQLiteDB); ok {
-		query = convertPostgresToSQLitePlaceholders(query)
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	result, err := db.Exec(query, queryArgs...)
-	if err != nil {
-		return fmt.Errorf("failed to delete errors: %w", err)
+	// Retry counts and error details are cleared together with the error counts
+	var deleted int64
+	for _, table := range []string{"query_errors", "query_retries", "query_error_events"} {
+		query := "DELETE FROM " + table + where
+		if _, ok := dbImpl.(*database.SQLiteDB); ok {
+			query = convertPostgresToSQLitePlaceholders(query)
+		}
+
+		result, err := db.Exec(query, queryArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to delete errors: %w", err)
+		}
+		affected, _ := result.RowsAffected()
+		deleted += affected
 	}
 
-	deleted, _ := result.RowsAffected()
 	if deleted == 0 {
 		fmt.Println("No error records found.")
 		return nil
@@ -170,33 +283,242 @@ func runRemoveErrors(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func deleteClusterMetrics(db *sql.DB, dbImpl database.Database, clusterName, kpiName string) (*ClusterInfo, int64, error) {
+// findCluster returns the cluster with the given name
+func findCluster(db *sql.DB, dbImpl database.Database, clusterName string) (*ClusterInfo, error) {
 	clusters, err := listClusters(db, dbImpl, clusterName)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to query cluster: %w", err)
+		return nil, fmt.Errorf("failed to query cluster: %w", err)
 	}
 	if len(clusters) == 0 {
-		return nil, 0, fmt.Errorf("cluster '%s' not found", clusterName)
+		return nil, fmt.Errorf("cluster '%s' not found", clusterName)
+	}
+	return &clusters[0], nil
+}
+
+// metricSelection selects the metric samples of a cluster removed by 'db remove'.
+// Unset fields do not restrict the selection.
+type metricSelection struct {
+	ClusterID    int64
+	KPIName      string
+	Since        *time.Time
+	Until        *time.Time
+	LabelFilters []*labels.Matcher
+}
+
+// where returns the WHERE clause and its arguments selecting the rows of
+// query_results, or of query_rollups when rollups is set. Rollup buckets are
+// only selected when they lie entirely inside the time window.
+func (s metricSelection) where(dbImpl database.Database, rollups bool) (string, []interface{}) {
+	_, sqlite := dbImpl.(*database.SQLiteDB)
+
+	args := []interface{}{s.ClusterID}
+	conditions := []string{"cluster_id = $1"}
+	addCondition := func(format string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if s.KPIName != "" {
+		addCondition("kpi_id = %s", s.KPIName)
+	}
+
+	// Time filters use second precision, as in 'db show kpis'
+	if s.Since != nil {
+		column := "timestamp_value"
+		if rollups {
+			column = "bucket_start"
+		}
+		addCondition(column+" >= %s", float64(s.Since.Truncate(time.Second).Unix()))
+	}
+	if s.Until != nil {
+		column := "timestamp_value"
+		if rollups {
+			column = "bucket_start + bucket_seconds"
+		}
+		addCondition(column+" <= %s", float64(s.Until.Truncate(time.Second).Unix()))
+	}
+
+	labelConditions, labelArgs := labelFilterConditions(dbImpl, "metric_labels", s.LabelFilters, len(args)+1)
+	conditions = append(conditions, labelConditions...)
+	args = append(args, labelArgs...)
+
+	where := " WHERE " + strings.Join(conditions, " AND ")
+	if sqlite {
+		where = convertPostgresToSQLitePlaceholders(where)
+	}
+	return where, args
+}
+
+// metricCount is the number of selected samples and rollup buckets of a KPI
+type metricCount struct {
+	KPIID   string
+	Samples int64
+	Buckets int64
+}
+
+// countSelectedMetrics returns the number of selected samples and rollup buckets per KPI, ordered by KPI
+func countSelectedMetrics(db *sql.DB, dbImpl database.Database, selection metricSelection) ([]metricCount, error) {
+	byKPI := make(map[string]*metricCount)
+	for _, rollups := range []bool{false, true} {
+		table := "query_results"
+		if rollups {
+			table = "query_rollups"
+		}
+		where, args := selection.where(dbImpl, rollups)
+
+		rows, err := db.Query("SELECT kpi_id, COUNT(*) FROM "+table+where+" GROUP BY kpi_id", args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count metrics: %w", err)
+		}
+		for rows.Next() {
+			var kpiID string
+			var count int64
+			if err := rows.Scan(&kpiID, &count); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			if byKPI[kpiID] == nil {
+				byKPI[kpiID] = &metricCount{KPIID: kpiID}
+			}
+			if rollups {
+				byKPI[kpiID].Buckets = count
+			} else {
+				byKPI[kpiID].Samples = count
+			}
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	counts := make([]metricCount, 0, len(byKPI))
+	for _, count := range byKPI {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].KPIID < counts[j].KPIID })
+	return counts, nil
+}
+
+// printMetricCounts prints the selected samples and rollup buckets per KPI and returns the total number of samples
+func printMetricCounts(w io.Writer, counts []metricCount) int64 {
+	var samples, buckets int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KPI_ID\tSAMPLES\tROLLUP_BUCKETS")
+	for _, count := range counts {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", count.KPIID, humanize.Comma(count.Samples), humanize.Comma(count.Buckets))
+		samples += count.Samples
+		buckets += count.Buckets
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(w, "\nTotal: %s metric samples and %s rollup bucket(s)\n", humanize.Comma(samples), humanize.Comma(buckets))
+	return samples
+}
+
+// deleteSelectedMetrics deletes the selected samples and rollup buckets in one
+// transaction and returns the number of samples deleted
+func deleteSelectedMetrics(db *sql.DB, dbImpl database.Database, selection metricSelection) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	deleted, err := deleteMetrics(tx, dbImpl, selection)
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// deleteCluster removes a cluster with its metrics, runs and bookkeeping rows
+// in one transaction, so that a failure leaves the cluster whole. It returns
+// the number of raw samples deleted.
+func deleteCluster(db *sql.DB, dbImpl database.Database, clusterID int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	metricsDeleted, err := deleteMetrics(tx, dbImpl, metricSelection{ClusterID: clusterID})
+	if err != nil {
+		return 0, err
 	}
-	cluster := clusters[0]
 
-	query := "DELETE FROM query_results WHERE cluster_id = $1"
-	queryArgs := []interface{}{cluster.ID}
+	// Unlink the KPI definitions from the cluster's runs before the runs are removed
+	unlinkQuery := "DELETE FROM collection_run_definitions WHERE run_id IN (SELECT id FROM collection_runs WHERE cluster_id = $1)"
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		unlinkQuery = convertPostgresToSQLitePlaceholders(unlinkQuery)
+	}
+	if _, err := tx.Exec(unlinkQuery, clusterID); err != nil {
+		return 0, fmt.Errorf("failed to delete collection_run_definitions: %w", err)
+	}
 
-	if kpiName != "" {
-		query += " AND kpi_id = $2"
-		queryArgs = append(queryArgs, kpiName)
+	// Remove bookkeeping rows that reference the cluster before the cluster itself
+	for _, table := range clusterScopedTables {
+		query := fmt.Sprintf("DELETE FROM %s WHERE cluster_id = $1", table)
+		if _, ok := dbImpl.(*database.SQLiteDB); ok {
+			query = convertPostgresToSQLitePlaceholders(query)
+		}
+		if _, err := tx.Exec(query, clusterID); err != nil {
+			return 0, fmt.Errorf("failed to delete %s: %w", table, err)
+		}
 	}
 
+	deleteQuery := "DELETE FROM clusters WHERE id = $1"
 	if _, ok := dbImpl.(*database.SQLiteDB); ok {
-		query = convertPostgresToSQLitePlaceholders(query)
+		deleteQuery = convertPostgresToSQLitePlaceholders(deleteQuery)
+	}
+	if _, err := tx.Exec(deleteQuery, clusterID); err != nil {
+		return 0, fmt.Errorf("failed to delete cluster: %w", err)
 	}
 
-	result, err := db.Exec(query, queryArgs...)
+	// Definitions are shared between clusters; drop those no remaining run used
+	if _, err := tx.Exec("DELETE FROM kpi_definitions WHERE id NOT IN (SELECT definition_id FROM collection_run_definitions)"); err != nil {
+		return 0, fmt.Errorf("failed to delete unused KPI definitions: %w", err)
+	}
+
+	return metricsDeleted, tx.Commit()
+}
+
+// deleteMetrics deletes the selected samples and rollups within tx
+func deleteMetrics(tx *sql.Tx, dbImpl database.Database, selection metricSelection) (int64, error) {
+	// Downsampled metrics are removed with the raw samples
+	where, args := selection.where(dbImpl, true)
+	if _, err := tx.Exec("DELETE FROM query_rollups"+where, args...); err != nil {
+		return 0, fmt.Errorf("failed to delete downsampled metrics: %w", err)
+	}
+
+	where, args = selection.where(dbImpl, false)
+	result, err := tx.Exec("DELETE FROM query_results"+where, args...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to delete metrics: %w", err)
+		return 0, fmt.Errorf("failed to delete metrics: %w", err)
 	}
 
 	deleted, _ := result.RowsAffected()
-	return &cluster, deleted, nil
+	return deleted, nil
+}
+
+// confirm asks a yes/no question on stdout and reads the answer, defaulting to no
+func confirm(reader *bufio.Reader, question string) (bool, error) {
+	fmt.Printf("%s [y/N]: ", question)
+
+	input, err := reader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && input != "") {
+		return false, fmt.Errorf("no confirmation received (use --yes to delete without confirmation): %w", err)
+	}
+
+	switch strings.TrimSpace(strings.ToLower(input)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
 }
@@ -0,0 +1,326 @@
+package commands
+
+import (
+	"bufio"
+	"database/sql"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+)
+
+var _ = Describe("db remove", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		tmpDir   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-remove-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			_ = db.Close()
+		}
+		database.OutputDir = database.DefaultOutputDir
+		removeClusterName = ""
+		removeKPIName = ""
+		removeAll = false
+		removeSince = ""
+		removeUntil = ""
+		removeLabelsFilter = ""
+		removeDryRun = false
+		removeYes = false
+		_ = os.RemoveAll(tmpDir)
+	})
+
+	countRows := func(table string, clusterID int64) int {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE cluster_id = ?", clusterID).Scan(&count)
+		Expect(err).NotTo(HaveOccurred())
+		return count
+	}
+
+	Describe("clusters", func() {
+		It("should delete the cluster with its metrics, rollups, runs, alerts, overrun records and errors", func() {
+			clusterID, err := sqliteDB.GetOrCreateCluster(db, "old-cluster", "ran")
+			Expect(err).NotTo(HaveOccurred())
+			keptID, err := sqliteDB.GetOrCreateCluster(db, "kept-cluster", "ran")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, id := range []int64{clusterID, keptID} {
+				vector := model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1, Timestamp: model.Time(time.Now().Unix() * 1000)}}
+				runID, err := sqliteDB.StartCollectionRun(db, id, database.CollectionRun{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sqliteDB.RecordKPIDefinitions(db, runID, []database.KPIDefinition{
+					{KPIID: "kpi-1", PromQuery: "up", ResolvedPromQuery: "up", QueryType: "instant"},
+					{KPIID: "kpi-" + strconv.FormatInt(id, 10), PromQuery: "up", ResolvedPromQuery: "up", QueryType: "instant"},
+				})).To(Succeed())
+				Expect(sqliteDB.StoreQueryResults(db, id, runID, "kpi-1", vector)).To(Succeed())
+				_, err = db.Exec(`INSERT INTO query_rollups (cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds, sample_count)
+					VALUES (?, 'kpi-1', '{}', 0, 3600, 1)`, id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sqliteDB.RecordOverrun(db, id, database.OverrunEvent{
+					Frequency: time.Minute, SampleNumber: 2, RunDuration: 90 * time.Second, MissedTicks: 1, Policy: "skip",
+				})).To(Succeed())
+				Expect(sqliteDB.IncrementQueryError(db, id, "kpi-1")).To(Succeed())
+				Expect(sqliteDB.IncrementQueryRetry(db, id, "kpi-1")).To(Succeed())
+				Expect(sqliteDB.RecordQueryError(db, id, database.QueryErrorEvent{
+					KPIID: "kpi-1", QueryType: "instant", PromQuery: "up", Message: "server error: 503", HTTPStatus: 503, Attempt: 1,
+				})).To(Succeed())
+				Expect(sqliteDB.RecordAlert(db, id, runID, database.AlertEvent{
+					KPIID: "kpi-1", Threshold: "max <= 0.5", Limit: 0.5, Value: 1, SampleTime: time.Now(), State: database.AlertFiring,
+				})).To(Succeed())
+			}
+
+			removeClusterName = "old-cluster"
+			Expect(runRemoveClusters(nil, nil)).To(Succeed())
+
+			Expect(countRows("query_results", clusterID)).To(Equal(0))
+			Expect(countRows("query_rollups", clusterID)).To(Equal(0))
+			Expect(countRows("collection_runs", clusterID)).To(Equal(0))
+			Expect(countRows("collection_overruns", clusterID)).To(Equal(0))
+			Expect(countRows("query_error_events", clusterID)).To(Equal(0))
+			Expect(countRows("query_errors", clusterID)).To(Equal(0))
+			Expect(countRows("query_retries", clusterID)).To(Equal(0))
+			Expect(countRows("alerts", clusterID)).To(Equal(0))
+			Expect(countRows("query_results", keptID)).To(Equal(1))
+			Expect(countRows("query_rollups", keptID)).To(Equal(1))
+			Expect(countRows("collection_runs", keptID)).To(Equal(1))
+			Expect(countRows("collection_overruns", keptID)).To(Equal(1))
+			Expect(countRows("query_error_events", keptID)).To(Equal(1))
+			Expect(countRows("query_errors", keptID)).To(Equal(1))
+			Expect(countRows("query_retries", keptID)).To(Equal(1))
+			Expect(countRows("alerts", keptID)).To(Equal(1))
+
+			clusters, err := listClusters(db, sqliteDB, "old-cluster")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusters).To(BeEmpty())
+
+			// Only the definitions still used by the kept cluster's run remain
+			definitions, err := listDefinitions(db, sqliteDB, "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(definitions).To(HaveLen(2))
+			for _, definition := range definitions {
+				Expect(definition.Runs).To(Equal(int64(1)))
+			}
+		})
+
+		It("should leave the cluster whole when a deletion fails", func() {
+			clusterID, err := sqliteDB.GetOrCreateCluster(db, "old-cluster", "ran")
+			Expect(err).NotTo(HaveOccurred())
+			runID, err := sqliteDB.StartCollectionRun(db, clusterID, database.CollectionRun{})
+			Expect(err).NotTo(HaveOccurred())
+			vector := model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1, Timestamp: model.Time(time.Now().Unix() * 1000)}}
+			Expect(sqliteDB.StoreQueryResults(db, clusterID, runID, "kpi-1", vector)).To(Succeed())
+
+			// Fail the last deletion, after the samples and runs are deleted
+			_, err = db.Exec(`CREATE TRIGGER keep_clusters BEFORE DELETE ON clusters
+				BEGIN SELECT RAISE(ABORT, 'clusters are kept'); END`)
+			Expect(err).NotTo(HaveOccurred())
+
+			removeClusterName = "old-cluster"
+			Expect(runRemoveClusters(nil, nil)).To(MatchError(ContainSubstring("clusters are kept")))
+
+			Expect(countRows("query_results", clusterID)).To(Equal(1))
+			Expect(countRows("collection_runs", clusterID)).To(Equal(1))
+		})
+	})
+
+	Describe("kpis", func() {
+		var clusterID, otherID int64
+		base := time.Date(2026, 4, 7, 12, 0, 0, 0, time.UTC)
+
+		storeSample := func(cluster int64, kpiID, instance string, at time.Time) {
+			vector := model.Vector{&model.Sample{
+				Metric:    model.Metric{"__name__": "up", "instance": model.LabelValue(instance)},
+				Value:     1,
+				Timestamp: model.TimeFromUnix(at.Unix()),
+			}}
+			Expect(sqliteDB.StoreQueryResults(db, cluster, 0, kpiID, vector)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			clusterID, err = sqliteDB.GetOrCreateCluster(db, "cluster-a", "")
+			Expect(err).NotTo(HaveOccurred())
+			otherID, err = sqliteDB.GetOrCreateCluster(db, "cluster-b", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			// One sample per 10 minutes from 12:00 to 13:00 for two nodes and two KPIs
+			for minutes := 0; minutes <= 60; minutes += 10 {
+				at := base.Add(time.Duration(minutes) * time.Minute)
+				for _, instance := range []string{"worker-1", "worker-2"} {
+					storeSample(clusterID, "cpu", instance, at)
+					storeSample(clusterID, "memory", instance, at)
+				}
+				storeSample(otherID, "cpu", "worker-1", at)
+			}
+
+			for _, bucket := range []time.Time{base, base.Add(30 * time.Minute)} {
+				_, err := db.Exec(`INSERT INTO query_rollups (cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds, sample_count)
+					VALUES (?, 'cpu', '{"__name__":"up","instance":"worker-1"}', ?, 1800, 3)`, clusterID, float64(bucket.Unix()))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			removeClusterName = "cluster-a"
+			removeYes = true
+		})
+
+		// window selects the worker-1 samples from 12:10 to 12:30
+		since, until := base.Add(10*time.Minute), base.Add(30*time.Minute)
+		window := metricSelection{Since: &since, Until: &until, LabelFilters: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "worker-1")}}
+
+		It("should count the samples of the time window and labels per KPI", func() {
+			selection := window
+			selection.ClusterID = clusterID
+			counts, err := countSelectedMetrics(db, sqliteDB, selection)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(counts).To(Equal([]metricCount{
+				{KPIID: "cpu", Samples: 3},
+				{KPIID: "memory", Samples: 3},
+			}))
+
+			var out strings.Builder
+			Expect(printMetricCounts(&out, counts)).To(Equal(int64(6)))
+			Expect(out.String()).To(ContainSubstring("Total: 6 metric samples and 0 rollup bucket(s)"))
+		})
+
+		It("should only delete the samples of the time window and labels", func() {
+			removeSince = window.Since.Format(time.RFC3339)
+			removeUntil = window.Until.Format(time.RFC3339)
+			removeLabelsFilter = "instance=worker-1"
+			removeKPIName = "cpu"
+
+			Expect(runRemoveKPIs(nil, nil)).To(Succeed())
+
+			results, err := queryKPIs(db, sqliteDB, KPIQueryParams{ClusterName: "cluster-a", KPIName: "cpu",
+				LabelFilters: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "worker-1")}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(4))
+			for _, result := range results {
+				Expect(result.TimestampValue).NotTo(BeNumerically("~", float64(base.Add(20*time.Minute).Unix())))
+			}
+			Expect(countRows("query_results", clusterID)).To(Equal(25))
+			Expect(countRows("query_results", otherID)).To(Equal(7))
+			Expect(countRows("query_rollups", clusterID)).To(Equal(2))
+		})
+
+		It("should remove the rollup buckets lying entirely inside the time window", func() {
+			removeSince = base.Format(time.RFC3339)
+			removeUntil = base.Add(45 * time.Minute).Format(time.RFC3339)
+
+			Expect(runRemoveKPIs(nil, nil)).To(Succeed())
+
+			Expect(countRows("query_rollups", clusterID)).To(Equal(1))
+			Expect(countRows("query_results", clusterID)).To(Equal(8))
+		})
+
+		It("should not delete anything on a dry run", func() {
+			removeDryRun = true
+			removeYes = false
+
+			Expect(runRemoveKPIs(nil, nil)).To(Succeed())
+
+			Expect(countRows("query_results", clusterID)).To(Equal(28))
+			Expect(countRows("query_rollups", clusterID)).To(Equal(2))
+		})
+
+		It("should reject an invalid label filter", func() {
+			removeLabelsFilter = "instance"
+			Expect(runRemoveKPIs(nil, nil)).To(MatchError(ContainSubstring("invalid label filter format")))
+		})
+
+		It("should reject a time window that ends before it starts", func() {
+			removeSince = "1h"
+			removeUntil = "2h"
+			Expect(runRemoveKPIs(nil, nil)).To(MatchError(ContainSubstring("--since must resolve before --until")))
+		})
+	})
+
+	Describe("confirm", func() {
+		DescribeTable("should read the answer",
+			func(input string, expected bool) {
+				confirmed, err := confirm(bufio.NewReader(strings.NewReader(input)), "Delete?")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(confirmed).To(Equal(expected))
+			},
+			Entry("yes", "yes\n", true),
+			Entry("y without newline", "y", true),
+			Entry("no", "n\n", false),
+			Entry("empty answer", "\n", false),
+		)
+
+		It("should fail without any input", func() {
+			_, err := confirm(bufio.NewReader(strings.NewReader("")), "Delete?")
+			Expect(err).To(MatchError(ContainSubstring("use --yes")))
+		})
+	})
+
+	Describe("errors", func() {
+		var clusterA, clusterB int64
+
+		BeforeEach(func() {
+			var err error
+			clusterA, err = sqliteDB.GetOrCreateCluster(db, "cluster-a", "")
+			Expect(err).NotTo(HaveOccurred())
+			clusterB, err = sqliteDB.GetOrCreateCluster(db, "cluster-b", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, id := range []int64{clusterA, clusterB} {
+				for _, kpiID := range []string{"kpi-1", "kpi-2"} {
+					Expect(sqliteDB.IncrementQueryError(db, id, kpiID)).To(Succeed())
+				}
+			}
+		})
+
+		It("should only clear the errors of the given cluster", func() {
+			removeClusterName = "cluster-a"
+			Expect(runRemoveErrors(nil, nil)).To(Succeed())
+
+			Expect(countRows("query_errors", clusterA)).To(Equal(0))
+			Expect(countRows("query_errors", clusterB)).To(Equal(2))
+		})
+
+		It("should combine the cluster and KPI filters", func() {
+			removeClusterName = "cluster-a"
+			removeKPIName = "kpi-1"
+			Expect(runRemoveErrors(nil, nil)).To(Succeed())
+
+			count, err := sqliteDB.GetQueryErrorCount(db, clusterA, "kpi-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(0))
+			count, err = sqliteDB.GetQueryErrorCount(db, clusterA, "kpi-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(1))
+			Expect(countRows("query_errors", clusterB)).To(Equal(2))
+		})
+
+		It("should reject an unknown cluster", func() {
+			removeClusterName = "missing-cluster"
+			Expect(runRemoveErrors(nil, nil)).To(MatchError("cluster 'missing-cluster' not found"))
+		})
+
+		It("should reject --all combined with a filter", func() {
+			removeAll = true
+			removeClusterName = "cluster-a"
+			Expect(runRemoveErrors(nil, nil)).To(MatchError(ContainSubstring("--all cannot be combined")))
+		})
+	})
+})
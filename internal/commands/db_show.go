@@ -4,12 +4,15 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
 
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/spf13/cobra"
 )
 
@@ -22,6 +25,38 @@ var kpiQueryFlags struct {
 	until        string
 	limit        int
 	sort         string
+	runID        int64
+	noTruncate   bool
+	outputFormat string
+	aggregate    string
+	groupBy      string
+	bucket       time.Duration
+}
+
+// errorQueryFlags holds the flags for the 'show errors' command
+var errorQueryFlags struct {
+	details      bool
+	kpiName      string
+	clusterName  string
+	since        string
+	until        string
+	limit        int
+	noTruncate   bool
+	outputFormat string
+}
+
+// runQueryFlags holds the flags for the 'show runs' command
+var runQueryFlags struct {
+	clusterName  string
+	limit        int
+	noTruncate   bool
+	outputFormat string
+}
+
+// definitionQueryFlags holds the flags for the 'show definitions' command
+var definitionQueryFlags struct {
+	kpiName      string
+	runID        int64
 	noTruncate   bool
 	outputFormat string
 }
@@ -42,6 +77,10 @@ var showKPIsCmd = &cobra.Command{
 	Short: "Show KPI metrics",
 	Long: `Query and display KPI metrics with optional filtering by name, cluster, labels, and time range.
 
+With --aggregate, the matching samples are aggregated per KPI and cluster, and
+optionally per --group-by label values and --bucket time buckets, instead of
+being listed. --limit then applies to groups and --sort orders the buckets.
+
 The results can be displayed in table, JSON, or CSV format.`,
 	Example: `  # Show all metrics for a KPI
   kpi-collector db show kpis --name="cpu-system"
@@ -52,6 +91,10 @@ The results can be displayed in table, JSON, or CSV format.`,
   # Filter by labels (exact match)
   kpi-collector db show kpis --name="cpu-system" \
     --labels-filter='id=/system.slice/systemd-logind.service'
+
+  # Filter by labels with PromQL matchers (=, !=, =~, !~)
+  kpi-collector db show kpis --name="cpu-system-slice" \
+    --labels-filter='{id=~"/system.slice/crio.*",cpu!="total"}'
   
   # Time-based filtering
   kpi-collector db show kpis --name="cpu-system" --since="2h" --until="1h"
@@ -61,6 +104,17 @@ The results can be displayed in table, JSON, or CSV format.`,
   
   # Limit results and sort
   kpi-collector db show kpis --name="cpu-pods" --limit=100 --sort="desc"
+
+  # Only samples collected by one run (see 'db show runs')
+  kpi-collector db show kpis --name="cpu-system" --run-id=3
+
+  # p99 of isolated-core CPU per node over a run
+  kpi-collector db show kpis --name="cpu-isolated" --run-id=3 \
+    --aggregate=avg,p99,max --group-by=instance
+
+  # Per-node statistics in 5 minute buckets
+  kpi-collector db show kpis --name="cpu-isolated" --since=2h \
+    --aggregate=min,avg,max,stddev,count --group-by=instance --bucket=5m
   
   # Output as JSON
   kpi-collector db show kpis --name="cpu-system" -o json
@@ -84,20 +138,83 @@ var showClustersCmd = &cobra.Command{
 
 var showErrorsCmd = &cobra.Command{
 	Use:   "errors",
-	Short: "Show query error counts",
+	Short: "Show query error and retry counts",
 	Long: `Display KPI queries that have encountered errors during collection.
-Shows the error count per KPI — not the error details. To see the actual
-error messages, check the log file in the artifacts directory.`,
-	Example: `  # List all query errors
-  kpi-collector db show errors`,
+By default shows the error count per cluster and KPI together with the number
+of transient failures that were retried. Use --details to list every failed query attempt
+with its cluster, time, PromQL, HTTP status, error message and warnings.
+
+The results can be displayed in table, JSON, or CSV format.`,
+	Example: `  # List error counts per cluster and KPI
+  kpi-collector db show errors
+
+  # List error counts of one cluster
+  kpi-collector db show errors --cluster-name="mycluster1"
+
+  # Show every failed attempt of the last 2 hours on one cluster
+  kpi-collector db show errors --details --cluster-name="mycluster1" --since="2h"
+
+  # Show the full error messages and queries of one KPI
+  kpi-collector db show errors --details --name="cpu-system" --no-truncate
+
+  # Export error details to CSV
+  kpi-collector db show errors --details -o csv > errors.csv`,
 	RunE: runShowErrors,
 }
 
+var showRunsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "List collection runs",
+	Long: `Display the collection runs recorded by 'kpi-collector run', newest first.
+
+Each run shows its cluster, start and end time, sampling frequency and duration,
+the number of samples stored, failed queries and retries, the kpi-collector version
+and the SHA-256 of the KPIs file it was started with. Runs without an end time
+were interrupted without a clean shutdown or are still running.
+
+Use the run ID with 'db show kpis --run-id' to compare test campaigns on the same cluster.
+
+The results can be displayed in table, JSON, or CSV format.`,
+	Example: `  # List all collection runs
+  kpi-collector db show runs
+
+  # List the last 5 runs of one cluster
+  kpi-collector db show runs --cluster-name="mycluster1" --limit=5
+
+  # Show the full KPIs file hashes
+  kpi-collector db show runs --no-truncate`,
+	RunE: runShowRuns,
+}
+
+var showDefinitionsCmd = &cobra.Command{
+	Use:   "definitions",
+	Short: "Show the KPI definitions used by collection runs",
+	Long: `Display the definitions of the KPIs as they were collected: the PromQL as written
+in the KPIs file, the PromQL actually sent after placeholders such as {{RESERVED_CPUS}}
+were substituted, the query type, the range window and the sampling frequency.
+
+A new version of a definition is recorded whenever any of these change, so older
+data can always be traced back to the exact query that produced it.
+
+The results can be displayed in table, JSON, or CSV format.`,
+	Example: `  # Show every version of a KPI definition
+  kpi-collector db show definitions --name="cpu-reserved-cores"
+
+  # Show the definitions used by one collection run
+  kpi-collector db show definitions --run-id=3
+
+  # Export as JSON
+  kpi-collector db show definitions --name="cpu-reserved-cores" -o json`,
+	RunE: runShowDefinitions,
+}
+
 func init() {
 	dbCmd.AddCommand(showCmd)
 	showCmd.AddCommand(showKPIsCmd)
 	showCmd.AddCommand(showClustersCmd)
 	showCmd.AddCommand(showErrorsCmd)
+	showCmd.AddCommand(showRunsCmd)
+	showCmd.AddCommand(showDefinitionsCmd)
 
 	// Flags for 'show kpis'
 	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.kpiName, "name", "",
@@ -105,7 +222,7 @@ func init() {
 	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.clusterName, "cluster-name", "",
 		"cluster name to filter by")
 	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.labelsFilter, "labels-filter", "",
-		"label filters in format 'key=value,key2=value2'")
+		"label filters as 'key=value,key2=value2' or a PromQL selector like '{key=~\"regex\",key2!=\"value\"}'")
 	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.since, "since", "",
 		"show metrics since sample timestamp (Go duration or RFC3339, e.g. '2h' or '2026-04-07T12:24:25Z')")
 	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.until, "until", "",
@@ -114,10 +231,56 @@ func init() {
 		"limit number of results (0 = no limit)")
 	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.sort, "sort", "asc",
 		"sort order by metric timestamp: asc or desc")
+	showKPIsCmd.Flags().Int64Var(&kpiQueryFlags.runID, "run-id", 0,
+		"only show samples collected by this collection run")
 	showKPIsCmd.Flags().BoolVar(&kpiQueryFlags.noTruncate, "no-truncate", false,
 		"show full labels without truncation")
 	showKPIsCmd.Flags().StringVarP(&kpiQueryFlags.outputFormat, "output", "o", "table",
 		"output format: table, json, or csv")
+	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.aggregate, "aggregate", "",
+		"aggregate the samples instead of listing them: min,max,avg,count,stddev and percentiles like p50,p95,p99")
+	showKPIsCmd.Flags().StringVar(&kpiQueryFlags.groupBy, "group-by", "",
+		"with --aggregate, also group by these labels (e.g. 'instance,cpu')")
+	showKPIsCmd.Flags().DurationVar(&kpiQueryFlags.bucket, "bucket", 0,
+		"with --aggregate, also group by time buckets of this size (e.g. 5m)")
+
+	// Flags for 'show errors'
+	showErrorsCmd.Flags().BoolVar(&errorQueryFlags.details, "details", false,
+		"list every failed query attempt instead of counts per KPI")
+	showErrorsCmd.Flags().StringVar(&errorQueryFlags.kpiName, "name", "",
+		"KPI name to filter by (requires --details)")
+	showErrorsCmd.Flags().StringVar(&errorQueryFlags.clusterName, "cluster-name", "",
+		"cluster name to filter by")
+	showErrorsCmd.Flags().StringVar(&errorQueryFlags.since, "since", "",
+		"show errors since (Go duration or RFC3339, requires --details)")
+	showErrorsCmd.Flags().StringVar(&errorQueryFlags.until, "until", "",
+		"show errors until (Go duration or RFC3339, requires --details)")
+	showErrorsCmd.Flags().IntVar(&errorQueryFlags.limit, "limit", 0,
+		"limit number of results (0 = no limit, requires --details)")
+	showErrorsCmd.Flags().BoolVar(&errorQueryFlags.noTruncate, "no-truncate", false,
+		"show full error messages, queries and warnings")
+	showErrorsCmd.Flags().StringVarP(&errorQueryFlags.outputFormat, "output", "o", "table",
+		"output format: table, json, or csv")
+
+	// Flags for 'show runs'
+	showRunsCmd.Flags().StringVar(&runQueryFlags.clusterName, "cluster-name", "",
+		"cluster name to filter by")
+	showRunsCmd.Flags().IntVar(&runQueryFlags.limit, "limit", 0,
+		"limit number of results (0 = no limit)")
+	showRunsCmd.Flags().BoolVar(&runQueryFlags.noTruncate, "no-truncate", false,
+		"show full KPIs file hashes")
+	showRunsCmd.Flags().StringVarP(&runQueryFlags.outputFormat, "output", "o", "table",
+		"output format: table, json, or csv")
+
+	// Flags for 'show definitions'
+	showDefinitionsCmd.Flags().StringVar(&definitionQueryFlags.kpiName, "name", "",
+		"KPI name to filter by")
+	showDefinitionsCmd.Flags().Int64Var(&definitionQueryFlags.runID, "run-id", 0,
+		"only show the definitions used by this collection run")
+	showDefinitionsCmd.Flags().BoolVar(&definitionQueryFlags.noTruncate, "no-truncate", false,
+		"show full definition hashes")
+	showDefinitionsCmd.Flags().StringVarP(&definitionQueryFlags.outputFormat, "output", "o", "table",
+		"output format: table, json, or csv")
 
 	// Flags for 'show clusters'
 	showClustersCmd.Flags().StringVar(&clusterQueryFlags.clusterName, "name", "",
@@ -131,6 +294,11 @@ func runShowKPIs(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	aggregation, err := parseAggregationFlags()
+	if err != nil {
+		return err
+	}
+
 	db, dbImpl, err := connectToDB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
@@ -144,7 +312,7 @@ func runShowKPIs(cmd *cobra.Command, args []string) error {
 	}
 
 	// Parse label filters
-	labelFilters := make(map[string]string)
+	var labelFilters []*labels.Matcher
 	if kpiQueryFlags.labelsFilter != "" {
 		labelFilters, err = parseLabelFilters(kpiQueryFlags.labelsFilter)
 		if err != nil {
@@ -161,6 +329,14 @@ func runShowKPIs(cmd *cobra.Command, args []string) error {
 		Until:        untilTime,
 		Limit:        kpiQueryFlags.limit,
 		Sort:         kpiQueryFlags.sort,
+		RunID:        kpiQueryFlags.runID,
+	}
+
+	printer := output.NewPrinter(format).WithNoTruncate(kpiQueryFlags.noTruncate)
+
+	if len(aggregation.Aggregates) > 0 {
+		aggregation.KPIQueryParams = params
+		return showAggregates(db, dbImpl, aggregation, printer)
 	}
 
 	// Query KPIs
@@ -178,7 +354,6 @@ func runShowKPIs(cmd *cobra.Command, args []string) error {
 	records := convertToKPIRecords(results)
 
 	// Print using the output package
-	printer := output.NewPrinter(format).WithNoTruncate(kpiQueryFlags.noTruncate)
 	return printer.PrintKPIs(records)
 }
 
@@ -215,13 +390,30 @@ func runShowClusters(cmd *cobra.Command, args []string) error {
 }
 
 func runShowErrors(cmd *cobra.Command, args []string) error {
-	db, _, err := connectToDB()
+	// Parse output format first (fail fast if invalid)
+	format, err := output.ParseFormat(errorQueryFlags.outputFormat)
+	if err != nil {
+		return err
+	}
+
+	if !errorQueryFlags.details && (errorQueryFlags.kpiName != "" ||
+		errorQueryFlags.since != "" || errorQueryFlags.until != "" || errorQueryFlags.limit != 0) {
+		return fmt.Errorf("--name, --since, --until and --limit require --details")
+	}
+
+	db, dbImpl, err := connectToDB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
 	defer func() { _ = db.Close() }()
 
-	errors, err := listErrors(db)
+	printer := output.NewPrinter(format).WithNoTruncate(errorQueryFlags.noTruncate)
+
+	if errorQueryFlags.details {
+		return showErrorDetails(db, dbImpl, printer)
+	}
+
+	errors, err := listErrors(db, dbImpl, errorQueryFlags.clusterName)
 	if err != nil {
 		return fmt.Errorf("failed to list errors: %w", err)
 	}
@@ -235,13 +427,93 @@ func runShowErrors(cmd *cobra.Command, args []string) error {
 	records := make([]output.ErrorRecord, len(errors))
 	for i, e := range errors {
 		records[i] = output.ErrorRecord{
+			Cluster:    e.ClusterName,
 			KPIID:      e.KPIID,
 			ErrorCount: e.ErrorCount,
+			RetryCount: e.RetryCount,
 		}
 	}
 
-	output.PrintErrorsTable(records)
-	return nil
+	return printer.PrintErrors(records)
+}
+
+func showErrorDetails(db *sql.DB, dbImpl database.Database, printer *output.Printer) error {
+	sinceTime, untilTime, err := parseKPIQueryTimeWindow(errorQueryFlags.since, errorQueryFlags.until, time.Now())
+	if err != nil {
+		return err
+	}
+
+	events, err := listErrorEvents(db, dbImpl, ErrorEventQueryParams{
+		KPIName:     errorQueryFlags.kpiName,
+		ClusterName: errorQueryFlags.clusterName,
+		Since:       sinceTime,
+		Until:       untilTime,
+		Limit:       errorQueryFlags.limit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list error details: %w", err)
+	}
+
+	if len(events) == 0 {
+		fmt.Println("No errors found.")
+		return nil
+	}
+
+	return printer.PrintErrorEvents(events)
+}
+
+func runShowRuns(cmd *cobra.Command, args []string) error {
+	// Parse output format first (fail fast if invalid)
+	format, err := output.ParseFormat(runQueryFlags.outputFormat)
+	if err != nil {
+		return err
+	}
+
+	db, dbImpl, err := connectToDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	runs, err := listRuns(db, dbImpl, runQueryFlags.clusterName, runQueryFlags.limit)
+	if err != nil {
+		return fmt.Errorf("failed to list collection runs: %w", err)
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No collection runs found.")
+		return nil
+	}
+
+	printer := output.NewPrinter(format).WithNoTruncate(runQueryFlags.noTruncate)
+	return printer.PrintRuns(runs)
+}
+
+func runShowDefinitions(cmd *cobra.Command, args []string) error {
+	// Parse output format first (fail fast if invalid)
+	format, err := output.ParseFormat(definitionQueryFlags.outputFormat)
+	if err != nil {
+		return err
+	}
+
+	db, dbImpl, err := connectToDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	definitions, err := listDefinitions(db, dbImpl, definitionQueryFlags.kpiName, definitionQueryFlags.runID)
+	if err != nil {
+		return fmt.Errorf("failed to list KPI definitions: %w", err)
+	}
+
+	if len(definitions) == 0 {
+		fmt.Println("No KPI definitions found.")
+		return nil
+	}
+
+	printer := output.NewPrinter(format).WithNoTruncate(definitionQueryFlags.noTruncate)
+	return printer.PrintDefinitions(definitions)
 }
 
 func parseKPIQueryTimeWindow(sinceInput, untilInput string, now time.Time) (*time.Time, *time.Time, error) {
@@ -288,17 +560,40 @@ func parseTimeFilter(timeStr string, now time.Time) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("must be a Go duration (e.g. \"2h\") or RFC3339 format (e.g. \"2026-04-07T12:24:25Z\")")
 }
 
-func parseLabelFilters(filterStr string) (map[string]string, error) {
-	filters := make(map[string]string)
-	pairs := strings.Split(filterStr, ",")
-	for _, pair := range pairs {
-		kv := strings.SplitN(pair, "=", 2)
-		if len(kv) != 2 {
-			return nil, fmt.Errorf("invalid label filter format: %s (use key=value)", pair)
+// parseLabelFilters parses --labels-filter, either a PromQL selector such as
+// '{namespace="openshift-etcd",pod=~"etcd-.*"}' or comma-separated
+// 'key=value' pairs, each an exact match. Matchers with !=, =~ or !~ are
+// parsed as a selector, with or without braces.
+func parseLabelFilters(filterStr string) ([]*labels.Matcher, error) {
+	var matchers []*labels.Matcher
+	switch trimmed := strings.TrimSpace(filterStr); {
+	case strings.HasPrefix(trimmed, "{"):
+		var err error
+		if matchers, err = parser.ParseMetricSelector(trimmed); err != nil {
+			return nil, err
+		}
+	case strings.Contains(trimmed, "!=") || strings.Contains(trimmed, "=~") || strings.Contains(trimmed, "!~"):
+		var err error
+		if matchers, err = parser.ParseMetricSelector("{" + trimmed + "}"); err != nil {
+			return nil, fmt.Errorf("invalid label filter %s (quote the values of !=, =~ and !~ matchers, e.g. '{node!=\"a\"}'): %w", filterStr, err)
+		}
+	default:
+		for _, pair := range strings.Split(filterStr, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid label filter format: %s (use key=value or a selector like '{key=~\"regex\"}')", pair)
+			}
+			matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])))
+		}
+	}
+
+	// Label names are inlined in SQLite JSON paths
+	for _, m := range matchers {
+		if !labelNamePattern.MatchString(m.Name) {
+			return nil, fmt.Errorf("invalid label name %q in label filter", m.Name)
 		}
-		filters[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
 	}
-	return filters, nil
+	return matchers, nil
 }
 
 type KPIResult struct {
@@ -314,11 +609,12 @@ type KPIResult struct {
 type KPIQueryParams struct {
 	KPIName      string
 	ClusterName  string
-	LabelFilters map[string]string
+	LabelFilters []*labels.Matcher
 	Since        *time.Time
 	Until        *time.Time
 	Limit        int
 	Sort         string
+	RunID        int64 // 0 = samples of any run
 }
 
 func queryKPIs(db *sql.DB, dbImpl database.Database, params KPIQueryParams) ([]KPIResult, error) {
@@ -330,35 +626,9 @@ func queryKPIs(db *sql.DB, dbImpl database.Database, params KPIQueryParams) ([]K
 		WHERE 1=1
 	`
 
-	args := []interface{}{}
-	argIndex := 1
-
-	if params.KPIName != "" {
-		query += fmt.Sprintf(" AND qr.kpi_id = $%d", argIndex)
-		args = append(args, params.KPIName)
-		argIndex++
-	}
-
-	if params.ClusterName != "" {
-		query += fmt.Sprintf(" AND c.cluster_name = $%d", argIndex)
-		args = append(args, params.ClusterName)
-		argIndex++
-	}
-
-	if params.Since != nil {
-		query += fmt.Sprintf(" AND qr.timestamp_value >= $%d", argIndex)
-		// CLI time filters intentionally use second precision (0 milliseconds).
-		// We truncate to whole seconds, then convert to Unix epoch seconds for
-		// comparison against query_results.timestamp_value.
-		args = append(args, float64(params.Since.Truncate(time.Second).Unix()))
-		argIndex++
-	}
-
-	if params.Until != nil {
-		query += fmt.Sprintf(" AND qr.timestamp_value <= $%d", argIndex)
-		args = append(args, float64(params.Until.Truncate(time.Second).Unix()))
-		argIndex++
-	}
+	conditions, args := kpiFilterConditions(dbImpl, params)
+	query += conditions
+	argIndex := len(args) + 1
 
 	if params.Sort == "desc" {
 		query += " ORDER BY qr.timestamp_value DESC"
@@ -391,34 +661,131 @@ func queryKPIs(db *sql.DB, dbImpl database.Database, params KPIQueryParams) ([]K
 			return nil, err
 		}
 
-		// Apply label filters
-		if len(params.LabelFilters) > 0 {
-			if !matchesLabelFilters(r.MetricLabels, params.LabelFilters) {
-				continue
-			}
-		}
-
 		results = append(results, r)
 	}
 
 	return results, rows.Err()
 }
 
-func matchesLabelFilters(labelsJSON string, filters map[string]string) bool {
-	var labels map[string]string
-	if err := json.Unmarshal([]byte(labelsJSON), &labels); err != nil {
-		return false
+// kpiFilterConditions returns the " AND ..." conditions selecting the
+// query_results rows (qr, joined with clusters as c) matched by the filters
+// of params, with placeholders numbered from $1
+func kpiFilterConditions(dbImpl database.Database, params KPIQueryParams) (string, []interface{}) {
+	query := ""
+	args := []interface{}{}
+	argIndex := 1
+
+	if params.KPIName != "" {
+		query += fmt.Sprintf(" AND qr.kpi_id = $%d", argIndex)
+		args = append(args, params.KPIName)
+		argIndex++
+	}
+
+	if params.ClusterName != "" {
+		query += fmt.Sprintf(" AND c.cluster_name = $%d", argIndex)
+		args = append(args, params.ClusterName)
+		argIndex++
+	}
+
+	if params.RunID != 0 {
+		query += fmt.Sprintf(" AND qr.run_id = $%d", argIndex)
+		args = append(args, params.RunID)
+		argIndex++
+	}
+
+	if params.Since != nil {
+		query += fmt.Sprintf(" AND qr.timestamp_value >= $%d", argIndex)
+		// CLI time filters intentionally use second precision (0 milliseconds).
+		// We truncate to whole seconds, then convert to Unix epoch seconds for
+		// comparison against query_results.timestamp_value.
+		args = append(args, float64(params.Since.Truncate(time.Second).Unix()))
+		argIndex++
+	}
+
+	if params.Until != nil {
+		query += fmt.Sprintf(" AND qr.timestamp_value <= $%d", argIndex)
+		args = append(args, float64(params.Until.Truncate(time.Second).Unix()))
+		argIndex++
+	}
+
+	// Label filters are evaluated by the database so that LIMIT applies to matching rows
+	conditions, labelArgs := labelFilterConditions(dbImpl, "qr.metric_labels", params.LabelFilters, argIndex)
+	for _, condition := range conditions {
+		query += " AND " + condition
 	}
+	return query, append(args, labelArgs...)
+}
 
-	for key, value := range filters {
-		labelValue, exists := labels[key]
-		if !exists || labelValue != value {
-			return false
+// labelFilterConditions translates label matchers into SQL predicates on a
+// JSON labels column, with placeholders numbered from firstArg. As in PromQL,
+// a label the sample does not have matches as the empty string, and regular
+// expressions are fully anchored. PostgreSQL tests JSONB containment for
+// non-empty equality, which can use the GIN index on
+// query_results.metric_labels, and evaluates regular expressions with its own
+// engine; SQLite uses the Go (RE2) REGEXP function registered by the database
+// package.
+func labelFilterConditions(dbImpl database.Database, column string, matchers []*labels.Matcher, firstArg int) ([]string, []interface{}) {
+	_, isSQLite := dbImpl.(*database.SQLiteDB)
+
+	conditions := make([]string, 0, len(matchers))
+	args := make([]interface{}, 0, 2*len(matchers))
+	for _, m := range matchers {
+		n := firstArg + len(args)
+
+		if m.Type == labels.MatchEqual && m.Value != "" {
+			if isSQLite {
+				conditions = append(conditions, fmt.Sprintf("json_extract(%s, $%d) = $%d", column, n, n+1))
+				args = append(args, sqliteLabelPath(m.Name), m.Value)
+			} else {
+				// Marshalling a map of strings cannot fail
+				label, _ := json.Marshal(map[string]string{m.Name: m.Value})
+				conditions = append(conditions, fmt.Sprintf("%s @> $%d::jsonb", column, n))
+				args = append(args, string(label))
+			}
+			continue
+		}
+
+		value := m.Value
+		if m.Type == labels.MatchRegexp || m.Type == labels.MatchNotRegexp {
+			value = "^(?:" + m.Value + ")$"
+		}
+
+		if isSQLite {
+			conditions = append(conditions, fmt.Sprintf("COALESCE(json_extract(%s, $%d), '') %s $%d",
+				column, n, sqliteMatchOperators[m.Type], n+1))
+			args = append(args, sqliteLabelPath(m.Name), value)
+		} else {
+			conditions = append(conditions, fmt.Sprintf("COALESCE(%s->>$%d::text, '') %s $%d",
+				column, n, postgresMatchOperators[m.Type], n+1))
+			args = append(args, m.Name, value)
 		}
 	}
-	return true
+	return conditions, args
+}
+
+// sqliteLabelPath returns the SQLite JSON path of a label, whose name was
+// checked against labelNamePattern
+func sqliteLabelPath(name string) string {
+	return `$."` + name + `"`
 }
 
+// sqliteMatchOperators and postgresMatchOperators are the SQL operators of
+// each label matcher type
+var (
+	sqliteMatchOperators = map[labels.MatchType]string{
+		labels.MatchEqual:     "=",
+		labels.MatchNotEqual:  "<>",
+		labels.MatchRegexp:    "REGEXP",
+		labels.MatchNotRegexp: "NOT REGEXP",
+	}
+	postgresMatchOperators = map[labels.MatchType]string{
+		labels.MatchEqual:     "=",
+		labels.MatchNotEqual:  "<>",
+		labels.MatchRegexp:    "~",
+		labels.MatchNotRegexp: "!~",
+	}
+)
+
 type ClusterInfo struct {
 	ID           int64
 	Name         string
@@ -465,14 +832,39 @@ func listClusters(db *sql.DB, dbImpl database.Database, clusterName string) ([]C
 }
 
 type ErrorInfo struct {
-	KPIID      string
-	ErrorCount int
+	ClusterName string // empty for counts recorded before they were keyed by cluster
+	KPIID       string
+	ErrorCount  int
+	RetryCount  int
 }
 
-func listErrors(db *sql.DB) ([]ErrorInfo, error) {
-	query := "SELECT kpi_id, errors FROM query_errors WHERE errors > 0 ORDER BY errors DESC"
+func listErrors(db *sql.DB, dbImpl database.Database, clusterName string) ([]ErrorInfo, error) {
+	query := `
+		SELECT COALESCE(c.cluster_name, ''), counts.kpi_id,
+		       SUM(counts.errors) AS errors, SUM(counts.retries) AS retries
+		FROM (
+			SELECT cluster_id, kpi_id, errors, 0 AS retries FROM query_errors
+			UNION ALL
+			SELECT cluster_id, kpi_id, 0 AS errors, retries FROM query_retries
+		) AS counts
+		LEFT JOIN clusters c ON counts.cluster_id = c.id
+	`
+	args := []interface{}{}
 
-	rows, err := db.Query(query)
+	if clusterName != "" {
+		query += " WHERE c.cluster_name = $1"
+		args = append(args, clusterName)
+	}
+
+	query += ` GROUP BY counts.cluster_id, c.cluster_name, counts.kpi_id
+		HAVING SUM(counts.errors) > 0 OR SUM(counts.retries) > 0
+		ORDER BY errors DESC, retries DESC, c.cluster_name, counts.kpi_id`
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -481,7 +873,7 @@ func listErrors(db *sql.DB) ([]ErrorInfo, error) {
 	var errors []ErrorInfo
 	for rows.Next() {
 		var e ErrorInfo
-		err := rows.Scan(&e.KPIID, &e.ErrorCount)
+		err := rows.Scan(&e.ClusterName, &e.KPIID, &e.ErrorCount, &e.RetryCount)
 		if err != nil {
 			return nil, err
 		}
@@ -491,6 +883,207 @@ func listErrors(db *sql.DB) ([]ErrorInfo, error) {
 	return errors, rows.Err()
 }
 
+type ErrorEventQueryParams struct {
+	KPIName     string
+	ClusterName string
+	Since       *time.Time
+	Until       *time.Time
+	Limit       int
+}
+
+// listErrorEvents returns failed query attempts, newest first
+func listErrorEvents(db *sql.DB, dbImpl database.Database, params ErrorEventQueryParams) ([]output.ErrorEventRecord, error) {
+	query := `
+		SELECT e.id, e.occurred_at, c.cluster_name, e.kpi_id, e.query_type, e.promquery,
+		       e.http_status, e.error_message, e.warnings, e.attempt, e.retried
+		FROM query_error_events e
+		JOIN clusters c ON e.cluster_id = c.id
+		WHERE 1=1
+	`
+
+	args := []interface{}{}
+	argIndex := 1
+
+	if params.KPIName != "" {
+		query += fmt.Sprintf(" AND e.kpi_id = $%d", argIndex)
+		args = append(args, params.KPIName)
+		argIndex++
+	}
+
+	if params.ClusterName != "" {
+		query += fmt.Sprintf(" AND c.cluster_name = $%d", argIndex)
+		args = append(args, params.ClusterName)
+		argIndex++
+	}
+
+	if params.Since != nil {
+		query += fmt.Sprintf(" AND e.occurred_at >= $%d", argIndex)
+		args = append(args, timestampArg(dbImpl, *params.Since))
+		argIndex++
+	}
+
+	if params.Until != nil {
+		query += fmt.Sprintf(" AND e.occurred_at <= $%d", argIndex)
+		args = append(args, timestampArg(dbImpl, *params.Until))
+		argIndex++
+	}
+
+	query += " ORDER BY e.occurred_at DESC, e.id DESC"
+
+	if params.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIndex)
+		args = append(args, params.Limit)
+	}
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var events []output.ErrorEventRecord
+	for rows.Next() {
+		var (
+			e          output.ErrorEventRecord
+			httpStatus sql.NullInt64
+			warnings   sql.NullString
+		)
+		err := rows.Scan(&e.ID, &e.OccurredAt, &e.Cluster, &e.KPIID, &e.QueryType, &e.PromQuery,
+			&httpStatus, &e.Message, &warnings, &e.Attempt, &e.Retried)
+		if err != nil {
+			return nil, err
+		}
+		e.HTTPStatus = int(httpStatus.Int64)
+		if warnings.Valid {
+			_ = json.Unmarshal([]byte(warnings.String), &e.Warnings)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// listRuns returns the collection runs, newest first
+func listRuns(db *sql.DB, dbImpl database.Database, clusterName string, limit int) ([]output.RunRecord, error) {
+	query := `
+		SELECT r.id, c.cluster_name, r.started_at, r.ended_at, r.frequency_seconds, r.duration_seconds,
+		       COALESCE(r.kpis_file_hash, ''), COALESCE(r.tool_version, ''), COALESCE(r.shutdown_reason, ''),
+		       r.failed_queries, r.retries,
+		       (SELECT COUNT(*) FROM query_results qr WHERE qr.run_id = r.id) AS samples
+		FROM collection_runs r
+		JOIN clusters c ON r.cluster_id = c.id
+	`
+	args := []interface{}{}
+
+	if clusterName != "" {
+		query += " WHERE c.cluster_name = $1"
+		args = append(args, clusterName)
+	}
+
+	query += " ORDER BY r.started_at DESC, r.id DESC"
+
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+		args = append(args, limit)
+	}
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var runs []output.RunRecord
+	for rows.Next() {
+		var (
+			r       output.RunRecord
+			endedAt sql.NullTime
+		)
+		err := rows.Scan(&r.ID, &r.Cluster, &r.StartedAt, &endedAt, &r.FrequencySeconds, &r.DurationSeconds,
+			&r.KPIsFileHash, &r.ToolVersion, &r.ShutdownReason, &r.FailedQueries, &r.Retries, &r.Samples)
+		if err != nil {
+			return nil, err
+		}
+		if endedAt.Valid {
+			r.EndedAt = &endedAt.Time
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, rows.Err()
+}
+
+// listDefinitions returns the versions of the KPI definitions, newest first per KPI
+func listDefinitions(db *sql.DB, dbImpl database.Database, kpiName string, runID int64) ([]output.DefinitionRecord, error) {
+	query := `
+		SELECT d.id, d.kpi_id, d.definition_hash, d.promquery, d.resolved_promquery, d.query_type,
+		       COALESCE(d.range_step, ''), COALESCE(d.range_since, ''), COALESCE(d.range_until, ''),
+		       d.frequency_seconds, d.run_once, d.created_at,
+		       (SELECT COUNT(*) FROM collection_run_definitions l WHERE l.definition_id = d.id),
+		       (SELECT COALESCE(MAX(l.run_id), 0) FROM collection_run_definitions l WHERE l.definition_id = d.id)
+		FROM kpi_definitions d
+		WHERE 1=1
+	`
+
+	args := []interface{}{}
+	argIndex := 1
+
+	if kpiName != "" {
+		query += fmt.Sprintf(" AND d.kpi_id = $%d", argIndex)
+		args = append(args, kpiName)
+		argIndex++
+	}
+
+	if runID != 0 {
+		query += fmt.Sprintf(" AND d.id IN (SELECT definition_id FROM collection_run_definitions WHERE run_id = $%d)", argIndex)
+		args = append(args, runID)
+	}
+
+	query += " ORDER BY d.kpi_id, d.id DESC"
+
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var definitions []output.DefinitionRecord
+	for rows.Next() {
+		var d output.DefinitionRecord
+		err := rows.Scan(&d.ID, &d.KPIID, &d.Hash, &d.PromQuery, &d.ResolvedPromQuery, &d.QueryType,
+			&d.RangeStep, &d.RangeSince, &d.RangeUntil, &d.FrequencySeconds, &d.RunOnce, &d.FirstSeen,
+			&d.Runs, &d.LastRunID)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, d)
+	}
+
+	return definitions, rows.Err()
+}
+
+// timestampArg renders a time filter for comparison against a TIMESTAMP column
+// filled by CURRENT_TIMESTAMP: SQLite stores those as UTC text, while PostgreSQL
+// converts a timestamptz argument to the session time zone itself.
+func timestampArg(dbImpl database.Database, t time.Time) interface{} {
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return t
+}
+
 // convertToKPIRecords converts internal KPIResult to output.KPIRecord
 func convertToKPIRecords(results []KPIResult) []output.KPIRecord {
 	records := make([]output.KPIRecord, len(results))
@@ -513,10 +1106,9 @@ func convertToKPIRecords(results []KPIResult) []output.KPIRecord {
 	return records
 }
 
+// postgresPlaceholder matches the numbered placeholders of a PostgreSQL query
+var postgresPlaceholder = regexp.MustCompile(`\$\d+`)
+
 func convertPostgresToSQLitePlaceholders(query string) string {
-	result := query
-	for i := 20; i >= 1; i-- {
-		result = strings.ReplaceAll(result, fmt.Sprintf("$%d", i), "?")
-	}
-	return result
+	return postgresPlaceholder.ReplaceAllString(query, "?")
 }
@@ -0,0 +1,249 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+// aggregateFuncs are the SQL aggregates of --aggregate besides percentiles
+var aggregateFuncs = map[string]string{
+	"min":    "MIN(qr.metric_value)",
+	"max":    "MAX(qr.metric_value)",
+	"avg":    "AVG(qr.metric_value)",
+	"count":  "COUNT(qr.metric_value)",
+	"stddev": "stddev_pop(qr.metric_value)",
+}
+
+// percentileAggregate matches percentile aggregates such as p50, p99 or p99.9
+var percentileAggregate = regexp.MustCompile(`^p(\d+(\.\d+)?)$`)
+
+// labelNamePattern matches Prometheus label names, which are safe to inline in SQL
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// KPIAggregateParams selects the samples to aggregate and how to group them
+type KPIAggregateParams struct {
+	KPIQueryParams
+	Aggregates []string
+	GroupBy    []string      // label names, besides KPI and cluster
+	Bucket     time.Duration // 0 = one group for the whole time window
+}
+
+// parseAggregationFlags validates the --aggregate, --group-by and --bucket
+// flags of 'show kpis'. Without --aggregate, the samples are listed.
+func parseAggregationFlags() (KPIAggregateParams, error) {
+	var params KPIAggregateParams
+	if kpiQueryFlags.aggregate == "" {
+		if kpiQueryFlags.groupBy != "" || kpiQueryFlags.bucket != 0 {
+			return params, fmt.Errorf("--group-by and --bucket require --aggregate")
+		}
+		return params, nil
+	}
+
+	var err error
+	if params.Aggregates, err = parseAggregates(kpiQueryFlags.aggregate); err != nil {
+		return params, fmt.Errorf("invalid --aggregate: %w", err)
+	}
+	if kpiQueryFlags.groupBy != "" {
+		if params.GroupBy, err = parseGroupBy(kpiQueryFlags.groupBy); err != nil {
+			return params, fmt.Errorf("invalid --group-by: %w", err)
+		}
+	}
+	if kpiQueryFlags.bucket < 0 || kpiQueryFlags.bucket%time.Second != 0 {
+		return params, fmt.Errorf("--bucket must be a positive whole number of seconds")
+	}
+	params.Bucket = kpiQueryFlags.bucket
+	return params, nil
+}
+
+// showAggregates prints the aggregated samples selected by params
+func showAggregates(db *sql.DB, dbImpl database.Database, params KPIAggregateParams, printer *output.Printer) error {
+	records, err := aggregateKPIs(db, dbImpl, params)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate KPIs: %w", err)
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No results found.")
+		return nil
+	}
+
+	return printer.PrintAggregates(output.AggregateReport{
+		Aggregates: params.Aggregates,
+		GroupBy:    params.GroupBy,
+		Bucketed:   params.Bucket > 0,
+		Records:    records,
+	})
+}
+
+// parseAggregates parses the comma-separated --aggregate list
+func parseAggregates(value string) ([]string, error) {
+	var aggregates []string
+	seen := make(map[string]bool)
+	for _, aggregate := range strings.Split(value, ",") {
+		aggregate = strings.ToLower(strings.TrimSpace(aggregate))
+		if _, ok := aggregateFuncs[aggregate]; !ok {
+			if _, err := percentileFraction(aggregate); err != nil {
+				return nil, err
+			}
+		}
+		if seen[aggregate] {
+			return nil, fmt.Errorf("duplicate aggregate %q", aggregate)
+		}
+		seen[aggregate] = true
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, nil
+}
+
+// percentileFraction returns the fraction (0 to 1) of a percentile aggregate
+func percentileFraction(aggregate string) (float64, error) {
+	match := percentileAggregate.FindStringSubmatch(aggregate)
+	if match == nil {
+		return 0, fmt.Errorf("unknown aggregate %q (use min, max, avg, count, stddev or a percentile like p99)", aggregate)
+	}
+	percentile, _ := strconv.ParseFloat(match[1], 64)
+	if percentile > 100 {
+		return 0, fmt.Errorf("invalid percentile %q: must be between p0 and p100", aggregate)
+	}
+	return percentile / 100, nil
+}
+
+// parseGroupBy parses the comma-separated --group-by label names
+func parseGroupBy(value string) ([]string, error) {
+	var labels []string
+	for _, label := range strings.Split(value, ",") {
+		label = strings.TrimSpace(label)
+		if !labelNamePattern.MatchString(label) {
+			return nil, fmt.Errorf("invalid label name %q", label)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// aggregateExpression returns the SQL computing the aggregate of the samples.
+// SQLite gets stddev_pop and percentile_cont from the database package.
+func aggregateExpression(dbImpl database.Database, aggregate string) string {
+	if expression, ok := aggregateFuncs[aggregate]; ok {
+		return expression
+	}
+	fraction, _ := percentileFraction(aggregate)
+	if _, ok := dbImpl.(*database.SQLiteDB); ok {
+		return fmt.Sprintf("percentile_cont(qr.metric_value, %g)", fraction)
+	}
+	return fmt.Sprintf("percentile_cont(%g) WITHIN GROUP (ORDER BY qr.metric_value)", fraction)
+}
+
+// aggregateKPIs aggregates the samples selected by the filters of params per
+// KPI, cluster, group-by label values and time bucket, all in SQL. Groups are
+// ordered by KPI, cluster and labels, then by bucket in the params sort order,
+// and the limit applies to groups.
+func aggregateKPIs(db *sql.DB, dbImpl database.Database, params KPIAggregateParams) ([]output.AggregateRecord, error) {
+	_, isSQLite := dbImpl.(*database.SQLiteDB)
+
+	columns := []string{"qr.kpi_id", "c.cluster_name"}
+	for _, label := range params.GroupBy {
+		// Label names are validated by parseGroupBy, and a missing label
+		// groups as "" like in PromQL
+		if isSQLite {
+			columns = append(columns, fmt.Sprintf(`COALESCE(json_extract(qr.metric_labels, '$."%s"'), '')`, label))
+		} else {
+			columns = append(columns, fmt.Sprintf("COALESCE(qr.metric_labels->>'%s', '')", label))
+		}
+	}
+
+	bucketSeconds := int64(params.Bucket / time.Second)
+	if bucketSeconds > 0 {
+		if isSQLite {
+			columns = append(columns, fmt.Sprintf("CAST(qr.timestamp_value / %[1]d AS INTEGER) * %[1]d", bucketSeconds))
+		} else {
+			columns = append(columns, fmt.Sprintf("FLOOR(qr.timestamp_value / %[1]d) * %[1]d", bucketSeconds))
+		}
+	}
+
+	groups := make([]string, len(columns))
+	for i := range columns {
+		groups[i] = strconv.Itoa(i + 1)
+	}
+	order := strings.Join(groups, ", ")
+	if bucketSeconds > 0 && params.Sort == "desc" {
+		order += " DESC"
+	}
+
+	for _, aggregate := range params.Aggregates {
+		columns = append(columns, aggregateExpression(dbImpl, aggregate))
+	}
+
+	conditions, args := kpiFilterConditions(dbImpl, params.KPIQueryParams)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM query_results qr
+		JOIN clusters c ON qr.cluster_id = c.id
+		WHERE 1=1%s
+		GROUP BY %s
+		ORDER BY %s`, strings.Join(columns, ", "), conditions, strings.Join(groups, ", "), order)
+
+	if params.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+		args = append(args, params.Limit)
+	}
+
+	if isSQLite {
+		query = convertPostgresToSQLitePlaceholders(query)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []output.AggregateRecord
+	for rows.Next() {
+		var r output.AggregateRecord
+		labelValues := make([]string, len(params.GroupBy))
+		var bucketStart float64
+		values := make([]sql.NullFloat64, len(params.Aggregates))
+
+		dest := []interface{}{&r.KPIName, &r.Cluster}
+		for i := range labelValues {
+			dest = append(dest, &labelValues[i])
+		}
+		if bucketSeconds > 0 {
+			dest = append(dest, &bucketStart)
+		}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if len(params.GroupBy) > 0 {
+			r.Labels = make(map[string]string, len(params.GroupBy))
+			for i, label := range params.GroupBy {
+				r.Labels[label] = labelValues[i]
+			}
+		}
+		if bucketSeconds > 0 {
+			start := time.Unix(int64(bucketStart), 0).UTC()
+			r.BucketStart = &start
+		}
+		r.Values = make(map[string]float64, len(params.Aggregates))
+		for i, aggregate := range params.Aggregates {
+			if values[i].Valid {
+				r.Values[aggregate] = values[i].Float64
+			}
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+var _ = Describe("aggregateKPIs", func() {
+	// base is aligned to 5 minute buckets
+	const base = 1699999800
+
+	var db *sql.DB
+
+	insert := func(value float64, timestamp int64, labels string) {
+		_, err := db.Exec(
+			"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels) VALUES (?, ?, ?, ?, ?, ?)",
+			"cpu", value, float64(timestamp), 1, "2026-01-01 00:00:00", labels,
+		)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+		db, err = newInMemoryKPIDB()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = db.Exec("INSERT INTO clusters (id, cluster_name) VALUES (?, ?)", 1, "cluster-a")
+		Expect(err).NotTo(HaveOccurred())
+
+		// worker-1 reports 1 to 10, one sample per minute
+		for i := int64(0); i < 10; i++ {
+			insert(float64(i+1), base+i*60, `{"instance":"worker-1","cpu":"2"}`)
+		}
+		insert(10, base, `{"instance":"worker-2","cpu":"2"}`)
+		insert(20, base+60, `{"instance":"worker-2","cpu":"3"}`)
+		insert(5, base, `{"cpu":"2"}`)
+	})
+
+	AfterEach(func() {
+		_ = db.Close()
+	})
+
+	It("should compute every aggregate per group-by label value", func() {
+		records, err := aggregateKPIs(db, &database.SQLiteDB{}, KPIAggregateParams{
+			Aggregates: []string{"min", "max", "avg", "count", "p50", "p90", "stddev"},
+			GroupBy:    []string{"instance"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(3))
+
+		// A missing label groups as ""
+		Expect(records[0].Labels).To(Equal(map[string]string{"instance": ""}))
+		Expect(records[0].Values).To(HaveKeyWithValue("count", 1.0))
+		Expect(records[0].Values).To(HaveKeyWithValue("stddev", 0.0))
+
+		Expect(records[1].KPIName).To(Equal("cpu"))
+		Expect(records[1].Cluster).To(Equal("cluster-a"))
+		Expect(records[1].Labels).To(Equal(map[string]string{"instance": "worker-1"}))
+		Expect(records[1].BucketStart).To(BeNil())
+		Expect(records[1].Values).To(Equal(map[string]float64{
+			"min": 1, "max": 10, "avg": 5.5, "count": 10, "p50": 5.5, "p90": 9.1,
+			"stddev": records[1].Values["stddev"],
+		}))
+		Expect(records[1].Values["stddev"]).To(BeNumerically("~", math.Sqrt(8.25), 1e-9))
+
+		Expect(records[2].Values).To(HaveKeyWithValue("p50", 15.0))
+		Expect(records[2].Values).To(HaveKeyWithValue("stddev", 5.0))
+	})
+
+	It("should aggregate per time bucket in the sort order and limit the groups", func() {
+		params := KPIAggregateParams{
+			KPIQueryParams: KPIQueryParams{
+				LabelFilters: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "worker-1")},
+				Sort:         "desc",
+			},
+			Aggregates: []string{"avg"},
+			Bucket:     5 * time.Minute,
+		}
+		records, err := aggregateKPIs(db, &database.SQLiteDB{}, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(*records[0].BucketStart).To(Equal(time.Unix(base+300, 0).UTC()))
+		Expect(records[0].Values["avg"]).To(Equal(8.0))
+		Expect(*records[1].BucketStart).To(Equal(time.Unix(base, 0).UTC()))
+		Expect(records[1].Values["avg"]).To(Equal(3.0))
+
+		params.Limit = 1
+		records, err = aggregateKPIs(db, &database.SQLiteDB{}, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+	})
+
+	It("should print one column per group-by label and aggregate", func() {
+		params := KPIAggregateParams{Aggregates: []string{"count", "max"}, GroupBy: []string{"instance", "cpu"}, Bucket: time.Hour}
+		records, err := aggregateKPIs(db, &database.SQLiteDB{}, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		var out strings.Builder
+		err = output.NewPrinter(output.FormatCSV).WithWriter(&out).PrintAggregates(output.AggregateReport{
+			Aggregates: params.Aggregates, GroupBy: params.GroupBy, Bucketed: true, Records: records,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).To(HaveLen(5))
+		Expect(lines[0]).To(Equal("kpi_name,cluster,bucket_start,instance,cpu,count,max"))
+		Expect(lines[2]).To(Equal("cpu,cluster-a,1699999200,worker-1,2,10,10.000000"))
+	})
+})
+
+var _ = Describe("parseAggregates", func() {
+	It("should accept the aggregates and percentiles in order", func() {
+		aggregates, err := parseAggregates("p99, AVG,min,p99.9")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(aggregates).To(Equal([]string{"p99", "avg", "min", "p99.9"}))
+	})
+
+	DescribeTable("should reject invalid aggregates",
+		func(value string) {
+			_, err := parseAggregates(value)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("unknown", "median"),
+		Entry("percentile above 100", "p101"),
+		Entry("duplicate", "avg,min,avg"),
+		Entry("empty", "avg,"),
+	)
+
+	It("should only accept label names for --group-by", func() {
+		groupBy, err := parseGroupBy("instance, cpu")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groupBy).To(Equal([]string{"instance", "cpu"}))
+
+		_, err = parseGroupBy("instance') --")
+		Expect(err).To(HaveOccurred())
+	})
+})
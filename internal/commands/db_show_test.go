@@ -2,10 +2,14 @@ package commands
 
 import (
 	"database/sql"
+	"fmt"
+	"os"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
 
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 	_ "modernc.org/sqlite"
@@ -115,6 +119,122 @@ var _ = Describe("queryKPIs", func() {
 			Expect(results[0].KPIName).To(Equal("kpi-until-hit"))
 		})
 	})
+
+	Context("when filtering by labels", func() {
+		BeforeEach(func() {
+			_, err := db.Exec("INSERT INTO clusters (id, cluster_name) VALUES (?, ?)", 1, "cluster-a")
+			Expect(err).NotTo(HaveOccurred())
+
+			// The matching pod only appears after many samples of other pods
+			for i := 0; i < 20; i++ {
+				pod := "other"
+				if i >= 15 {
+					pod = "x"
+				}
+				_, err = db.Exec(
+					"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels) VALUES (?, ?, ?, ?, ?, ?)",
+					"kpi-labels", float64(i), float64(1700000000+i), 1, "2026-01-01 00:00:00",
+					fmt.Sprintf(`{"namespace":"ns-%d","pod":"%s"}`, i%2, pod),
+				)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("should apply the limit to the matching rows only", func() {
+			results, err := queryKPIs(db, &database.SQLiteDB{}, KPIQueryParams{
+				LabelFilters: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "pod", "x")},
+				Limit:        3,
+				Sort:         "asc",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			for _, result := range results {
+				Expect(result.MetricLabels).To(ContainSubstring(`"pod":"x"`))
+			}
+			Expect(results[0].MetricValue).To(Equal(15.0))
+		})
+
+		It("should require every label to match", func() {
+			matchers, err := parseLabelFilters("pod=x,namespace=ns-1")
+			Expect(err).NotTo(HaveOccurred())
+			results, err := queryKPIs(db, &database.SQLiteDB{}, KPIQueryParams{
+				LabelFilters: matchers,
+				Sort:         "asc",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			for i, value := range []float64{15, 17, 19} {
+				Expect(results[i].MetricValue).To(Equal(value))
+			}
+		})
+
+		DescribeTable("should evaluate PromQL label matchers",
+			func(selector string, expectedCount int) {
+				matchers, err := parseLabelFilters(selector)
+				Expect(err).NotTo(HaveOccurred())
+				results, err := queryKPIs(db, &database.SQLiteDB{}, KPIQueryParams{LabelFilters: matchers})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(HaveLen(expectedCount))
+			},
+			Entry("equality", `{pod="x"}`, 5),
+			Entry("negation", `{pod!="x"}`, 15),
+			Entry("regex", `{pod=~"o.*"}`, 15),
+			Entry("anchored regex", `{pod=~"th"}`, 0),
+			Entry("set membership", `{pod=~"x|other"}`, 20),
+			Entry("negated regex", `{namespace!~"ns-0|ns-2"}`, 10),
+			Entry("every matcher", `{pod!="other",namespace=~"ns-1"}`, 3),
+			Entry("missing label equals empty", `{node=""}`, 20),
+			Entry("missing label is not set", `{node!=""}`, 0),
+			Entry("more than 20 arguments",
+				`{pod!="other",pod!="a1",pod!="a2",pod!="a3",pod!="a4",pod!="a5",pod!="a6",pod!="a7",pod!="a8",pod!="a9",namespace=~"ns-1"}`, 3),
+		)
+	})
+})
+
+var _ = Describe("parseLabelFilters", func() {
+	It("should parse comma-separated pairs as exact matches", func() {
+		matchers, err := parseLabelFilters("pod=x, namespace = ns-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matchers).To(Equal([]*labels.Matcher{
+			labels.MustNewMatcher(labels.MatchEqual, "pod", "x"),
+			labels.MustNewMatcher(labels.MatchEqual, "namespace", "ns-1"),
+		}))
+	})
+
+	It("should parse a PromQL selector", func() {
+		matchers, err := parseLabelFilters(`{id=~"/system.slice/crio.*",cpu!="total"}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matchers).To(HaveLen(2))
+		Expect(matchers[0].Type).To(Equal(labels.MatchRegexp))
+		Expect(matchers[0].Matches("/system.slice/crio-1234.scope")).To(BeTrue())
+		Expect(matchers[1].Type).To(Equal(labels.MatchNotEqual))
+	})
+
+	It("should parse matchers other than equality as a selector without braces", func() {
+		matchers, err := parseLabelFilters(`node!="a",pod=~"etcd-.*"`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matchers).To(HaveLen(2))
+		Expect(matchers[0].String()).To(Equal(`node!="a"`))
+		Expect(matchers[1].String()).To(Equal(`pod=~"etcd-.*"`))
+	})
+
+	It("should reject invalid filters", func() {
+		_, err := parseLabelFilters("pod")
+		Expect(err).To(HaveOccurred())
+		_, err = parseLabelFilters(`{pod=~"("}`)
+		Expect(err).To(HaveOccurred())
+		_, err = parseLabelFilters("node!=a")
+		Expect(err).To(MatchError(ContainSubstring("quote the values")))
+		_, err = parseLabelFilters("no-de=a")
+		Expect(err).To(MatchError(ContainSubstring(`invalid label name "no-de"`)))
+	})
+})
+
+var _ = Describe("convertPostgresToSQLitePlaceholders", func() {
+	It("should replace every numbered placeholder, past $20", func() {
+		Expect(convertPostgresToSQLitePlaceholders("a = $1 AND b IN ($20, $21, $22) AND c = '$.x'")).
+			To(Equal("a = ? AND b IN (?, ?, ?) AND c = '$.x'"))
+	})
 })
 
 var _ = Describe("parseTimeFilter", func() {
@@ -176,3 +296,292 @@ var _ = Describe("parseKPIQueryTimeWindow", func() {
 		Expect(err.Error()).To(ContainSubstring("must resolve before --until"))
 	})
 })
+
+var _ = Describe("db show errors", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		tmpDir   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-show-errors-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			_ = db.Close()
+		}
+		database.OutputDir = database.DefaultOutputDir
+		_ = os.RemoveAll(tmpDir)
+	})
+
+	Describe("listErrors", func() {
+		var clusterA, clusterB int64
+
+		BeforeEach(func() {
+			var err error
+			clusterA, err = sqliteDB.GetOrCreateCluster(db, "cluster-a", "")
+			Expect(err).NotTo(HaveOccurred())
+			clusterB, err = sqliteDB.GetOrCreateCluster(db, "cluster-b", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should report error and retry counts per cluster and KPI", func() {
+			Expect(sqliteDB.IncrementQueryError(db, clusterA, "kpi-failing")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryRetry(db, clusterA, "kpi-failing")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryRetry(db, clusterA, "kpi-failing")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryRetry(db, clusterA, "kpi-recovered")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryRetry(db, clusterB, "kpi-failing")).To(Succeed())
+
+			errors, err := listErrors(db, sqliteDB, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors).To(Equal([]ErrorInfo{
+				{ClusterName: "cluster-a", KPIID: "kpi-failing", ErrorCount: 1, RetryCount: 2},
+				{ClusterName: "cluster-a", KPIID: "kpi-recovered", ErrorCount: 0, RetryCount: 1},
+				{ClusterName: "cluster-b", KPIID: "kpi-failing", ErrorCount: 0, RetryCount: 1},
+			}))
+		})
+
+		It("should filter by cluster", func() {
+			Expect(sqliteDB.IncrementQueryError(db, clusterA, "kpi-failing")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryError(db, clusterB, "kpi-failing")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryError(db, clusterB, "kpi-failing")).To(Succeed())
+
+			errors, err := listErrors(db, sqliteDB, "cluster-b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors).To(Equal([]ErrorInfo{
+				{ClusterName: "cluster-b", KPIID: "kpi-failing", ErrorCount: 2, RetryCount: 0},
+			}))
+		})
+	})
+
+	Describe("listErrorEvents", func() {
+		var clusterA, clusterB int64
+
+		recordAt := func(clusterID int64, kpiID string, status int, occurredAt time.Time) {
+			Expect(sqliteDB.RecordQueryError(db, clusterID, database.QueryErrorEvent{
+				KPIID: kpiID, QueryType: "instant", PromQuery: "up", HTTPStatus: status,
+				Message: "server error", Warnings: []string{"partial response"}, Attempt: 1,
+			})).To(Succeed())
+			_, err := db.Exec("UPDATE query_error_events SET occurred_at = ? WHERE id = (SELECT MAX(id) FROM query_error_events)",
+				occurredAt.UTC().Format("2006-01-02 15:04:05"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			var err error
+			clusterA, err = sqliteDB.GetOrCreateCluster(db, "cluster-a", "")
+			Expect(err).NotTo(HaveOccurred())
+			clusterB, err = sqliteDB.GetOrCreateCluster(db, "cluster-b", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should return the details of every failed attempt, newest first", func() {
+			now := time.Now()
+			recordAt(clusterA, "kpi-old", 503, now.Add(-3*time.Hour))
+			recordAt(clusterB, "kpi-new", 0, now.Add(-time.Minute))
+
+			events, err := listErrorEvents(db, sqliteDB, ErrorEventQueryParams{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(2))
+
+			Expect(events[0].KPIID).To(Equal("kpi-new"))
+			Expect(events[0].Cluster).To(Equal("cluster-b"))
+			Expect(events[0].HTTPStatus).To(BeZero())
+			Expect(events[1].KPIID).To(Equal("kpi-old"))
+			Expect(events[1].HTTPStatus).To(Equal(503))
+			Expect(events[1].PromQuery).To(Equal("up"))
+			Expect(events[1].Warnings).To(Equal([]string{"partial response"}))
+			Expect(events[1].OccurredAt).To(BeTemporally("~", now.Add(-3*time.Hour), time.Second))
+		})
+
+		It("should filter by cluster and time window", func() {
+			now := time.Now()
+			recordAt(clusterA, "kpi-1", 503, now.Add(-3*time.Hour))
+			recordAt(clusterA, "kpi-1", 503, now.Add(-30*time.Minute))
+			recordAt(clusterB, "kpi-1", 503, now.Add(-30*time.Minute))
+
+			since := now.Add(-time.Hour)
+			events, err := listErrorEvents(db, sqliteDB, ErrorEventQueryParams{ClusterName: "cluster-a", Since: &since})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Cluster).To(Equal("cluster-a"))
+			Expect(events[0].OccurredAt).To(BeTemporally("~", now.Add(-30*time.Minute), time.Second))
+
+			until := now.Add(-time.Hour)
+			events, err = listErrorEvents(db, sqliteDB, ErrorEventQueryParams{Until: &until})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].OccurredAt).To(BeTemporally("~", now.Add(-3*time.Hour), time.Second))
+		})
+	})
+})
+
+var _ = Describe("db show runs", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		tmpDir   string
+		cluster  int64
+	)
+
+	sample := func(value float64) model.Vector {
+		return model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: model.SampleValue(value),
+			Timestamp: model.Time(time.Now().Unix()*1000) + model.Time(value)}}
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-show-runs-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		cluster, err = sqliteDB.GetOrCreateCluster(db, "cluster-a", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			_ = db.Close()
+		}
+		database.OutputDir = database.DefaultOutputDir
+		_ = os.RemoveAll(tmpDir)
+	})
+
+	It("should list runs newest first with their sample counts", func() {
+		first, err := sqliteDB.StartCollectionRun(db, cluster, database.CollectionRun{
+			Frequency: 30 * time.Second, Duration: time.Hour, KPIsFileHash: "abc", ToolVersion: "v1.0.0",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqliteDB.StoreQueryResults(db, cluster, first, "kpi-1", sample(1))).To(Succeed())
+		Expect(sqliteDB.StoreQueryResults(db, cluster, first, "kpi-1", sample(2))).To(Succeed())
+		Expect(sqliteDB.FinishCollectionRun(db, first, database.CollectionRunResult{
+			ShutdownReason: "Duration completed", FailedQueries: 2, Retries: 3,
+		})).To(Succeed())
+
+		second, err := sqliteDB.StartCollectionRun(db, cluster, database.CollectionRun{ToolVersion: "v1.1.0"})
+		Expect(err).NotTo(HaveOccurred())
+
+		runs, err := listRuns(db, sqliteDB, "", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(HaveLen(2))
+
+		Expect(runs[0].ID).To(Equal(second))
+		Expect(runs[0].EndedAt).To(BeNil())
+		Expect(runs[0].FrequencySeconds).To(BeZero())
+
+		Expect(runs[1].ID).To(Equal(first))
+		Expect(runs[1].Cluster).To(Equal("cluster-a"))
+		Expect(runs[1].EndedAt).NotTo(BeNil())
+		Expect(runs[1].FrequencySeconds).To(Equal(30.0))
+		Expect(runs[1].DurationSeconds).To(Equal(3600.0))
+		Expect(runs[1].KPIsFileHash).To(Equal("abc"))
+		Expect(runs[1].ToolVersion).To(Equal("v1.0.0"))
+		Expect(runs[1].ShutdownReason).To(Equal("Duration completed"))
+		Expect(runs[1].FailedQueries).To(Equal(int64(2)))
+		Expect(runs[1].Retries).To(Equal(int64(3)))
+		Expect(runs[1].Samples).To(Equal(int64(2)))
+
+		runs, err = listRuns(db, sqliteDB, "", 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(HaveLen(1))
+
+		runs, err = listRuns(db, sqliteDB, "other-cluster", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs).To(BeEmpty())
+	})
+
+	It("should only show the samples of the requested run", func() {
+		first, err := sqliteDB.StartCollectionRun(db, cluster, database.CollectionRun{})
+		Expect(err).NotTo(HaveOccurred())
+		second, err := sqliteDB.StartCollectionRun(db, cluster, database.CollectionRun{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqliteDB.StoreQueryResults(db, cluster, first, "kpi-1", sample(1))).To(Succeed())
+		Expect(sqliteDB.StoreQueryResults(db, cluster, second, "kpi-1", sample(2))).To(Succeed())
+
+		results, err := queryKPIs(db, sqliteDB, KPIQueryParams{KPIName: "kpi-1", RunID: second})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].MetricValue).To(Equal(2.0))
+
+		results, err = queryKPIs(db, sqliteDB, KPIQueryParams{KPIName: "kpi-1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("db show definitions", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		tmpDir   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "db-show-definitions-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if db != nil {
+			_ = db.Close()
+		}
+		database.OutputDir = database.DefaultOutputDir
+		_ = os.RemoveAll(tmpDir)
+	})
+
+	It("should list every version of a definition with the runs that used it", func() {
+		cluster, err := sqliteDB.GetOrCreateCluster(db, "cluster-a", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		v1 := database.KPIDefinition{KPIID: "cpu", PromQuery: "up", ResolvedPromQuery: "up", QueryType: "instant", Frequency: time.Minute}
+		v2 := database.KPIDefinition{KPIID: "cpu", PromQuery: "up", ResolvedPromQuery: "up", QueryType: "range",
+			RangeStep: "30s", RangeSince: "1h", Frequency: time.Minute}
+		other := database.KPIDefinition{KPIID: "memory", PromQuery: "mem", ResolvedPromQuery: "mem", QueryType: "instant", RunOnce: true}
+
+		var runs []int64
+		for _, definitions := range [][]database.KPIDefinition{{v1, other}, {v1}, {v2}} {
+			runID, err := sqliteDB.StartCollectionRun(db, cluster, database.CollectionRun{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sqliteDB.RecordKPIDefinitions(db, runID, definitions)).To(Succeed())
+			runs = append(runs, runID)
+		}
+
+		definitions, err := listDefinitions(db, sqliteDB, "cpu", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(definitions).To(HaveLen(2))
+		Expect(definitions[0].QueryType).To(Equal("range"))
+		Expect(definitions[0].RangeStep).To(Equal("30s"))
+		Expect(definitions[0].RangeSince).To(Equal("1h"))
+		Expect(definitions[0].Runs).To(Equal(int64(1)))
+		Expect(definitions[0].LastRunID).To(Equal(runs[2]))
+		Expect(definitions[1].Hash).To(Equal(v1.Hash()))
+		Expect(definitions[1].FrequencySeconds).To(Equal(60.0))
+		Expect(definitions[1].Runs).To(Equal(int64(2)))
+		Expect(definitions[1].LastRunID).To(Equal(runs[1]))
+
+		definitions, err = listDefinitions(db, sqliteDB, "", runs[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(definitions).To(HaveLen(2))
+		Expect(definitions[0].KPIID).To(Equal("cpu"))
+		Expect(definitions[1].KPIID).To(Equal("memory"))
+		Expect(definitions[1].RunOnce).To(BeTrue())
+	})
+})
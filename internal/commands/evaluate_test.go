@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+var _ = Describe("evaluate", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		runID    int64
+		kpis     config.KPIs
+	)
+
+	timestamp := 1700000000.0
+	insert := func(kpiName string, value float64, labels string, run interface{}) {
+		timestamp += 60
+		_, err := db.Exec(
+			"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels, run_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			kpiName, value, timestamp, 1, "2026-01-01 00:00:00", labels, run,
+		)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		tmpDir, err := os.MkdirTemp("", "evaluate-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+		DeferCleanup(func() {
+			database.OutputDir = database.DefaultOutputDir
+			_ = os.RemoveAll(tmpDir)
+		})
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+
+		clusterID, err := sqliteDB.GetOrCreateCluster(db, "prod", "ran")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clusterID).To(Equal(int64(1)))
+		runID, err = sqliteDB.StartCollectionRun(db, clusterID, database.CollectionRun{})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, value := range []float64{0.2, 0.4, 0.9} {
+			insert("cpu", value, `{"cpu":"0"}`, runID)
+		}
+		insert("cpu", 0.3, `{"cpu":"2"}`, runID)
+		insert("cpu", 0.85, `{"cpu":"2"}`, nil)
+
+		kpis = config.KPIs{Queries: []config.Query{
+			{ID: "cpu", PromQuery: "up", Thresholds: &config.Thresholds{
+				Limits: map[string]float64{"avg-max": 0.6, "max": 0.8},
+				Overrides: []config.ThresholdOverride{
+					{Labels: `{cpu="0"}`, Limits: map[string]float64{"max": 1}},
+				},
+			}},
+			{ID: "no-thresholds", PromQuery: "up"},
+			{ID: "latency", PromQuery: "up", Thresholds: &config.Thresholds{
+				Limits: map[string]float64{"p99-max": 0.1},
+			}},
+		}}
+	})
+
+	It("should check every bound of every series, in KPI order then by labels", func() {
+		records, failures, err := evaluateKPIs(db, sqliteDB, kpis, KPIQueryParams{RunID: runID})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(5))
+
+		// cpu 0 uses the override of max
+		Expect(records[0].Labels).To(Equal(map[string]string{"cpu": "0"}))
+		Expect(records[0].Threshold).To(Equal("avg <= 0.6"))
+		Expect(*records[0].Value).To(BeNumerically("~", 0.5, 1e-9))
+		Expect(records[0].Samples).To(Equal(3))
+		Expect(records[0].Status).To(Equal(output.EvaluationPass))
+		Expect(records[1].Threshold).To(Equal("max <= 1"))
+		Expect(records[1].Status).To(Equal(output.EvaluationPass))
+
+		// cpu 2 only has one sample in the run
+		Expect(records[3].Labels).To(Equal(map[string]string{"cpu": "2"}))
+		Expect(records[3].Threshold).To(Equal("max <= 0.8"))
+		Expect(*records[3].Value).To(Equal(0.3))
+
+		Expect(records[4]).To(Equal(output.EvaluationRecord{KPIName: "latency", Status: output.EvaluationNoData}))
+		Expect(failures).To(Equal(1))
+	})
+
+	It("should fail the evaluation of a cluster beyond a threshold", func() {
+		cmd := &cobra.Command{}
+		var out strings.Builder
+		printer := output.NewPrinter(output.FormatTable).WithWriter(&out)
+
+		kpis.Queries = kpis.Queries[:1]
+		err := evaluateAndReport(cmd, db, sqliteDB, kpis,
+			comparisonSide{ClusterName: "prod"}, KPIQueryParams{ClusterName: "prod"}, printer)
+		Expect(err).To(MatchError("1 threshold check(s) failed"))
+		Expect(cmd.SilenceUsage).To(BeTrue())
+
+		Expect(out.String()).To(ContainSubstring("Scope: cluster prod"))
+		Expect(out.String()).To(MatchRegexp(`cpu\s+\{cpu="2"\}\s+max <= 0.8\s+0.850000\s+2\s+FAIL`))
+		Expect(out.String()).To(ContainSubstring("FAILED: 4 check(s), 1 failed"))
+	})
+
+	It("should pass when every check passes", func() {
+		kpis.Queries = kpis.Queries[:1]
+		var out strings.Builder
+		err := evaluateAndReport(&cobra.Command{}, db, sqliteDB, kpis,
+			comparisonSide{RunID: runID}, KPIQueryParams{RunID: runID}, output.NewPrinter(output.FormatCSV).WithWriter(&out))
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).To(HaveLen(5))
+		Expect(lines[0]).To(Equal("kpi_name,labels,threshold,value,samples,status"))
+		Expect(lines[1]).To(Equal(`cpu,"{""cpu"":""0""}",avg <= 0.6,0.500000,3,pass`))
+	})
+
+	It("should evaluate the run a collection recorded, not the latest run of its cluster", func() {
+		// A concurrent collector on the same cluster records a later run
+		laterRunID, err := sqliteDB.StartCollectionRun(db, 1, database.CollectionRun{})
+		Expect(err).NotTo(HaveOccurred())
+		insert("cpu", 5, `{"cpu":"0"}`, laterRunID)
+
+		kpis.Queries = kpis.Queries[:1]
+		flags := config.InputFlags{DatabaseType: "sqlite"}
+		Expect(evaluateCollectionRun(&cobra.Command{}, kpis, flags, runID)).To(Succeed())
+		Expect(evaluateCollectionRun(&cobra.Command{}, kpis, flags, laterRunID)).To(MatchError("2 threshold check(s) failed"))
+		Expect(dbFlags.DatabaseType).To(BeEmpty())
+	})
+
+	DescribeTable("should compute the statistics of the sorted values",
+		func(statistic string, expected float64) {
+			Expect(seriesStatistic([]float64{1, 2, 3, 4}, statistic)).To(BeNumerically("~", expected, 1e-9))
+		},
+		Entry("min", "min", 1.0),
+		Entry("max", "max", 4.0),
+		Entry("avg", "avg", 2.5),
+		Entry("p50", "p50", 2.5),
+		Entry("p90", "p90", 3.7),
+	)
+})
@@ -0,0 +1,255 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/report"
+
+	"github.com/spf13/cobra"
+)
+
+var reportFlags struct {
+	clusterName string
+	runID       int64
+	kpisFile    string
+	since       string
+	until       string
+	format      string
+	outputFile  string
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate an HTML or Markdown test report from the stored data",
+	Long: `Generate a self-contained test report of a cluster, or of one of its collection
+runs, from the data stored by 'run'. The report holds:
+
+  - the collection runs (see 'db show runs')
+  - the KPI definitions they used (see 'db show definitions')
+  - the threshold results, with --kpis-file (see 'evaluate')
+  - per KPI, an SVG time-series chart and the count, min, avg, p50, p95, p99
+    and max of each series
+  - the query errors and retries of the cluster (see 'db show errors')
+
+HTML reports embed their charts and styles, so they can be attached or mailed
+as a single file. Markdown reports embed the charts as inline SVG, which most
+Markdown viewers render.`,
+	Example: `  # HTML report of a collection run
+  kpi-collector report --cluster-name prod --run-id 7 -o report.html
+
+  # Markdown report of every sample of a cluster, with the threshold results
+  kpi-collector report --cluster-name prod --kpis-file kpis.yaml --format markdown -o report.md
+
+  # Report of the last 24 hours, written to stdout
+  kpi-collector report --cluster-name prod --since 24h`,
+	RunE: runReport,
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	reportCmd.Flags().StringVar(&reportFlags.clusterName, "cluster-name", "",
+		"cluster to report on (required)")
+	reportCmd.Flags().Int64Var(&reportFlags.runID, "run-id", 0,
+		"only report on this collection run of the cluster")
+	reportCmd.Flags().StringVar(&reportFlags.kpisFile, "kpis-file", "",
+		"KPIs file whose thresholds are evaluated in the report")
+	reportCmd.Flags().StringVar(&reportFlags.since, "since", "",
+		"only report samples since this timestamp (Go duration or RFC3339)")
+	reportCmd.Flags().StringVar(&reportFlags.until, "until", "",
+		"only report samples until this timestamp (Go duration or RFC3339)")
+	reportCmd.Flags().StringVar(&reportFlags.format, "format", "html",
+		"report format: html or markdown")
+	reportCmd.Flags().StringVarP(&reportFlags.outputFile, "output", "o", "",
+		"file the report is written to (default: stdout)")
+
+	// The database flags of the db commands
+	reportCmd.Flags().StringVar(&dbFlags.DatabaseType, "db-type", "",
+		"database type: sqlite (default), postgres or file")
+	reportCmd.Flags().StringVar(&dbFlags.PostgresURL, "postgres-url", "",
+		"PostgreSQL connection string")
+
+	_ = reportCmd.MarkFlagRequired("cluster-name")
+}
+
+func runReport(cmd *cobra.Command, args []string) error {
+	format, err := report.ParseFormat(reportFlags.format)
+	if err != nil {
+		return err
+	}
+	if reportFlags.runID < 0 {
+		return fmt.Errorf("--run-id must be a positive run ID")
+	}
+
+	sinceTime, untilTime, err := parseKPIQueryTimeWindow(reportFlags.since, reportFlags.until, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var kpis *config.KPIs
+	if reportFlags.kpisFile != "" {
+		loaded, err := loadThresholdKPIs(reportFlags.kpisFile)
+		if err != nil {
+			return err
+		}
+		kpis = &loaded
+	}
+
+	db, dbImpl, err := connectToDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	params := KPIQueryParams{ClusterName: reportFlags.clusterName, RunID: reportFlags.runID, Since: sinceTime, Until: untilTime}
+	data, err := buildReport(db, dbImpl, params, kpis)
+	if err != nil {
+		return err
+	}
+
+	if reportFlags.outputFile == "" {
+		return report.Render(os.Stdout, format, data)
+	}
+	if err := writeReport(reportFlags.outputFile, format, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Report written to %s\n", reportFlags.outputFile)
+	return nil
+}
+
+// writeReport renders the report to a file
+func writeReport(path string, format report.Format, data report.Data) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write report file: %w", closeErr)
+		}
+	}()
+
+	return report.Render(file, format, data)
+}
+
+// buildReport collects the report data of the cluster, or run, selected by
+// params. The thresholds of kpis are evaluated when it is not nil.
+func buildReport(db *sql.DB, dbImpl database.Database, params KPIQueryParams, kpis *config.KPIs) (report.Data, error) {
+	scope := comparisonSide{ClusterName: params.ClusterName, RunID: params.RunID}
+	description, err := scope.describe(db, dbImpl)
+	if err != nil {
+		return report.Data{}, err
+	}
+
+	data := report.Data{Scope: description, GeneratedAt: time.Now(), ToolVersion: gitVersion()}
+
+	runs, err := listRuns(db, dbImpl, params.ClusterName, 0)
+	if err != nil {
+		return data, fmt.Errorf("failed to list collection runs: %w", err)
+	}
+	for _, run := range runs {
+		if params.RunID == 0 || run.ID == params.RunID {
+			data.Runs = append(data.Runs, run)
+		}
+	}
+	if params.RunID != 0 && len(data.Runs) == 0 {
+		return data, fmt.Errorf("run %d is not a run of cluster %s", params.RunID, params.ClusterName)
+	}
+
+	if data.Definitions, err = runDefinitions(db, dbImpl, data.Runs); err != nil {
+		return data, err
+	}
+
+	kpiSeries, err := loadSeries(db, dbImpl, params, nil)
+	if err != nil {
+		return data, fmt.Errorf("failed to load KPI samples: %w", err)
+	}
+	data.KPIs = reportKPIs(kpiSeries)
+
+	if kpis != nil {
+		evaluation := output.EvaluationReport{Scope: description}
+		evaluation.Records, evaluation.Failures, err = evaluateKPIs(db, dbImpl, *kpis, params)
+		if err != nil {
+			return data, fmt.Errorf("failed to evaluate KPIs: %w", err)
+		}
+		evaluation.Passed = evaluation.Failures == 0
+		data.Evaluation = &evaluation
+	}
+
+	errorCounts, err := listErrors(db, dbImpl, params.ClusterName)
+	if err != nil {
+		return data, fmt.Errorf("failed to list query errors: %w", err)
+	}
+	for _, e := range errorCounts {
+		data.Errors = append(data.Errors, report.ErrorSummary{KPIID: e.KPIID, Errors: e.ErrorCount, Retries: e.RetryCount})
+	}
+
+	return data, nil
+}
+
+// runDefinitions returns the KPI definitions used by the runs, by KPI and
+// newest first
+func runDefinitions(db *sql.DB, dbImpl database.Database, runs []output.RunRecord) ([]output.DefinitionRecord, error) {
+	seen := make(map[int64]bool)
+	var definitions []output.DefinitionRecord
+	for _, run := range runs {
+		used, err := listDefinitions(db, dbImpl, "", run.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the KPI definitions of run %d: %w", run.ID, err)
+		}
+		for _, d := range used {
+			if !seen[d.ID] {
+				seen[d.ID] = true
+				definitions = append(definitions, d)
+			}
+		}
+	}
+
+	sort.SliceStable(definitions, func(i, j int) bool {
+		if definitions[i].KPIID != definitions[j].KPIID {
+			return definitions[i].KPIID < definitions[j].KPIID
+		}
+		return definitions[i].ID > definitions[j].ID
+	})
+	return definitions, nil
+}
+
+// reportKPIs converts the series to the report KPIs, sorted by name, with
+// their series sorted by labels
+func reportKPIs(kpiSeries map[string]*series) []report.KPI {
+	keys := make([]string, 0, len(kpiSeries))
+	for key := range kpiSeries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var kpis []report.KPI
+	for _, key := range keys {
+		s := kpiSeries[key]
+		if len(kpis) == 0 || kpis[len(kpis)-1].Name != s.KPIName {
+			kpis = append(kpis, report.KPI{Name: s.KPIName})
+		}
+
+		points := make([]report.Point, len(s.Values))
+		for i, value := range s.Values {
+			seconds, fraction := math.Modf(s.Timestamps[i])
+			points[i] = report.Point{Time: time.Unix(int64(seconds), int64(fraction*1e9)), Value: value}
+		}
+
+		kpi := &kpis[len(kpis)-1]
+		kpi.Series = append(kpi.Series, report.Series{
+			Labels: s.Labels,
+			Stats:  *summarize(s.Values),
+			Points: points,
+		})
+	}
+	return kpis
+}
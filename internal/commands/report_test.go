@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/report"
+)
+
+var _ = Describe("report", func() {
+	var (
+		db       *sql.DB
+		sqliteDB *database.SQLiteDB
+		runID    int64
+		params   KPIQueryParams
+	)
+
+	BeforeEach(func() {
+		tmpDir, err := os.MkdirTemp("", "report-test-*")
+		Expect(err).NotTo(HaveOccurred())
+		database.OutputDir = tmpDir
+		DeferCleanup(func() {
+			database.OutputDir = database.DefaultOutputDir
+			_ = os.RemoveAll(tmpDir)
+		})
+
+		sqliteDB = database.NewSQLiteDB()
+		db, err = sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+
+		clusterID, err := sqliteDB.GetOrCreateCluster(db, "prod", "ran")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = sqliteDB.GetOrCreateCluster(db, "lab", "ran")
+		Expect(err).NotTo(HaveOccurred())
+
+		// An older run that the report of the second run leaves out
+		oldRunID, err := sqliteDB.StartCollectionRun(db, clusterID, database.CollectionRun{})
+		Expect(err).NotTo(HaveOccurred())
+		runID, err = sqliteDB.StartCollectionRun(db, clusterID, database.CollectionRun{ToolVersion: "v1.2.3"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqliteDB.RecordKPIDefinitions(db, runID, []database.KPIDefinition{
+			{KPIID: "cpu", PromQuery: "rate(cpu[1m])", ResolvedPromQuery: "rate(cpu[1m])", QueryType: "instant"},
+		})).To(Succeed())
+
+		for i, value := range []float64{0.2, 0.4, 0.9} {
+			_, err = db.Exec(
+				"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels, run_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				"cpu", value, 1700000000.0+60*float64(i), clusterID, "2026-01-01 00:00:00", `{"cpu":"<0>"}`, runID,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = db.Exec(
+			"INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, execution_time, metric_labels, run_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			"memory", 5.0, 1690000000.0, clusterID, "2026-01-01 00:00:00", `{}`, oldRunID,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sqliteDB.IncrementQueryError(db, clusterID, "cpu")).To(Succeed())
+
+		params = KPIQueryParams{ClusterName: "prod", RunID: runID}
+	})
+
+	It("should collect the data of a run", func() {
+		kpis := config.KPIs{Queries: []config.Query{
+			{ID: "cpu", PromQuery: "up", Thresholds: &config.Thresholds{Limits: map[string]float64{"max": 0.8}}},
+		}}
+		data, err := buildReport(db, sqliteDB, params, &kpis)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(data.Scope).To(Equal("run 2 (cluster prod)"))
+		Expect(data.Runs).To(HaveLen(1))
+		Expect(data.Runs[0].ToolVersion).To(Equal("v1.2.3"))
+		Expect(data.Definitions).To(HaveLen(1))
+		Expect(data.Definitions[0].KPIID).To(Equal("cpu"))
+
+		Expect(data.KPIs).To(HaveLen(1))
+		cpu := data.KPIs[0].Series[0]
+		Expect(cpu.Labels).To(Equal(map[string]string{"cpu": "<0>"}))
+		Expect(cpu.Stats.Count).To(Equal(int64(3)))
+		Expect(cpu.Stats.Max).To(Equal(0.9))
+		Expect(cpu.Points).To(HaveLen(3))
+		Expect(cpu.Points[2].Time.Unix()).To(Equal(int64(1700000120)))
+
+		Expect(data.Evaluation.Passed).To(BeFalse())
+		Expect(data.Evaluation.Records[0].Status).To(Equal(output.EvaluationFail))
+		Expect(data.Errors).To(Equal([]report.ErrorSummary{{KPIID: "cpu", Errors: 1}}))
+	})
+
+	It("should reject a run of another cluster", func() {
+		params.ClusterName = "lab"
+		_, err := buildReport(db, sqliteDB, params, nil)
+		Expect(err).To(MatchError("run 2 is not a run of cluster lab"))
+	})
+
+	It("should render every section as HTML and Markdown", func() {
+		params.RunID = 0
+		data, err := buildReport(db, sqliteDB, params, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Runs).To(HaveLen(2))
+		Expect(data.KPIs).To(HaveLen(2))
+
+		var htmlReport strings.Builder
+		Expect(report.Render(&htmlReport, report.FormatHTML, data)).To(Succeed())
+		Expect(htmlReport.String()).To(ContainSubstring("<title>KPI Report: cluster prod</title>"))
+		Expect(htmlReport.String()).To(ContainSubstring("<code>rate(cpu[1m])</code>"))
+		Expect(htmlReport.String()).To(ContainSubstring("<h3>cpu</h3>\n<svg"))
+		Expect(htmlReport.String()).To(ContainSubstring(`{cpu=&#34;&lt;0&gt;&#34;}`))
+		Expect(htmlReport.String()).To(ContainSubstring("No thresholds evaluated"))
+
+		var markdownReport strings.Builder
+		Expect(report.Render(&markdownReport, report.FormatMarkdown, data)).To(Succeed())
+		Expect(markdownReport.String()).To(HavePrefix("# KPI Report: cluster prod\n"))
+		Expect(markdownReport.String()).To(ContainSubstring("### memory\n\n<svg"))
+		Expect(markdownReport.String()).To(ContainSubstring("| `{cpu=\"<0>\"}` | 3 | 0.2 | 0.5 | 0.4 | 0.85 | 0.89 | 0.9 |"))
+		Expect(markdownReport.String()).To(ContainSubstring("| cpu | 1 | 0 |"))
+	})
+})
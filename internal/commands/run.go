@@ -7,11 +7,14 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/alerting"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/collector"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/kubernetes"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/logger"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/prometheus"
 
 	"github.com/spf13/cobra"
 )
@@ -52,6 +55,15 @@ Use --artifacts-dir to override.`,
     --kubeconfig ~/.kube/config --kpis-file kpis.yaml \
     --frequency 30s --duration 2h
 
+  # Keep 30 days of raw samples and hourly rollups of older ones
+  kpi-collector run --cluster-name prod --cluster-type ran \
+    --kubeconfig ~/.kube/config --kpis-file kpis.yaml \
+    --retention 30d --retention-downsample 1h
+
+  # Collect once and fail when a KPI threshold is not met, e.g. in CI
+  kpi-collector run --cluster-name prod --cluster-type ran \
+    --kubeconfig ~/.kube/config --kpis-file kpis.yaml --once --evaluate
+
   # With PostgreSQL backend
   kpi-collector run --cluster-name prod --cluster-type hub \
     --kubeconfig ~/.kube/config --kpis-file kpis.yaml \
@@ -84,9 +96,28 @@ func init() {
 
 	// Database flags
 	runCmd.Flags().StringVar(&flags.DatabaseType, "db-type", "sqlite",
-		"database type: sqlite (default) or postgres")
+		"database type: sqlite (default), postgres or file")
 	runCmd.Flags().StringVar(&flags.PostgresURL, "postgres-url", "",
 		"PostgreSQL connection string (required if db-type=postgres)")
+	runCmd.Flags().StringVar(&flags.FileFormat, "file-format", database.FileFormatJSONL,
+		"format of the sample files if db-type=file: jsonl or openmetrics")
+	runCmd.Flags().IntVar(&flags.InsertBatchSize, "insert-batch-size", database.DefaultInsertBatchSize,
+		"number of samples written per database INSERT statement")
+
+	runCmd.Flags().IntVar(&flags.MaxConcurrentQueries, "max-concurrent-queries", prometheus.DefaultMaxConcurrentQueries,
+		"maximum number of queries executed in parallel within a frequency group")
+	runCmd.Flags().IntVar(&flags.MaxRetries, "max-retries", prometheus.DefaultMaxRetries,
+		"retries after a transient query failure (HTTP 429/5xx, timeouts, network errors)")
+	runCmd.Flags().DurationVar(&flags.RetryBackoff, "retry-backoff", prometheus.DefaultRetryBackoff,
+		"delay before the first retry, doubled on every further retry")
+
+	// Retention flags
+	runCmd.Flags().Var(newAgeValue(0, &flags.Retention), "retention",
+		"prune this cluster's samples older than this age while collecting, e.g. 30d (0 keeps all samples)")
+	runCmd.Flags().Var(newAgeValue(0, &flags.RetentionDownsample), "retention-downsample",
+		"roll pruned samples up into buckets of this size instead of only deleting them, e.g. 1h or 1d")
+	runCmd.Flags().DurationVar(&flags.RetentionInterval, "retention-interval", time.Hour,
+		"how often samples are pruned when --retention is set")
 
 	runCmd.Flags().StringVar(&flags.KPIsFile, "kpis-file", "",
 		"path to KPIs configuration file (required)")
@@ -95,6 +126,21 @@ func init() {
 	runCmd.Flags().BoolVar(&flags.SingleRun, "once", false,
 		"collect all KPI metrics once and exit (ignores --frequency and --duration)")
 
+	runCmd.Flags().BoolVar(&flags.Evaluate, "evaluate", false,
+		"check the collected samples against the KPI thresholds once collection ends (see 'evaluate')")
+
+	// Alert flags
+	runCmd.Flags().StringVar(&flags.AlertWebhookURL, "alert-webhook", "",
+		"URL the KPI threshold alerts raised during collection are POSTed to as JSON")
+	runCmd.Flags().Float64Var(&flags.AlertHysteresis, "alert-hysteresis", alerting.DefaultHysteresis,
+		"percent of a threshold a value must clear before its alert resolves")
+
+	// Sink flags
+	runCmd.Flags().StringArrayVar(&flags.Sinks, "sink", nil,
+		"additional storage the samples are also written to, repeatable: sqlite:<path>, postgres:<url>, jsonl:<path> or remote-write:<url>")
+	runCmd.Flags().StringVar(&flags.RemoteWriteURL, "remote-write-url", "",
+		"Prometheus remote-write endpoint the collected samples are also sent to (same as --sink remote-write:<url>)")
+
 	// Mark required flags
 	if err := runCmd.MarkFlagRequired("cluster-name"); err != nil {
 		panic(fmt.Sprintf("failed to mark cluster-name as required: %v", err))
@@ -157,6 +203,13 @@ func runCollect(cmd *cobra.Command, args []string) error {
 	}
 	fmt.Printf("✓ Validated %d KPI(s)\n", len(kpis.Queries))
 
+	if flags.Evaluate && !hasThresholds(kpis) {
+		return fmt.Errorf("--evaluate requires thresholds on at least one KPI of %s", flags.KPIsFile)
+	}
+	if flags.AlertWebhookURL != "" && !hasThresholds(kpis) {
+		return fmt.Errorf("--alert-webhook requires thresholds on at least one KPI of %s", flags.KPIsFile)
+	}
+
 	kpis, err = substituteCPUsIfNeeded(kpis, flags)
 	if err != nil {
 		return err
@@ -190,12 +243,15 @@ func runCollect(cmd *cobra.Command, args []string) error {
 			tokenDuration)
 	}
 
+	flags.ToolVersion = gitVersion()
+
 	// Run collection
+	var runID int64
 	var collectionErr error
 	if flags.SingleRun {
-		collectionErr = collector.RunOnce(kpis, flags)
+		runID, collectionErr = collector.RunOnce(kpis, flags)
 	} else {
-		collectionErr = collector.Run(kpis, flags)
+		runID, collectionErr = collector.Run(kpis, flags)
 	}
 
 	absOutputDir, err := filepath.Abs(database.OutputDir)
@@ -208,12 +264,48 @@ func runCollect(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("collection completed with errors: %w", collectionErr)
 	}
 
+	if flags.Evaluate {
+		fmt.Println()
+		return evaluateCollectionRun(cmd, kpis, flags, runID)
+	}
+
 	return nil
 }
 
+// hasThresholds reports whether any KPI has thresholds to evaluate
+func hasThresholds(kpis config.KPIs) bool {
+	for _, kpi := range kpis.Queries {
+		if kpi.Thresholds != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateCollectionRun evaluates the collection run that just ended against
+// the KPI thresholds
+func evaluateCollectionRun(cmd *cobra.Command, kpis config.KPIs, flags config.InputFlags, runID int64) error {
+	if runID == 0 {
+		return fmt.Errorf("no collection run was recorded to evaluate")
+	}
+
+	db, dbImpl, err := connectToDBWith(flags.DatabaseType, flags.PostgresURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	scope := comparisonSide{RunID: runID}
+	params := KPIQueryParams{RunID: runID}
+	return evaluateAndReport(cmd, db, dbImpl, kpis, scope, params, output.NewPrinter(output.FormatTable))
+}
+
 func databaseLocation(flags config.InputFlags) string {
-	if flags.DatabaseType == "postgres" {
+	switch flags.DatabaseType {
+	case "postgres":
 		return "postgres (external)"
+	case "file":
+		return fmt.Sprintf("%s files (%s)", flags.FileFormat, database.OutputDir)
 	}
 	return fmt.Sprintf("sqlite (%s)", filepath.Join(database.OutputDir, database.DefaultDBFileName))
 }
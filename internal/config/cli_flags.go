@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"time"
 )
 
 // validateFlags ensures the correct combination of flags is provided
@@ -39,14 +41,77 @@ func ValidateFlags(flags InputFlags) error {
 		return fmt.Errorf("duration must be greater than 0")
 	}
 
-	if flags.DatabaseType != "sqlite" && flags.DatabaseType != "postgres" {
-		return fmt.Errorf("invalid db-type: must be 'sqlite' or 'postgres'")
+	if flags.DatabaseType != "sqlite" && flags.DatabaseType != "postgres" && flags.DatabaseType != "file" {
+		return fmt.Errorf("invalid db-type: must be 'sqlite', 'postgres' or 'file'")
 	}
 
 	if flags.DatabaseType == "postgres" && flags.PostgresURL == "" {
 		return fmt.Errorf("postgres-url is required when db-type=postgres")
 	}
 
+	if flags.DatabaseType == "file" {
+		if flags.FileFormat != "jsonl" && flags.FileFormat != "openmetrics" {
+			return fmt.Errorf("invalid file-format: must be 'jsonl' or 'openmetrics'")
+		}
+		// Sample files are append-only, and only read back once collection ends
+		if flags.Retention > 0 {
+			return fmt.Errorf("retention is not supported with db-type=file")
+		}
+		if flags.Evaluate {
+			return fmt.Errorf("evaluate is not supported with db-type=file; run 'kpi-collector evaluate --db-type file' once collection ends")
+		}
+	}
+
+	if flags.InsertBatchSize < 0 {
+		return fmt.Errorf("insert-batch-size must be >= 0 (0 uses the default)")
+	}
+
+	if flags.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("max-concurrent-queries must be >= 0 (0 uses the default)")
+	}
+
+	if flags.MaxRetries < 0 {
+		return fmt.Errorf("max-retries must be >= 0")
+	}
+
+	if flags.RetryBackoff < 0 {
+		return fmt.Errorf("retry-backoff must be >= 0")
+	}
+
+	if flags.Retention < 0 {
+		return fmt.Errorf("retention must be >= 0 (0 keeps all samples)")
+	}
+
+	if flags.RetentionDownsample != 0 && flags.Retention == 0 {
+		return fmt.Errorf("retention-downsample requires --retention")
+	}
+
+	if flags.RetentionDownsample < 0 || flags.RetentionDownsample%time.Second != 0 {
+		return fmt.Errorf("retention-downsample must be a positive whole number of seconds")
+	}
+
+	if flags.Retention > 0 && flags.RetentionInterval <= 0 {
+		return fmt.Errorf("retention-interval must be greater than 0")
+	}
+
+	if flags.AlertHysteresis < 0 || flags.AlertHysteresis >= 100 {
+		return fmt.Errorf("alert-hysteresis must be >= 0 and < 100 (percent)")
+	}
+
+	if flags.AlertWebhookURL != "" {
+		webhook, err := url.Parse(flags.AlertWebhookURL)
+		if err != nil || (webhook.Scheme != "http" && webhook.Scheme != "https") || webhook.Host == "" {
+			return fmt.Errorf("invalid alert-webhook '%s': must be an http:// or https:// URL", flags.AlertWebhookURL)
+		}
+	}
+
+	if flags.RemoteWriteURL != "" {
+		endpoint, err := url.Parse(flags.RemoteWriteURL)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("invalid remote-write-url '%s': must be an http:// or https:// URL", flags.RemoteWriteURL)
+		}
+	}
+
 	if flags.KPIsFile == "" {
 		return fmt.Errorf("kpis-file must be specified")
 	}
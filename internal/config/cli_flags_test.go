@@ -24,9 +24,22 @@ const (
 	errInvalidFlagComboMsg    = "invalid flag combination: either provide --token and --thanos-url, or provide --kubeconfig"
 	errSamplingFreqMsg        = "sampling frequency must be greater than 0"
 	errDurationMsg            = "duration must be greater than 0"
-	errInvalidDBTypeMsg       = "invalid db-type: must be 'sqlite' or 'postgres'"
+	errInvalidDBTypeMsg       = "invalid db-type: must be 'sqlite', 'postgres' or 'file'"
+	errFileFormatMsg          = "invalid file-format: must be 'jsonl' or 'openmetrics'"
+	errFileRetentionMsg       = "retention is not supported with db-type=file"
 	errPostgresURLRequiredMsg = "postgres-url is required when db-type=postgres"
 	errKPIsFileMsg            = "kpis-file must be specified"
+	errInsertBatchSizeMsg     = "insert-batch-size must be >= 0 (0 uses the default)"
+	errMaxConcurrentMsg       = "max-concurrent-queries must be >= 0 (0 uses the default)"
+	errMaxRetriesMsg          = "max-retries must be >= 0"
+	errRetryBackoffMsg        = "retry-backoff must be >= 0"
+	errRetentionMsg           = "retention must be >= 0 (0 keeps all samples)"
+	errDownsampleNoRetention  = "retention-downsample requires --retention"
+	errDownsampleSecondsMsg   = "retention-downsample must be a positive whole number of seconds"
+	errRetentionIntervalMsg   = "retention-interval must be greater than 0"
+	errAlertHysteresisMsg     = "alert-hysteresis must be >= 0 and < 100 (percent)"
+	errAlertWebhookMsg        = "invalid alert-webhook 'hooks.example.com': must be an http:// or https:// URL"
+	errRemoteWriteMsg         = "invalid remote-write-url 'ftp://metrics.example.com/api/v1/write': must be an http:// or https:// URL"
 )
 
 var _ = Describe("validateFlags test", func() {
@@ -57,6 +70,20 @@ var _ = Describe("validateFlags test", func() {
 			},
 			"", // no error expected
 		),
+		Entry("valid file database",
+			InputFlags{
+				ClusterName:  validClusterName,
+				ClusterType:  validClusterType,
+				BearerToken:  validBearerToken,
+				ThanosURL:    validThanosURL,
+				SamplingFreq: validSamplingFreq,
+				Duration:     validDuration,
+				DatabaseType: "file",
+				FileFormat:   "openmetrics",
+				KPIsFile:     validKPIsFile,
+			},
+			"",
+		),
 		Entry("valid kubeconfig",
 			InputFlags{
 				ClusterName:  validClusterName,
@@ -296,5 +323,249 @@ var _ = Describe("validateFlags test", func() {
 			},
 			errKPIsFileMsg,
 		),
+		// Error cases - invalid insert batch size
+		Entry("negative insert-batch-size",
+			InputFlags{
+				ClusterName:     validClusterName,
+				ClusterType:     validClusterType,
+				BearerToken:     validBearerToken,
+				ThanosURL:       validThanosURL,
+				SamplingFreq:    validSamplingFreq,
+				Duration:        validDuration,
+				DatabaseType:    validDatabaseType,
+				KPIsFile:        validKPIsFile,
+				InsertBatchSize: -1,
+			},
+			errInsertBatchSizeMsg,
+		),
+		// Error cases - invalid max concurrent queries
+		Entry("negative max-concurrent-queries",
+			InputFlags{
+				ClusterName:          validClusterName,
+				ClusterType:          validClusterType,
+				BearerToken:          validBearerToken,
+				ThanosURL:            validThanosURL,
+				SamplingFreq:         validSamplingFreq,
+				Duration:             validDuration,
+				DatabaseType:         validDatabaseType,
+				KPIsFile:             validKPIsFile,
+				MaxConcurrentQueries: -1,
+			},
+			errMaxConcurrentMsg,
+		),
+		Entry("zero max-concurrent-queries uses the default",
+			InputFlags{
+				ClusterName:          validClusterName,
+				ClusterType:          validClusterType,
+				BearerToken:          validBearerToken,
+				ThanosURL:            validThanosURL,
+				SamplingFreq:         validSamplingFreq,
+				Duration:             validDuration,
+				DatabaseType:         validDatabaseType,
+				KPIsFile:             validKPIsFile,
+				MaxConcurrentQueries: 0,
+			},
+			"",
+		),
+		// Error cases - invalid retry policy
+		Entry("negative max-retries",
+			InputFlags{
+				ClusterName:  validClusterName,
+				ClusterType:  validClusterType,
+				BearerToken:  validBearerToken,
+				ThanosURL:    validThanosURL,
+				SamplingFreq: validSamplingFreq,
+				Duration:     validDuration,
+				DatabaseType: validDatabaseType,
+				KPIsFile:     validKPIsFile,
+				MaxRetries:   -1,
+			},
+			errMaxRetriesMsg,
+		),
+		Entry("negative retry-backoff",
+			InputFlags{
+				ClusterName:  validClusterName,
+				ClusterType:  validClusterType,
+				BearerToken:  validBearerToken,
+				ThanosURL:    validThanosURL,
+				SamplingFreq: validSamplingFreq,
+				Duration:     validDuration,
+				DatabaseType: validDatabaseType,
+				KPIsFile:     validKPIsFile,
+				RetryBackoff: -time.Second,
+			},
+			errRetryBackoffMsg,
+		),
+		Entry("retention with downsampling",
+			InputFlags{
+				ClusterName:         validClusterName,
+				ClusterType:         validClusterType,
+				BearerToken:         validBearerToken,
+				ThanosURL:           validThanosURL,
+				SamplingFreq:        validSamplingFreq,
+				Duration:            validDuration,
+				DatabaseType:        validDatabaseType,
+				KPIsFile:            validKPIsFile,
+				Retention:           30 * 24 * time.Hour,
+				RetentionDownsample: time.Hour,
+				RetentionInterval:   time.Hour,
+			},
+			"",
+		),
+		Entry("negative retention",
+			InputFlags{
+				ClusterName:  validClusterName,
+				ClusterType:  validClusterType,
+				BearerToken:  validBearerToken,
+				ThanosURL:    validThanosURL,
+				SamplingFreq: validSamplingFreq,
+				Duration:     validDuration,
+				DatabaseType: validDatabaseType,
+				KPIsFile:     validKPIsFile,
+				Retention:    -time.Hour,
+			},
+			errRetentionMsg,
+		),
+		Entry("retention-downsample without retention",
+			InputFlags{
+				ClusterName:         validClusterName,
+				ClusterType:         validClusterType,
+				BearerToken:         validBearerToken,
+				ThanosURL:           validThanosURL,
+				SamplingFreq:        validSamplingFreq,
+				Duration:            validDuration,
+				DatabaseType:        validDatabaseType,
+				KPIsFile:            validKPIsFile,
+				RetentionDownsample: time.Hour,
+			},
+			errDownsampleNoRetention,
+		),
+		Entry("retention-downsample with fractional seconds",
+			InputFlags{
+				ClusterName:         validClusterName,
+				ClusterType:         validClusterType,
+				BearerToken:         validBearerToken,
+				ThanosURL:           validThanosURL,
+				SamplingFreq:        validSamplingFreq,
+				Duration:            validDuration,
+				DatabaseType:        validDatabaseType,
+				KPIsFile:            validKPIsFile,
+				Retention:           time.Hour,
+				RetentionDownsample: 1500 * time.Millisecond,
+				RetentionInterval:   time.Hour,
+			},
+			errDownsampleSecondsMsg,
+		),
+		Entry("retention without interval",
+			InputFlags{
+				ClusterName:  validClusterName,
+				ClusterType:  validClusterType,
+				BearerToken:  validBearerToken,
+				ThanosURL:    validThanosURL,
+				SamplingFreq: validSamplingFreq,
+				Duration:     validDuration,
+				DatabaseType: validDatabaseType,
+				KPIsFile:     validKPIsFile,
+				Retention:    time.Hour,
+			},
+			errRetentionIntervalMsg,
+		),
+		Entry("alert-hysteresis of 100 percent",
+			InputFlags{
+				ClusterName:     validClusterName,
+				ClusterType:     validClusterType,
+				BearerToken:     validBearerToken,
+				ThanosURL:       validThanosURL,
+				SamplingFreq:    validSamplingFreq,
+				Duration:        validDuration,
+				DatabaseType:    validDatabaseType,
+				KPIsFile:        validKPIsFile,
+				AlertHysteresis: 100,
+			},
+			errAlertHysteresisMsg,
+		),
+		Entry("alert-webhook without scheme",
+			InputFlags{
+				ClusterName:     validClusterName,
+				ClusterType:     validClusterType,
+				BearerToken:     validBearerToken,
+				ThanosURL:       validThanosURL,
+				SamplingFreq:    validSamplingFreq,
+				Duration:        validDuration,
+				DatabaseType:    validDatabaseType,
+				KPIsFile:        validKPIsFile,
+				AlertWebhookURL: "hooks.example.com",
+			},
+			errAlertWebhookMsg,
+		),
+		Entry("file database with unknown format",
+			InputFlags{
+				ClusterName:  validClusterName,
+				ClusterType:  validClusterType,
+				BearerToken:  validBearerToken,
+				ThanosURL:    validThanosURL,
+				SamplingFreq: validSamplingFreq,
+				Duration:     validDuration,
+				DatabaseType: "file",
+				FileFormat:   "csv",
+				KPIsFile:     validKPIsFile,
+			},
+			errFileFormatMsg,
+		),
+		Entry("file database with retention",
+			InputFlags{
+				ClusterName:       validClusterName,
+				ClusterType:       validClusterType,
+				BearerToken:       validBearerToken,
+				ThanosURL:         validThanosURL,
+				SamplingFreq:      validSamplingFreq,
+				Duration:          validDuration,
+				DatabaseType:      "file",
+				FileFormat:        "jsonl",
+				Retention:         time.Hour,
+				RetentionInterval: time.Hour,
+				KPIsFile:          validKPIsFile,
+			},
+			errFileRetentionMsg,
+		),
+		Entry("remote-write-url with unsupported scheme",
+			InputFlags{
+				ClusterName:    validClusterName,
+				ClusterType:    validClusterType,
+				BearerToken:    validBearerToken,
+				ThanosURL:      validThanosURL,
+				SamplingFreq:   validSamplingFreq,
+				Duration:       validDuration,
+				DatabaseType:   validDatabaseType,
+				KPIsFile:       validKPIsFile,
+				RemoteWriteURL: "ftp://metrics.example.com/api/v1/write",
+			},
+			errRemoteWriteMsg,
+		),
+	)
+})
+
+var _ = Describe("ParseAge", func() {
+	DescribeTable("valid ages",
+		func(value string, expected time.Duration) {
+			age, err := ParseAge(value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(age).To(Equal(expected))
+		},
+		Entry("days", "30d", 30*24*time.Hour),
+		Entry("days and hours", "1d12h", 36*time.Hour),
+		Entry("Go duration", "90m", 90*time.Minute),
+		Entry("surrounding spaces", " 7d ", 7*24*time.Hour),
+	)
+
+	DescribeTable("invalid ages",
+		func(value string) {
+			_, err := ParseAge(value)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("fractional days", "1.5d"),
+		Entry("days after hours", "12h1d"),
+		Entry("unknown unit", "3w"),
 	)
 })
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
@@ -25,6 +27,18 @@ func LoadKPIs(filepath string) (KPIs, error) {
 	return kpis, nil
 }
 
+// HashKPIsFile returns the hex-encoded SHA-256 of a KPIs file, identifying
+// the exact set of queries a collection run was started with
+func HashKPIsFile(filepath string) (string, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open kpis file: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 // ValidateKPIs checks all KPI queries for syntax errors and configuration issues.
 // Returns a slice of errors found during validation.
 func ValidateKPIs(kpis KPIs) []error {
@@ -56,6 +70,28 @@ func ValidateKPIs(kpis KPIs) []error {
 		}
 
 		errors = append(errors, validateQueryType(kpi)...)
+
+		if kpi.Timeout != nil && kpi.Timeout.Duration <= 0 {
+			errors = append(errors, fmt.Errorf("KPI '%s': timeout must be > 0", kpi.ID))
+		}
+
+		if kpi.Retry != nil {
+			if kpi.Retry.MaxRetries != nil && *kpi.Retry.MaxRetries < 0 {
+				errors = append(errors, fmt.Errorf("KPI '%s': retry.max-retries must be >= 0", kpi.ID))
+			}
+			if kpi.Retry.Backoff != nil && kpi.Retry.Backoff.Duration < 0 {
+				errors = append(errors, fmt.Errorf("KPI '%s': retry.backoff must be >= 0", kpi.ID))
+			}
+		}
+
+		errors = append(errors, validateThresholds(kpi)...)
+
+		switch kpi.GetEffectiveOverrunPolicy() {
+		case OverrunPolicySkip, OverrunPolicyCatchUp, OverrunPolicyCoalesce:
+		default:
+			errors = append(errors, fmt.Errorf("KPI '%s': invalid overrun-policy '%s' (must be 'skip', 'catch-up' or 'coalesce')",
+				kpi.ID, kpi.OverrunPolicy))
+		}
 	}
 
 	return errors
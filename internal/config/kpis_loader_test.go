@@ -198,6 +198,22 @@ var _ = Describe("KPIs Loader", func() {
 		})
 	})
 
+	Describe("HashKPIsFile", func() {
+		It("should return the SHA-256 of the file content", func() {
+			kpisPath := filepath.Join(tmpDir, "kpis.yaml")
+			Expect(os.WriteFile(kpisPath, []byte("kpis: []\n"), 0644)).To(Succeed())
+
+			hash, err := HashKPIsFile(kpisPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hash).To(Equal("c91eaf5efe44f07711240119deb33632ca408322ff55d9d29f6830dcb21d3b79"))
+		})
+
+		It("should return an error for a missing file", func() {
+			_, err := HashKPIsFile(filepath.Join(tmpDir, "missing.yaml"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Describe("ValidateKPIs", func() {
 		Context("when all KPIs are valid", func() {
 			It("should return no errors for valid PromQL queries", func() {
@@ -339,6 +355,157 @@ var _ = Describe("KPIs Loader", func() {
 			})
 		})
 
+		Context("when KPIs set a per-query timeout", func() {
+			It("should accept a positive timeout", func() {
+				kpis := KPIs{Queries: []Query{
+					{ID: "slow-kpi", PromQuery: "up", Timeout: &Duration{Duration: 30 * time.Second}},
+				}}
+
+				Expect(ValidateKPIs(kpis)).To(BeEmpty())
+				Expect(kpis.Queries[0].GetEffectiveTimeout(5 * time.Second)).To(Equal(30 * time.Second))
+			})
+
+			It("should reject a non-positive timeout", func() {
+				kpis := KPIs{Queries: []Query{
+					{ID: "bad-timeout", PromQuery: "up", Timeout: &Duration{Duration: 0}},
+				}}
+
+				errs := ValidateKPIs(kpis)
+				Expect(errs).To(HaveLen(1))
+				Expect(errs[0].Error()).To(ContainSubstring("timeout must be > 0"))
+			})
+
+			It("should fall back to the default timeout when unset", func() {
+				query := Query{ID: "default-timeout", PromQuery: "up"}
+				Expect(query.GetEffectiveTimeout(5 * time.Second)).To(Equal(5 * time.Second))
+			})
+		})
+
+		Context("when KPIs set an overrun policy", func() {
+			It("should accept the supported policies", func() {
+				kpis := KPIs{Queries: []Query{
+					{ID: "kpi-skip", PromQuery: "up", OverrunPolicy: OverrunPolicySkip},
+					{ID: "kpi-catch-up", PromQuery: "up", OverrunPolicy: OverrunPolicyCatchUp},
+					{ID: "kpi-coalesce", PromQuery: "up", OverrunPolicy: OverrunPolicyCoalesce},
+					{ID: "kpi-default", PromQuery: "up"},
+				}}
+
+				Expect(ValidateKPIs(kpis)).To(BeEmpty())
+				Expect(kpis.Queries[3].GetEffectiveOverrunPolicy()).To(Equal(OverrunPolicyCoalesce))
+			})
+
+			It("should reject an unknown policy", func() {
+				kpis := KPIs{Queries: []Query{
+					{ID: "kpi-bad", PromQuery: "up", OverrunPolicy: "queue"},
+				}}
+
+				errs := ValidateKPIs(kpis)
+				Expect(errs).To(HaveLen(1))
+				Expect(errs[0].Error()).To(ContainSubstring("invalid overrun-policy 'queue'"))
+			})
+		})
+
+		Context("when KPIs override the retry policy", func() {
+			defaults := RetryPolicy{MaxRetries: 2, Backoff: time.Second}
+
+			It("should override only the fields that are set", func() {
+				maxRetries := 5
+				query := Query{ID: "flaky-kpi", PromQuery: "up", Retry: &RetryConfig{MaxRetries: &maxRetries}}
+
+				Expect(ValidateKPIs(KPIs{Queries: []Query{query}})).To(BeEmpty())
+				Expect(query.GetEffectiveRetryPolicy(defaults)).To(Equal(RetryPolicy{MaxRetries: 5, Backoff: time.Second}))
+			})
+
+			It("should allow disabling retries for a KPI", func() {
+				noRetries := 0
+				query := Query{ID: "no-retry-kpi", PromQuery: "up", Retry: &RetryConfig{MaxRetries: &noRetries}}
+				Expect(query.GetEffectiveRetryPolicy(defaults).MaxRetries).To(Equal(0))
+			})
+
+			It("should fall back to the defaults when unset", func() {
+				query := Query{ID: "default-retry", PromQuery: "up"}
+				Expect(query.GetEffectiveRetryPolicy(defaults)).To(Equal(defaults))
+			})
+
+			It("should reject negative values", func() {
+				maxRetries := -1
+				kpis := KPIs{Queries: []Query{
+					{ID: "bad-retry", PromQuery: "up", Retry: &RetryConfig{
+						MaxRetries: &maxRetries,
+						Backoff:    &Duration{Duration: -time.Second},
+					}},
+				}}
+
+				errs := ValidateKPIs(kpis)
+				Expect(errs).To(HaveLen(2))
+				Expect(errs[0].Error()).To(ContainSubstring("retry.max-retries must be >= 0"))
+				Expect(errs[1].Error()).To(ContainSubstring("retry.backoff must be >= 0"))
+			})
+		})
+
+		Context("when KPIs set thresholds", func() {
+			It("should load the limits, aggregation and overrides", func() {
+				kpisYAML := `kpis:
+  - id: cpu-usage
+    promquery: up
+    thresholds:
+      aggregation: avg
+      max: 0.8
+      p99-max: 0.95
+      overrides:
+        - labels: '{cpu=~"0|1"}'
+          max: 0.5
+`
+				kpisPath := filepath.Join(tmpDir, "kpis.yaml")
+				Expect(os.WriteFile(kpisPath, []byte(kpisYAML), 0644)).To(Succeed())
+
+				kpis, err := LoadKPIs(kpisPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ValidateKPIs(kpis)).To(BeEmpty())
+
+				thresholds := kpis.Queries[0].Thresholds
+				Expect(thresholds).NotTo(BeNil())
+				Expect(thresholds.Aggregation).To(Equal("avg"))
+				Expect(thresholds.Limits).To(Equal(map[string]float64{"max": 0.8, "p99-max": 0.95}))
+				Expect(thresholds.Overrides).To(HaveLen(1))
+				Expect(thresholds.Overrides[0].Limits).To(Equal(map[string]float64{"max": 0.5}))
+
+				bounds, err := thresholds.BoundsFor(map[string]string{"cpu": "3"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bounds).To(Equal([]ThresholdBound{
+					{Statistic: "avg", Upper: true, Value: 0.8},
+					{Statistic: "p99", Upper: true, Value: 0.95},
+				}))
+
+				bounds, err = thresholds.BoundsFor(map[string]string{"cpu": "1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bounds).To(HaveLen(2))
+				Expect(bounds[0].String()).To(Equal("avg <= 0.5"))
+				Expect(bounds[0].Passes(0.5)).To(BeTrue())
+				Expect(bounds[0].Passes(0.6)).To(BeFalse())
+			})
+
+			It("should reject unknown limits, aggregations and override selectors", func() {
+				kpis := KPIs{Queries: []Query{
+					{ID: "bad-thresholds", PromQuery: "up", Thresholds: &Thresholds{
+						Aggregation: "median",
+						Limits:      map[string]float64{"max": 1},
+						Overrides: []ThresholdOverride{
+							{Labels: "{cpu=", Limits: map[string]float64{"p101-max": 1}},
+						},
+					}},
+					{ID: "empty-thresholds", PromQuery: "up", Thresholds: &Thresholds{}},
+				}}
+
+				errs := ValidateKPIs(kpis)
+				Expect(errs).To(HaveLen(4))
+				Expect(errs[0].Error()).To(ContainSubstring("invalid aggregation 'median'"))
+				Expect(errs[1].Error()).To(ContainSubstring("thresholds.overrides[0]: invalid labels"))
+				Expect(errs[2].Error()).To(ContainSubstring("invalid threshold 'p101-max'"))
+				Expect(errs[3].Error()).To(ContainSubstring("thresholds must set at least one limit"))
+			})
+		})
+
 		Context("when KPIs have multiple validation issues", func() {
 			It("should return all errors", func() {
 				kpis := KPIs{
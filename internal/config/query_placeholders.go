@@ -38,6 +38,9 @@ func SubstituteCPUPlaceholders(kpis KPIs, cpus *CPUPlaceholders) KPIs {
 
 		resolvedKPIs.Queries[i] = originalQuery
 		resolvedKPIs.Queries[i].PromQuery = resolvedPromQL
+		if resolvedPromQL != originalQuery.PromQuery {
+			resolvedKPIs.Queries[i].RawPromQuery = originalQuery.GetRawPromQuery()
+		}
 	}
 
 	return resolvedKPIs
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Thresholds are the expected values of a KPI, evaluated per series (KPI
+// and label set) by 'kpi-collector evaluate'. Limits are keyed by
+// "<statistic>-min" or "<statistic>-max", e.g. "p99-max: 0.7", where the
+// statistic is min, max, avg or a percentile like p99. The bare "min" and
+// "max" keys bound the Aggregation statistic, by default every sample.
+type Thresholds struct {
+	Aggregation string              `yaml:"aggregation,omitempty"`
+	Limits      map[string]float64  `yaml:",inline"`
+	Overrides   []ThresholdOverride `yaml:"overrides,omitempty"`
+}
+
+// ThresholdOverride replaces some thresholds for the series matching a PromQL
+// label selector such as '{cpu=~"0|1"}'. The first matching override applies.
+type ThresholdOverride struct {
+	Labels      string             `yaml:"labels"`
+	Aggregation string             `yaml:"aggregation,omitempty"`
+	Limits      map[string]float64 `yaml:",inline"`
+}
+
+// ThresholdBound is one limit of a series: its Statistic must not be above
+// Value when Upper is set, nor below it otherwise
+type ThresholdBound struct {
+	Statistic string
+	Upper     bool
+	Value     float64
+}
+
+// String describes the bound, e.g. "p99 <= 0.7"
+func (b ThresholdBound) String() string {
+	operator := ">="
+	if b.Upper {
+		operator = "<="
+	}
+	return fmt.Sprintf("%s %s %s", b.Statistic, operator, strconv.FormatFloat(b.Value, 'g', -1, 64))
+}
+
+// Passes reports whether the statistic value is within the bound
+func (b ThresholdBound) Passes(value float64) bool {
+	if b.Upper {
+		return value <= b.Value
+	}
+	return value >= b.Value
+}
+
+// thresholdStatistic matches the statistics thresholds can bound
+var thresholdStatistic = regexp.MustCompile(`^(min|max|avg|p(\d+(\.\d+)?))$`)
+
+// validStatistic reports whether thresholds can bound the statistic
+func validStatistic(statistic string) bool {
+	match := thresholdStatistic.FindStringSubmatch(statistic)
+	if match == nil {
+		return false
+	}
+	if match[2] != "" {
+		percentile, _ := strconv.ParseFloat(match[2], 64)
+		return percentile <= 100
+	}
+	return true
+}
+
+// BoundsFor returns the bounds of the series with these labels, sorted by
+// statistic with lower bounds first
+func (t *Thresholds) BoundsFor(seriesLabels map[string]string) ([]ThresholdBound, error) {
+	aggregation, limits := t.Aggregation, t.Limits
+	for _, override := range t.Overrides {
+		matchers, err := parser.ParseMetricSelector(override.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid override labels %q: %w", override.Labels, err)
+		}
+		if !matchesAll(matchers, seriesLabels) {
+			continue
+		}
+
+		limits = maps.Clone(limits)
+		if limits == nil {
+			limits = make(map[string]float64)
+		}
+		maps.Copy(limits, override.Limits)
+		if override.Aggregation != "" {
+			aggregation = override.Aggregation
+		}
+		break
+	}
+	return thresholdBounds(aggregation, limits)
+}
+
+// matchesAll reports whether the labels match every matcher; a missing
+// label matches as the empty string
+func matchesAll(matchers []*labels.Matcher, seriesLabels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(seriesLabels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// thresholdBounds parses the limits into bounds
+func thresholdBounds(aggregation string, limits map[string]float64) ([]ThresholdBound, error) {
+	if aggregation != "" && !validStatistic(aggregation) {
+		return nil, fmt.Errorf("invalid aggregation '%s' (must be min, max, avg or a percentile like p99)", aggregation)
+	}
+
+	bounds := make([]ThresholdBound, 0, len(limits))
+	for _, key := range slices.Sorted(maps.Keys(limits)) {
+		bound := ThresholdBound{Value: limits[key]}
+		switch key {
+		case "min":
+			bound.Statistic = "min"
+		case "max":
+			bound.Statistic, bound.Upper = "max", true
+		default:
+			statistic, found := strings.CutSuffix(key, "-max")
+			bound.Upper = found
+			if !found {
+				statistic, found = strings.CutSuffix(key, "-min")
+			}
+			if !found || !validStatistic(statistic) {
+				return nil, fmt.Errorf("invalid threshold '%s' (use min, max, or <statistic>-min/-max like p99-max)", key)
+			}
+			bound.Statistic = statistic
+		}
+		if (key == "min" || key == "max") && aggregation != "" {
+			bound.Statistic = aggregation
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// validateThresholds checks the thresholds of a KPI and its overrides
+func validateThresholds(kpi Query) []error {
+	if kpi.Thresholds == nil {
+		return nil
+	}
+
+	var errors []error
+	if len(kpi.Thresholds.Limits) == 0 && len(kpi.Thresholds.Overrides) == 0 {
+		errors = append(errors, fmt.Errorf("KPI '%s': thresholds must set at least one limit", kpi.ID))
+	}
+	if _, err := thresholdBounds(kpi.Thresholds.Aggregation, kpi.Thresholds.Limits); err != nil {
+		errors = append(errors, fmt.Errorf("KPI '%s': thresholds: %w", kpi.ID, err))
+	}
+	for i, override := range kpi.Thresholds.Overrides {
+		if _, err := parser.ParseMetricSelector(override.Labels); err != nil {
+			errors = append(errors, fmt.Errorf("KPI '%s': thresholds.overrides[%d]: invalid labels %q: %w", kpi.ID, i, override.Labels, err))
+		}
+		if _, err := thresholdBounds(override.Aggregation, override.Limits); err != nil {
+			errors = append(errors, fmt.Errorf("KPI '%s': thresholds.overrides[%d]: %w", kpi.ID, i, err))
+		}
+	}
+	return errors
+}
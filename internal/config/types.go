@@ -5,6 +5,7 @@ package config
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -47,6 +48,31 @@ func (d Duration) MarshalYAML() (interface{}, error) {
 	return d.String(), nil
 }
 
+// ParseAge parses an age such as "30d", "36h" or "1d12h". It accepts any Go
+// duration string and additionally a leading whole number of days.
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	var days time.Duration
+	if i := strings.Index(value, "d"); i > 0 {
+		n, err := strconv.Atoi(value[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: %w", value, err)
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		value = value[i+1:]
+		if value == "" {
+			return days, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q: must be a Go duration (e.g. \"36h\") optionally preceded by days (e.g. \"30d\")", value)
+	}
+	return days + d, nil
+}
+
 // TimeRef represents a flexible time specification that can be either a Go
 // duration string (e.g. "2h", "1m30s") interpreted as relative to "now", or an
 // absolute RFC 3339 time reference (e.g. "2026-04-07T12:24:25Z").
@@ -99,6 +125,17 @@ func (t *TimeRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return fmt.Errorf("invalid time reference %q: must be a Go duration (e.g. \"2h\") or RFC 3339 format (e.g. \"2026-04-07T12:24:25Z\")", s)
 }
 
+// String returns the time reference as written in the KPIs file
+func (t *TimeRef) String() string {
+	if t.duration != nil {
+		return t.duration.String()
+	}
+	if t.absolute != nil {
+		return t.absolute.Format(time.RFC3339)
+	}
+	return ""
+}
+
 // MarshalYAML implements yaml.Marshaler for TimeRef
 func (t TimeRef) MarshalYAML() (interface{}, error) {
 	if t.duration != nil {
@@ -129,10 +166,44 @@ type InputFlags struct {
 	InsecureTLS  bool
 	SamplingFreq time.Duration
 	Duration     time.Duration
-	DatabaseType string // "sqlite" or "postgres"
+	DatabaseType string // "sqlite", "postgres" or "file"
 	PostgresURL  string // PostgreSQL connection string
+	FileFormat   string // format of the sample files with db-type file: "jsonl" or "openmetrics"
 	KPIsFile     string
 	SingleRun    bool // collect metrics once and exit
+
+	InsertBatchSize      int // samples written per INSERT statement (0 = default)
+	MaxConcurrentQueries int // queries executed in parallel within a frequency group
+
+	MaxRetries   int           // retries of a query after a transient failure
+	RetryBackoff time.Duration // delay before the first retry, doubled on every further retry
+
+	ToolVersion string // kpi-collector version recorded with each collection run
+
+	Retention           time.Duration // raw samples older than this are pruned while collecting (0 = keep)
+	RetentionDownsample time.Duration // bucket size pruned samples are rolled up into (0 = delete them)
+	RetentionInterval   time.Duration // how often the retention task prunes during a run
+
+	Evaluate bool // evaluate the KPI thresholds against the run once collection ends
+
+	AlertWebhookURL string  // URL the threshold alerts raised during collection are posted to
+	AlertHysteresis float64 // percent of a threshold a value must clear before its alert resolves
+
+	RemoteWriteURL string   // Prometheus remote-write endpoint the stored samples are also sent to
+	Sinks          []string // additional storage the samples are written to, e.g. sqlite:<path> (--sink)
+}
+
+// RetryPolicy controls how often a query is retried after a transient
+// Prometheus/Thanos failure and how long to wait between attempts
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+// RetryConfig overrides the global retry policy for a single KPI
+type RetryConfig struct {
+	MaxRetries *int      `yaml:"max-retries,omitempty"`
+	Backoff    *Duration `yaml:"backoff,omitempty"`
 }
 
 // Query represents a single KPI query configuration
@@ -143,8 +214,27 @@ type Query struct {
 	QueryType       string       `yaml:"query-type,omitempty"`
 	Range           *RangeWindow `yaml:"range,omitempty"`
 	RunOnce         *bool        `yaml:"run-once,omitempty"`
+	Timeout         *Duration    `yaml:"timeout,omitempty"`
+	OverrunPolicy   string       `yaml:"overrun-policy,omitempty"`
+	Retry           *RetryConfig `yaml:"retry,omitempty"`
+	Thresholds      *Thresholds  `yaml:"thresholds,omitempty"`
+
+	// RawPromQuery is the PromQL as written in the KPIs file. It is only set
+	// once placeholders have been substituted into PromQuery.
+	RawPromQuery string `yaml:"-"`
 }
 
+// Overrun policies decide what happens to sampling ticks that elapse while
+// a previous sample of the same frequency group is still running.
+const (
+	// OverrunPolicySkip drops the missed ticks and waits for the next scheduled tick
+	OverrunPolicySkip = "skip"
+	// OverrunPolicyCatchUp runs every missed tick back-to-back until on schedule again
+	OverrunPolicyCatchUp = "catch-up"
+	// OverrunPolicyCoalesce runs a single sample immediately in place of all missed ticks
+	OverrunPolicyCoalesce = "coalesce"
+)
+
 // IsRunOnce returns true if this query is configured to run only once
 func (q *Query) IsRunOnce() bool {
 	return q.RunOnce != nil && *q.RunOnce
@@ -156,6 +246,15 @@ type KPIs struct {
 	Queries []Query `yaml:"kpis"`
 }
 
+// GetRawPromQuery returns the PromQL as written in the KPIs file,
+// before any placeholder substitution
+func (q *Query) GetRawPromQuery() string {
+	if q.RawPromQuery != "" {
+		return q.RawPromQuery
+	}
+	return q.PromQuery
+}
+
 // GetEffectiveFrequency returns the sample frequency for this query,
 // falling back to the provided default if not specified
 func (q *Query) GetEffectiveFrequency(defaultFreq time.Duration) time.Duration {
@@ -165,6 +264,40 @@ func (q *Query) GetEffectiveFrequency(defaultFreq time.Duration) time.Duration {
 	return defaultFreq
 }
 
+// GetEffectiveTimeout returns the per-query execution timeout for this query,
+// falling back to the provided default if not specified
+func (q *Query) GetEffectiveTimeout(defaultTimeout time.Duration) time.Duration {
+	if q.Timeout != nil && q.Timeout.Duration > 0 {
+		return q.Timeout.Duration
+	}
+	return defaultTimeout
+}
+
+// GetEffectiveRetryPolicy returns the retry policy for this query,
+// overriding the provided defaults with any fields set in the KPI's retry block
+func (q *Query) GetEffectiveRetryPolicy(defaults RetryPolicy) RetryPolicy {
+	policy := defaults
+	if q.Retry == nil {
+		return policy
+	}
+	if q.Retry.MaxRetries != nil {
+		policy.MaxRetries = *q.Retry.MaxRetries
+	}
+	if q.Retry.Backoff != nil {
+		policy.Backoff = q.Retry.Backoff.Duration
+	}
+	return policy
+}
+
+// GetEffectiveOverrunPolicy returns the overrun policy for this query,
+// defaulting to "coalesce" if not specified
+func (q *Query) GetEffectiveOverrunPolicy() string {
+	if policy := strings.TrimSpace(q.OverrunPolicy); policy != "" {
+		return policy
+	}
+	return OverrunPolicyCoalesce
+}
+
 // GetEffectiveQueryType returns the query type for this query,
 // defaulting to "instant" if not specified
 func (q *Query) GetEffectiveQueryType() string {
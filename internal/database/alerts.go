@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertEvent describes a KPI series crossing one of its thresholds during
+// collection, or coming back within it
+type AlertEvent struct {
+	KPIID      string
+	Labels     map[string]string
+	Threshold  string  // the bound, e.g. "max <= 0.8"
+	Limit      float64 // the threshold value
+	Value      float64 // the sample value that changed the alert state
+	SampleTime time.Time
+	State      string // AlertFiring or AlertResolved
+}
+
+// alertRow converts the event labels and run ID to their column values
+func alertRow(runID int64, event AlertEvent) (sql.NullInt64, string, error) {
+	labels, err := json.Marshal(event.Labels)
+	if err != nil {
+		return sql.NullInt64{}, "", err
+	}
+	return sql.NullInt64{Int64: runID, Valid: runID != 0}, string(labels), nil
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The records of an exported archive. IDs are those of the exporting
+// database; importing remaps them to the IDs of the target database.
+
+// ArchiveCluster is a cluster of an archive
+type ArchiveCluster struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArchiveRun is a collection run of an archive
+type ArchiveRun struct {
+	ID               int64      `json:"id"`
+	ClusterID        int64      `json:"cluster_id"`
+	StartedAt        time.Time  `json:"started_at"`
+	EndedAt          *time.Time `json:"ended_at,omitempty"`
+	FrequencySeconds float64    `json:"frequency_seconds"`
+	DurationSeconds  float64    `json:"duration_seconds"`
+	KPIsFileHash     string     `json:"kpis_file_hash,omitempty"`
+	ToolVersion      string     `json:"tool_version,omitempty"`
+	ShutdownReason   string     `json:"shutdown_reason,omitempty"`
+	FailedQueries    int64      `json:"failed_queries"`
+	Retries          int64      `json:"retries"`
+}
+
+// ArchiveDefinition is a KPI definition of an archive with the runs that used it
+type ArchiveDefinition struct {
+	ID                int64   `json:"id"`
+	KPIID             string  `json:"kpi_id"`
+	Hash              string  `json:"definition_hash"`
+	PromQuery         string  `json:"promquery"`
+	ResolvedPromQuery string  `json:"resolved_promquery"`
+	QueryType         string  `json:"query_type"`
+	RangeStep         string  `json:"range_step,omitempty"`
+	RangeSince        string  `json:"range_since,omitempty"`
+	RangeUntil        string  `json:"range_until,omitempty"`
+	FrequencySeconds  float64 `json:"frequency_seconds"`
+	RunOnce           bool    `json:"run_once"`
+	RunIDs            []int64 `json:"run_ids"`
+}
+
+// ArchiveSample is a KPI sample of an archive
+type ArchiveSample struct {
+	ClusterID     int64           `json:"cluster_id"`
+	RunID         int64           `json:"run_id,omitempty"` // 0 for samples of no run
+	KPIID         string          `json:"kpi_id"`
+	Value         *float64        `json:"value"`
+	Timestamp     float64         `json:"timestamp"` // Unix seconds of the sample
+	Labels        json.RawMessage `json:"labels"`    // as stored, null when unknown
+	ExecutionTime time.Time       `json:"execution_time"`
+}
+
+// KPIDefinition returns the definition as recorded for a collection run
+func (d ArchiveDefinition) KPIDefinition() KPIDefinition {
+	return KPIDefinition{
+		KPIID:             d.KPIID,
+		PromQuery:         d.PromQuery,
+		ResolvedPromQuery: d.ResolvedPromQuery,
+		QueryType:         d.QueryType,
+		RangeStep:         d.RangeStep,
+		RangeSince:        d.RangeSince,
+		RangeUntil:        d.RangeUntil,
+		Frequency:         time.Duration(d.FrequencySeconds * float64(time.Second)),
+		RunOnce:           d.RunOnce,
+	}
+}
+
+// timestampFunc renders a time as a TIMESTAMP column argument
+type timestampFunc func(t time.Time) interface{}
+
+// sqliteTimestamp stores a time as the UTC text CURRENT_TIMESTAMP produces
+func sqliteTimestamp(t time.Time) interface{} {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// postgresTimestamp passes a time as is to a timestamp column
+func postgresTimestamp(t time.Time) interface{} {
+	return t
+}
+
+// importCollectionRun returns the ID of the run of the cluster started at the
+// same time as the archived run, inserting it when there is none, so that
+// importing an archive twice does not duplicate its runs. created reports
+// whether the run was inserted.
+func importCollectionRun(db *sql.DB, clusterID int64, run ArchiveRun, placeholder placeholderFunc, timestamp timestampFunc) (runID int64, created bool, err error) {
+	err = db.QueryRow(
+		fmt.Sprintf("SELECT id FROM collection_runs WHERE cluster_id = %s AND started_at = %s ORDER BY id LIMIT 1",
+			placeholder(1), placeholder(2)),
+		clusterID, timestamp(run.StartedAt),
+	).Scan(&runID)
+	if err == nil {
+		return runID, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	var endedAt interface{}
+	if run.EndedAt != nil {
+		endedAt = timestamp(*run.EndedAt)
+	}
+	err = db.QueryRow(
+		fmt.Sprintf(`
+        INSERT INTO collection_runs
+        (cluster_id, started_at, ended_at, frequency_seconds, duration_seconds, kpis_file_hash, tool_version,
+         shutdown_reason, failed_queries, retries)
+        VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s) RETURNING id`,
+			placeholder(1), placeholder(2), placeholder(3), placeholder(4), placeholder(5),
+			placeholder(6), placeholder(7), placeholder(8), placeholder(9), placeholder(10)),
+		clusterID, timestamp(run.StartedAt), endedAt, run.FrequencySeconds, run.DurationSeconds,
+		nullString(run.KPIsFileHash), nullString(run.ToolVersion), nullString(run.ShutdownReason),
+		run.FailedQueries, run.Retries,
+	).Scan(&runID)
+	return runID, err == nil, err
+}
+
+// archiveSampleColumns is the number of bind parameters per imported sample
+const archiveSampleColumns = 7
+
+// importQueryResults inserts samples whose cluster and run IDs are already
+// those of the target database, in one transaction. Samples already stored
+// are skipped by the query_results dedup index. Returns the number inserted.
+func importQueryResults(db *sql.DB, samples []ArchiveSample, placeholder placeholderFunc, timestamp timestampFunc, maxVariables int) (int64, error) {
+	if len(samples) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// NULL labels never conflict in the dedup index, so samples without
+	// labels, which only older versions stored, are checked one by one
+	var labelled []ArchiveSample
+	var inserted int64
+	for _, sample := range samples {
+		if sampleLabels(sample).Valid {
+			labelled = append(labelled, sample)
+			continue
+		}
+		stored, err := importUnlabelledSample(tx, sample, placeholder, timestamp)
+		if err != nil {
+			return 0, err
+		}
+		if stored {
+			inserted++
+		}
+	}
+
+	batchSize := maxVariables / archiveSampleColumns
+	for start := 0; start < len(labelled); start += batchSize {
+		batch := labelled[start:min(start+batchSize, len(labelled))]
+
+		var sb strings.Builder
+		sb.WriteString("INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, run_id, metric_labels, execution_time) VALUES ")
+		args := make([]interface{}, 0, len(batch)*archiveSampleColumns)
+		for i, sample := range batch {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("(")
+			for col := 1; col <= archiveSampleColumns; col++ {
+				if col > 1 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(placeholder(len(args) + col))
+			}
+			sb.WriteString(")")
+
+			args = append(args, sampleArgs(sample, timestamp)...)
+		}
+		sb.WriteString(" ON CONFLICT (kpi_id, cluster_id, timestamp_value, metric_labels) DO NOTHING")
+
+		result, err := tx.Exec(sb.String(), args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert samples %d-%d of %d: %w", start+1, start+len(batch), len(labelled), err)
+		}
+		affected, _ := result.RowsAffected()
+		inserted += affected
+	}
+
+	return inserted, tx.Commit()
+}
+
+// importUnlabelledSample inserts a sample without labels unless the same
+// sample is already stored, and reports whether it was inserted
+func importUnlabelledSample(tx *sql.Tx, sample ArchiveSample, placeholder placeholderFunc, timestamp timestampFunc) (bool, error) {
+	var exists int
+	err := tx.QueryRow(
+		fmt.Sprintf(`SELECT COUNT(*) FROM query_results
+        WHERE kpi_id = %s AND cluster_id = %s AND timestamp_value = %s AND metric_labels IS NULL`,
+			placeholder(1), placeholder(2), placeholder(3)),
+		sample.KPIID, sample.ClusterID, sample.Timestamp,
+	).Scan(&exists)
+	if err != nil || exists > 0 {
+		return false, err
+	}
+
+	_, err = tx.Exec(
+		fmt.Sprintf("INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, run_id, metric_labels, execution_time) VALUES (%s, %s, %s, %s, %s, %s, %s)",
+			placeholder(1), placeholder(2), placeholder(3), placeholder(4), placeholder(5), placeholder(6), placeholder(7)),
+		sampleArgs(sample, timestamp)...,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert sample: %w", err)
+	}
+	return true, nil
+}
+
+// sampleArgs returns the column values of a sample, in archiveSampleColumns order
+func sampleArgs(sample ArchiveSample, timestamp timestampFunc) []interface{} {
+	var run sql.NullInt64
+	if sample.RunID != 0 {
+		run = sql.NullInt64{Int64: sample.RunID, Valid: true}
+	}
+	return []interface{}{sample.KPIID, sample.Value, sample.Timestamp, sample.ClusterID, run, sampleLabels(sample),
+		timestamp(sample.ExecutionTime)}
+}
+
+// sampleLabels returns the labels column of a sample, NULL when unknown
+func sampleLabels(sample ArchiveSample) sql.NullString {
+	if len(sample.Labels) == 0 || string(sample.Labels) == "null" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(sample.Labels), Valid: true}
+}
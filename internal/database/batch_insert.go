@@ -0,0 +1,181 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// DefaultInsertBatchSize is the default number of samples written per INSERT statement
+	DefaultInsertBatchSize = 500
+
+	// queryResultColumns is the number of bind parameters used per query_results row
+	queryResultColumns = 6
+
+	// sqliteMaxVariables is SQLite's default SQLITE_MAX_VARIABLE_NUMBER (3.32.0+)
+	sqliteMaxVariables = 32766
+	// postgresMaxVariables is the maximum number of bind parameters in one PostgreSQL statement
+	postgresMaxVariables = 65535
+)
+
+// placeholderFunc renders the bind parameter for the n-th (1-based) statement argument
+type placeholderFunc func(n int) string
+
+func questionPlaceholder(int) string { return "?" }
+
+func dollarPlaceholder(n int) string { return "$" + strconv.Itoa(n) }
+
+// resultRow is a single sample flattened out of a Prometheus vector or matrix
+type resultRow struct {
+	value     float64
+	timestamp float64
+	labels    string
+}
+
+// flattenResult converts a Prometheus result into rows ready for insertion.
+// Supports model.Vector (from instant queries) and model.Matrix (from range queries).
+func flattenResult(queryID string, result model.Value) ([]resultRow, error) {
+	switch values := result.(type) {
+	case model.Vector:
+		rows := make([]resultRow, 0, len(values))
+		for _, sample := range values {
+			labelsJSON, err := json.Marshal(sample.Metric)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, resultRow{
+				value:     float64(sample.Value),
+				timestamp: float64(sample.Timestamp) / 1000,
+				labels:    string(labelsJSON),
+			})
+		}
+		return rows, nil
+
+	case model.Matrix:
+		var rows []resultRow
+		for _, stream := range values {
+			labelsJSON, err := json.Marshal(stream.Metric)
+			if err != nil {
+				return nil, err
+			}
+			for _, samplePair := range stream.Values {
+				rows = append(rows, resultRow{
+					value:     float64(samplePair.Value),
+					timestamp: float64(samplePair.Timestamp) / 1000,
+					labels:    string(labelsJSON),
+				})
+			}
+		}
+		return rows, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported Prometheus result type for KPI '%s': %T", queryID, result)
+	}
+}
+
+// effectiveBatchSize resolves the requested batch size (0 = DefaultInsertBatchSize)
+// and caps it so a statement never exceeds the backend's bind parameter limit.
+func effectiveBatchSize(requested int, maxVariables int) int {
+	limit := maxVariables / queryResultColumns
+	switch {
+	case requested <= 0:
+		return min(DefaultInsertBatchSize, limit)
+	case requested > limit:
+		return limit
+	default:
+		return requested
+	}
+}
+
+// buildResultInsert renders a multi-row INSERT for rowCount query_results rows.
+// Duplicate samples (same KPI, cluster, timestamp and labels) are skipped.
+func buildResultInsert(rowCount int, placeholder placeholderFunc) string {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO query_results (kpi_id, metric_value, timestamp_value, cluster_id, run_id, metric_labels) VALUES ")
+
+	arg := 1
+	for i := 0; i < rowCount; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(")
+		for col := 0; col < queryResultColumns; col++ {
+			if col > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(placeholder(arg))
+			arg++
+		}
+		sb.WriteString(")")
+	}
+
+	sb.WriteString(" ON CONFLICT (kpi_id, cluster_id, timestamp_value, metric_labels) DO NOTHING")
+	return sb.String()
+}
+
+// insertResultRows writes all rows of one query result inside a single
+// transaction using prepared multi-row INSERT statements of batchSize rows.
+// If any batch fails the transaction is rolled back, so no partial result is stored.
+func insertResultRows(db *sql.DB, clusterID int64, runID int64, queryID string, rows []resultRow, batchSize int, placeholder placeholderFunc) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := insertBatches(tx, clusterID, runID, queryID, rows, batchSize, placeholder); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertBatches(tx *sql.Tx, clusterID int64, runID int64, queryID string, rows []resultRow, batchSize int, placeholder placeholderFunc) error {
+	// Samples stored outside of a collection run are not attributed to one
+	var run sql.NullInt64
+	if runID != 0 {
+		run = sql.NullInt64{Int64: runID, Valid: true}
+	}
+
+	// Full-size batches share one prepared statement; only the trailing
+	// partial batch needs a statement of its own.
+	var fullStmt *sql.Stmt
+	if len(rows) >= batchSize {
+		stmt, err := tx.Prepare(buildResultInsert(batchSize, placeholder))
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert: %w", err)
+		}
+		defer func() { _ = stmt.Close() }()
+		fullStmt = stmt
+	}
+
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+
+		args := make([]interface{}, 0, len(batch)*queryResultColumns)
+		for _, row := range batch {
+			args = append(args, queryID, row.value, row.timestamp, clusterID, run, row.labels)
+		}
+
+		var err error
+		if len(batch) == batchSize {
+			_, err = fullStmt.Exec(args...)
+		} else {
+			_, err = tx.Exec(buildResultInsert(len(batch), placeholder), args...)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to insert samples %d-%d of %d: %w", start+1, start+len(batch), len(rows), err)
+		}
+	}
+
+	return nil
+}
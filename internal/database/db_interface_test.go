@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -87,12 +89,20 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 	})
 
 	Describe("IncrementQueryError", func() {
+		var clusterID int64
+
+		BeforeEach(func() {
+			var err error
+			clusterID, err = dbImpl.GetOrCreateCluster(db, "error-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
 		Context("when KPI has no previous errors", func() {
 			It("should create a new error record with count 1", func() {
-				err := dbImpl.IncrementQueryError(db, "test-kpi-1")
+				err := dbImpl.IncrementQueryError(db, clusterID, "test-kpi-1")
 				Expect(err).NotTo(HaveOccurred())
 
-				count, err := dbImpl.GetQueryErrorCount(db, "test-kpi-1")
+				count, err := dbImpl.GetQueryErrorCount(db, clusterID, "test-kpi-1")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(count).To(Equal(1))
 			})
@@ -100,18 +110,18 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 
 		Context("when KPI already has errors", func() {
 			BeforeEach(func() {
-				err := dbImpl.IncrementQueryError(db, "existing-kpi")
+				err := dbImpl.IncrementQueryError(db, clusterID, "existing-kpi")
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("should increment the existing error count", func() {
-				err := dbImpl.IncrementQueryError(db, "existing-kpi")
+				err := dbImpl.IncrementQueryError(db, clusterID, "existing-kpi")
 				Expect(err).NotTo(HaveOccurred())
 
-				err = dbImpl.IncrementQueryError(db, "existing-kpi")
+				err = dbImpl.IncrementQueryError(db, clusterID, "existing-kpi")
 				Expect(err).NotTo(HaveOccurred())
 
-				count, err := dbImpl.GetQueryErrorCount(db, "existing-kpi")
+				count, err := dbImpl.GetQueryErrorCount(db, clusterID, "existing-kpi")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(count).To(Equal(3))
 			})
@@ -119,29 +129,56 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 
 		Context("with multiple different KPI IDs", func() {
 			It("should handle them independently", func() {
-				err := dbImpl.IncrementQueryError(db, "kpi-a")
+				err := dbImpl.IncrementQueryError(db, clusterID, "kpi-a")
 				Expect(err).NotTo(HaveOccurred())
-				err = dbImpl.IncrementQueryError(db, "kpi-a")
+				err = dbImpl.IncrementQueryError(db, clusterID, "kpi-a")
 				Expect(err).NotTo(HaveOccurred())
 
-				err = dbImpl.IncrementQueryError(db, "kpi-b")
+				err = dbImpl.IncrementQueryError(db, clusterID, "kpi-b")
 				Expect(err).NotTo(HaveOccurred())
 
-				countA, err := dbImpl.GetQueryErrorCount(db, "kpi-a")
+				countA, err := dbImpl.GetQueryErrorCount(db, clusterID, "kpi-a")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(countA).To(Equal(2))
 
-				countB, err := dbImpl.GetQueryErrorCount(db, "kpi-b")
+				countB, err := dbImpl.GetQueryErrorCount(db, clusterID, "kpi-b")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(countB).To(Equal(1))
 			})
 		})
+
+		Context("with the same KPI ID on different clusters", func() {
+			It("should count errors per cluster", func() {
+				otherClusterID, err := dbImpl.GetOrCreateCluster(db, "other-cluster", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dbImpl.IncrementQueryError(db, clusterID, "shared-kpi")).To(Succeed())
+				Expect(dbImpl.IncrementQueryError(db, clusterID, "shared-kpi")).To(Succeed())
+				Expect(dbImpl.IncrementQueryError(db, otherClusterID, "shared-kpi")).To(Succeed())
+
+				count, err := dbImpl.GetQueryErrorCount(db, clusterID, "shared-kpi")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(2))
+
+				count, err = dbImpl.GetQueryErrorCount(db, otherClusterID, "shared-kpi")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+			})
+		})
 	})
 
 	Describe("GetQueryErrorCount", func() {
+		var clusterID int64
+
+		BeforeEach(func() {
+			var err error
+			clusterID, err = dbImpl.GetOrCreateCluster(db, "error-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
 		Context("when KPI ID does not exist", func() {
 			It("should return 0", func() {
-				count, err := dbImpl.GetQueryErrorCount(db, "non-existent-kpi")
+				count, err := dbImpl.GetQueryErrorCount(db, clusterID, "non-existent-kpi")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(count).To(Equal(0))
 			})
@@ -150,19 +187,448 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 		Context("when KPI ID exists", func() {
 			BeforeEach(func() {
 				for i := 0; i < 5; i++ {
-					err := dbImpl.IncrementQueryError(db, "test-kpi")
+					err := dbImpl.IncrementQueryError(db, clusterID, "test-kpi")
 					Expect(err).NotTo(HaveOccurred())
 				}
 			})
 
 			It("should return the correct count", func() {
-				count, err := dbImpl.GetQueryErrorCount(db, "test-kpi")
+				count, err := dbImpl.GetQueryErrorCount(db, clusterID, "test-kpi")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(count).To(Equal(5))
 			})
 		})
 	})
 
+	Describe("IncrementQueryRetry", func() {
+		var clusterID int64
+
+		BeforeEach(func() {
+			var err error
+			clusterID, err = dbImpl.GetOrCreateCluster(db, "error-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should count retries separately from hard failures", func() {
+			Expect(dbImpl.IncrementQueryRetry(db, clusterID, "flaky-kpi")).To(Succeed())
+			Expect(dbImpl.IncrementQueryRetry(db, clusterID, "flaky-kpi")).To(Succeed())
+
+			retries, err := dbImpl.GetQueryRetryCount(db, clusterID, "flaky-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retries).To(Equal(2))
+
+			errors, err := dbImpl.GetQueryErrorCount(db, clusterID, "flaky-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors).To(Equal(0))
+		})
+
+		It("should return 0 for a KPI that was never retried", func() {
+			retries, err := dbImpl.GetQueryRetryCount(db, clusterID, "steady-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retries).To(Equal(0))
+		})
+	})
+
+	Describe("RecordOverrun", func() {
+		It("should store the overrun event for the cluster", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "overrun-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = dbImpl.RecordOverrun(db, clusterID, OverrunEvent{
+				Frequency:    30 * time.Second,
+				SampleNumber: 4,
+				RunDuration:  75 * time.Second,
+				MissedTicks:  2,
+				Policy:       "coalesce",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var (
+				storedClusterID            int64
+				frequencySecs, runDuration float64
+				sampleNumber, missedTicks  int
+				policy, occurredAt         string
+			)
+			err = db.QueryRow(`
+				SELECT cluster_id, frequency_seconds, sample_number, run_duration_seconds, missed_ticks, policy, occurred_at
+				FROM collection_overruns
+			`).Scan(&storedClusterID, &frequencySecs, &sampleNumber, &runDuration, &missedTicks, &policy, &occurredAt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedClusterID).To(Equal(clusterID))
+			Expect(frequencySecs).To(Equal(30.0))
+			Expect(sampleNumber).To(Equal(4))
+			Expect(runDuration).To(Equal(75.0))
+			Expect(missedTicks).To(Equal(2))
+			Expect(policy).To(Equal("coalesce"))
+			Expect(occurredAt).NotTo(BeEmpty())
+		})
+	})
+
+	Describe("RecordQueryError", func() {
+		It("should store the details of the failed attempt for the cluster", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "error-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = dbImpl.RecordQueryError(db, clusterID, QueryErrorEvent{
+				KPIID:      "kpi-503",
+				QueryType:  "range",
+				PromQuery:  "sum(rate(up[5m]))",
+				HTTPStatus: 503,
+				Message:    "server error: 503",
+				Warnings:   []string{"partial response"},
+				Attempt:    1,
+				Retried:    true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var (
+				storedClusterID                  int64
+				kpiID, queryType, promQuery, msg string
+				httpStatus                       sql.NullInt64
+				warnings                         sql.NullString
+				attempt                          int
+				retried                          bool
+			)
+			err = db.QueryRow(`
+				SELECT cluster_id, kpi_id, query_type, promquery, http_status, error_message, warnings, attempt, retried
+				FROM query_error_events
+			`).Scan(&storedClusterID, &kpiID, &queryType, &promQuery, &httpStatus, &msg, &warnings, &attempt, &retried)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedClusterID).To(Equal(clusterID))
+			Expect(kpiID).To(Equal("kpi-503"))
+			Expect(queryType).To(Equal("range"))
+			Expect(promQuery).To(Equal("sum(rate(up[5m]))"))
+			Expect(httpStatus).To(Equal(sql.NullInt64{Int64: 503, Valid: true}))
+			Expect(msg).To(Equal("server error: 503"))
+			Expect(warnings.String).To(MatchJSON(`["partial response"]`))
+			Expect(attempt).To(Equal(1))
+			Expect(retried).To(BeTrue())
+		})
+
+		It("should store NULL for a missing HTTP status and warnings", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "error-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = dbImpl.RecordQueryError(db, clusterID, QueryErrorEvent{
+				KPIID: "kpi-dial", QueryType: "instant", PromQuery: "up", Message: "connection refused", Attempt: 1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var (
+				httpStatus sql.NullInt64
+				warnings   sql.NullString
+			)
+			err = db.QueryRow("SELECT http_status, warnings FROM query_error_events WHERE kpi_id = $1", "kpi-dial").
+				Scan(&httpStatus, &warnings)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(httpStatus.Valid).To(BeFalse())
+			Expect(warnings.Valid).To(BeFalse())
+		})
+	})
+
+	Describe("RecordAlert", func() {
+		It("should store the alert of the run with its labels", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "alert-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+			runID, err := dbImpl.StartCollectionRun(db, clusterID, CollectionRun{})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = dbImpl.RecordAlert(db, clusterID, runID, AlertEvent{
+				KPIID:      "cpu",
+				Labels:     map[string]string{"cpu": "2"},
+				Threshold:  "max <= 0.8",
+				Limit:      0.8,
+				Value:      0.93,
+				SampleTime: time.Unix(1700000000, 500000000),
+				State:      AlertFiring,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbImpl.RecordAlert(db, clusterID, 0, AlertEvent{KPIID: "cpu", State: AlertResolved})).To(Succeed())
+
+			var (
+				storedRunID                sql.NullInt64
+				labels, threshold, state   string
+				limit, value, sampleSecond float64
+			)
+			err = db.QueryRow(`
+				SELECT run_id, metric_labels, threshold, limit_value, metric_value, sample_timestamp, state
+				FROM alerts WHERE state = $1
+			`, AlertFiring).Scan(&storedRunID, &labels, &threshold, &limit, &value, &sampleSecond, &state)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedRunID).To(Equal(sql.NullInt64{Int64: runID, Valid: true}))
+			Expect(labels).To(MatchJSON(`{"cpu":"2"}`))
+			Expect(threshold).To(Equal("max <= 0.8"))
+			Expect(limit).To(Equal(0.8))
+			Expect(value).To(Equal(0.93))
+			Expect(sampleSecond).To(Equal(1700000000.5))
+			Expect(state).To(Equal(AlertFiring))
+
+			err = db.QueryRow("SELECT run_id FROM alerts WHERE state = $1", AlertResolved).Scan(&storedRunID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedRunID.Valid).To(BeFalse())
+		})
+	})
+
+	Describe("collection runs", func() {
+		It("should record the start and end of a run and attribute samples to it", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "run-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			runID, err := dbImpl.StartCollectionRun(db, clusterID, CollectionRun{
+				Frequency: 30 * time.Second, Duration: time.Hour, KPIsFileHash: "abc123", ToolVersion: "v1.0.0",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runID).To(BeNumerically(">", 0))
+
+			vector := model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1, Timestamp: model.Now()}}
+			Expect(dbImpl.StoreQueryResults(db, clusterID, runID, "run-kpi", vector)).To(Succeed())
+
+			var endedAt sql.NullTime
+			err = db.QueryRow("SELECT ended_at FROM collection_runs WHERE id = $1", runID).Scan(&endedAt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(endedAt.Valid).To(BeFalse())
+
+			err = dbImpl.FinishCollectionRun(db, runID, CollectionRunResult{ShutdownReason: "Duration completed", FailedQueries: 2, Retries: 5})
+			Expect(err).NotTo(HaveOccurred())
+
+			var (
+				storedClusterID           int64
+				frequency, duration       float64
+				hash, version, reason     string
+				failedQueries, retryCount int
+			)
+			err = db.QueryRow(`
+				SELECT cluster_id, ended_at, frequency_seconds, duration_seconds, kpis_file_hash, tool_version,
+				       shutdown_reason, failed_queries, retries
+				FROM collection_runs WHERE id = $1
+			`, runID).Scan(&storedClusterID, &endedAt, &frequency, &duration, &hash, &version, &reason, &failedQueries, &retryCount)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedClusterID).To(Equal(clusterID))
+			Expect(endedAt.Valid).To(BeTrue())
+			Expect(frequency).To(Equal(30.0))
+			Expect(duration).To(Equal(3600.0))
+			Expect(hash).To(Equal("abc123"))
+			Expect(version).To(Equal("v1.0.0"))
+			Expect(reason).To(Equal("Duration completed"))
+			Expect(failedQueries).To(Equal(2))
+			Expect(retryCount).To(Equal(5))
+
+			var storedRunID sql.NullInt64
+			err = db.QueryRow("SELECT run_id FROM query_results WHERE kpi_id = $1", "run-kpi").Scan(&storedRunID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedRunID.Int64).To(Equal(runID))
+		})
+
+		It("should store samples outside of a run without a run ID", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "run-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			vector := model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1, Timestamp: model.Now()}}
+			Expect(dbImpl.StoreQueryResults(db, clusterID, 0, "no-run-kpi", vector)).To(Succeed())
+
+			var storedRunID sql.NullInt64
+			err = db.QueryRow("SELECT run_id FROM query_results WHERE kpi_id = $1", "no-run-kpi").Scan(&storedRunID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storedRunID.Valid).To(BeFalse())
+		})
+	})
+
+	Describe("RecordKPIDefinitions", func() {
+		countDefinitions := func(kpiID string) int {
+			var count int
+			err := db.QueryRow("SELECT COUNT(*) FROM kpi_definitions WHERE kpi_id = $1", kpiID).Scan(&count)
+			Expect(err).NotTo(HaveOccurred())
+			return count
+		}
+
+		It("should store identical definitions once and a new version when they change", func() {
+			clusterID, err := dbImpl.GetOrCreateCluster(db, "definitions-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+			definition := KPIDefinition{
+				KPIID:             "cpu-reserved-cores",
+				PromQuery:         `sum(rate(node_cpu_seconds_total{cpu=~"{{RESERVED_CPUS}}"}[5m]))`,
+				ResolvedPromQuery: `sum(rate(node_cpu_seconds_total{cpu=~"0|1"}[5m]))`,
+				QueryType:         "instant",
+				Frequency:         30 * time.Second,
+			}
+
+			firstRun, err := dbImpl.StartCollectionRun(db, clusterID, CollectionRun{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbImpl.RecordKPIDefinitions(db, firstRun, []KPIDefinition{definition})).To(Succeed())
+
+			secondRun, err := dbImpl.StartCollectionRun(db, clusterID, CollectionRun{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbImpl.RecordKPIDefinitions(db, secondRun, []KPIDefinition{definition})).To(Succeed())
+			Expect(countDefinitions("cpu-reserved-cores")).To(Equal(1))
+
+			var links int
+			err = db.QueryRow("SELECT COUNT(*) FROM collection_run_definitions WHERE run_id IN ($1, $2)", firstRun, secondRun).Scan(&links)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links).To(Equal(2))
+
+			definition.ResolvedPromQuery = `sum(rate(node_cpu_seconds_total{cpu=~"0|1|32|33"}[5m]))`
+			thirdRun, err := dbImpl.StartCollectionRun(db, clusterID, CollectionRun{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbImpl.RecordKPIDefinitions(db, thirdRun, []KPIDefinition{definition})).To(Succeed())
+			Expect(countDefinitions("cpu-reserved-cores")).To(Equal(2))
+
+			var (
+				hash, raw, resolved string
+				rangeStep           sql.NullString
+				frequency           float64
+				runOnce             bool
+			)
+			err = db.QueryRow(`
+				SELECT d.definition_hash, d.promquery, d.resolved_promquery, d.range_step, d.frequency_seconds, d.run_once
+				FROM kpi_definitions d JOIN collection_run_definitions l ON l.definition_id = d.id
+				WHERE l.run_id = $1
+			`, thirdRun).Scan(&hash, &raw, &resolved, &rangeStep, &frequency, &runOnce)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hash).To(Equal(definition.Hash()))
+			Expect(raw).To(ContainSubstring("{{RESERVED_CPUS}}"))
+			Expect(resolved).To(ContainSubstring("0|1|32|33"))
+			Expect(rangeStep.Valid).To(BeFalse())
+			Expect(frequency).To(Equal(30.0))
+			Expect(runOnce).To(BeFalse())
+		})
+	})
+
+	Describe("PruneQueryResults", func() {
+		var clusterID, otherClusterID int64
+		// base is aligned to an hour so samples fall into predictable buckets
+		base := time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)
+
+		storeSample := func(cluster int64, value float64, at time.Time) {
+			vector := model.Vector{&model.Sample{
+				Metric:    model.Metric{"__name__": "up", "instance": "node-1"},
+				Value:     model.SampleValue(value),
+				Timestamp: model.TimeFromUnix(at.Unix()),
+			}}
+			Expect(dbImpl.StoreQueryResults(db, cluster, 0, "kpi-prune", vector)).To(Succeed())
+		}
+
+		countSamples := func(cluster int64) int {
+			var count int
+			err := db.QueryRow("SELECT COUNT(*) FROM query_results WHERE cluster_id = $1", cluster).Scan(&count)
+			Expect(err).NotTo(HaveOccurred())
+			return count
+		}
+
+		BeforeEach(func() {
+			var err error
+			clusterID, err = dbImpl.GetOrCreateCluster(db, "prune-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+			otherClusterID, err = dbImpl.GetOrCreateCluster(db, "other-prune-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			for i, value := range []float64{1, 3, 8} {
+				storeSample(clusterID, value, base.Add(time.Duration(i*10)*time.Minute))
+			}
+			storeSample(clusterID, 5, base.Add(90*time.Minute))
+			storeSample(otherClusterID, 7, base)
+		})
+
+		It("should delete the samples before the cutoff of one cluster", func() {
+			result, err := dbImpl.PruneQueryResults(db, RetentionPolicy{Before: base.Add(time.Hour), ClusterID: clusterID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal(int64(3)))
+			Expect(result.Buckets).To(BeZero())
+			Expect(result.Cutoff.Equal(base.Add(time.Hour))).To(BeTrue())
+
+			Expect(countSamples(clusterID)).To(Equal(1))
+			Expect(countSamples(otherClusterID)).To(Equal(1))
+		})
+
+		It("should leave the database unchanged on a dry run", func() {
+			result, err := dbImpl.PruneQueryResults(db, RetentionPolicy{Before: base.Add(2 * time.Hour), Downsample: time.Hour, DryRun: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal(int64(5)))
+			Expect(result.Buckets).To(Equal(int64(3)))
+
+			Expect(countSamples(clusterID)).To(Equal(4))
+			var rollups int
+			Expect(db.QueryRow("SELECT COUNT(*) FROM query_rollups").Scan(&rollups)).To(Succeed())
+			Expect(rollups).To(BeZero())
+		})
+
+		It("should roll whole buckets up and merge late samples into them", func() {
+			// The cutoff is aligned down to 11:00, keeping the 11:30 sample raw
+			result, err := dbImpl.PruneQueryResults(db, RetentionPolicy{
+				Before: base.Add(100 * time.Minute), Downsample: time.Hour, ClusterID: clusterID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal(int64(3)))
+			Expect(result.Buckets).To(Equal(int64(1)))
+			Expect(result.Cutoff.Equal(base.Add(time.Hour))).To(BeTrue())
+			Expect(countSamples(clusterID)).To(Equal(1))
+
+			type rollup struct {
+				bucketStart, minValue, maxValue, avgValue float64
+				count                                     int
+			}
+			readRollup := func() rollup {
+				var r rollup
+				err := db.QueryRow(`
+					SELECT bucket_start, min_value, max_value, avg_value, sample_count
+					FROM query_rollups WHERE cluster_id = $1 AND kpi_id = 'kpi-prune' AND bucket_seconds = 3600
+				`, clusterID).Scan(&r.bucketStart, &r.minValue, &r.maxValue, &r.avgValue, &r.count)
+				Expect(err).NotTo(HaveOccurred())
+				return r
+			}
+			Expect(readRollup()).To(Equal(rollup{bucketStart: float64(base.Unix()), minValue: 1, maxValue: 8, avgValue: 4, count: 3}))
+
+			// A sample arriving late for an already rolled-up bucket is merged into it
+			storeSample(clusterID, 12, base.Add(50*time.Minute))
+			result, err = dbImpl.PruneQueryResults(db, RetentionPolicy{
+				Before: base.Add(100 * time.Minute), Downsample: time.Hour, ClusterID: clusterID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Deleted).To(Equal(int64(1)))
+			Expect(readRollup()).To(Equal(rollup{bucketStart: float64(base.Unix()), minValue: 1, maxValue: 12, avgValue: 6, count: 4}))
+		})
+
+		It("should reject a bucket that is not a whole number of seconds", func() {
+			_, err := dbImpl.PruneQueryResults(db, RetentionPolicy{Before: base, Downsample: 1500 * time.Millisecond})
+			Expect(err).To(MatchError(ContainSubstring("whole number of seconds")))
+		})
+	})
+
+	Describe("schema migrations", func() {
+		It("should migrate a new database to the latest version", func() {
+			version, err := dbImpl.SchemaVersion(db)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(LatestVersion(dbImpl)))
+
+			_, pending, err := PendingMigrations(db, dbImpl, LatestVersion(dbImpl))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pending).To(BeEmpty())
+		})
+
+		It("should number the migrations consecutively from 1", func() {
+			for i, migration := range dbImpl.Migrations() {
+				Expect(migration.Version).To(Equal(i + 1))
+				Expect(migration.Description).NotTo(BeEmpty())
+			}
+		})
+
+		It("should refuse a database migrated by a newer version", func() {
+			newer := LatestVersion(dbImpl) + 1
+			_, err := db.Exec("INSERT INTO schema_migrations (version, description) VALUES ($1, 'from the future')", newer)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, migrateErr := Migrate(db, dbImpl, LatestVersion(dbImpl))
+			_, err = db.Exec("DELETE FROM schema_migrations WHERE version = $1", newer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.Is(migrateErr, ErrSchemaTooNew)).To(BeTrue())
+			Expect(migrateErr.Error()).To(ContainSubstring("upgrade kpi-collector"))
+		})
+
+		It("should reject unknown target versions", func() {
+			_, _, err := PendingMigrations(db, dbImpl, LatestVersion(dbImpl)+1)
+			Expect(err).To(MatchError(ContainSubstring("unknown schema version")))
+		})
+	})
+
 	Describe("StoreQueryResults", func() {
 		var clusterID int64
 
@@ -186,7 +652,7 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 					},
 				}
 
-				err := dbImpl.StoreQueryResults(db, clusterID, "test-query-1", vector)
+				err := dbImpl.StoreQueryResults(db, clusterID, 0, "test-query-1", vector)
 				Expect(err).NotTo(HaveOccurred())
 
 				var count int
@@ -223,7 +689,7 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 						Timestamp: model.Time(time.Now().Unix() * 1000),
 					},
 				}
-				err := dbImpl.StoreQueryResults(db, clusterID, "test-query-3", vector)
+				err := dbImpl.StoreQueryResults(db, clusterID, 0, "test-query-3", vector)
 				Expect(err).NotTo(HaveOccurred())
 
 				var metricLabels string
@@ -244,7 +710,7 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 						Timestamp: model.Time(time.Now().Unix() * 1000),
 					},
 				}
-				err := dbImpl.StoreQueryResults(db, clusterID, "test-query-4", vector)
+				err := dbImpl.StoreQueryResults(db, clusterID, 0, "test-query-4", vector)
 				Expect(err).NotTo(HaveOccurred())
 
 				var executionTime, createdAt string
@@ -279,7 +745,7 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 					},
 				}
 
-				err := dbImpl.StoreQueryResults(db, clusterID, "test-query-2", vector)
+				err := dbImpl.StoreQueryResults(db, clusterID, 0, "test-query-2", vector)
 				Expect(err).NotTo(HaveOccurred())
 
 				var count int
@@ -289,6 +755,59 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 			})
 		})
 
+		Context("with a range result larger than the insert batch size", func() {
+			BeforeEach(func() {
+				dbImpl = withInsertBatchSize(dbImpl, 7)
+			})
+
+			It("should store every sample across several batches", func() {
+				start := time.Now().Add(-time.Hour).Unix()
+				matrix := model.Matrix{}
+				for s := 0; s < 3; s++ {
+					stream := &model.SampleStream{Metric: model.Metric{"series": model.LabelValue(fmt.Sprintf("s%d", s))}}
+					for i := 0; i < 20; i++ {
+						stream.Values = append(stream.Values, model.SamplePair{
+							Timestamp: model.Time((start + int64(i*30)) * 1000),
+							Value:     model.SampleValue(i),
+						})
+					}
+					matrix = append(matrix, stream)
+				}
+
+				err := dbImpl.StoreQueryResults(db, clusterID, 0, "batched-range", matrix)
+				Expect(err).NotTo(HaveOccurred())
+
+				var count int
+				err = db.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = $1", "batched-range").Scan(&count)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(60))
+			})
+
+			It("should skip samples that were already stored", func() {
+				ts := model.Time(time.Now().Unix() * 1000)
+				matrix := model.Matrix{
+					&model.SampleStream{
+						Metric: model.Metric{"__name__": "dup"},
+						Values: []model.SamplePair{{Timestamp: ts, Value: 1}, {Timestamp: ts + 30000, Value: 2}},
+					},
+				}
+
+				Expect(dbImpl.StoreQueryResults(db, clusterID, 0, "batched-dedup", matrix)).To(Succeed())
+				Expect(dbImpl.StoreQueryResults(db, clusterID, 0, "batched-dedup", matrix)).To(Succeed())
+
+				var count int
+				err := db.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = $1", "batched-dedup").Scan(&count)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(2))
+			})
+		})
+
+		It("should reject unsupported result types without storing anything", func() {
+			err := dbImpl.StoreQueryResults(db, clusterID, 0, "scalar-kpi", &model.Scalar{Value: 1})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unsupported Prometheus result type"))
+		})
+
 		Context("with multiple clusters", func() {
 			var clusterID2 int64
 
@@ -315,10 +834,10 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 					},
 				}
 
-				err := dbImpl.StoreQueryResults(db, clusterID, "query-cluster-1", vector1)
+				err := dbImpl.StoreQueryResults(db, clusterID, 0, "query-cluster-1", vector1)
 				Expect(err).NotTo(HaveOccurred())
 
-				err = dbImpl.StoreQueryResults(db, clusterID2, "query-cluster-2", vector2)
+				err = dbImpl.StoreQueryResults(db, clusterID2, 0, "query-cluster-2", vector2)
 				Expect(err).NotTo(HaveOccurred())
 
 				var storedClusterID int64
@@ -333,3 +852,20 @@ func RunDatabaseInterfaceTests(getImpl func() (Database, *sql.DB)) {
 		})
 	})
 }
+
+// withInsertBatchSize returns a copy of dbImpl that writes batchSize samples per INSERT statement
+func withInsertBatchSize(dbImpl Database, batchSize int) Database {
+	switch impl := dbImpl.(type) {
+	case *SQLiteDB:
+		sqliteCopy := *impl
+		sqliteCopy.InsertBatchSize = batchSize
+		return &sqliteCopy
+	case *PostgresDB:
+		postgresCopy := *impl
+		postgresCopy.InsertBatchSize = batchSize
+		return &postgresCopy
+	default:
+		Fail(fmt.Sprintf("unsupported Database implementation %T", dbImpl))
+		return nil
+	}
+}
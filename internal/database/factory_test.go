@@ -0,0 +1,63 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseSpec", func() {
+	DescribeTable("parsing database arguments",
+		func(spec string, expected Database, expectedErr string) {
+			dbImpl, err := ParseSpec(spec)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbImpl).To(Equal(expected))
+		},
+		Entry("SQLite file", "sqlite:/data/kpi.db", &SQLiteDB{Path: "/data/kpi.db"}, ""),
+		Entry("PostgreSQL", "postgres:postgresql://u:p@db/kpi", NewPostgresDB("postgresql://u:p@db/kpi"), ""),
+		Entry("bare PostgreSQL URL", "postgres://u:p@db/kpi", NewPostgresDB("postgres://u:p@db/kpi"), ""),
+		Entry("PostgreSQL without URL", "postgres:", nil, "requires a connection string"),
+		Entry("unknown scheme", "mysql://db", nil, "must be sqlite:<path> or postgres:<url>"),
+	)
+
+	It("should resolve a SQLite artifacts directory to its database file", func() {
+		tmpDir := GinkgoT().TempDir()
+
+		dbImpl, err := ParseSpec("sqlite:" + tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dbImpl).To(Equal(&SQLiteDB{Path: filepath.Join(tmpDir, DefaultDBFileName)}))
+		Expect(SameDatabase(dbImpl, &SQLiteDB{Path: filepath.Join(tmpDir, ".", DefaultDBFileName)})).To(BeTrue())
+	})
+
+	It("should resolve the default SQLite database to the artifacts directory", func() {
+		OutputDir = GinkgoT().TempDir()
+		DeferCleanup(func() { OutputDir = DefaultOutputDir })
+		Expect(os.WriteFile(filepath.Join(OutputDir, DefaultDBFileName), nil, 0644)).To(Succeed())
+
+		Expect(SameDatabase(NewSQLiteDB(), SQLiteAt(OutputDir))).To(BeTrue())
+		Expect(SameDatabase(NewSQLiteDB(), NewPostgresDB("postgres://db/kpi"))).To(BeFalse())
+	})
+})
+
+var _ = Describe("NewDatabase", func() {
+	It("should create file databases writing the given format, named after it", func() {
+		OutputDir = "/data/lab"
+		DeferCleanup(func() { OutputDir = DefaultOutputDir })
+
+		dbImpl, err := NewDatabase("file", "", 0, FileFormatOpenMetrics)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dbImpl).To(Equal(&FileDB{Format: FileFormatOpenMetrics}))
+		Expect(DisplayName(dbImpl)).To(Equal("openmetrics files (/data/lab)"))
+		Expect(DisplayName(NewFileDB())).To(Equal("jsonl files (/data/lab)"))
+
+		Expect(SameDatabase(dbImpl, NewFileDB())).To(BeTrue())
+		Expect(SameDatabase(dbImpl, NewSQLiteDB())).To(BeFalse())
+		Expect(SameDatabase(NewSQLiteDB(), dbImpl)).To(BeFalse())
+	})
+})
@@ -0,0 +1,750 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Formats of the sample files of a file database
+const (
+	FileFormatJSONL       = "jsonl"
+	FileFormatOpenMetrics = "openmetrics"
+)
+
+const (
+	// DefaultMaxFileSize is the size past which a sample file is rotated
+	DefaultMaxFileSize = 64 << 20
+
+	// sampleFilePrefix starts the name of the sample files in OutputDir
+	sampleFilePrefix = "kpi_samples-"
+	// runFileSuffix replaces the extension of a sample file in the name of
+	// its run file
+	runFileSuffix = ".run.json"
+	// loadBatchSize is the number of samples loaded from the files per INSERT
+	loadBatchSize = 5000
+)
+
+// sampleFileExtensions maps the formats to the extension of their files
+var sampleFileExtensions = map[string]string{
+	FileFormatJSONL:       ".jsonl",
+	FileFormatOpenMetrics: ".om",
+}
+
+// FileSample is a line of a JSONL sample file
+type FileSample struct {
+	KPIID         string            `json:"kpi_id"`
+	Cluster       string            `json:"cluster"`
+	Labels        map[string]string `json:"labels"`
+	Value         float64           `json:"value"`
+	Timestamp     time.Time         `json:"timestamp"`
+	ExecutionTime time.Time         `json:"execution_time"`
+}
+
+// FileRun is the run file written next to each sample file: the collection
+// run that wrote its samples, with its cluster and KPI definitions
+type FileRun struct {
+	Cluster     string              `json:"cluster"`
+	ClusterType string              `json:"cluster_type,omitempty"`
+	Run         ArchiveRun          `json:"run"`
+	Definitions []ArchiveDefinition `json:"kpi_definitions"`
+}
+
+// FileDB implements the Database interface for air-gapped collection, where
+// no database tooling is available: samples are appended to rotated JSONL or
+// OpenMetrics text files in OutputDir, one OpenMetrics file per KPI, as the
+// samples of a metric family must not be interleaved with other families.
+// Each sample file gets a run file describing its collection run. Everything
+// else, e.g. query errors and alerts, is kept in an in-memory SQLite database
+// for the duration of the run only; Load reads the sample and run files back
+// into it, so that they can be queried like a SQLite database.
+type FileDB struct {
+	SQLiteDB
+
+	// Format is the format of the files written: jsonl (default) or openmetrics
+	Format string
+	// MaxFileSize is the size past which a file is rotated (0 = DefaultMaxFileSize)
+	MaxFileSize int64
+
+	mu       sync.Mutex
+	files    map[string]*sampleFile // open files by family, "" for JSONL
+	closed   []string               // sample files closed during the run
+	run      *FileRun               // the run writing the files, nil before StartCollectionRun
+	clusters map[int64]string       // cluster names by ID
+}
+
+// sampleFile is a sample file being written
+type sampleFile struct {
+	*os.File
+	size int64
+}
+
+// NewFileDB creates a new file database instance
+func NewFileDB() *FileDB {
+	return &FileDB{}
+}
+
+// Connect opens the in-memory database, without creating the schema. It is
+// limited to one connection, as every connection has a database of its own.
+func (f *FileDB) Connect() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	return db, nil
+}
+
+// InitDB opens the in-memory database with the latest schema. The sample
+// files are not read; see Load.
+func (f *FileDB) InitDB() (*sql.DB, error) {
+	db, err := f.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := Migrate(db, f, LatestVersion(f)); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// StartCollectionRun records the start of a collection run in memory, and
+// in the run files of the sample files it writes
+func (f *FileDB) StartCollectionRun(db *sql.DB, clusterID int64, run CollectionRun) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	record := FileRun{Run: ArchiveRun{
+		ClusterID:        clusterID,
+		StartedAt:        time.Now().UTC().Truncate(time.Second),
+		FrequencySeconds: run.Frequency.Seconds(),
+		DurationSeconds:  run.Duration.Seconds(),
+		KPIsFileHash:     run.KPIsFileHash,
+		ToolVersion:      run.ToolVersion,
+	}}
+	err := db.QueryRow("SELECT cluster_name, COALESCE(cluster_type, '') FROM clusters WHERE id = ?", clusterID).
+		Scan(&record.Cluster, &record.ClusterType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up cluster %d: %w", clusterID, err)
+	}
+
+	runID, _, err := f.SQLiteDB.ImportCollectionRun(db, clusterID, record.Run)
+	if err != nil {
+		return 0, err
+	}
+	record.Run.ID = runID
+	f.run = &record
+	return runID, nil
+}
+
+// RecordKPIDefinitions stores the KPI definitions of a collection run in
+// memory, and in the run files of the sample files it writes
+func (f *FileDB) RecordKPIDefinitions(db *sql.DB, runID int64, definitions []KPIDefinition) error {
+	if err := f.SQLiteDB.RecordKPIDefinitions(db, runID, definitions); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.run != nil && f.run.Run.ID == runID {
+		for _, d := range definitions {
+			f.run.Definitions = append(f.run.Definitions, ArchiveDefinition{
+				KPIID:             d.KPIID,
+				Hash:              d.Hash(),
+				PromQuery:         d.PromQuery,
+				ResolvedPromQuery: d.ResolvedPromQuery,
+				QueryType:         d.QueryType,
+				RangeStep:         d.RangeStep,
+				RangeSince:        d.RangeSince,
+				RangeUntil:        d.RangeUntil,
+				FrequencySeconds:  d.Frequency.Seconds(),
+				RunOnce:           d.RunOnce,
+				RunIDs:            []int64{runID},
+			})
+		}
+	}
+	return nil
+}
+
+// StoreQueryResults appends the samples of a query result to the current
+// sample file, rotating it when full. The samples belong to the run of the
+// run file, whatever runID is.
+func (f *FileDB) StoreQueryResults(db *sql.DB, clusterID int64, runID int64, queryID string, result model.Value) error {
+	rows, err := flattenResult(queryID, result)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cluster, err := f.clusterName(db, clusterID)
+	if err != nil {
+		return err
+	}
+
+	// Render the result first, so that it is written to a single file
+	var records []sampleRecord
+	executionTime := time.Now().UTC()
+	for _, row := range rows {
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(row.labels), &labels); err != nil {
+			return err
+		}
+		records = append(records, sampleRecord{
+			kpiID:         queryID,
+			cluster:       cluster,
+			labels:        labels,
+			value:         row.value,
+			timestamp:     row.timestamp,
+			executionTime: executionTime,
+		})
+	}
+
+	family := ""
+	if f.format() == FileFormatOpenMetrics {
+		family = MetricName(queryID)
+	}
+	file := f.files[family]
+	if file == nil || file.size >= f.maxFileSize() {
+		if file, err = f.rotate(family); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		if family != "" {
+			appendOpenMetrics(&buf, family, record)
+		} else if err := appendJSONL(&buf, record); err != nil {
+			return err
+		}
+	}
+
+	n, err := file.Write(buf.Bytes())
+	file.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+// FinishCollectionRun closes the sample files, and records the end of the
+// run in memory and in the run file of every sample file of the run
+func (f *FileDB) FinishCollectionRun(db *sql.DB, runID int64, result CollectionRunResult) error {
+	if err := f.SQLiteDB.FinishCollectionRun(db, runID, result); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	for family, file := range f.files {
+		err = errors.Join(err, f.closeFile(file, family))
+	}
+	f.files = nil
+
+	if f.run != nil && f.run.Run.ID == runID {
+		endedAt := time.Now().UTC().Truncate(time.Second)
+		f.run.Run.EndedAt = &endedAt
+		f.run.Run.ShutdownReason = result.ShutdownReason
+		f.run.Run.FailedQueries = result.FailedQueries
+		f.run.Run.Retries = result.Retries
+		for _, path := range f.closed {
+			err = errors.Join(err, f.writeRunFile(path))
+		}
+	}
+	return err
+}
+
+// Files returns the sample files in OutputDir, oldest first
+func (f *FileDB) Files() ([]string, error) {
+	entries, err := os.ReadDir(OutputDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), sampleFilePrefix) &&
+			sampleFileFormat(entry.Name()) != "" {
+			files = append(files, filepath.Join(OutputDir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Load reads the samples of every file in OutputDir, in either format, into
+// the in-memory database, with the collection runs of their run files. The
+// samples of a file without a run file are attributed to no run, and their
+// clusters are created by name, without a type.
+func (f *FileDB) Load(db *sql.DB) (int64, error) {
+	files, err := f.Files()
+	if err != nil {
+		return 0, err
+	}
+
+	clusterIDs := make(map[string]int64)
+	var batch []ArchiveSample
+	var loaded int64
+	flush := func() error {
+		n, err := f.ImportQueryResults(db, batch)
+		loaded += n
+		batch = batch[:0]
+		return err
+	}
+
+	for _, path := range files {
+		run, runID, err := f.loadRunFile(db, path)
+		if err != nil {
+			return loaded, err
+		}
+
+		err = readSampleFile(path, func(record sampleRecord) error {
+			clusterID, ok := clusterIDs[record.cluster]
+			if !ok {
+				id, err := f.GetOrCreateCluster(db, record.cluster, "")
+				if err != nil {
+					return err
+				}
+				clusterID, clusterIDs[record.cluster] = id, id
+			}
+
+			labels, err := json.Marshal(record.labels)
+			if err != nil {
+				return err
+			}
+			value := record.value
+			sample := ArchiveSample{
+				ClusterID:     clusterID,
+				KPIID:         record.kpiID,
+				Value:         &value,
+				Timestamp:     record.timestamp,
+				Labels:        labels,
+				ExecutionTime: record.executionTime,
+			}
+			if run != nil && record.cluster == run.Cluster {
+				sample.RunID = runID
+			}
+			batch = append(batch, sample)
+			if len(batch) == loadBatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return loaded, err
+		}
+	}
+	return loaded, flush()
+}
+
+// loadRunFile reads the run file of a sample file, if any, into the
+// in-memory database, and returns it with the ID of its run there
+func (f *FileDB) loadRunFile(db *sql.DB, path string) (*FileRun, int64, error) {
+	content, err := os.ReadFile(runFilePath(path))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var run FileRun
+	if err := json.Unmarshal(content, &run); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", runFilePath(path), err)
+	}
+	clusterID, err := f.GetOrCreateCluster(db, run.Cluster, run.ClusterType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The files of a run share it; its definitions are recorded once
+	runID, created, err := f.ImportCollectionRun(db, clusterID, run.Run)
+	if err != nil || !created {
+		return &run, runID, err
+	}
+	definitions := make([]KPIDefinition, len(run.Definitions))
+	for i, d := range run.Definitions {
+		definitions[i] = d.KPIDefinition()
+	}
+	return &run, runID, f.SQLiteDB.RecordKPIDefinitions(db, runID, definitions)
+}
+
+// writeRunFile writes the run file of a sample file
+func (f *FileDB) writeRunFile(path string) error {
+	content, err := json.MarshalIndent(f.run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(runFilePath(path), append(content, '\n'), 0644)
+}
+
+// runFilePath returns the path of the run file of a sample file
+func runFilePath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + runFileSuffix
+}
+
+func (f *FileDB) format() string {
+	if f.Format == "" {
+		return FileFormatJSONL
+	}
+	return f.Format
+}
+
+func (f *FileDB) maxFileSize() int64 {
+	if f.MaxFileSize <= 0 {
+		return DefaultMaxFileSize
+	}
+	return f.MaxFileSize
+}
+
+// clusterName returns the name of a cluster of the in-memory database
+func (f *FileDB) clusterName(db *sql.DB, clusterID int64) (string, error) {
+	if name, ok := f.clusters[clusterID]; ok {
+		return name, nil
+	}
+	var name string
+	if err := db.QueryRow("SELECT cluster_name FROM clusters WHERE id = ?", clusterID).Scan(&name); err != nil {
+		return "", fmt.Errorf("failed to look up cluster %d: %w", clusterID, err)
+	}
+	if f.clusters == nil {
+		f.clusters = make(map[int64]string)
+	}
+	f.clusters[clusterID] = name
+	return name, nil
+}
+
+// rotate closes the current sample file of a family and opens a new one,
+// starting with the TYPE of an OpenMetrics family
+func (f *FileDB) rotate(family string) (*sampleFile, error) {
+	if file := f.files[family]; file != nil {
+		delete(f.files, family)
+		if err := f.closeFile(file, family); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(OutputDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Files rotated within the same millisecond get a sequence number
+	stamp := sampleFilePrefix + time.Now().UTC().Format("20060102T150405.000Z")
+	var file *os.File
+	for seq := 0; file == nil; seq++ {
+		name := stamp
+		if seq > 0 {
+			name = fmt.Sprintf("%s-%d", stamp, seq)
+		}
+		if family != "" {
+			name += "-" + family
+		}
+		var err error
+		file, err = os.OpenFile(filepath.Join(OutputDir, name+sampleFileExtensions[f.format()]), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+	}
+
+	current := &sampleFile{File: file}
+	if family != "" {
+		n, err := fmt.Fprintf(file, "# TYPE %s gauge\n", family)
+		current.size = int64(n)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	if f.files == nil {
+		f.files = make(map[string]*sampleFile)
+	}
+	f.files[family] = current
+	return current, nil
+}
+
+// closeFile closes a sample file, ending the file of an OpenMetrics family
+// with the # EOF marker, and writes its run file
+func (f *FileDB) closeFile(file *sampleFile, family string) error {
+	if family != "" {
+		if _, err := file.WriteString("# EOF\n"); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	f.closed = append(f.closed, file.Name())
+	if f.run == nil {
+		return nil
+	}
+	return f.writeRunFile(file.Name())
+}
+
+// sampleRecord is a sample of a sample file, in either format
+type sampleRecord struct {
+	kpiID         string
+	cluster       string
+	labels        map[string]string
+	value         float64
+	timestamp     float64 // Unix seconds, as in query_results
+	executionTime time.Time
+}
+
+// appendJSONL renders a sample as a JSONL line
+func appendJSONL(buf *bytes.Buffer, record sampleRecord) error {
+	line, err := json.Marshal(FileSample{
+		KPIID:         record.kpiID,
+		Cluster:       record.cluster,
+		Labels:        record.labels,
+		Value:         record.value,
+		Timestamp:     unixTime(record.timestamp),
+		ExecutionTime: record.executionTime,
+	})
+	if err != nil {
+		return err
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// appendOpenMetrics renders a sample as a line of its OpenMetrics family,
+// named after the KPI. The labels of the query result are kept under their
+// ExportedLabelName. The execution time is not kept.
+func appendOpenMetrics(buf *bytes.Buffer, family string, record sampleRecord) {
+	labels := map[string]string{"kpi_id": record.kpiID, "cluster": record.cluster}
+	for name, value := range record.labels {
+		labels[ExportedLabelName(name)] = value
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf.WriteString(family)
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%s=\"%s\"", name, openMetricsEscaper.Replace(labels[name]))
+	}
+	fmt.Fprintf(buf, "} %s %s\n",
+		strconv.FormatFloat(record.value, 'g', -1, 64),
+		strconv.FormatFloat(record.timestamp, 'f', -1, 64))
+}
+
+// exportedPrefix starts the exported names of the labels of a query result
+// clashing with the labels of exported series
+const exportedPrefix = "exported_"
+
+// ExportedLabelName returns the name a label of a query result is kept under
+// in exported series, which have __name__, kpi_id and cluster labels of their
+// own. As Prometheus does for clashing target labels, those three get an
+// exported_ prefix, __name__ becoming exported_name; so do the labels already
+// starting with exported_, so that importedLabelName can reverse it.
+func ExportedLabelName(name string) string {
+	switch {
+	case name == model.MetricNameLabel:
+		return exportedPrefix + "name"
+	case name == "kpi_id", name == "cluster", strings.HasPrefix(name, exportedPrefix):
+		return exportedPrefix + name
+	}
+	return name
+}
+
+// importedLabelName returns the name of a label of a query result from its
+// ExportedLabelName
+func importedLabelName(name string) string {
+	original, ok := strings.CutPrefix(name, exportedPrefix)
+	switch {
+	case !ok:
+		return name
+	case original == "name":
+		return model.MetricNameLabel
+	}
+	return original
+}
+
+var (
+	openMetricsEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	openMetricsUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n")
+)
+
+// MetricName returns the name of the series of a KPI in exported formats:
+// the KPI ID made a valid metric name, with a prefix so that it cannot
+// collide with the metric it was computed from, e.g. node-cpu-usage is
+// kpi_collector_node_cpu_usage
+func MetricName(kpiID string) string {
+	var b strings.Builder
+	b.WriteString("kpi_collector_")
+	for _, r := range kpiID {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == ':' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// sampleFileFormat returns the format of a sample file from its extension,
+// or "" for other files
+func sampleFileFormat(name string) string {
+	for format, extension := range sampleFileExtensions {
+		if strings.HasSuffix(name, extension) {
+			return format
+		}
+	}
+	return ""
+}
+
+// readSampleFile calls fn with every sample of a sample file
+func readSampleFile(path string, fn func(sampleRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	format := sampleFileFormat(path)
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if text := strings.TrimSpace(line); text != "" && !strings.HasPrefix(text, "#") {
+			var record sampleRecord
+			var parseErr error
+			if format == FileFormatOpenMetrics {
+				record, parseErr = parseOpenMetrics(text)
+			} else {
+				record, parseErr = parseJSONL(text)
+			}
+			if parseErr != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNumber, parseErr)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parseJSONL parses a JSONL sample line
+func parseJSONL(line string) (sampleRecord, error) {
+	var sample FileSample
+	if err := json.Unmarshal([]byte(line), &sample); err != nil {
+		return sampleRecord{}, err
+	}
+	if sample.KPIID == "" || sample.Cluster == "" {
+		return sampleRecord{}, fmt.Errorf("sample without kpi_id or cluster")
+	}
+	return sampleRecord{
+		kpiID:         sample.KPIID,
+		cluster:       sample.Cluster,
+		labels:        sample.Labels,
+		value:         sample.Value,
+		timestamp:     float64(sample.Timestamp.UnixMilli()) / 1000,
+		executionTime: sample.ExecutionTime,
+	}, nil
+}
+
+// parseOpenMetrics parses an OpenMetrics sample line written by
+// appendOpenMetrics. The execution time is the sample time.
+func parseOpenMetrics(line string) (sampleRecord, error) {
+	open := strings.IndexByte(line, '{')
+	closing := strings.LastIndexByte(line, '}')
+	if open < 0 || closing < open {
+		return sampleRecord{}, fmt.Errorf("sample without labels")
+	}
+
+	labels := make(map[string]string)
+	rest := line[open+1 : closing]
+	for rest != "" {
+		eq := strings.Index(rest, `="`)
+		if eq < 0 {
+			return sampleRecord{}, fmt.Errorf("malformed labels")
+		}
+		name := rest[:eq]
+		rest = rest[eq+2:]
+
+		// The value ends at the first quote not escaped by a backslash
+		end := -1
+		for i := 0; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+			} else if rest[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return sampleRecord{}, fmt.Errorf("unterminated value of label %s", name)
+		}
+		labels[name] = openMetricsUnescaper.Replace(rest[:end])
+		rest = strings.TrimPrefix(rest[end+1:], ",")
+	}
+
+	fields := strings.Fields(line[closing+1:])
+	if len(fields) != 2 {
+		return sampleRecord{}, fmt.Errorf("sample without value and timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sampleRecord{}, fmt.Errorf("invalid value: %w", err)
+	}
+	timestamp, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return sampleRecord{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	record := sampleRecord{
+		kpiID:         labels["kpi_id"],
+		cluster:       labels["cluster"],
+		labels:        make(map[string]string),
+		value:         value,
+		timestamp:     timestamp,
+		executionTime: unixTime(timestamp),
+	}
+	if record.kpiID == "" || record.cluster == "" {
+		return sampleRecord{}, fmt.Errorf("sample without kpi_id or cluster label")
+	}
+	for name, value := range labels {
+		if name != "kpi_id" && name != "cluster" {
+			record.labels[importedLabelName(name)] = value
+		}
+	}
+	return record, nil
+}
+
+// unixTime converts Unix seconds to a UTC time, to the millisecond
+func unixTime(seconds float64) time.Time {
+	return time.UnixMilli(int64(math.Round(seconds * 1000))).UTC()
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+)
+
+var _ = Describe("FileDB", func() {
+	var (
+		fileDB    *FileDB
+		db        *sql.DB
+		clusterID int64
+	)
+
+	vector := model.Vector{
+		{Metric: model.Metric{"__name__": "node_cpu", "exported_name": "kube", "cpu": "0"}, Value: 0.5, Timestamp: 1700000000123},
+		{Metric: model.Metric{"cluster": "ha", "path": `C:\data "x"` + "\n"}, Value: 2, Timestamp: 1700000000123},
+	}
+	matrix := model.Matrix{
+		{Metric: model.Metric{"cpu": "1"}, Values: []model.SamplePair{{Timestamp: 1700000000000, Value: 1}, {Timestamp: 1700000060000, Value: 3}}},
+	}
+
+	// storedSamples returns the samples of a database as kpi_id|value|timestamp|labels
+	storedSamples := func(db *sql.DB) []string {
+		rows, err := db.Query("SELECT kpi_id, metric_value, timestamp_value, metric_labels FROM query_results ORDER BY kpi_id, timestamp_value, metric_labels")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = rows.Close() }()
+
+		var samples []string
+		for rows.Next() {
+			var kpiID, labels string
+			var value, timestamp float64
+			Expect(rows.Scan(&kpiID, &value, &timestamp, &labels)).To(Succeed())
+			samples = append(samples, strings.Join([]string{kpiID,
+				model.SampleValue(value).String(), model.Time(timestamp * 1000).String(), labels}, "|"))
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		return samples
+	}
+
+	// collect stores the results in a new file database and a SQLite one
+	collect := func(format string) *sql.DB {
+		fileDB = &FileDB{Format: format}
+		var err error
+		db, err = fileDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+		clusterID, err = fileDB.GetOrCreateCluster(db, "lab", "core")
+		Expect(err).NotTo(HaveOccurred())
+
+		sqliteDB := &SQLiteDB{Path: filepath.Join(GinkgoT().TempDir(), "kpi.db")}
+		reference, err := sqliteDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(reference.Close)
+		referenceID, err := sqliteDB.GetOrCreateCluster(reference, "lab", "core")
+		Expect(err).NotTo(HaveOccurred())
+
+		for kpiID, result := range map[string]model.Value{"cpu": vector, "cpu-range": matrix} {
+			Expect(fileDB.StoreQueryResults(db, clusterID, 0, kpiID, result)).To(Succeed())
+			Expect(sqliteDB.StoreQueryResults(reference, referenceID, 0, kpiID, result)).To(Succeed())
+		}
+		Expect(fileDB.FinishCollectionRun(db, 0, CollectionRunResult{})).To(Succeed())
+		return reference
+	}
+
+	// load reads the sample files into a new file database
+	load := func() *sql.DB {
+		reader := NewFileDB()
+		loaded, err := reader.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(loaded.Close)
+		_, err = reader.Load(loaded)
+		Expect(err).NotTo(HaveOccurred())
+		return loaded
+	}
+
+	BeforeEach(func() {
+		OutputDir = GinkgoT().TempDir()
+		DeferCleanup(func() { OutputDir = DefaultOutputDir })
+	})
+
+	DescribeTable("reading back the samples as stored in SQLite",
+		func(format string, extension string, fileCount int) {
+			reference := collect(format)
+
+			files, err := fileDB.Files()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(fileCount))
+			Expect(files).To(HaveEach(HaveSuffix(extension)))
+
+			// Samples are not stored in memory while collecting
+			var count int
+			Expect(db.QueryRow("SELECT COUNT(*) FROM query_results").Scan(&count)).To(Succeed())
+			Expect(count).To(BeZero())
+
+			loaded := load()
+			Expect(storedSamples(loaded)).To(Equal(storedSamples(reference)))
+			Expect(storedSamples(loaded)).To(HaveLen(4))
+
+			var clusterName string
+			Expect(loaded.QueryRow("SELECT cluster_name FROM clusters").Scan(&clusterName)).To(Succeed())
+			Expect(clusterName).To(Equal("lab"))
+		},
+		Entry("JSONL", FileFormatJSONL, ".jsonl", 1),
+		Entry("OpenMetrics, a file per KPI", FileFormatOpenMetrics, ".om", 2),
+	)
+
+	It("should write an OpenMetrics family named after the KPI per file, ended by # EOF", func() {
+		collect(FileFormatOpenMetrics)
+		files, err := fileDB.Files()
+		Expect(err).NotTo(HaveOccurred())
+
+		families := make(map[string][]string)
+		for _, path := range files {
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			Expect(lines[len(lines)-1]).To(Equal("# EOF"))
+			family := strings.TrimSuffix(strings.TrimPrefix(lines[0], "# TYPE "), " gauge")
+			Expect(path).To(HaveSuffix("-" + family + ".om"))
+			families[family] = lines[1 : len(lines)-1]
+		}
+
+		Expect(families).To(HaveLen(2))
+		Expect(families["kpi_collector_cpu"]).To(ConsistOf(
+			`kpi_collector_cpu{cluster="lab",cpu="0",exported_exported_name="kube",exported_name="node_cpu",kpi_id="cpu"} 0.5 1700000000.123`,
+			`kpi_collector_cpu{cluster="lab",exported_cluster="ha",kpi_id="cpu",path="C:\\data \"x\"\n"} 2 1700000000.123`,
+		))
+		Expect(families["kpi_collector_cpu_range"]).To(ConsistOf(
+			`kpi_collector_cpu_range{cluster="lab",cpu="1",kpi_id="cpu-range"} 1 1700000000`,
+			`kpi_collector_cpu_range{cluster="lab",cpu="1",kpi_id="cpu-range"} 3 1700000060`,
+		))
+	})
+
+	It("should rotate full files and skip the samples loaded twice", func() {
+		fileDB = &FileDB{MaxFileSize: 1}
+		var err error
+		db, err = fileDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+		clusterID, err = fileDB.GetOrCreateCluster(db, "lab", "core")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fileDB.StoreQueryResults(db, clusterID, 0, "cpu", vector)).To(Succeed())
+		Expect(fileDB.StoreQueryResults(db, clusterID, 0, "cpu-range", matrix)).To(Succeed())
+		Expect(fileDB.FinishCollectionRun(db, 0, CollectionRunResult{})).To(Succeed())
+
+		files, err := fileDB.Files()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+
+		// A copy of a file, e.g. gathered twice from a lab, adds no sample
+		content, err := os.ReadFile(files[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(OutputDir, "kpi_samples-copy.jsonl"), content, 0644)).To(Succeed())
+
+		Expect(storedSamples(load())).To(HaveLen(4))
+	})
+
+	It("should read back the collection run and KPI definitions of the run files", func() {
+		fileDB = &FileDB{MaxFileSize: 1}
+		var err error
+		db, err = fileDB.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+		clusterID, err = fileDB.GetOrCreateCluster(db, "lab", "core")
+		Expect(err).NotTo(HaveOccurred())
+
+		runID, err := fileDB.StartCollectionRun(db, clusterID, CollectionRun{Frequency: time.Minute, ToolVersion: "v1.2.0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileDB.RecordKPIDefinitions(db, runID, []KPIDefinition{
+			{KPIID: "cpu", PromQuery: "node_cpu", ResolvedPromQuery: "node_cpu", QueryType: "instant", Frequency: time.Minute},
+		})).To(Succeed())
+		Expect(fileDB.StoreQueryResults(db, clusterID, runID, "cpu", vector)).To(Succeed())
+		Expect(fileDB.StoreQueryResults(db, clusterID, runID, "cpu", vector)).To(Succeed())
+		Expect(fileDB.FinishCollectionRun(db, runID, CollectionRunResult{ShutdownReason: "completed", FailedQueries: 2})).To(Succeed())
+
+		// Both files of the run have a run file, with the end of the run
+		files, err := fileDB.Files()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		for _, path := range files {
+			Expect(strings.TrimSuffix(path, ".jsonl") + ".run.json").To(BeAnExistingFile())
+		}
+
+		loaded := load()
+		var clusterType, shutdownReason, toolVersion string
+		var failedQueries int
+		var frequency float64
+		Expect(loaded.QueryRow(`
+			SELECT c.cluster_type, r.shutdown_reason, r.tool_version, r.failed_queries, r.frequency_seconds
+			FROM collection_runs r JOIN clusters c ON c.id = r.cluster_id WHERE r.ended_at IS NOT NULL`).
+			Scan(&clusterType, &shutdownReason, &toolVersion, &failedQueries, &frequency)).To(Succeed())
+		Expect([]interface{}{clusterType, shutdownReason, toolVersion, failedQueries, frequency}).
+			To(Equal([]interface{}{"core", "completed", "v1.2.0", 2, 60.0}))
+
+		var runs, samplesOfRun, definitions int
+		Expect(loaded.QueryRow("SELECT COUNT(*) FROM collection_runs").Scan(&runs)).To(Succeed())
+		Expect(loaded.QueryRow("SELECT COUNT(*) FROM query_results WHERE run_id IS NOT NULL").Scan(&samplesOfRun)).To(Succeed())
+		Expect(loaded.QueryRow("SELECT COUNT(*) FROM collection_run_definitions").Scan(&definitions)).To(Succeed())
+		Expect([]int{runs, samplesOfRun, definitions}).To(Equal([]int{1, 2, 1}))
+	})
+
+	It("should report the file and line of a malformed sample", func() {
+		path := filepath.Join(OutputDir, "kpi_samples-broken.jsonl")
+		Expect(os.WriteFile(path, []byte(`{"kpi_id":"cpu","cluster":"lab","value":1}`+"\n{\n"), 0644)).To(Succeed())
+
+		reader := NewFileDB()
+		loaded, err := reader.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(loaded.Close)
+		_, err = reader.Load(loaded)
+		Expect(err).To(MatchError(ContainSubstring(path + ":2:")))
+	})
+})
@@ -6,25 +6,132 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/prometheus/common/model"
 )
 
+// OverrunEvent describes a sample that ran longer than its sampling frequency,
+// causing one or more scheduled ticks to elapse before it completed.
+type OverrunEvent struct {
+	Frequency    time.Duration
+	SampleNumber int
+	RunDuration  time.Duration
+	MissedTicks  int
+	Policy       string
+}
+
+// QueryErrorEvent describes a single failed query attempt
+type QueryErrorEvent struct {
+	KPIID      string
+	QueryType  string
+	PromQuery  string // PromQL as sent to Prometheus, after placeholder substitution
+	HTTPStatus int    // HTTP status of the response, 0 if the request never got one
+	Message    string
+	Warnings   []string // Prometheus warnings returned with the failure
+	Attempt    int      // 1-based attempt number within the sample
+	Retried    bool     // whether another attempt followed this failure
+}
+
+// CollectionRun describes one invocation of 'kpi-collector run'
+type CollectionRun struct {
+	Frequency    time.Duration // default sampling frequency, 0 in single-run mode
+	Duration     time.Duration // planned collection duration, 0 in single-run mode
+	KPIsFileHash string        // SHA-256 of the KPIs file the run was started with
+	ToolVersion  string        // kpi-collector version that performed the run
+}
+
+// CollectionRunResult describes how a collection run ended
+type CollectionRunResult struct {
+	ShutdownReason string
+	FailedQueries  int64 // queries that failed after all retries
+	Retries        int64 // transient failures that were retried
+}
+
+// KPIDefinition describes what a KPI measured during a collection run
+type KPIDefinition struct {
+	KPIID             string
+	PromQuery         string // PromQL as written in the KPIs file
+	ResolvedPromQuery string // PromQL after placeholder substitution
+	QueryType         string
+	RangeStep         string // empty for instant queries
+	RangeSince        string
+	RangeUntil        string
+	Frequency         time.Duration // 0 for run-once KPIs and single runs
+	RunOnce           bool
+}
+
 // Database defines the interface that all database implementations must satisfy
 type Database interface {
-	// InitDB initializes the database and creates required tables
+	// InitDB initializes the database and migrates it to the latest schema.
+	// It fails with ErrSchemaTooNew for a database migrated by a newer version.
 	InitDB() (*sql.DB, error)
 
+	// Connect opens the database without creating or migrating the schema
+	Connect() (*sql.DB, error)
+
+	// SchemaVersion returns the latest migration applied to the database,
+	// 0 for a database without migration history
+	SchemaVersion(db *sql.DB) (int, error)
+
+	// Migrations returns the ordered schema migrations of the backend
+	Migrations() []Migration
+
 	// GetOrCreateCluster gets existing cluster ID or creates a new cluster record
 	GetOrCreateCluster(db *sql.DB, clusterName string, clusterType string) (int64, error)
 
-	// IncrementQueryError increments the error count for a given KPI ID
-	IncrementQueryError(db *sql.DB, kpiID string) error
+	// IncrementQueryError increments the error count for a given KPI ID on a cluster
+	IncrementQueryError(db *sql.DB, clusterID int64, kpiID string) error
 
-	// GetQueryErrorCount returns the error count for a given KPI ID
-	GetQueryErrorCount(db *sql.DB, kpiID string) (int, error)
+	// GetQueryErrorCount returns the error count for a given KPI ID on a cluster
+	GetQueryErrorCount(db *sql.DB, clusterID int64, kpiID string) (int, error)
+
+	// IncrementQueryRetry increments the count of transient failures that were retried for a given KPI ID on a cluster
+	IncrementQueryRetry(db *sql.DB, clusterID int64, kpiID string) error
+
+	// GetQueryRetryCount returns the retry count for a given KPI ID on a cluster
+	GetQueryRetryCount(db *sql.DB, clusterID int64, kpiID string) (int, error)
 
 	// StoreQueryResults stores the results of a Prometheus query in the database.
 	// Supports model.Vector (from instant queries) and model.Matrix (from range queries).
-	StoreQueryResults(db *sql.DB, clusterID int64, queryID string, result model.Value) error
+	// Samples are attributed to the collection run runID, or to no run when it is 0.
+	StoreQueryResults(db *sql.DB, clusterID int64, runID int64, queryID string, result model.Value) error
+
+	// StartCollectionRun records the start of a collection run on a cluster and returns its ID
+	StartCollectionRun(db *sql.DB, clusterID int64, run CollectionRun) (int64, error)
+
+	// FinishCollectionRun records the end of a collection run
+	FinishCollectionRun(db *sql.DB, runID int64, result CollectionRunResult) error
+
+	// RecordKPIDefinitions stores the KPI definitions a collection run was started
+	// with. Identical definitions are stored once and shared between runs.
+	RecordKPIDefinitions(db *sql.DB, runID int64, definitions []KPIDefinition) error
+
+	// PruneQueryResults deletes old raw samples from query_results, rolling
+	// them up into query_rollups buckets first when the policy downsamples
+	PruneQueryResults(db *sql.DB, policy RetentionPolicy) (PruneResult, error)
+
+	// RecordOverrun stores a sampling tick overrun detected for a cluster
+	RecordOverrun(db *sql.DB, clusterID int64, event OverrunEvent) error
+
+	// RecordQueryError stores the details of a failed query attempt for a cluster
+	RecordQueryError(db *sql.DB, clusterID int64, event QueryErrorEvent) error
+
+	// RecordAlert stores a threshold alert raised during the collection run
+	// runID, or outside of any run when it is 0
+	RecordAlert(db *sql.DB, clusterID int64, runID int64, event AlertEvent) error
+
+	// ImportCollectionRun stores an archived collection run on a cluster and
+	// returns its ID, or returns the ID of the cluster's run started at the
+	// same time, and false, when it was already imported
+	ImportCollectionRun(db *sql.DB, clusterID int64, run ArchiveRun) (int64, bool, error)
+
+	// ImportQueryResults stores archived samples whose cluster and run IDs are
+	// those of this database, skipping the samples already stored, and
+	// returns the number of samples inserted
+	ImportQueryResults(db *sql.DB, samples []ArchiveSample) (int64, error)
+
+	// AddQueryCounts adds error and retry counts of a KPI on a cluster, e.g.
+	// the counts of another database being merged
+	AddQueryCounts(db *sql.DB, clusterID int64, kpiID string, errors, retries int64) error
 }
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Hash returns the content hash that versions a KPI definition. A definition
+// changes whenever anything that affects the measured values changes.
+func (d KPIDefinition) Hash() string {
+	content, _ := json.Marshal(d)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordKPIDefinitions stores the definitions that are not yet known and links
+// every definition to the collection run, in a single transaction
+func recordKPIDefinitions(db *sql.DB, runID int64, definitions []KPIDefinition, placeholder placeholderFunc) error {
+	insertDefinition := fmt.Sprintf(`
+        INSERT INTO kpi_definitions
+        (kpi_id, definition_hash, promquery, resolved_promquery, query_type,
+         range_step, range_since, range_until, frequency_seconds, run_once)
+        VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s)
+        ON CONFLICT (kpi_id, definition_hash) DO NOTHING`,
+		placeholder(1), placeholder(2), placeholder(3), placeholder(4), placeholder(5),
+		placeholder(6), placeholder(7), placeholder(8), placeholder(9), placeholder(10))
+	selectDefinition := fmt.Sprintf("SELECT id FROM kpi_definitions WHERE kpi_id = %s AND definition_hash = %s",
+		placeholder(1), placeholder(2))
+	linkDefinition := fmt.Sprintf(`
+        INSERT INTO collection_run_definitions (run_id, definition_id) VALUES (%s, %s)
+        ON CONFLICT (run_id, definition_id) DO NOTHING`,
+		placeholder(1), placeholder(2))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, definition := range definitions {
+		hash := definition.Hash()
+		_, err := tx.Exec(insertDefinition,
+			definition.KPIID, hash, definition.PromQuery, definition.ResolvedPromQuery, definition.QueryType,
+			nullString(definition.RangeStep), nullString(definition.RangeSince), nullString(definition.RangeUntil),
+			definition.Frequency.Seconds(), definition.RunOnce)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to store definition of KPI '%s': %w", definition.KPIID, err)
+		}
+
+		var definitionID int64
+		if err := tx.QueryRow(selectDefinition, definition.KPIID, hash).Scan(&definitionID); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to look up definition of KPI '%s': %w", definition.KPIID, err)
+		}
+
+		if _, err := tx.Exec(linkDefinition, runID, definitionID); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to link definition of KPI '%s' to run %d: %w", definition.KPIID, runID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaTooNew is returned when a database was migrated by a newer
+// kpi-collector than the running one. Writing to it could corrupt data the
+// newer version relies on, so it is refused.
+var ErrSchemaTooNew = errors.New("database schema is newer than this kpi-collector supports")
+
+// Migration is a single versioned schema change. The migrations of a backend
+// are applied in order of Version, each in its own transaction together with
+// its record in the schema_migrations table.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// execStatements returns a migration step that executes the statements in order
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// LatestVersion returns the version of the last migration known for the backend
+func LatestVersion(dbImpl Database) int {
+	migrations := dbImpl.Migrations()
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// PendingMigrations returns the current schema version of the database and the
+// migrations needed to bring it to the target version. Databases created before
+// versioned migrations existed report version 0; all migrations are written so
+// that they also apply cleanly on top of those schemas.
+func PendingMigrations(db *sql.DB, dbImpl Database, target int) (int, []Migration, error) {
+	current, err := dbImpl.SchemaVersion(db)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	latest := LatestVersion(dbImpl)
+	if current > latest {
+		return current, nil, fmt.Errorf("%w: database is at version %d, the latest known version is %d; upgrade kpi-collector",
+			ErrSchemaTooNew, current, latest)
+	}
+	if target < 0 || target > latest {
+		return current, nil, fmt.Errorf("unknown schema version %d (latest is %d)", target, latest)
+	}
+	if target < current {
+		return current, nil, fmt.Errorf("database is already at version %d; downgrading to version %d is not supported", current, target)
+	}
+
+	var pending []Migration
+	for _, migration := range dbImpl.Migrations() {
+		if migration.Version > current && migration.Version <= target {
+			pending = append(pending, migration)
+		}
+	}
+	return current, pending, nil
+}
+
+// Migrate applies the pending migrations up to the target version and returns
+// the migrations that were applied. It refuses databases with a newer schema.
+func Migrate(db *sql.DB, dbImpl Database, target int) ([]Migration, error) {
+	_, pending, err := PendingMigrations(db, dbImpl, target)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            description TEXT NOT NULL,
+            applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		if err := applyMigration(db, migration); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// applyMigration runs one migration and records it in a single transaction
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := migration.Up(tx); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, description) VALUES ($1, $2)",
+		migration.Version, migration.Description)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+
+	return tx.Commit()
+}
+
+// maxAppliedVersion returns the highest version recorded in schema_migrations
+func maxAppliedVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// clusterScopedCounters are the per-KPI counter tables keyed by (cluster_id, kpi_id)
+var clusterScopedCounters = []struct {
+	table  string
+	column string
+}{
+	{table: "query_errors", column: "errors"},
+	{table: "query_retries", column: "retries"},
+}
+
+// legacyCounterCluster selects the cluster that counts recorded before they
+// were keyed by cluster are attributed to: the only cluster of the database,
+// or NULL when the database holds several clusters and the origin is unknown.
+const legacyCounterCluster = "(SELECT MIN(id) FROM clusters HAVING COUNT(*) = 1)"
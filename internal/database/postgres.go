@@ -2,7 +2,6 @@ package database
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 
 	_ "github.com/lib/pq"
@@ -12,6 +11,9 @@ import (
 // PostgresDB implements the Database interface for PostgreSQL
 type PostgresDB struct {
 	ConnectionURL string
+
+	// InsertBatchSize is the number of samples written per INSERT statement (0 = default)
+	InsertBatchSize int
 }
 
 // NewPostgresDB creates a new PostgreSQL database instance
@@ -19,8 +21,8 @@ func NewPostgresDB(connectionURL string) *PostgresDB {
 	return &PostgresDB{ConnectionURL: connectionURL}
 }
 
-// InitDB initializes the PostgreSQL database and creates required tables
-func (p *PostgresDB) InitDB() (*sql.DB, error) {
+// Connect opens the PostgreSQL connection without creating or migrating the schema
+func (p *PostgresDB) Connect() (*sql.DB, error) {
 	db, err := sql.Open("postgres", p.ConnectionURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
@@ -31,44 +33,39 @@ func (p *PostgresDB) InitDB() (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to ping postgres: %v", err)
 	}
 
-	schema := `
-    CREATE TABLE IF NOT EXISTS clusters (
-        id SERIAL PRIMARY KEY,
-        cluster_name TEXT UNIQUE NOT NULL,
-		cluster_type TEXT,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );
-    
-    CREATE TABLE IF NOT EXISTS query_results (
-        id SERIAL PRIMARY KEY,
-        kpi_id TEXT NOT NULL,
-        metric_value DOUBLE PRECISION,
-        timestamp_value DOUBLE PRECISION,
-        cluster_id INTEGER NOT NULL REFERENCES clusters(id),
-        execution_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        metric_labels JSONB
-    );
-
-    CREATE TABLE IF NOT EXISTS query_errors (
-        id SERIAL PRIMARY KEY,
-        kpi_id TEXT UNIQUE NOT NULL,
-        errors INTEGER DEFAULT 0
-    );
-
-    -- Create indexes for better query performance
-    CREATE INDEX IF NOT EXISTS idx_query_results_cluster_id ON query_results(cluster_id);
-    CREATE INDEX IF NOT EXISTS idx_query_results_kpi_id ON query_results(kpi_id);
-    CREATE INDEX IF NOT EXISTS idx_query_results_created_at ON query_results(created_at);
-    CREATE INDEX IF NOT EXISTS idx_query_results_labels ON query_results USING GIN(metric_labels);
-
-    -- Prevent duplicate data points from overlapping range query windows
-    CREATE UNIQUE INDEX IF NOT EXISTS idx_query_results_dedup
-    ON query_results(kpi_id, cluster_id, timestamp_value, metric_labels);
-    `
-
-	_, err = db.Exec(schema)
-	return db, err
+	return db, nil
+}
+
+// InitDB initializes the PostgreSQL database and migrates it to the latest schema
+func (p *PostgresDB) InitDB() (*sql.DB, error) {
+	db, err := p.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := Migrate(db, p, LatestVersion(p)); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// SchemaVersion returns the latest migration applied to the database
+func (p *PostgresDB) SchemaVersion(db *sql.DB) (int, error) {
+	var tables int
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM information_schema.tables
+        WHERE table_schema = current_schema() AND table_name = 'schema_migrations'`).Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+	return maxAppliedVersion(db)
+}
+
+// Migrations returns the ordered schema migrations for PostgreSQL
+func (p *PostgresDB) Migrations() []Migration {
+	return postgresMigrations
 }
 
 // GetOrCreateCluster gets existing cluster ID or creates a new cluster record
@@ -96,86 +93,164 @@ func (p *PostgresDB) GetOrCreateCluster(db *sql.DB, clusterName string, clusterT
 	return clusterID, err
 }
 
-// IncrementQueryError increments the error count for a given KPI ID
-func (p *PostgresDB) IncrementQueryError(db *sql.DB, kpiID string) error {
+// IncrementQueryError increments the error count for a given KPI ID on a cluster
+func (p *PostgresDB) IncrementQueryError(db *sql.DB, clusterID int64, kpiID string) error {
 	_, err := db.Exec(`
-        INSERT INTO query_errors (kpi_id, errors) VALUES ($1, 1)
-        ON CONFLICT(kpi_id) DO UPDATE SET errors = query_errors.errors + 1
-    `, kpiID)
+        INSERT INTO query_errors (cluster_id, kpi_id, errors) VALUES ($1, $2, 1)
+        ON CONFLICT(cluster_id, kpi_id) DO UPDATE SET errors = query_errors.errors + 1
+    `, clusterID, kpiID)
 	return err
 }
 
-// GetQueryErrorCount returns the error count for a given KPI ID
-func (p *PostgresDB) GetQueryErrorCount(db *sql.DB, kpiID string) (int, error) {
+// GetQueryErrorCount returns the error count for a given KPI ID on a cluster
+func (p *PostgresDB) GetQueryErrorCount(db *sql.DB, clusterID int64, kpiID string) (int, error) {
 	var count int
-	err := db.QueryRow("SELECT errors FROM query_errors WHERE kpi_id = $1", kpiID).Scan(&count)
+	err := db.QueryRow("SELECT errors FROM query_errors WHERE cluster_id = $1 AND kpi_id = $2", clusterID, kpiID).Scan(&count)
 	if err == sql.ErrNoRows {
 		return 0, nil
 	}
 	return count, err
 }
 
-// StoreQueryResults stores the results of a Prometheus query in the database
-func (p *PostgresDB) StoreQueryResults(db *sql.DB, clusterID int64, queryID string, result model.Value) error {
-	switch values := result.(type) {
-	case model.Vector:
-		return p.storeVectorResults(db, clusterID, queryID, values)
-	case model.Matrix:
-		return p.storeMatrixResults(db, clusterID, queryID, values)
-	default:
-		return fmt.Errorf("unsupported Prometheus result type for KPI '%s': %T", queryID, result)
+// IncrementQueryRetry increments the retry count for a given KPI ID on a cluster
+func (p *PostgresDB) IncrementQueryRetry(db *sql.DB, clusterID int64, kpiID string) error {
+	_, err := db.Exec(`
+        INSERT INTO query_retries (cluster_id, kpi_id, retries) VALUES ($1, $2, 1)
+        ON CONFLICT(cluster_id, kpi_id) DO UPDATE SET retries = query_retries.retries + 1
+    `, clusterID, kpiID)
+	return err
+}
+
+// GetQueryRetryCount returns the retry count for a given KPI ID on a cluster
+func (p *PostgresDB) GetQueryRetryCount(db *sql.DB, clusterID int64, kpiID string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT retries FROM query_retries WHERE cluster_id = $1 AND kpi_id = $2", clusterID, kpiID).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
 	}
+	return count, err
 }
 
-func (p *PostgresDB) storeVectorResults(db *sql.DB, clusterID int64, queryID string, vector model.Vector) error {
-	for _, sample := range vector {
-		metric := sample.Metric
-		value := float64(sample.Value)
-		timestamp := float64(sample.Timestamp) / 1000
+// StoreQueryResults stores the results of a Prometheus query in the database.
+// All samples of the result are written in one transaction using batched
+// multi-row inserts, so a failure leaves no partial result behind.
+func (p *PostgresDB) StoreQueryResults(db *sql.DB, clusterID int64, runID int64, queryID string, result model.Value) error {
+	rows, err := flattenResult(queryID, result)
+	if err != nil {
+		return err
+	}
 
-		labelsJSON, err := json.Marshal(metric)
-		if err != nil {
-			return err
-		}
+	return insertResultRows(db, clusterID, runID, queryID, rows, effectiveBatchSize(p.InsertBatchSize, postgresMaxVariables), dollarPlaceholder)
+}
 
-		_, err = db.Exec(`
-            INSERT INTO query_results 
-            (kpi_id, metric_value, timestamp_value, cluster_id, metric_labels)
-            VALUES ($1, $2, $3, $4, $5)
-            ON CONFLICT (kpi_id, cluster_id, timestamp_value, metric_labels) DO NOTHING`,
-			queryID, value, timestamp, clusterID, string(labelsJSON),
-		)
-		if err != nil {
-			return err
-		}
+// RecordOverrun stores a sampling tick overrun in the collection_overruns table
+func (p *PostgresDB) RecordOverrun(db *sql.DB, clusterID int64, event OverrunEvent) error {
+	_, err := db.Exec(`
+        INSERT INTO collection_overruns
+        (cluster_id, frequency_seconds, sample_number, run_duration_seconds, missed_ticks, policy)
+        VALUES ($1, $2, $3, $4, $5, $6)`,
+		clusterID, event.Frequency.Seconds(), event.SampleNumber, event.RunDuration.Seconds(), event.MissedTicks, event.Policy,
+	)
+	return err
+}
+
+// RecordQueryError stores a failed query attempt in the query_error_events table
+func (p *PostgresDB) RecordQueryError(db *sql.DB, clusterID int64, event QueryErrorEvent) error {
+	warnings, err := warningsJSON(event.Warnings)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	_, err = db.Exec(`
+        INSERT INTO query_error_events
+        (cluster_id, kpi_id, query_type, promquery, http_status, error_message, warnings, attempt, retried)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		clusterID, event.KPIID, event.QueryType, event.PromQuery, httpStatusValue(event.HTTPStatus),
+		event.Message, warnings, event.Attempt, event.Retried,
+	)
+	return err
 }
 
-func (p *PostgresDB) storeMatrixResults(db *sql.DB, clusterID int64, queryID string, matrix model.Matrix) error {
-	for _, stream := range matrix {
-		metric := stream.Metric
-		labelsJSON, err := json.Marshal(metric)
-		if err != nil {
-			return err
-		}
+// StartCollectionRun inserts a collection_runs row for the cluster and returns its ID
+func (p *PostgresDB) StartCollectionRun(db *sql.DB, clusterID int64, run CollectionRun) (int64, error) {
+	var runID int64
+	err := db.QueryRow(`
+        INSERT INTO collection_runs (cluster_id, frequency_seconds, duration_seconds, kpis_file_hash, tool_version)
+        VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		clusterID, run.Frequency.Seconds(), run.Duration.Seconds(), run.KPIsFileHash, run.ToolVersion,
+	).Scan(&runID)
+	return runID, err
+}
 
-		for _, samplePair := range stream.Values {
-			value := float64(samplePair.Value)
-			timestamp := float64(samplePair.Timestamp) / 1000
-
-			_, execErr := db.Exec(`
-                INSERT INTO query_results 
-                (kpi_id, metric_value, timestamp_value, cluster_id, metric_labels)
-                VALUES ($1, $2, $3, $4, $5)
-                ON CONFLICT (kpi_id, cluster_id, timestamp_value, metric_labels) DO NOTHING`,
-				queryID, value, timestamp, clusterID, string(labelsJSON),
-			)
-			if execErr != nil {
-				return execErr
-			}
-		}
+// FinishCollectionRun stores the end time, shutdown reason and failure counts of a collection run
+func (p *PostgresDB) FinishCollectionRun(db *sql.DB, runID int64, result CollectionRunResult) error {
+	_, err := db.Exec(`
+        UPDATE collection_runs
+        SET ended_at = CURRENT_TIMESTAMP, shutdown_reason = $1, failed_queries = $2, retries = $3
+        WHERE id = $4`,
+		result.ShutdownReason, result.FailedQueries, result.Retries, runID,
+	)
+	return err
+}
+
+// RecordKPIDefinitions stores the KPI definitions of a collection run in kpi_definitions
+func (p *PostgresDB) RecordKPIDefinitions(db *sql.DB, runID int64, definitions []KPIDefinition) error {
+	return recordKPIDefinitions(db, runID, definitions, dollarPlaceholder)
+}
+
+// postgresRollupQuery rolls raw samples up into query_rollups, merging them
+// into buckets already rolled up by an earlier prune
+const postgresRollupQuery = `
+        INSERT INTO query_rollups
+        (cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds, min_value, max_value, avg_value, sample_count)
+        SELECT cluster_id, kpi_id, metric_labels, FLOOR(timestamp_value / %[1]d) * %[1]d, %[1]d,
+               MIN(metric_value), MAX(metric_value), AVG(metric_value), COUNT(metric_value)
+        FROM query_results %[2]s
+        GROUP BY cluster_id, kpi_id, metric_labels, FLOOR(timestamp_value / %[1]d)
+        ON CONFLICT(cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds) DO UPDATE SET
+            min_value = LEAST(query_rollups.min_value, EXCLUDED.min_value),
+            max_value = GREATEST(query_rollups.max_value, EXCLUDED.max_value),
+            avg_value = CASE
+                WHEN EXCLUDED.sample_count = 0 THEN query_rollups.avg_value
+                WHEN query_rollups.sample_count = 0 THEN EXCLUDED.avg_value
+                ELSE (query_rollups.avg_value * query_rollups.sample_count + EXCLUDED.avg_value * EXCLUDED.sample_count)
+                     / (query_rollups.sample_count + EXCLUDED.sample_count)
+            END,
+            sample_count = query_rollups.sample_count + EXCLUDED.sample_count`
+
+// PruneQueryResults deletes old raw samples, rolling them up into query_rollups first when downsampling
+func (p *PostgresDB) PruneQueryResults(db *sql.DB, policy RetentionPolicy) (PruneResult, error) {
+	return pruneQueryResults(db, policy, postgresRollupQuery, dollarPlaceholder)
+}
+
+// RecordAlert stores a threshold alert in the alerts table
+func (p *PostgresDB) RecordAlert(db *sql.DB, clusterID int64, runID int64, event AlertEvent) error {
+	run, labels, err := alertRow(runID, event)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	_, err = db.Exec(`
+        INSERT INTO alerts
+        (cluster_id, run_id, kpi_id, metric_labels, threshold, limit_value, metric_value, sample_timestamp, state)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		clusterID, run, event.KPIID, labels, event.Threshold, event.Limit, event.Value,
+		float64(event.SampleTime.UnixMilli())/1000, event.State,
+	)
+	return err
+}
+
+// ImportCollectionRun stores an archived collection run unless it was already imported
+func (p *PostgresDB) ImportCollectionRun(db *sql.DB, clusterID int64, run ArchiveRun) (int64, bool, error) {
+	return importCollectionRun(db, clusterID, run, dollarPlaceholder, postgresTimestamp)
+}
+
+// ImportQueryResults stores archived samples in query_results, skipping duplicates
+func (p *PostgresDB) ImportQueryResults(db *sql.DB, samples []ArchiveSample) (int64, error) {
+	return importQueryResults(db, samples, dollarPlaceholder, postgresTimestamp, postgresMaxVariables)
+}
+
+// AddQueryCounts adds error and retry counts of a KPI on a cluster
+func (p *PostgresDB) AddQueryCounts(db *sql.DB, clusterID int64, kpiID string, errors, retries int64) error {
+	return addQueryCounts(db, clusterID, kpiID, errors, retries, dollarPlaceholder)
 }
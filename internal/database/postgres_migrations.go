@@ -0,0 +1,234 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// postgresMigrations are the schema migrations for PostgreSQL, in version order.
+// Never edit a released migration; append a new one instead.
+var postgresMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create clusters, query_results and query_errors",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS clusters (
+                id SERIAL PRIMARY KEY,
+                cluster_name TEXT UNIQUE NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`, `
+            CREATE TABLE IF NOT EXISTS query_results (
+                id SERIAL PRIMARY KEY,
+                kpi_id TEXT NOT NULL,
+                metric_value DOUBLE PRECISION,
+                timestamp_value DOUBLE PRECISION,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                execution_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                metric_labels JSONB
+            )`, `
+            CREATE TABLE IF NOT EXISTS query_errors (
+                id SERIAL PRIMARY KEY,
+                kpi_id TEXT UNIQUE NOT NULL,
+                errors INTEGER DEFAULT 0
+            )`,
+			// Indexes for better query performance
+			"CREATE INDEX IF NOT EXISTS idx_query_results_cluster_id ON query_results(cluster_id)",
+			"CREATE INDEX IF NOT EXISTS idx_query_results_kpi_id ON query_results(kpi_id)",
+			"CREATE INDEX IF NOT EXISTS idx_query_results_created_at ON query_results(created_at)",
+			"CREATE INDEX IF NOT EXISTS idx_query_results_labels ON query_results USING GIN(metric_labels)",
+			// Prevent duplicate data points from overlapping range query windows
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_query_results_dedup
+            ON query_results(kpi_id, cluster_id, timestamp_value, metric_labels)`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add cluster_type to clusters",
+		Up:          postgresAddColumn("clusters", "cluster_type", "TEXT"),
+	},
+	{
+		Version:     3,
+		Description: "create query_retries, collection_overruns and query_error_events",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS query_retries (
+                id SERIAL PRIMARY KEY,
+                kpi_id TEXT UNIQUE NOT NULL,
+                retries INTEGER DEFAULT 0
+            )`, `
+            CREATE TABLE IF NOT EXISTS collection_overruns (
+                id SERIAL PRIMARY KEY,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                frequency_seconds DOUBLE PRECISION NOT NULL,
+                sample_number INTEGER NOT NULL,
+                run_duration_seconds DOUBLE PRECISION NOT NULL,
+                missed_ticks INTEGER NOT NULL,
+                policy TEXT NOT NULL,
+                occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`, `
+            CREATE TABLE IF NOT EXISTS query_error_events (
+                id SERIAL PRIMARY KEY,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                kpi_id TEXT NOT NULL,
+                query_type TEXT NOT NULL,
+                promquery TEXT NOT NULL,
+                http_status INTEGER,
+                error_message TEXT NOT NULL,
+                warnings JSONB,
+                attempt INTEGER NOT NULL,
+                retried BOOLEAN NOT NULL,
+                occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+			"CREATE INDEX IF NOT EXISTS idx_query_error_events_occurred_at ON query_error_events(occurred_at)",
+		),
+	},
+	{
+		Version:     4,
+		Description: "key query error and retry counts by cluster",
+		Up:          postgresScopeCountersByCluster,
+	},
+	{
+		Version:     5,
+		Description: "create collection_runs and add run_id to query_results",
+		Up: func(tx *sql.Tx) error {
+			err := execStatements(`
+            CREATE TABLE IF NOT EXISTS collection_runs (
+                id SERIAL PRIMARY KEY,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                ended_at TIMESTAMP,
+                frequency_seconds DOUBLE PRECISION NOT NULL,
+                duration_seconds DOUBLE PRECISION NOT NULL,
+                kpis_file_hash TEXT,
+                tool_version TEXT,
+                shutdown_reason TEXT,
+                failed_queries INTEGER NOT NULL DEFAULT 0,
+                retries INTEGER NOT NULL DEFAULT 0
+            )`)(tx)
+			if err != nil {
+				return err
+			}
+			if err := postgresAddColumn("query_results", "run_id", "INTEGER REFERENCES collection_runs(id)")(tx); err != nil {
+				return err
+			}
+			return execStatements("CREATE INDEX IF NOT EXISTS idx_query_results_run_id ON query_results(run_id)")(tx)
+		},
+	},
+	{
+		Version:     6,
+		Description: "create kpi_definitions and collection_run_definitions",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS kpi_definitions (
+                id SERIAL PRIMARY KEY,
+                kpi_id TEXT NOT NULL,
+                definition_hash TEXT NOT NULL,
+                promquery TEXT NOT NULL,
+                resolved_promquery TEXT NOT NULL,
+                query_type TEXT NOT NULL,
+                range_step TEXT,
+                range_since TEXT,
+                range_until TEXT,
+                frequency_seconds DOUBLE PRECISION NOT NULL,
+                run_once BOOLEAN NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                UNIQUE(kpi_id, definition_hash)
+            )`, `
+            CREATE TABLE IF NOT EXISTS collection_run_definitions (
+                run_id INTEGER NOT NULL REFERENCES collection_runs(id),
+                definition_id INTEGER NOT NULL REFERENCES kpi_definitions(id),
+                PRIMARY KEY (run_id, definition_id)
+            )`,
+		),
+	},
+	{
+		Version:     7,
+		Description: "create query_rollups and index query_results by timestamp",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS query_rollups (
+                id SERIAL PRIMARY KEY,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                kpi_id TEXT NOT NULL,
+                metric_labels JSONB,
+                bucket_start DOUBLE PRECISION NOT NULL,  -- Unix seconds, a multiple of bucket_seconds
+                bucket_seconds INTEGER NOT NULL,
+                min_value DOUBLE PRECISION,
+                max_value DOUBLE PRECISION,
+                avg_value DOUBLE PRECISION,
+                sample_count INTEGER NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                UNIQUE(cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds)
+            )`, `
+            CREATE INDEX IF NOT EXISTS idx_query_results_timestamp_value
+            ON query_results(timestamp_value)`,
+		),
+	},
+	{
+		Version:     8,
+		Description: "create alerts",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS alerts (
+                id SERIAL PRIMARY KEY,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                run_id INTEGER REFERENCES collection_runs(id),
+                kpi_id TEXT NOT NULL,
+                metric_labels JSONB,
+                threshold TEXT NOT NULL,
+                limit_value DOUBLE PRECISION NOT NULL,
+                metric_value DOUBLE PRECISION NOT NULL,
+                sample_timestamp DOUBLE PRECISION NOT NULL,  -- Unix seconds of the sample
+                state TEXT NOT NULL,
+                occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+			"CREATE INDEX IF NOT EXISTS idx_alerts_occurred_at ON alerts(occurred_at)",
+		),
+	},
+}
+
+// postgresHasColumn reports whether the table has the column
+func postgresHasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`
+        SELECT COUNT(*) FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`,
+		table, column).Scan(&count)
+	return count > 0, err
+}
+
+// postgresAddColumn returns a migration step that adds the column unless the
+// table already has it (ADD COLUMN IF NOT EXISTS needs PostgreSQL 9.6)
+func postgresAddColumn(table, column, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := postgresHasColumn(tx, table, column)
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+		return err
+	}
+}
+
+// postgresScopeCountersByCluster adds cluster_id to query_errors and
+// query_retries and replaces their UNIQUE(kpi_id) constraint with
+// UNIQUE(cluster_id, kpi_id)
+func postgresScopeCountersByCluster(tx *sql.Tx) error {
+	for _, counter := range clusterScopedCounters {
+		scoped, err := postgresHasColumn(tx, counter.table, "cluster_id")
+		if err != nil {
+			return err
+		}
+		if scoped {
+			continue
+		}
+
+		err = execStatements(
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN cluster_id INTEGER REFERENCES clusters(id)", counter.table),
+			fmt.Sprintf("UPDATE %s SET cluster_id = %s", counter.table, legacyCounterCluster),
+			fmt.Sprintf("ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[1]s_kpi_id_key", counter.table),
+			fmt.Sprintf("ALTER TABLE %[1]s ADD CONSTRAINT %[1]s_cluster_id_kpi_id_key UNIQUE (cluster_id, kpi_id)", counter.table),
+		)(tx)
+		if err != nil {
+			return fmt.Errorf("failed to key %s by cluster: %w", counter.table, err)
+		}
+	}
+	return nil
+}
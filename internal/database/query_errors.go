@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// warningsJSON encodes Prometheus warnings for the warnings column, NULL if there are none
+func warningsJSON(warnings []string) (sql.NullString, error) {
+	if len(warnings) == 0 {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(warnings)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
+
+// httpStatusValue maps a missing HTTP status (0) to NULL
+func httpStatusValue(status int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(status), Valid: status != 0}
+}
+
+// addQueryCounts adds error and retry counts of a KPI on a cluster to
+// query_errors and query_retries
+func addQueryCounts(db *sql.DB, clusterID int64, kpiID string, errors, retries int64, placeholder placeholderFunc) error {
+	counts := map[string]int64{"query_errors": errors, "query_retries": retries}
+	for _, counter := range clusterScopedCounters {
+		count := counts[counter.table]
+		if count == 0 {
+			continue
+		}
+
+		_, err := db.Exec(
+			fmt.Sprintf(`
+        INSERT INTO %[1]s (cluster_id, kpi_id, %[2]s) VALUES (%[3]s, %[4]s, %[5]s)
+        ON CONFLICT(cluster_id, kpi_id) DO UPDATE SET %[2]s = %[1]s.%[2]s + excluded.%[2]s`,
+				counter.table, counter.column, placeholder(1), placeholder(2), placeholder(3)),
+			clusterID, kpiID, count,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add to %s: %w", counter.table, err)
+		}
+	}
+	return nil
+}
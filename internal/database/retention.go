@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy selects the raw samples removed from query_results by
+// PruneQueryResults and whether they are downsampled before removal
+type RetentionPolicy struct {
+	// Before prunes the samples with a Prometheus timestamp before this time
+	Before time.Time
+	// Downsample is the size of the query_rollups buckets the samples are
+	// rolled up into before deletion, 0 deletes them without a rollup.
+	// It must be a whole number of seconds.
+	Downsample time.Duration
+	// ClusterID restricts pruning to one cluster, 0 prunes every cluster
+	ClusterID int64
+	// DryRun computes the result without changing the database
+	DryRun bool
+}
+
+// PruneResult describes what PruneQueryResults removed
+type PruneResult struct {
+	// Deleted is the number of raw samples deleted from query_results
+	Deleted int64
+	// Buckets is the number of query_rollups buckets created or extended
+	Buckets int64
+	// Cutoff is the time before which samples were pruned. When downsampling
+	// it is aligned down to a bucket boundary, so no bucket is rolled up
+	// while some of its samples are still kept raw.
+	Cutoff time.Time
+}
+
+// pruneQueryResults deletes the raw samples selected by the policy, first
+// rolling them up with rollupQuery when downsampling. rollupQuery is the
+// backend's INSERT ... SELECT into query_rollups; its %[1]d verb is replaced
+// by the bucket size in seconds and %[2]s by the WHERE clause.
+// Everything runs in one transaction, which is rolled back for a dry run.
+func pruneQueryResults(db *sql.DB, policy RetentionPolicy, rollupQuery string, placeholder placeholderFunc) (PruneResult, error) {
+	bucketSeconds := int64(policy.Downsample / time.Second)
+	if policy.Downsample < 0 || time.Duration(bucketSeconds)*time.Second != policy.Downsample {
+		return PruneResult{}, fmt.Errorf("invalid downsample bucket %s: must be a whole number of seconds", policy.Downsample)
+	}
+
+	cutoff := policy.Before.Unix()
+	if bucketSeconds > 0 {
+		cutoff -= cutoff % bucketSeconds
+	}
+	result := PruneResult{Cutoff: time.Unix(cutoff, 0)}
+
+	where := "WHERE timestamp_value < " + placeholder(1)
+	args := []interface{}{float64(cutoff)}
+	if policy.ClusterID != 0 {
+		where += " AND cluster_id = " + placeholder(2)
+		args = append(args, policy.ClusterID)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if bucketSeconds > 0 {
+		rollup, err := tx.Exec(fmt.Sprintf(rollupQuery, bucketSeconds, where), args...)
+		if err != nil {
+			return result, fmt.Errorf("failed to roll up samples: %w", err)
+		}
+		result.Buckets, _ = rollup.RowsAffected()
+	}
+
+	deleted, err := tx.Exec("DELETE FROM query_results "+where, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to delete samples: %w", err)
+	}
+	result.Deleted, _ = deleted.RowsAffected()
+
+	if policy.DryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
@@ -2,8 +2,6 @@ package database
 
 import (
 	"database/sql"
-	"encoding/json"
-	"fmt"
 	"os"
 	"path/filepath"
 
@@ -22,59 +20,71 @@ const (
 // and can be overridden via the --artifacts-dir flag.
 var OutputDir = DefaultOutputDir
 
-type SQLiteDB struct{}
+// SQLiteDB implements the Database interface for SQLite
+type SQLiteDB struct {
+	// InsertBatchSize is the number of samples written per INSERT statement (0 = default)
+	InsertBatchSize int
+	// Path is the database file (empty = <OutputDir>/kpi_metrics.db)
+	Path string
+}
 
 // NewSQLiteDB creates a new SQLite database instance
 func NewSQLiteDB() *SQLiteDB {
 	return &SQLiteDB{}
 }
 
-// InitDB initializes the SQLite database and creates required tables.
-// The database is stored in <OutputDir>/kpi_metrics.db.
-func (sqlite_db *SQLiteDB) InitDB() (*sql.DB, error) {
-	dbPath := filepath.Join(OutputDir, DefaultDBFileName)
+// path returns the database file, by default <OutputDir>/kpi_metrics.db
+func (sqlite_db *SQLiteDB) path() string {
+	if sqlite_db.Path == "" {
+		return filepath.Join(OutputDir, DefaultDBFileName)
+	}
+	return sqlite_db.Path
+}
 
-	if err := os.MkdirAll(OutputDir, 0755); err != nil {
+// Connect opens the SQLite database in Path, by default
+// <OutputDir>/kpi_metrics.db, without creating or migrating the schema.
+func (sqlite_db *SQLiteDB) Connect() (*sql.DB, error) {
+	dbPath := sqlite_db.path()
+
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	// The connection is shared by concurrent collection goroutines, so wait
+	// for a competing writer instead of failing with SQLITE_BUSY, and take the
+	// write lock up front so concurrent transactions cannot deadlock on upgrade.
+	return sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_txlock=immediate")
+}
+
+// InitDB initializes the SQLite database and migrates it to the latest schema.
+// The database is stored in Path, by default <OutputDir>/kpi_metrics.db.
+func (sqlite_db *SQLiteDB) InitDB() (*sql.DB, error) {
+	db, err := sqlite_db.Connect()
 	if err != nil {
 		return nil, err
 	}
 
-	schema := `
-    CREATE TABLE IF NOT EXISTS clusters (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        cluster_name TEXT UNIQUE NOT NULL,
-		cluster_type TEXT,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );
-    
-    
-    CREATE TABLE IF NOT EXISTS query_results (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        kpi_id TEXT NOT NULL,
-        metric_value REAL,
-        timestamp_value REAL,
-		cluster_id INTEGER NOT NULL REFERENCES clusters(id),
-		execution_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        metric_labels TEXT  -- JSON string of all labels
-    );
-
-	CREATE TABLE IF NOT EXISTS query_errors (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		kpi_id TEXT UNIQUE NOT NULL,
-		errors INTEGER DEFAULT 0
-	);
-
-	CREATE UNIQUE INDEX IF NOT EXISTS idx_query_results_dedup
-	ON query_results(kpi_id, cluster_id, timestamp_value, metric_labels)
-    `
-
-	_, err = db.Exec(schema)
-	return db, err
+	if _, err := Migrate(db, sqlite_db, LatestVersion(sqlite_db)); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// SchemaVersion returns the latest migration applied to the database
+func (sqlite_db *SQLiteDB) SchemaVersion(db *sql.DB) (int, error) {
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+	return maxAppliedVersion(db)
+}
+
+// Migrations returns the ordered schema migrations for SQLite
+func (sqlite_db *SQLiteDB) Migrations() []Migration {
+	return sqliteMigrations
 }
 
 // getOrCreateCluster gets existing cluster ID or creates a new cluster record
@@ -98,86 +108,167 @@ func (sqlite_db *SQLiteDB) GetOrCreateCluster(db *sql.DB, clusterName string, cl
 	return result.LastInsertId()
 }
 
-// increments the error count for a given KPI ID in the query_errors table.
-func (sqlite_db *SQLiteDB) IncrementQueryError(db *sql.DB, kpiID string) error {
+// increments the error count for a given KPI ID on a cluster in the query_errors table.
+func (sqlite_db *SQLiteDB) IncrementQueryError(db *sql.DB, clusterID int64, kpiID string) error {
 	_, err := db.Exec(`
-        INSERT INTO query_errors (kpi_id, errors) VALUES (?, 1)
-        ON CONFLICT(kpi_id) DO UPDATE SET errors = errors + 1
-    `, kpiID)
+        INSERT INTO query_errors (cluster_id, kpi_id, errors) VALUES (?, ?, 1)
+        ON CONFLICT(cluster_id, kpi_id) DO UPDATE SET errors = errors + 1
+    `, clusterID, kpiID)
 	return err
 }
 
-// returns the error count for a given KPI ID.
-func (sqlite_db *SQLiteDB) GetQueryErrorCount(db *sql.DB, kpiID string) (int, error) {
+// returns the error count for a given KPI ID on a cluster.
+func (sqlite_db *SQLiteDB) GetQueryErrorCount(db *sql.DB, clusterID int64, kpiID string) (int, error) {
 	var count int
-	err := db.QueryRow("SELECT errors FROM query_errors WHERE kpi_id = ?", kpiID).Scan(&count)
+	err := db.QueryRow("SELECT errors FROM query_errors WHERE cluster_id = ? AND kpi_id = ?", clusterID, kpiID).Scan(&count)
 	if err == sql.ErrNoRows {
 		return 0, nil
 	}
 	return count, err
 }
 
-// StoreQueryResults stores the results of a Prometheus query in the database
-func (sqlite_db *SQLiteDB) StoreQueryResults(db *sql.DB, clusterID int64, queryID string, result model.Value) error {
-	switch values := result.(type) {
-	case model.Vector:
-		return sqlite_db.storeVectorResults(db, clusterID, queryID, values)
-	case model.Matrix:
-		return sqlite_db.storeMatrixResults(db, clusterID, queryID, values)
-	default:
-		return fmt.Errorf("unsupported Prometheus result type for KPI '%s': %T", queryID, result)
+// increments the retry count for a given KPI ID on a cluster in the query_retries table.
+func (sqlite_db *SQLiteDB) IncrementQueryRetry(db *sql.DB, clusterID int64, kpiID string) error {
+	_, err := db.Exec(`
+        INSERT INTO query_retries (cluster_id, kpi_id, retries) VALUES (?, ?, 1)
+        ON CONFLICT(cluster_id, kpi_id) DO UPDATE SET retries = retries + 1
+    `, clusterID, kpiID)
+	return err
+}
+
+// returns the retry count for a given KPI ID on a cluster.
+func (sqlite_db *SQLiteDB) GetQueryRetryCount(db *sql.DB, clusterID int64, kpiID string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT retries FROM query_retries WHERE cluster_id = ? AND kpi_id = ?", clusterID, kpiID).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
 	}
+	return count, err
 }
 
-func (sqlite_db *SQLiteDB) storeVectorResults(db *sql.DB, clusterID int64, queryID string, vector model.Vector) error {
-	for _, sample := range vector {
-		metric := sample.Metric
-		value := float64(sample.Value)
-		timestamp := float64(sample.Timestamp) / 1000
+// StoreQueryResults stores the results of a Prometheus query in the database.
+// All samples of the result are written in one transaction using batched
+// multi-row inserts, so a failure leaves no partial result behind.
+func (sqlite_db *SQLiteDB) StoreQueryResults(db *sql.DB, clusterID int64, runID int64, queryID string, result model.Value) error {
+	rows, err := flattenResult(queryID, result)
+	if err != nil {
+		return err
+	}
 
-		labelsJSON, err := json.Marshal(metric)
-		if err != nil {
-			return err
-		}
+	return insertResultRows(db, clusterID, runID, queryID, rows, effectiveBatchSize(sqlite_db.InsertBatchSize, sqliteMaxVariables), questionPlaceholder)
+}
 
-		_, err = db.Exec(`
-            INSERT INTO query_results 
-            (kpi_id, metric_value, timestamp_value, cluster_id, metric_labels)
-            VALUES (?, ?, ?, ?, ?)
-            ON CONFLICT(kpi_id, cluster_id, timestamp_value, metric_labels) DO NOTHING`,
-			queryID, value, timestamp, clusterID, string(labelsJSON),
-		)
-		if err != nil {
-			return err
-		}
+// RecordOverrun stores a sampling tick overrun in the collection_overruns table
+func (sqlite_db *SQLiteDB) RecordOverrun(db *sql.DB, clusterID int64, event OverrunEvent) error {
+	_, err := db.Exec(`
+        INSERT INTO collection_overruns
+        (cluster_id, frequency_seconds, sample_number, run_duration_seconds, missed_ticks, policy)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		clusterID, event.Frequency.Seconds(), event.SampleNumber, event.RunDuration.Seconds(), event.MissedTicks, event.Policy,
+	)
+	return err
+}
+
+// RecordQueryError stores a failed query attempt in the query_error_events table
+func (sqlite_db *SQLiteDB) RecordQueryError(db *sql.DB, clusterID int64, event QueryErrorEvent) error {
+	warnings, err := warningsJSON(event.Warnings)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	_, err = db.Exec(`
+        INSERT INTO query_error_events
+        (cluster_id, kpi_id, query_type, promquery, http_status, error_message, warnings, attempt, retried)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		clusterID, event.KPIID, event.QueryType, event.PromQuery, httpStatusValue(event.HTTPStatus),
+		event.Message, warnings, event.Attempt, event.Retried,
+	)
+	return err
 }
 
-func (sqlite_db *SQLiteDB) storeMatrixResults(db *sql.DB, clusterID int64, queryID string, matrix model.Matrix) error {
-	for _, stream := range matrix {
-		metric := stream.Metric
-		labelsJSON, err := json.Marshal(metric)
-		if err != nil {
-			return err
-		}
+// StartCollectionRun inserts a collection_runs row for the cluster and returns its ID
+func (sqlite_db *SQLiteDB) StartCollectionRun(db *sql.DB, clusterID int64, run CollectionRun) (int64, error) {
+	result, err := db.Exec(`
+        INSERT INTO collection_runs (cluster_id, frequency_seconds, duration_seconds, kpis_file_hash, tool_version)
+        VALUES (?, ?, ?, ?, ?)`,
+		clusterID, run.Frequency.Seconds(), run.Duration.Seconds(), run.KPIsFileHash, run.ToolVersion,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
 
-		for _, samplePair := range stream.Values {
-			value := float64(samplePair.Value)
-			timestamp := float64(samplePair.Timestamp) / 1000
-
-			_, execErr := db.Exec(`
-                INSERT INTO query_results 
-                (kpi_id, metric_value, timestamp_value, cluster_id, metric_labels)
-                VALUES (?, ?, ?, ?, ?)
-                ON CONFLICT(kpi_id, cluster_id, timestamp_value, metric_labels) DO NOTHING`,
-				queryID, value, timestamp, clusterID, string(labelsJSON),
-			)
-			if execErr != nil {
-				return execErr
-			}
-		}
+// FinishCollectionRun stores the end time, shutdown reason and failure counts of a collection run
+func (sqlite_db *SQLiteDB) FinishCollectionRun(db *sql.DB, runID int64, result CollectionRunResult) error {
+	_, err := db.Exec(`
+        UPDATE collection_runs
+        SET ended_at = CURRENT_TIMESTAMP, shutdown_reason = ?, failed_queries = ?, retries = ?
+        WHERE id = ?`,
+		result.ShutdownReason, result.FailedQueries, result.Retries, runID,
+	)
+	return err
+}
+
+// RecordKPIDefinitions stores the KPI definitions of a collection run in kpi_definitions
+func (sqlite_db *SQLiteDB) RecordKPIDefinitions(db *sql.DB, runID int64, definitions []KPIDefinition) error {
+	return recordKPIDefinitions(db, runID, definitions, questionPlaceholder)
+}
+
+// sqliteRollupQuery rolls raw samples up into query_rollups, merging them into
+// buckets already rolled up by an earlier prune. SQLite's two-argument MIN and
+// MAX return NULL if either value is NULL, hence the COALESCE.
+const sqliteRollupQuery = `
+        INSERT INTO query_rollups
+        (cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds, min_value, max_value, avg_value, sample_count)
+        SELECT cluster_id, kpi_id, metric_labels, CAST(timestamp_value / %[1]d AS INTEGER) * %[1]d, %[1]d,
+               MIN(metric_value), MAX(metric_value), AVG(metric_value), COUNT(metric_value)
+        FROM query_results %[2]s
+        GROUP BY cluster_id, kpi_id, metric_labels, CAST(timestamp_value / %[1]d AS INTEGER)
+        ON CONFLICT(cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds) DO UPDATE SET
+            min_value = COALESCE(MIN(query_rollups.min_value, excluded.min_value), query_rollups.min_value, excluded.min_value),
+            max_value = COALESCE(MAX(query_rollups.max_value, excluded.max_value), query_rollups.max_value, excluded.max_value),
+            avg_value = CASE
+                WHEN excluded.sample_count = 0 THEN query_rollups.avg_value
+                WHEN query_rollups.sample_count = 0 THEN excluded.avg_value
+                ELSE (query_rollups.avg_value * query_rollups.sample_count + excluded.avg_value * excluded.sample_count)
+                     / (query_rollups.sample_count + excluded.sample_count)
+            END,
+            sample_count = query_rollups.sample_count + excluded.sample_count`
+
+// PruneQueryResults deletes old raw samples, rolling them up into query_rollups first when downsampling
+func (sqlite_db *SQLiteDB) PruneQueryResults(db *sql.DB, policy RetentionPolicy) (PruneResult, error) {
+	return pruneQueryResults(db, policy, sqliteRollupQuery, questionPlaceholder)
+}
+
+// RecordAlert stores a threshold alert in the alerts table
+func (sqlite_db *SQLiteDB) RecordAlert(db *sql.DB, clusterID int64, runID int64, event AlertEvent) error {
+	run, labels, err := alertRow(runID, event)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	_, err = db.Exec(`
+        INSERT INTO alerts
+        (cluster_id, run_id, kpi_id, metric_labels, threshold, limit_value, metric_value, sample_timestamp, state)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		clusterID, run, event.KPIID, labels, event.Threshold, event.Limit, event.Value,
+		float64(event.SampleTime.UnixMilli())/1000, event.State,
+	)
+	return err
+}
+
+// ImportCollectionRun stores an archived collection run unless it was already imported
+func (sqlite_db *SQLiteDB) ImportCollectionRun(db *sql.DB, clusterID int64, run ArchiveRun) (int64, bool, error) {
+	return importCollectionRun(db, clusterID, run, questionPlaceholder, sqliteTimestamp)
+}
+
+// ImportQueryResults stores archived samples in query_results, skipping duplicates
+func (sqlite_db *SQLiteDB) ImportQueryResults(db *sql.DB, samples []ArchiveSample) (int64, error) {
+	return importQueryResults(db, samples, questionPlaceholder, sqliteTimestamp, sqliteMaxVariables)
+}
+
+// AddQueryCounts adds error and retry counts of a KPI on a cluster
+func (sqlite_db *SQLiteDB) AddQueryCounts(db *sql.DB, clusterID int64, kpiID string, errors, retries int64) error {
+	return addQueryCounts(db, clusterID, kpiID, errors, retries, questionPlaceholder)
 }
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteRegexps caches the patterns compiled by sqliteRegexp, keyed by pattern
+var sqliteRegexps sync.Map
+
+func init() {
+	// SQLite parses the REGEXP operator but leaves it undefined, so register
+	// it for every connection opened by this process. "X REGEXP Y" calls
+	// regexp(Y, X).
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp)
+
+	// PostgreSQL's statistical aggregates, used by 'db show kpis --aggregate'.
+	// percentile_cont(value, fraction) takes the arguments of SQLite's
+	// percentile extension, which modernc.org/sqlite does not compile in.
+	mustRegisterAggregate("stddev_pop", 1, func() sqlite.AggregateFunction { return &sqliteStddev{} })
+	mustRegisterAggregate("percentile_cont", 2, func() sqlite.AggregateFunction { return &sqlitePercentile{} })
+}
+
+// mustRegisterAggregate registers a deterministic aggregate function
+func mustRegisterAggregate(name string, nArgs int32, newAggregate func() sqlite.AggregateFunction) {
+	err := sqlite.RegisterFunction(name, &sqlite.FunctionImpl{
+		NArgs:         nArgs,
+		Deterministic: true,
+		MakeAggregate: func(sqlite.FunctionContext) (sqlite.AggregateFunction, error) {
+			return newAggregate(), nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// errNotWindowFunction is returned when an aggregate is used as a window function
+var errNotWindowFunction = errors.New("aggregate cannot be used as a window function")
+
+// sqliteRegexp reports whether the value matches the Go (RE2) pattern, which
+// is not implicitly anchored. It returns NULL when either argument is NULL.
+func sqliteRegexp(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := sqliteText(args[0])
+	if !ok {
+		return nil, nil
+	}
+	value, ok := sqliteText(args[1])
+	if !ok {
+		return nil, nil
+	}
+
+	re, cached := sqliteRegexps.Load(pattern)
+	if !cached {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+		}
+		re, _ = sqliteRegexps.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(value), nil
+}
+
+// sqliteText converts a TEXT or BLOB function argument to a string
+func sqliteText(value driver.Value) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	default:
+		return "", false
+	}
+}
+
+// sqliteNumber converts a numeric function argument to a float64
+func sqliteNumber(value driver.Value) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// sqliteStddev implements stddev_pop(value): the population standard
+// deviation of the non-NULL values, computed with Welford's algorithm
+type sqliteStddev struct {
+	count int64
+	mean  float64
+	m2    float64
+}
+
+func (s *sqliteStddev) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	value, ok := sqliteNumber(args[0])
+	if !ok {
+		return nil
+	}
+	s.count++
+	delta := value - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (value - s.mean)
+	return nil
+}
+
+func (s *sqliteStddev) WindowInverse(*sqlite.FunctionContext, []driver.Value) error {
+	return errNotWindowFunction
+}
+
+func (s *sqliteStddev) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	if s.count == 0 {
+		return nil, nil
+	}
+	return math.Sqrt(s.m2 / float64(s.count)), nil
+}
+
+func (s *sqliteStddev) Final(*sqlite.FunctionContext) {}
+
+// sqlitePercentile implements percentile_cont(value, fraction): the value
+// below which the fraction (0 to 1) of the non-NULL values fall,
+// interpolating linearly between the two closest values like PostgreSQL
+type sqlitePercentile struct {
+	values   []float64
+	fraction float64
+}
+
+func (p *sqlitePercentile) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	fraction, ok := sqliteNumber(args[1])
+	if !ok || fraction < 0 || fraction > 1 {
+		return fmt.Errorf("percentile_cont fraction must be between 0 and 1, got %v", args[1])
+	}
+	p.fraction = fraction
+
+	if value, ok := sqliteNumber(args[0]); ok {
+		p.values = append(p.values, value)
+	}
+	return nil
+}
+
+func (p *sqlitePercentile) WindowInverse(*sqlite.FunctionContext, []driver.Value) error {
+	return errNotWindowFunction
+}
+
+func (p *sqlitePercentile) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	if len(p.values) == 0 {
+		return nil, nil
+	}
+	sort.Float64s(p.values)
+
+	rank := p.fraction * float64(len(p.values)-1)
+	lower := int(math.Floor(rank))
+	if lower == len(p.values)-1 {
+		return p.values[lower], nil
+	}
+	return p.values[lower] + (rank-float64(lower))*(p.values[lower+1]-p.values[lower]), nil
+}
+
+func (p *sqlitePercentile) Final(*sqlite.FunctionContext) {}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// sqliteMigrations are the schema migrations for SQLite, in version order.
+// Never edit a released migration; append a new one instead.
+var sqliteMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create clusters, query_results and query_errors",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS clusters (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_name TEXT UNIQUE NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`, `
+            CREATE TABLE IF NOT EXISTS query_results (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                kpi_id TEXT NOT NULL,
+                metric_value REAL,
+                timestamp_value REAL,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                execution_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                metric_labels TEXT  -- JSON string of all labels
+            )`, `
+            CREATE TABLE IF NOT EXISTS query_errors (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                kpi_id TEXT UNIQUE NOT NULL,
+                errors INTEGER DEFAULT 0
+            )`, `
+            CREATE UNIQUE INDEX IF NOT EXISTS idx_query_results_dedup
+            ON query_results(kpi_id, cluster_id, timestamp_value, metric_labels)`,
+		),
+	},
+	{
+		Version:     2,
+		Description: "add cluster_type to clusters",
+		Up:          sqliteAddColumn("clusters", "cluster_type", "TEXT"),
+	},
+	{
+		Version:     3,
+		Description: "create query_retries, collection_overruns and query_error_events",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS query_retries (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                kpi_id TEXT UNIQUE NOT NULL,
+                retries INTEGER DEFAULT 0
+            )`, `
+            CREATE TABLE IF NOT EXISTS collection_overruns (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                frequency_seconds REAL NOT NULL,
+                sample_number INTEGER NOT NULL,
+                run_duration_seconds REAL NOT NULL,
+                missed_ticks INTEGER NOT NULL,
+                policy TEXT NOT NULL,
+                occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`, `
+            CREATE TABLE IF NOT EXISTS query_error_events (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                kpi_id TEXT NOT NULL,
+                query_type TEXT NOT NULL,
+                promquery TEXT NOT NULL,
+                http_status INTEGER,
+                error_message TEXT NOT NULL,
+                warnings TEXT,  -- JSON array of Prometheus warnings
+                attempt INTEGER NOT NULL,
+                retried BOOLEAN NOT NULL,
+                occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`, `
+            CREATE INDEX IF NOT EXISTS idx_query_error_events_occurred_at
+            ON query_error_events(occurred_at)`,
+		),
+	},
+	{
+		Version:     4,
+		Description: "key query error and retry counts by cluster",
+		Up:          sqliteScopeCountersByCluster,
+	},
+	{
+		Version:     5,
+		Description: "create collection_runs and add run_id to query_results",
+		Up: func(tx *sql.Tx) error {
+			err := execStatements(`
+            CREATE TABLE IF NOT EXISTS collection_runs (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                ended_at TIMESTAMP,
+                frequency_seconds REAL NOT NULL,
+                duration_seconds REAL NOT NULL,
+                kpis_file_hash TEXT,
+                tool_version TEXT,
+                shutdown_reason TEXT,
+                failed_queries INTEGER NOT NULL DEFAULT 0,
+                retries INTEGER NOT NULL DEFAULT 0
+            )`)(tx)
+			if err != nil {
+				return err
+			}
+			if err := sqliteAddColumn("query_results", "run_id", "INTEGER REFERENCES collection_runs(id)")(tx); err != nil {
+				return err
+			}
+			return execStatements("CREATE INDEX IF NOT EXISTS idx_query_results_run_id ON query_results(run_id)")(tx)
+		},
+	},
+	{
+		Version:     6,
+		Description: "create kpi_definitions and collection_run_definitions",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS kpi_definitions (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                kpi_id TEXT NOT NULL,
+                definition_hash TEXT NOT NULL,
+                promquery TEXT NOT NULL,
+                resolved_promquery TEXT NOT NULL,
+                query_type TEXT NOT NULL,
+                range_step TEXT,
+                range_since TEXT,
+                range_until TEXT,
+                frequency_seconds REAL NOT NULL,
+                run_once BOOLEAN NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                UNIQUE(kpi_id, definition_hash)
+            )`, `
+            CREATE TABLE IF NOT EXISTS collection_run_definitions (
+                run_id INTEGER NOT NULL REFERENCES collection_runs(id),
+                definition_id INTEGER NOT NULL REFERENCES kpi_definitions(id),
+                PRIMARY KEY (run_id, definition_id)
+            )`,
+		),
+	},
+	{
+		Version:     7,
+		Description: "create query_rollups and index query_results by timestamp",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS query_rollups (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                kpi_id TEXT NOT NULL,
+                metric_labels TEXT,  -- JSON string of all labels
+                bucket_start REAL NOT NULL,  -- Unix seconds, a multiple of bucket_seconds
+                bucket_seconds INTEGER NOT NULL,
+                min_value REAL,
+                max_value REAL,
+                avg_value REAL,
+                sample_count INTEGER NOT NULL,
+                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                UNIQUE(cluster_id, kpi_id, metric_labels, bucket_start, bucket_seconds)
+            )`, `
+            CREATE INDEX IF NOT EXISTS idx_query_results_timestamp_value
+            ON query_results(timestamp_value)`,
+		),
+	},
+	{
+		Version:     8,
+		Description: "create alerts",
+		Up: execStatements(`
+            CREATE TABLE IF NOT EXISTS alerts (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_id INTEGER NOT NULL REFERENCES clusters(id),
+                run_id INTEGER REFERENCES collection_runs(id),
+                kpi_id TEXT NOT NULL,
+                metric_labels TEXT,  -- JSON string of all labels
+                threshold TEXT NOT NULL,
+                limit_value REAL NOT NULL,
+                metric_value REAL NOT NULL,
+                sample_timestamp REAL NOT NULL,  -- Unix seconds of the sample
+                state TEXT NOT NULL,
+                occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            )`,
+			"CREATE INDEX IF NOT EXISTS idx_alerts_occurred_at ON alerts(occurred_at)",
+		),
+	},
+}
+
+// sqliteHasColumn reports whether the table has the column
+func sqliteHasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
+
+// sqliteAddColumn returns a migration step that adds the column unless the
+// table already has it (SQLite has no ADD COLUMN IF NOT EXISTS)
+func sqliteAddColumn(table, column, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := sqliteHasColumn(tx, table, column)
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+		return err
+	}
+}
+
+// sqliteScopeCountersByCluster rebuilds query_errors and query_retries keyed
+// by (cluster_id, kpi_id). SQLite cannot drop the old UNIQUE(kpi_id)
+// constraint, so each table is copied into a new one.
+func sqliteScopeCountersByCluster(tx *sql.Tx) error {
+	for _, counter := range clusterScopedCounters {
+		scoped, err := sqliteHasColumn(tx, counter.table, "cluster_id")
+		if err != nil {
+			return err
+		}
+		if scoped {
+			continue
+		}
+
+		err = execStatements(
+			fmt.Sprintf(`CREATE TABLE %[1]s_scoped (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                cluster_id INTEGER REFERENCES clusters(id),
+                kpi_id TEXT NOT NULL,
+                %[2]s INTEGER DEFAULT 0,
+                UNIQUE(cluster_id, kpi_id)
+            )`, counter.table, counter.column),
+			fmt.Sprintf("INSERT INTO %[1]s_scoped (id, cluster_id, kpi_id, %[2]s) SELECT id, %[3]s, kpi_id, %[2]s FROM %[1]s",
+				counter.table, counter.column, legacyCounterCluster),
+			fmt.Sprintf("DROP TABLE %s", counter.table),
+			fmt.Sprintf("ALTER TABLE %[1]s_scoped RENAME TO %[1]s", counter.table),
+		)(tx)
+		if err != nil {
+			return fmt.Errorf("failed to key %s by cluster: %w", counter.table, err)
+		}
+	}
+	return nil
+}
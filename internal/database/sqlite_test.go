@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
 )
 
 var _ = Describe("Sqlite", func() {
@@ -79,6 +81,158 @@ var _ = Describe("Sqlite", func() {
 			_, err := os.Stat(dbFile)
 			Expect(err).NotTo(HaveOccurred())
 		})
+
+		It("should leave no partial result when a later batch fails", func() {
+			clusterID, err := sqliteDB.GetOrCreateCluster(db, "test-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			// Reject one sample of the second batch to simulate a mid-way failure
+			_, err = db.Exec(`
+				CREATE TRIGGER reject_poisoned_sample BEFORE INSERT ON query_results
+				WHEN NEW.metric_value = 666
+				BEGIN SELECT RAISE(ABORT, 'poisoned sample'); END`)
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now().Add(-time.Hour).Unix()
+			stream := &model.SampleStream{Metric: model.Metric{"__name__": "rollback"}}
+			for i := 0; i < 6; i++ {
+				value := model.SampleValue(i)
+				if i == 4 {
+					value = 666
+				}
+				stream.Values = append(stream.Values, model.SamplePair{
+					Timestamp: model.Time((start + int64(i*30)) * 1000),
+					Value:     value,
+				})
+			}
+
+			batched := &SQLiteDB{InsertBatchSize: 3}
+			err = batched.StoreQueryResults(db, clusterID, 0, "rollback-kpi", model.Matrix{stream})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to insert samples 4-6 of 6"))
+
+			var count int
+			err = db.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = ?", "rollback-kpi").Scan(&count)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(0), "samples of the first batch must be rolled back")
+		})
+	})
+
+	Describe("migrating error counts recorded before they were keyed by cluster", func() {
+		// reinitWithLegacyCounters replaces the counter tables with the schema used
+		// before counts were keyed by cluster and re-runs InitDB on the database
+		reinitWithLegacyCounters := func(clusterNames ...string) {
+			for _, name := range clusterNames {
+				_, err := sqliteDB.GetOrCreateCluster(db, name, "")
+				Expect(err).NotTo(HaveOccurred())
+			}
+			_, err := db.Exec(`
+				DROP TABLE schema_migrations;
+				DROP TABLE query_errors;
+				DROP TABLE query_retries;
+				CREATE TABLE query_errors (id INTEGER PRIMARY KEY AUTOINCREMENT, kpi_id TEXT UNIQUE NOT NULL, errors INTEGER DEFAULT 0);
+				CREATE TABLE query_retries (id INTEGER PRIMARY KEY AUTOINCREMENT, kpi_id TEXT UNIQUE NOT NULL, retries INTEGER DEFAULT 0);
+				INSERT INTO query_errors (kpi_id, errors) VALUES ('legacy-kpi', 4);
+				INSERT INTO query_retries (kpi_id, retries) VALUES ('legacy-kpi', 7);
+			`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.Close()).To(Succeed())
+
+			db, err = sqliteDB.InitDB()
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("should attribute legacy counts to the only cluster and count per cluster afterwards", func() {
+			reinitWithLegacyCounters("only-cluster")
+			clusterID, err := sqliteDB.GetOrCreateCluster(db, "only-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			errors, err := sqliteDB.GetQueryErrorCount(db, clusterID, "legacy-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors).To(Equal(4))
+			retries, err := sqliteDB.GetQueryRetryCount(db, clusterID, "legacy-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retries).To(Equal(7))
+
+			otherID, err := sqliteDB.GetOrCreateCluster(db, "new-cluster", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sqliteDB.IncrementQueryError(db, otherID, "legacy-kpi")).To(Succeed())
+			Expect(sqliteDB.IncrementQueryError(db, clusterID, "legacy-kpi")).To(Succeed())
+
+			errors, err = sqliteDB.GetQueryErrorCount(db, clusterID, "legacy-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors).To(Equal(5))
+			errors, err = sqliteDB.GetQueryErrorCount(db, otherID, "legacy-kpi")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors).To(Equal(1))
+		})
+
+		It("should keep legacy counts without a cluster when several clusters exist", func() {
+			reinitWithLegacyCounters("cluster-a", "cluster-b")
+
+			var clusterID sql.NullInt64
+			var errors int
+			err := db.QueryRow("SELECT cluster_id, errors FROM query_errors WHERE kpi_id = 'legacy-kpi'").Scan(&clusterID, &errors)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterID.Valid).To(BeFalse())
+			Expect(errors).To(Equal(4))
+		})
+	})
+
+	Describe("migrating a database created before versioned migrations", func() {
+		// reopenUnversioned replaces the database with the schema created by
+		// kpi-collector before clusters had a cluster_type column
+		reopenUnversioned := func() {
+			Expect(db.Close()).To(Succeed())
+			dbFile := filepath.Join(OutputDir, DefaultDBFileName)
+			Expect(os.Remove(dbFile)).To(Succeed())
+
+			var err error
+			db, err = sqliteDB.Connect()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Exec(`
+				CREATE TABLE clusters (id INTEGER PRIMARY KEY AUTOINCREMENT, cluster_name TEXT UNIQUE NOT NULL, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+				CREATE TABLE query_results (id INTEGER PRIMARY KEY AUTOINCREMENT, kpi_id TEXT NOT NULL, metric_value REAL, timestamp_value REAL,
+					cluster_id INTEGER NOT NULL REFERENCES clusters(id), execution_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+					created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, metric_labels TEXT);
+				CREATE TABLE query_errors (id INTEGER PRIMARY KEY AUTOINCREMENT, kpi_id TEXT UNIQUE NOT NULL, errors INTEGER DEFAULT 0);
+				INSERT INTO clusters (cluster_name) VALUES ('old-cluster');
+			`)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("should upgrade the schema and keep existing data", func() {
+			reopenUnversioned()
+
+			version, err := sqliteDB.SchemaVersion(db)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(0))
+
+			applied, err := Migrate(db, sqliteDB, LatestVersion(sqliteDB))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(HaveLen(LatestVersion(sqliteDB)))
+
+			clusterID, err := sqliteDB.GetOrCreateCluster(db, "old-cluster", "ran")
+			Expect(err).NotTo(HaveOccurred())
+			var clusterType string
+			err = db.QueryRow("SELECT cluster_type FROM clusters WHERE id = ?", clusterID).Scan(&clusterType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterType).To(Equal("ran"))
+		})
+
+		It("should stop at the requested version and refuse to downgrade", func() {
+			reopenUnversioned()
+
+			applied, err := Migrate(db, sqliteDB, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			version, err := sqliteDB.SchemaVersion(db)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(2))
+
+			_, err = Migrate(db, sqliteDB, 1)
+			Expect(err).To(MatchError(ContainSubstring("downgrading to version 1 is not supported")))
+		})
 	})
 
 	RunDatabaseInterfaceTests(func() (Database, *sql.DB) { return sqliteDB, db })
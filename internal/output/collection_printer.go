@@ -2,7 +2,10 @@ package output
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"sync"
+	"text/tabwriter"
 	"time"
 )
 
@@ -19,6 +22,9 @@ type QueryInfo struct {
 	Step         time.Duration
 	Since        time.Time
 	Until        time.Time
+	Timeout      time.Duration // per-attempt timeout (0 = none)
+	MaxRetries   int           // retries allowed after a transient failure
+	RetryBackoff time.Duration // delay before the first retry
 }
 
 // QueryResult holds the result of a query execution
@@ -26,6 +32,7 @@ type QueryResult struct {
 	Success  bool
 	Error    error
 	Warnings []string
+	Notes    []string // additional lines printed after the status
 }
 
 // PrintQueryResult prints the complete query execution result atomically (thread-safe)
@@ -58,6 +65,37 @@ func PrintQueryResult(info QueryInfo, result QueryResult) {
 	} else {
 		fmt.Printf("  Status: FAILED - %v\n", result.Error)
 	}
+
+	for _, note := range result.Notes {
+		fmt.Printf("  %s\n", note)
+	}
+}
+
+// AlertInfo describes a KPI series crossing a threshold during collection,
+// or coming back within it
+type AlertInfo struct {
+	KPIID      string
+	Labels     map[string]string
+	Threshold  string
+	Value      float64
+	SampleTime time.Time
+	Resolved   bool
+}
+
+// PrintAlert prints a highlighted alert banner (thread-safe)
+func PrintAlert(alert AlertInfo) {
+	printMutex.Lock()
+	defer printMutex.Unlock()
+
+	fmt.Println()
+	if alert.Resolved {
+		fmt.Printf("✓ RESOLVED [%s] %s back within %s\n", alert.KPIID, labelsString(alert.Labels), alert.Threshold)
+	} else {
+		fmt.Println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+		fmt.Printf("!!! ALERT [%s] %s breaks %s\n", alert.KPIID, labelsString(alert.Labels), alert.Threshold)
+		fmt.Println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	}
+	fmt.Printf("  Value: %s at %s\n", strconv.FormatFloat(alert.Value, 'g', -1, 64), alert.SampleTime.UTC().Format(time.RFC3339))
 }
 
 // PrintStartup prints collection startup info (thread-safe)
@@ -77,3 +115,66 @@ func PrintShutdown(reason string) {
 	fmt.Println()
 	fmt.Printf("KPI Collection Stopped: %s\n", reason)
 }
+
+// GroupSummary holds planned vs. actual sample counts for one KPI group
+type GroupSummary struct {
+	Frequency time.Duration
+	Policy    string
+	KPICount  int
+	Planned   int
+	Executed  int
+	Skipped   int
+	Overruns  int
+}
+
+// PrintCollectionSummary prints planned vs. actual samples per KPI group (thread-safe)
+func PrintCollectionSummary(groups []*GroupSummary) {
+	if len(groups) == 0 {
+		return
+	}
+
+	printMutex.Lock()
+	defer printMutex.Unlock()
+
+	fmt.Println()
+	fmt.Println("Collection summary:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  FREQUENCY\tPOLICY\tKPIS\tPLANNED\tEXECUTED\tSKIPPED\tOVERRUNS")
+	for _, g := range groups {
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+			g.Frequency, g.Policy, g.KPICount, g.Planned, g.Executed, g.Skipped, g.Overruns)
+	}
+	_ = w.Flush()
+}
+
+// SinkSummary holds the samples written to one storage sink of a collection run
+type SinkSummary struct {
+	Sink      string
+	Status    string // ok, degraded or unavailable
+	Written   int64
+	Failed    int64
+	LastError string
+}
+
+// PrintSinkSummary prints the samples written to and lost by each sink (thread-safe)
+func PrintSinkSummary(sinks []SinkSummary) {
+	if len(sinks) == 0 {
+		return
+	}
+
+	printMutex.Lock()
+	defer printMutex.Unlock()
+
+	fmt.Println()
+	fmt.Println("Storage summary:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  SINK\tSTATUS\tWRITTEN\tFAILED\tLAST_ERROR")
+	for _, s := range sinks {
+		lastError := s.LastError
+		if lastError == "" {
+			lastError = "-"
+		}
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%d\t%d\t%s\n", s.Sink, s.Status, s.Written, s.Failed, lastError)
+	}
+	_ = w.Flush()
+}
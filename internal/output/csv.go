@@ -35,3 +35,222 @@ func (p *Printer) printKPIsCSV(records []KPIRecord) error {
 	return w.Error()
 }
 
+func (p *Printer) printErrorsCSV(records []ErrorRecord) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	if err := w.Write([]string{"cluster", "kpi_id", "error_count", "retry_count"}); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		row := []string{r.Cluster, r.KPIID, strconv.Itoa(r.ErrorCount), strconv.Itoa(r.RetryCount)}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
+
+func (p *Printer) printErrorEventsCSV(records []ErrorEventRecord) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	header := []string{"id", "occurred_at", "cluster", "kpi_id", "query_type", "promquery",
+		"http_status", "error_message", "warnings", "attempt", "retried"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		status := ""
+		if r.HTTPStatus != 0 {
+			status = strconv.Itoa(r.HTTPStatus)
+		}
+		warnings := ""
+		if len(r.Warnings) > 0 {
+			warningsJSON, _ := json.Marshal(r.Warnings)
+			warnings = string(warningsJSON)
+		}
+		row := []string{
+			strconv.FormatInt(r.ID, 10),
+			r.OccurredAt.Format("2006-01-02 15:04:05"),
+			r.Cluster,
+			r.KPIID,
+			r.QueryType,
+			r.PromQuery,
+			status,
+			r.Message,
+			warnings,
+			strconv.Itoa(r.Attempt),
+			strconv.FormatBool(r.Retried),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
+
+func (p *Printer) printRunsCSV(records []RunRecord) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	header := []string{"run_id", "cluster", "started_at", "ended_at", "frequency_seconds", "duration_seconds",
+		"samples", "failed_queries", "retries", "tool_version", "kpis_file_hash", "shutdown_reason"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		endedAt := ""
+		if r.EndedAt != nil {
+			endedAt = r.EndedAt.Format("2006-01-02 15:04:05")
+		}
+		row := []string{
+			strconv.FormatInt(r.ID, 10),
+			r.Cluster,
+			r.StartedAt.Format("2006-01-02 15:04:05"),
+			endedAt,
+			strconv.FormatFloat(r.FrequencySeconds, 'f', -1, 64),
+			strconv.FormatFloat(r.DurationSeconds, 'f', -1, 64),
+			strconv.FormatInt(r.Samples, 10),
+			strconv.FormatInt(r.FailedQueries, 10),
+			strconv.FormatInt(r.Retries, 10),
+			r.ToolVersion,
+			r.KPIsFileHash,
+			r.ShutdownReason,
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
+
+func (p *Printer) printDefinitionsCSV(records []DefinitionRecord) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	header := []string{"id", "kpi_id", "definition_hash", "promquery", "resolved_promquery", "query_type",
+		"range_step", "range_since", "range_until", "frequency_seconds", "run_once", "first_seen", "runs", "last_run_id"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		row := []string{
+			strconv.FormatInt(r.ID, 10),
+			r.KPIID,
+			r.Hash,
+			r.PromQuery,
+			r.ResolvedPromQuery,
+			r.QueryType,
+			r.RangeStep,
+			r.RangeSince,
+			r.RangeUntil,
+			strconv.FormatFloat(r.FrequencySeconds, 'f', -1, 64),
+			strconv.FormatBool(r.RunOnce),
+			r.FirstSeen.Format("2006-01-02 15:04:05"),
+			strconv.FormatInt(r.Runs, 10),
+			strconv.FormatInt(r.LastRunID, 10),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
+
+func (p *Printer) printAggregatesCSV(report AggregateReport) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	header := []string{"kpi_name", "cluster"}
+	if report.Bucketed {
+		header = append(header, "bucket_start")
+	}
+	header = append(header, report.GroupBy...)
+	header = append(header, report.Aggregates...)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range report.Records {
+		row := []string{r.KPIName, r.Cluster}
+		if report.Bucketed {
+			row = append(row, strconv.FormatInt(r.BucketStart.Unix(), 10))
+		}
+		for _, label := range report.GroupBy {
+			row = append(row, r.Labels[label])
+		}
+		for _, aggregate := range report.Aggregates {
+			row = append(row, aggregateString(r, aggregate))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
+
+func (p *Printer) printComparisonCSV(report ComparisonReport) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	header := []string{"kpi_name", "labels", "statistic", "baseline", "candidate", "delta", "relative_delta",
+		"status", "baseline_count", "candidate_count"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range report.Records {
+		labelsJSON, _ := json.Marshal(r.Labels)
+		baseline, candidate, delta, _ := comparisonValues(report, r)
+		relative, baselineCount, candidateCount := "", "", ""
+		if r.RelativeDelta != nil {
+			relative = strconv.FormatFloat(*r.RelativeDelta, 'f', 6, 64)
+		}
+		if r.Baseline != nil {
+			baselineCount = strconv.FormatInt(r.Baseline.Count, 10)
+		}
+		if r.Candidate != nil {
+			candidateCount = strconv.FormatInt(r.Candidate.Count, 10)
+		}
+		row := []string{r.KPIName, string(labelsJSON), report.Statistic, baseline, candidate, delta, relative,
+			r.Status, baselineCount, candidateCount}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
+
+func (p *Printer) printEvaluationCSV(report EvaluationReport) error {
+	w := csv.NewWriter(p.writer)
+	defer w.Flush()
+
+	if err := w.Write([]string{"kpi_name", "labels", "threshold", "value", "samples", "status"}); err != nil {
+		return err
+	}
+
+	for _, r := range report.Records {
+		labelsJSON := ""
+		if r.Labels != nil {
+			encoded, _ := json.Marshal(r.Labels)
+			labelsJSON = string(encoded)
+		}
+		row := []string{r.KPIName, labelsJSON, r.Threshold, evaluationValue(r), strconv.Itoa(r.Samples), r.Status}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
@@ -5,7 +5,11 @@ import (
 )
 
 func (p *Printer) printKPIsJSON(records []KPIRecord) error {
+	return p.printJSON(records)
+}
+
+func (p *Printer) printJSON(v any) error {
 	encoder := json.NewEncoder(p.writer)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(records)
+	return encoder.Encode(v)
 }
@@ -0,0 +1,38 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (p *Printer) printComparisonMarkdown(report ComparisonReport) error {
+	_, _ = fmt.Fprintf(p.writer, "**Baseline:** %s  \n**Candidate:** %s\n\n",
+		markdownEscape(report.Baseline), markdownEscape(report.Candidate))
+
+	_, _ = fmt.Fprintf(p.writer, "| KPI | Labels | Baseline %[1]s | Candidate %[1]s | Delta | Delta %% | Status |\n", report.Statistic)
+	_, _ = fmt.Fprintln(p.writer, "|---|---|---:|---:|---:|---:|---|")
+	for _, r := range report.Records {
+		baseline, candidate, delta, relative := comparisonValues(report, r)
+		status := r.Status
+		if status == ComparisonRegression {
+			status = "**" + status + "**"
+		}
+		_, _ = fmt.Fprintf(p.writer, "| %s | `%s` | %s | %s | %s | %s | %s |\n",
+			markdownEscape(r.KPIName), markdownCodeEscape(labelsString(r.Labels)),
+			baseline, candidate, delta, relative, status)
+	}
+
+	_, _ = fmt.Fprintf(p.writer, "\n%d series, %d regression(s) beyond %g%% on %s\n",
+		len(report.Records), report.Regressions, report.Tolerance, report.Statistic)
+	return nil
+}
+
+// markdownEscape escapes the characters that would break a Markdown table cell
+func markdownEscape(value string) string {
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`).Replace(value)
+}
+
+// markdownCodeEscape escapes a Markdown table cell rendered as a code span
+func markdownCodeEscape(value string) string {
+	return strings.NewReplacer("`", "'", "|", `\|`).Replace(value)
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,6 +19,8 @@ const (
 	FormatTable Format = "table"
 	FormatJSON  Format = "json"
 	FormatCSV   Format = "csv"
+	// FormatMarkdown is only supported by comparisons, see ParseComparisonFormat
+	FormatMarkdown Format = "markdown"
 )
 
 // ParseFormat converts a string to a Format, returning an error if invalid
@@ -32,6 +37,19 @@ func ParseFormat(s string) (Format, error) {
 	}
 }
 
+// ParseComparisonFormat is ParseFormat for comparisons, which can also be
+// rendered as a Markdown table for pull requests and reports
+func ParseComparisonFormat(s string) (Format, error) {
+	if s == "markdown" || s == "md" {
+		return FormatMarkdown, nil
+	}
+	format, err := ParseFormat(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid output format %q: must be table, json, csv, or markdown", s)
+	}
+	return format, nil
+}
+
 // KPIRecord represents a single KPI metric for output
 type KPIRecord struct {
 	ID            int64             `json:"id"`
@@ -52,10 +70,172 @@ type ClusterRecord struct {
 	TotalMetrics int64     `json:"total_metrics"`
 }
 
+// TransferRecord counts the rows of one table moved by 'db import' or 'db copy'
+type TransferRecord struct {
+	Table   string `json:"table"`
+	Read    int64  `json:"read"`    // rows in the archive or source database
+	Written int64  `json:"written"` // rows inserted into the target database
+}
+
 // ErrorRecord represents a query error record for output
 type ErrorRecord struct {
+	Cluster    string `json:"cluster"`
 	KPIID      string `json:"kpi_id"`
 	ErrorCount int    `json:"error_count"`
+	RetryCount int    `json:"retry_count"`
+}
+
+// ErrorEventRecord represents the details of one failed query attempt for output
+type ErrorEventRecord struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Cluster    string    `json:"cluster"`
+	KPIID      string    `json:"kpi_id"`
+	QueryType  string    `json:"query_type"`
+	PromQuery  string    `json:"promquery"`
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Message    string    `json:"error_message"`
+	Warnings   []string  `json:"warnings,omitempty"`
+	Attempt    int       `json:"attempt"`
+	Retried    bool      `json:"retried"`
+}
+
+// RunRecord represents a collection run for output
+type RunRecord struct {
+	ID               int64      `json:"run_id"`
+	Cluster          string     `json:"cluster"`
+	StartedAt        time.Time  `json:"started_at"`
+	EndedAt          *time.Time `json:"ended_at,omitempty"` // nil while running or after a crash
+	FrequencySeconds float64    `json:"frequency_seconds"`  // 0 for single runs
+	DurationSeconds  float64    `json:"duration_seconds"`   // 0 for single runs
+	KPIsFileHash     string     `json:"kpis_file_hash"`
+	ToolVersion      string     `json:"tool_version"`
+	ShutdownReason   string     `json:"shutdown_reason"`
+	FailedQueries    int64      `json:"failed_queries"`
+	Retries          int64      `json:"retries"`
+	Samples          int64      `json:"samples"`
+}
+
+// DefinitionRecord represents one version of a KPI definition for output
+type DefinitionRecord struct {
+	ID                int64     `json:"id"`
+	KPIID             string    `json:"kpi_id"`
+	Hash              string    `json:"definition_hash"`
+	PromQuery         string    `json:"promquery"`
+	ResolvedPromQuery string    `json:"resolved_promquery"`
+	QueryType         string    `json:"query_type"`
+	RangeStep         string    `json:"range_step,omitempty"`
+	RangeSince        string    `json:"range_since,omitempty"`
+	RangeUntil        string    `json:"range_until,omitempty"`
+	FrequencySeconds  float64   `json:"frequency_seconds"`
+	RunOnce           bool      `json:"run_once"`
+	FirstSeen         time.Time `json:"first_seen"`
+	Runs              int64     `json:"runs"`
+	LastRunID         int64     `json:"last_run_id"`
+}
+
+// AggregateRecord represents the aggregated values of one group of KPI samples
+type AggregateRecord struct {
+	KPIName     string             `json:"kpi_name"`
+	Cluster     string             `json:"cluster"`
+	BucketStart *time.Time         `json:"bucket_start,omitempty"` // nil without time buckets
+	Labels      map[string]string  `json:"labels,omitempty"`       // the group-by labels, "" when missing
+	Values      map[string]float64 `json:"values"`                 // by aggregate name, absent when undefined
+}
+
+// AggregateReport holds aggregated KPI values with the column order for output
+type AggregateReport struct {
+	Aggregates []string // aggregate names, in column order
+	GroupBy    []string // group-by label names, in column order
+	Bucketed   bool     // whether records have a BucketStart
+	Records    []AggregateRecord
+}
+
+// ComparisonStats summarizes the samples of one series on one side of a comparison
+type ComparisonStats struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Statistic returns the named statistic: min, avg, p50, p95, p99 or max
+func (s ComparisonStats) Statistic(name string) float64 {
+	switch name {
+	case "min":
+		return s.Min
+	case "p50":
+		return s.P50
+	case "p95":
+		return s.P95
+	case "p99":
+		return s.P99
+	case "max":
+		return s.Max
+	default:
+		return s.Avg
+	}
+}
+
+// Comparison statuses of a series
+const (
+	ComparisonOK          = "ok"
+	ComparisonRegression  = "regression"
+	ComparisonImprovement = "improvement"
+	ComparisonMissing     = "missing" // only in the baseline
+	ComparisonNew         = "new"     // only in the candidate
+)
+
+// ComparisonRecord compares one series between the baseline and the candidate
+type ComparisonRecord struct {
+	KPIName   string            `json:"kpi_name"`
+	Labels    map[string]string `json:"labels"`
+	Baseline  *ComparisonStats  `json:"baseline,omitempty"`  // nil when the series is new
+	Candidate *ComparisonStats  `json:"candidate,omitempty"` // nil when the series is missing
+	// Delta is the candidate minus the baseline value of the compared statistic
+	Delta *float64 `json:"delta,omitempty"`
+	// RelativeDelta is Delta relative to the baseline value, nil when it is 0
+	RelativeDelta *float64 `json:"relative_delta,omitempty"`
+	Status        string   `json:"status"`
+}
+
+// ComparisonReport holds the comparison of the series of a baseline and a candidate
+type ComparisonReport struct {
+	Baseline       string             `json:"baseline"`
+	Candidate      string             `json:"candidate"`
+	Statistic      string             `json:"statistic"`
+	Tolerance      float64            `json:"tolerance_percent"`
+	HigherIsBetter bool               `json:"higher_is_better"`
+	Regressions    int                `json:"regressions"`
+	Records        []ComparisonRecord `json:"series"`
+}
+
+// Evaluation statuses of a threshold check
+const (
+	EvaluationPass   = "pass"
+	EvaluationFail   = "fail"
+	EvaluationNoData = "no_data" // the KPI has thresholds but no samples
+)
+
+// EvaluationRecord is the check of one threshold of a series
+type EvaluationRecord struct {
+	KPIName   string            `json:"kpi_name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Threshold string            `json:"threshold,omitempty"` // e.g. "p99 <= 0.7", "" without data
+	Value     *float64          `json:"value,omitempty"`     // the bounded statistic, nil without data
+	Samples   int               `json:"samples"`
+	Status    string            `json:"status"`
+}
+
+// EvaluationReport holds the threshold checks of the KPIs of a cluster or run
+type EvaluationReport struct {
+	Scope    string             `json:"scope"`
+	Passed   bool               `json:"passed"`
+	Failures int                `json:"failures"`
+	Records  []EvaluationRecord `json:"checks"`
 }
 
 // Printer handles output formatting
@@ -97,3 +277,141 @@ func (p *Printer) PrintKPIs(records []KPIRecord) error {
 	}
 }
 
+// PrintErrors outputs per-KPI error and retry counts in the configured format
+func (p *Printer) PrintErrors(records []ErrorRecord) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(records)
+	case FormatCSV:
+		return p.printErrorsCSV(records)
+	default:
+		return p.printErrorsTable(records)
+	}
+}
+
+// PrintErrorEvents outputs failed query attempts in the configured format
+func (p *Printer) PrintErrorEvents(records []ErrorEventRecord) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(records)
+	case FormatCSV:
+		return p.printErrorEventsCSV(records)
+	default:
+		return p.printErrorEventsTable(records)
+	}
+}
+
+// PrintRuns outputs collection runs in the configured format
+func (p *Printer) PrintRuns(records []RunRecord) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(records)
+	case FormatCSV:
+		return p.printRunsCSV(records)
+	default:
+		return p.printRunsTable(records)
+	}
+}
+
+// PrintDefinitions outputs KPI definitions in the configured format
+func (p *Printer) PrintDefinitions(records []DefinitionRecord) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(records)
+	case FormatCSV:
+		return p.printDefinitionsCSV(records)
+	default:
+		return p.printDefinitionsTable(records)
+	}
+}
+
+// PrintAggregates outputs aggregated KPI values in the configured format
+func (p *Printer) PrintAggregates(report AggregateReport) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(report.Records)
+	case FormatCSV:
+		return p.printAggregatesCSV(report)
+	default:
+		return p.printAggregatesTable(report)
+	}
+}
+
+// PrintComparison outputs a comparison in the configured format
+func (p *Printer) PrintComparison(report ComparisonReport) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(report)
+	case FormatCSV:
+		return p.printComparisonCSV(report)
+	case FormatMarkdown:
+		return p.printComparisonMarkdown(report)
+	default:
+		return p.printComparisonTable(report)
+	}
+}
+
+// PrintEvaluation outputs a threshold evaluation in the configured format
+func (p *Printer) PrintEvaluation(report EvaluationReport) error {
+	switch p.format {
+	case FormatJSON:
+		return p.printJSON(report)
+	case FormatCSV:
+		return p.printEvaluationCSV(report)
+	default:
+		return p.printEvaluationTable(report)
+	}
+}
+
+// evaluationValue formats the statistic value of a check, "" without data
+func evaluationValue(r EvaluationRecord) string {
+	if r.Value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*r.Value, 'f', 6, 64)
+}
+
+// comparisonValues formats the compared statistic of both sides and the
+// deltas of a comparison record, "" when undefined
+func comparisonValues(report ComparisonReport, r ComparisonRecord) (baseline, candidate, delta, relative string) {
+	if r.Baseline != nil {
+		baseline = strconv.FormatFloat(r.Baseline.Statistic(report.Statistic), 'f', 6, 64)
+	}
+	if r.Candidate != nil {
+		candidate = strconv.FormatFloat(r.Candidate.Statistic(report.Statistic), 'f', 6, 64)
+	}
+	if r.Delta != nil {
+		delta = strconv.FormatFloat(*r.Delta, 'f', 6, 64)
+	}
+	if r.RelativeDelta != nil {
+		relative = fmt.Sprintf("%+.2f%%", *r.RelativeDelta*100)
+	}
+	return baseline, candidate, delta, relative
+}
+
+// labelsString formats labels as a sorted, PromQL-like {key="value"} list
+func labelsString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", key, labels[key])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// aggregateString formats an aggregated value, "" when it is undefined
+func aggregateString(record AggregateRecord, aggregate string) string {
+	value, ok := record.Values[aggregate]
+	if !ok {
+		return ""
+	}
+	if aggregate == "count" {
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
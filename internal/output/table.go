@@ -3,7 +3,9 @@ package output
 import (
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/dustin/go-humanize"
 )
@@ -71,14 +73,239 @@ func PrintClustersTable(records []ClusterRecord) {
 	_ = w.Flush()
 }
 
-// PrintErrorsTable prints error records as a table to stdout
-func PrintErrorsTable(records []ErrorRecord) {
+// PrintTransferTable prints per-table transfer counts as a table to stdout.
+// Rows read but not written were already in the target database.
+func PrintTransferTable(records []TransferRecord) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	_, _ = fmt.Fprintln(w, "KPI_ID\tERROR_COUNT")
-	_, _ = fmt.Fprintln(w, "---\t---")
+	_, _ = fmt.Fprintln(w, "TABLE\tREAD\tWRITTEN\tSKIPPED_DUPLICATES")
+	_, _ = fmt.Fprintln(w, "---\t---\t---\t---")
+
+	for _, r := range records {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Table,
+			humanize.Comma(r.Read), humanize.Comma(r.Written), humanize.Comma(r.Read-r.Written))
+	}
+	_ = w.Flush()
+}
+
+func (p *Printer) printErrorsTable(records []ErrorRecord) error {
+	w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CLUSTER\tKPI_ID\tERROR_COUNT\tRETRY_COUNT")
+	_, _ = fmt.Fprintln(w, "---\t---\t---\t---")
 
 	for _, e := range records {
-		_, _ = fmt.Fprintf(w, "%s\t%d\n", e.KPIID, e.ErrorCount)
+		cluster := e.Cluster
+		if cluster == "" {
+			cluster = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", cluster, e.KPIID, e.ErrorCount, e.RetryCount)
+	}
+	return w.Flush()
+}
+
+func (p *Printer) printErrorEventsTable(records []ErrorEventRecord) error {
+	w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tOCCURRED_AT\tCLUSTER\tKPI_ID\tSTATUS\tATTEMPT\tRETRIED\tERROR")
+	_, _ = fmt.Fprintln(w, "---\t---\t---\t---\t---\t---\t---\t---")
+
+	for _, r := range records {
+		status := "-"
+		if r.HTTPStatus != 0 {
+			status = fmt.Sprintf("%d", r.HTTPStatus)
+		}
+		message := r.Message
+		if !p.noTruncate && len(message) > 60 {
+			message = message[:57] + "..."
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%t\t%s\n",
+			r.ID, r.OccurredAt.Format("2006-01-02 15:04:05"), r.Cluster, r.KPIID,
+			status, r.Attempt, r.Retried, message)
+
+		if p.noTruncate {
+			// Print the query and warnings below the entry
+			_ = w.Flush()
+			_, _ = fmt.Fprintf(p.writer, "  Query (%s): %s\n", r.QueryType, r.PromQuery)
+			for _, warning := range r.Warnings {
+				_, _ = fmt.Fprintf(p.writer, "  Warning: %s\n", warning)
+			}
+			_, _ = fmt.Fprintln(p.writer)
+		}
+	}
+	_ = w.Flush()
+
+	_, _ = fmt.Fprintf(p.writer, "\nTotal errors: %d\n", len(records))
+	return nil
+}
+
+func (p *Printer) printRunsTable(records []RunRecord) error {
+	w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "RUN_ID\tCLUSTER\tSTARTED_AT\tENDED_AT\tFREQUENCY\tDURATION\tSAMPLES\tFAILED\tRETRIES\tVERSION\tKPIS_HASH\tSHUTDOWN_REASON")
+	_, _ = fmt.Fprintln(w, "---\t---\t---\t---\t---\t---\t---\t---\t---\t---\t---\t---")
+
+	for _, r := range records {
+		endedAt, reason := "-", r.ShutdownReason
+		if r.EndedAt != nil {
+			endedAt = r.EndedAt.Format("2006-01-02 15:04:05")
+		} else {
+			reason = "(not finished)"
+		}
+		frequency, duration := "once", "-"
+		if r.FrequencySeconds > 0 {
+			frequency = secondsString(r.FrequencySeconds)
+			duration = secondsString(r.DurationSeconds)
+		}
+		hash := r.KPIsFileHash
+		if hash == "" {
+			hash = "-"
+		} else if !p.noTruncate && len(hash) > 12 {
+			hash = hash[:12]
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			r.ID, r.Cluster, r.StartedAt.Format("2006-01-02 15:04:05"), endedAt,
+			frequency, duration, humanize.Comma(r.Samples), r.FailedQueries, r.Retries,
+			r.ToolVersion, hash, reason)
+	}
+	_ = w.Flush()
+
+	_, _ = fmt.Fprintf(p.writer, "\nTotal runs: %d\n", len(records))
+	return nil
+}
+
+func (p *Printer) printDefinitionsTable(records []DefinitionRecord) error {
+	for i, r := range records {
+		if i > 0 {
+			_, _ = fmt.Fprintln(p.writer)
+		}
+
+		w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tKPI_ID\tHASH\tQUERY_TYPE\tFREQUENCY\tRUNS\tLAST_RUN\tFIRST_SEEN")
+		hash := r.Hash
+		if !p.noTruncate && len(hash) > 12 {
+			hash = hash[:12]
+		}
+		frequency := "-"
+		if r.RunOnce {
+			frequency = "run-once"
+		} else if r.FrequencySeconds > 0 {
+			frequency = secondsString(r.FrequencySeconds)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			r.ID, r.KPIID, hash, r.QueryType, frequency, r.Runs, r.LastRunID,
+			r.FirstSeen.Format("2006-01-02 15:04:05"))
+		_ = w.Flush()
+
+		// The queries are printed in full below the entry
+		_, _ = fmt.Fprintf(p.writer, "  PromQL:   %s\n", r.PromQuery)
+		if r.ResolvedPromQuery != r.PromQuery {
+			_, _ = fmt.Fprintf(p.writer, "  Resolved: %s\n", r.ResolvedPromQuery)
+		}
+		if r.RangeStep != "" {
+			until := r.RangeUntil
+			if until == "" {
+				until = "now"
+			}
+			_, _ = fmt.Fprintf(p.writer, "  Range:    since %s until %s, step %s\n", r.RangeSince, until, r.RangeStep)
+		}
+	}
+
+	_, _ = fmt.Fprintf(p.writer, "\nTotal definitions: %d\n", len(records))
+	return nil
+}
+
+// secondsString renders a number of seconds as a Go duration string, e.g. "1m30s"
+func (p *Printer) printAggregatesTable(report AggregateReport) error {
+	w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+
+	header := []string{"KPI_NAME", "CLUSTER"}
+	if report.Bucketed {
+		header = append(header, "BUCKET_START")
+	}
+	header = append(header, report.GroupBy...)
+	for _, aggregate := range report.Aggregates {
+		header = append(header, strings.ToUpper(aggregate))
+	}
+	_, _ = fmt.Fprintln(w, strings.Join(header, "\t"))
+	_, _ = fmt.Fprintln(w, strings.TrimSuffix(strings.Repeat("---\t", len(header)), "\t"))
+
+	for _, r := range report.Records {
+		row := []string{r.KPIName, r.Cluster}
+		if report.Bucketed {
+			row = append(row, r.BucketStart.UTC().Format(time.RFC3339))
+		}
+		for _, label := range report.GroupBy {
+			row = append(row, r.Labels[label])
+		}
+		for _, aggregate := range report.Aggregates {
+			value := aggregateString(r, aggregate)
+			if value == "" {
+				value = "-"
+			}
+			row = append(row, value)
+		}
+		_, _ = fmt.Fprintln(w, strings.Join(row, "\t"))
 	}
 	_ = w.Flush()
+
+	_, _ = fmt.Fprintf(p.writer, "\nTotal groups: %d\n", len(report.Records))
+	return nil
+}
+
+func (p *Printer) printComparisonTable(report ComparisonReport) error {
+	_, _ = fmt.Fprintf(p.writer, "Baseline:  %s\nCandidate: %s\n\n", report.Baseline, report.Candidate)
+
+	w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+	stat := strings.ToUpper(report.Statistic)
+	_, _ = fmt.Fprintf(w, "KPI_NAME\tLABELS\tBASELINE_%s\tCANDIDATE_%s\tDELTA\tDELTA_%%\tSTATUS\n", stat, stat)
+	_, _ = fmt.Fprintln(w, "---\t---\t---\t---\t---\t---\t---")
+
+	for _, r := range report.Records {
+		labels := labelsString(r.Labels)
+		if !p.noTruncate && len(labels) > 50 {
+			labels = labels[:47] + "..."
+		}
+		baseline, candidate, delta, relative := comparisonValues(report, r)
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.KPIName, labels,
+			dashIfEmpty(baseline), dashIfEmpty(candidate), dashIfEmpty(delta), dashIfEmpty(relative), r.Status)
+	}
+	_ = w.Flush()
+
+	_, _ = fmt.Fprintf(p.writer, "\nTotal series: %d, regressions beyond %g%%: %d\n",
+		len(report.Records), report.Tolerance, report.Regressions)
+	return nil
+}
+
+func (p *Printer) printEvaluationTable(report EvaluationReport) error {
+	_, _ = fmt.Fprintf(p.writer, "Scope: %s\n\n", report.Scope)
+
+	w := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KPI_NAME\tLABELS\tTHRESHOLD\tVALUE\tSAMPLES\tSTATUS")
+	_, _ = fmt.Fprintln(w, "---\t---\t---\t---\t---\t---")
+
+	for _, r := range report.Records {
+		labels := labelsString(r.Labels)
+		if !p.noTruncate && len(labels) > 50 {
+			labels = labels[:47] + "..."
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", r.KPIName, labels,
+			dashIfEmpty(r.Threshold), dashIfEmpty(evaluationValue(r)), r.Samples, strings.ToUpper(r.Status))
+	}
+	_ = w.Flush()
+
+	result := "PASSED"
+	if !report.Passed {
+		result = "FAILED"
+	}
+	_, _ = fmt.Fprintf(p.writer, "\n%s: %d check(s), %d failed\n", result, len(report.Records), report.Failures)
+	return nil
+}
+
+// dashIfEmpty returns "-" for an empty table cell
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func secondsString(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).String()
 }
@@ -7,18 +7,20 @@ package prometheus
 import (
 	"context"
 	"crypto/tls"
-	"database/sql"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/sink"
 
 	"github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
@@ -26,9 +28,13 @@ import (
 )
 
 const (
-	// queryTimeoutPerKPI is the maximum time allowed for each individual KPI query.
-	// The total context timeout is calculated as: numberOfQueries * queryTimeoutPerKPI
-	queryTimeoutPerKPI = 5 * time.Second
+	// defaultQueryTimeout is the maximum time allowed for each individual KPI query.
+	// It can be overridden per KPI with the `timeout` field in the KPIs file.
+	defaultQueryTimeout = 5 * time.Second
+
+	// DefaultMaxConcurrentQueries is the default number of queries executed in
+	// parallel within one frequency group.
+	DefaultMaxConcurrentQueries = 4
 )
 
 // setupPromClient creates and configures a Prometheus API client.
@@ -56,104 +62,111 @@ func setupPromClient(prometheusURL, bearerToken string, insecureTLS bool) (promv
 	return promv1.NewAPI(client), nil
 }
 
-// RunQueries executes all Prometheus queries and stores results in database
-func RunQueries(kpisToRun config.KPIs, flags config.InputFlags, sampleNumber int, totalSamples int, frequency time.Duration) error {
-	// Initialize Database based on configuration
-	db, dbImpl, err := database.InitDatabaseWithConfig(flags.DatabaseType, flags.PostgresURL)
-	if err != nil {
-		return fmt.Errorf("failed to init database: %v", err)
-	}
-	defer func() {
-		if closeErr := db.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close database: %v\n", closeErr)
-		}
-	}()
-
-	// Get or create cluster in DB
-	clusterID, err := dbImpl.GetOrCreateCluster(db, flags.ClusterName, flags.ClusterType)
-	if err != nil {
-		return fmt.Errorf("failed to get cluster ID: %v", err)
-	}
+// RunQueries executes all Prometheus queries and stores results in the session database.
+// Queries run concurrently on a bounded worker pool, each attempt under its own timeout,
+// so a single slow query cannot starve the rest of the group. Transient failures are
+// retried according to the session's retry policy or the KPI's retry override.
+// Cancelling ctx, on shutdown, aborts the queries in flight and their retries and
+// skips the queries not started yet; neither counts as failed.
+func (s *Session) RunQueries(ctx context.Context, kpisToRun config.KPIs, sampleNumber int, totalSamples int, frequency time.Duration) error {
+	var (
+		failedCount atomic.Int32
+		wg          sync.WaitGroup
+	)
+	workers := make(chan struct{}, s.maxConcurrentQueries)
 
-	// Create Prometheus client
-	v1api, err := setupPromClient(flags.ThanosURL, flags.BearerToken, flags.InsecureTLS)
-	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
-	}
+queries:
+	for _, query := range kpisToRun.Queries {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			log.Printf("Collection stopping, skipped the remaining queries of sample %d", sampleNumber)
+			break queries
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
 
-	// Execute queries with a timeout proportional to the number of queries
-	totalTimeout := time.Duration(len(kpisToRun.Queries)) * queryTimeoutPerKPI
-	ctx, cancel := context.WithTimeout(context.Background(), totalTimeout)
-	defer cancel()
+			// Resolve the range window only once a worker is free, so queued
+			// range queries do not run against a stale "now"
+			queryInfo := buildQueryInfo(query, sampleNumber, totalSamples, frequency, s.retry)
 
-	var failedCount int
-	for _, query := range kpisToRun.Queries {
-		queryInfo := output.QueryInfo{
-			QueryID:      query.ID,
-			PromQuery:    query.PromQuery,
-			Frequency:    frequency,
-			SampleNumber: sampleNumber,
-			TotalSamples: totalSamples,
-			QueryType:    query.GetEffectiveQueryType(),
-		}
-		if query.Range != nil {
-			now := time.Now()
-			if query.Range.Step != nil {
-				queryInfo.Step = query.Range.Step.Duration
-			}
-			if query.Range.Since != nil {
-				queryInfo.Since = query.Range.Since.Resolve(now)
-			}
-			if query.Range.Until != nil {
-				queryInfo.Until = query.Range.Until.Resolve(now)
-			} else {
-				queryInfo.Until = now
+			if !s.executeQuery(ctx, queryInfo) {
+				failedCount.Add(1)
 			}
-		}
-		if !executeQuery(ctx, v1api, db, dbImpl, clusterID, queryInfo) {
-			failedCount++
-		}
+		}()
 	}
+	wg.Wait()
 
-	if failedCount > 0 {
-		return fmt.Errorf("%d of %d queries failed", failedCount, len(kpisToRun.Queries))
+	if failed := failedCount.Load(); failed > 0 {
+		return fmt.Errorf("%d of %d queries failed", failed, len(kpisToRun.Queries))
 	}
 
 	return nil
 }
 
-// executeQuery executes a single Prometheus query, handles the result,
-// and returns true if the query succeeded.
-func executeQuery(ctx context.Context, v1api promv1.API, db *sql.DB, dbImpl database.Database, clusterID int64, info output.QueryInfo) bool {
-	now := time.Now()
+// buildQueryInfo resolves a KPI query into the execution details used for
+// querying and printing, including the concrete range window for range queries
+// and the effective timeout and retry policy.
+func buildQueryInfo(query config.Query, sampleNumber int, totalSamples int, frequency time.Duration, defaultRetry config.RetryPolicy) output.QueryInfo {
+	retry := query.GetEffectiveRetryPolicy(defaultRetry)
+	queryInfo := output.QueryInfo{
+		QueryID:      query.ID,
+		PromQuery:    query.PromQuery,
+		Frequency:    frequency,
+		SampleNumber: sampleNumber,
+		TotalSamples: totalSamples,
+		QueryType:    query.GetEffectiveQueryType(),
+		Timeout:      query.GetEffectiveTimeout(defaultQueryTimeout),
+		MaxRetries:   retry.MaxRetries,
+		RetryBackoff: retry.Backoff,
+	}
+	if query.Range != nil {
+		now := time.Now()
+		if query.Range.Step != nil {
+			queryInfo.Step = query.Range.Step.Duration
+		}
+		if query.Range.Since != nil {
+			queryInfo.Since = query.Range.Since.Resolve(now)
+		}
+		if query.Range.Until != nil {
+			queryInfo.Until = query.Range.Until.Resolve(now)
+		} else {
+			queryInfo.Until = now
+		}
+	}
 
-	var (
-		result   model.Value
-		warnings promv1.Warnings
-		err      error
-	)
+	return queryInfo
+}
 
-	// Execute query using the Prometheus client library
-	if info.QueryType == "range" {
-		queryRange := promv1.Range{
-			Start: info.Since,
-			End:   info.Until,
-			Step:  info.Step,
-		}
-		result, warnings, err = v1api.QueryRange(ctx, info.PromQuery, queryRange)
-	} else {
-		result, warnings, err = v1api.Query(ctx, info.PromQuery, now)
+// executeQuery executes a single Prometheus query (retrying transient failures),
+// handles the result, and returns false if the query failed. A query cancelled
+// with ctx is only logged.
+func (s *Session) executeQuery(ctx context.Context, info output.QueryInfo) bool {
+	result, warnings, retries, err := s.queryWithRetry(ctx, info)
+	if err != nil && ctx.Err() != nil {
+		log.Printf("[%s] Query cancelled by shutdown: %v", info.QueryID, err)
+		return true
 	}
 
 	queryResult := output.QueryResult{
 		Warnings: warnings,
 	}
+	if retries > 0 {
+		if err != nil {
+			queryResult.Notes = append(queryResult.Notes, fmt.Sprintf("Note: gave up after %d attempt(s)", retries+1))
+		} else {
+			queryResult.Notes = append(queryResult.Notes, fmt.Sprintf("Note: succeeded after %d retry attempt(s)", retries))
+		}
+	}
 
 	if err != nil {
 		queryResult.Success = false
 		queryResult.Error = err
 		output.PrintQueryResult(info, queryResult)
-		if storeErr := dbImpl.IncrementQueryError(db, info.QueryID); storeErr != nil {
+		s.failedQueries.Add(1)
+		if storeErr := s.dbImpl.IncrementQueryError(s.db, s.clusterID, info.QueryID); storeErr != nil {
 			fmt.Fprintf(os.Stderr, "Failed to increment error count: %v\n", storeErr)
 		}
 		return false
@@ -166,35 +179,146 @@ func executeQuery(ctx context.Context, v1api promv1.API, db *sql.DB, dbImpl data
 	// Check if anything remains to store
 	if isEmptyResult(result) {
 		queryResult.Success = true
-		output.PrintQueryResult(info, queryResult)
 		if nanCount > 0 {
-			fmt.Printf("  Warning: all %d sample(s) were NaN — nothing stored\n", nanCount)
+			queryResult.Notes = append(queryResult.Notes, fmt.Sprintf("Warning: all %d sample(s) were NaN — nothing stored", nanCount))
 			log.Printf("[%s] All %d sample(s) were NaN, nothing stored: %s", info.QueryID, nanCount, info.PromQuery)
 		} else {
-			fmt.Printf("  Warning: query returned no data (metric may not exist on this cluster)\n")
+			queryResult.Notes = append(queryResult.Notes, "Warning: query returned no data (metric may not exist on this cluster)")
 			log.Printf("[%s] Query returned no data: %s", info.QueryID, info.PromQuery)
 		}
+		output.PrintQueryResult(info, queryResult)
 		return true
 	}
 
-	// Store results
-	err = dbImpl.StoreQueryResults(db, clusterID, info.QueryID, result)
+	// Store results, then write them to the sinks whether or not the
+	// database failed, so that an outage of one loses no data in the others
+	err = s.dbImpl.StoreQueryResults(s.db, s.clusterID, s.runID, info.QueryID, result)
+	if s.sinks != nil {
+		s.stored.Count(sink.SampleCount(result), err)
+		s.sinks.Write(info.QueryID, result)
+	}
 	if err != nil {
 		queryResult.Success = false
 		queryResult.Error = fmt.Errorf("failed to store: %v", err)
 		output.PrintQueryResult(info, queryResult)
+		s.failedQueries.Add(1)
 		return false
 	}
 
 	queryResult.Success = true
-	output.PrintQueryResult(info, queryResult)
 	if nanCount > 0 {
-		fmt.Printf("  Note: skipped %d NaN value(s)\n", nanCount)
+		queryResult.Notes = append(queryResult.Notes, fmt.Sprintf("Note: skipped %d NaN value(s)", nanCount))
 		log.Printf("[%s] Skipped %d NaN/Inf value(s): %s", info.QueryID, nanCount, info.PromQuery)
 	}
+	output.PrintQueryResult(info, queryResult)
+
+	s.raiseAlerts(info.QueryID, result)
 	return true
 }
 
+// raiseAlerts checks the stored samples against the KPI thresholds and
+// reports every alert raised or resolved on the console, in the log, in the
+// database and to the webhook
+func (s *Session) raiseAlerts(kpiID string, result model.Value) {
+	if s.alerts == nil {
+		return
+	}
+
+	for _, event := range s.alerts.Check(kpiID, result) {
+		output.PrintAlert(output.AlertInfo{
+			KPIID:      event.KPIID,
+			Labels:     event.Labels,
+			Threshold:  event.Threshold,
+			Value:      event.Value,
+			SampleTime: event.SampleTime,
+			Resolved:   event.State == database.AlertResolved,
+		})
+		log.Printf("[%s] Alert %s: %s (value %g, labels %v)", kpiID, event.State, event.Threshold, event.Value, event.Labels)
+
+		if err := s.dbImpl.RecordAlert(s.db, s.clusterID, s.runID, event); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record alert: %v\n", err)
+		}
+		if s.webhook != nil {
+			if err := s.webhook.Send(s.runID, event); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to send alert to the webhook: %v\n", err)
+				log.Printf("[%s] Failed to send alert to the webhook: %v", kpiID, err)
+			}
+		}
+	}
+}
+
+// queryWithRetry runs the query, retrying transient failures with exponential
+// backoff up to info.MaxRetries times. Every retry is counted in the database
+// separately from hard failures; attempts cut short by cancelling ctx are not
+// recorded. Returns the number of retries made.
+func (s *Session) queryWithRetry(ctx context.Context, info output.QueryInfo) (model.Value, promv1.Warnings, int, error) {
+	for retries := 0; ; retries++ {
+		result, warnings, err := s.queryOnce(ctx, info)
+		if err == nil {
+			return result, warnings, retries, nil
+		}
+
+		if ctx.Err() != nil {
+			return result, warnings, retries, err
+		}
+
+		retry := retries < info.MaxRetries && isRetryableError(err)
+		s.recordQueryError(info, retries+1, retry, warnings, err)
+		if !retry {
+			return result, warnings, retries, err
+		}
+
+		delay := retryDelay(info.RetryBackoff, retries+1)
+		log.Printf("[%s] Attempt %d/%d failed, retrying in %s: %v",
+			info.QueryID, retries+1, info.MaxRetries+1, delay.Round(time.Millisecond), err)
+		if !sleepContext(ctx, delay) {
+			return result, warnings, retries, err
+		}
+
+		s.retries.Add(1)
+		if storeErr := s.dbImpl.IncrementQueryRetry(s.db, s.clusterID, info.QueryID); storeErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to increment retry count: %v\n", storeErr)
+		}
+	}
+}
+
+// recordQueryError stores the details of a failed attempt for `db show errors --details`
+func (s *Session) recordQueryError(info output.QueryInfo, attempt int, retried bool, warnings promv1.Warnings, err error) {
+	event := database.QueryErrorEvent{
+		KPIID:      info.QueryID,
+		QueryType:  info.QueryType,
+		PromQuery:  info.PromQuery,
+		HTTPStatus: httpStatusOfError(err),
+		Message:    err.Error(),
+		Warnings:   warnings,
+		Attempt:    attempt,
+		Retried:    retried,
+	}
+	if storeErr := s.dbImpl.RecordQueryError(s.db, s.clusterID, event); storeErr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record query error details: %v\n", storeErr)
+	}
+}
+
+// queryOnce performs a single query attempt under the per-query timeout
+func (s *Session) queryOnce(ctx context.Context, info output.QueryInfo) (model.Value, promv1.Warnings, error) {
+	if info.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, info.Timeout)
+		defer cancel()
+	}
+
+	// Execute query using the Prometheus client library
+	if info.QueryType == "range" {
+		queryRange := promv1.Range{
+			Start: info.Since,
+			End:   info.Until,
+			Step:  info.Step,
+		}
+		return s.v1api.QueryRange(ctx, info.PromQuery, queryRange)
+	}
+	return s.v1api.Query(ctx, info.PromQuery, time.Now())
+}
+
 // isEmptyResult checks whether a Prometheus query returned no data points.
 func isEmptyResult(result model.Value) bool {
 	switch v := result.(type) {
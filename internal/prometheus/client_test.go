@@ -3,8 +3,11 @@ package prometheus
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"math"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -12,6 +15,8 @@ import (
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/alerting"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
 	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
 )
@@ -336,6 +341,10 @@ var _ = Describe("Client", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		newTestSession := func(mock *mockPromAPI) *Session {
+			return &Session{db: testDB, dbImpl: sqliteDB, clusterID: clusterID, v1api: mock, maxConcurrentQueries: 4}
+		}
+
 		AfterEach(func() {
 			if testDB != nil {
 				err := testDB.Close()
@@ -352,6 +361,24 @@ var _ = Describe("Client", func() {
 			}
 		})
 
+		It("should open one session that owns the database and cluster record", func() {
+			flags := config.InputFlags{
+				DatabaseType: "sqlite",
+				ClusterName:  "session-cluster",
+				ClusterType:  "ran",
+				ThanosURL:    "thanos.example.com",
+				BearerToken:  "token",
+			}
+
+			session, err := NewSession(flags)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session.clusterID).To(BeNumerically(">", 0))
+			Expect(session.v1api).NotTo(BeNil())
+			Expect(session.db.Ping()).To(Succeed())
+
+			Expect(session.Close()).To(Succeed())
+		})
+
 		// Test executeQuery with successful response
 		It("should successfully execute query and store results with vector response", func() {
 			// A mock client that returns a vector result (no actual server)
@@ -370,7 +397,7 @@ var _ = Describe("Client", func() {
 			// We execute a query with the mock client
 			ctx := context.Background()
 			info := output.QueryInfo{QueryID: "test-query-1", PromQuery: "up", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 4}
-			executeQuery(ctx, mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(ctx, info)
 
 			// Results should be stored in the database
 			var count int
@@ -394,7 +421,7 @@ var _ = Describe("Client", func() {
 
 			ctx := context.Background()
 			info := output.QueryInfo{QueryID: "test-query-2", PromQuery: "cpu_usage", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 4}
-			executeQuery(ctx, mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(ctx, info)
 
 			// Multiple results should be stored
 			var count int
@@ -415,7 +442,7 @@ var _ = Describe("Client", func() {
 			ctx := context.Background()
 			queryID := "test-query-error"
 			info := output.QueryInfo{QueryID: queryID, PromQuery: "invalid{query", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 4}
-			executeQuery(ctx, mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(ctx, info)
 
 			// Error count should be incremented in database
 			var errorCount int
@@ -437,7 +464,7 @@ var _ = Describe("Client", func() {
 
 			ctx := context.Background()
 			info := output.QueryInfo{QueryID: "test-query-warnings", PromQuery: "test", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 4}
-			executeQuery(ctx, mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(ctx, info)
 		})
 
 		// Test executeQuery with empty results
@@ -451,7 +478,7 @@ var _ = Describe("Client", func() {
 
 			ctx := context.Background()
 			info := output.QueryInfo{QueryID: "test-query-empty", PromQuery: "nonexistent_metric", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 4}
-			executeQuery(ctx, mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(ctx, info)
 
 			// No results should be stored
 			var count int
@@ -477,7 +504,322 @@ var _ = Describe("Client", func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 			defer cancel()
 			info := output.QueryInfo{QueryID: "test-query-timeout", PromQuery: "slow_query", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 4}
-			executeQuery(ctx, mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(ctx, info)
+		})
+
+		Describe("retries", func() {
+			// failingMock fails the first `failures` attempts with err, then succeeds
+			failingMock := func(failures int, err error) (*mockPromAPI, *atomic.Int32) {
+				var attempts atomic.Int32
+				return &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						if int(attempts.Add(1)) <= failures {
+							return nil, nil, err
+						}
+						return model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1}}, nil, nil
+					},
+				}, &attempts
+			}
+
+			counts := func(queryID string) (int, int) {
+				retries, err := sqliteDB.GetQueryRetryCount(testDB, clusterID, queryID)
+				Expect(err).NotTo(HaveOccurred())
+				errorCount, err := sqliteDB.GetQueryErrorCount(testDB, clusterID, queryID)
+				Expect(err).NotTo(HaveOccurred())
+				return retries, errorCount
+			}
+
+			retryInfo := func(queryID string, maxRetries int) output.QueryInfo {
+				return output.QueryInfo{
+					QueryID: queryID, PromQuery: "up", Frequency: 5 * time.Second, SampleNumber: 1, TotalSamples: 1,
+					MaxRetries: maxRetries, RetryBackoff: time.Millisecond,
+				}
+			}
+
+			It("should retry a 503 and store the result of the successful attempt", func() {
+				mock, attempts := failingMock(1, &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"})
+
+				Expect(newTestSession(mock).executeQuery(context.Background(), retryInfo("retry-503", 2))).To(BeTrue())
+
+				Expect(attempts.Load()).To(Equal(int32(2)))
+				retries, errorCount := counts("retry-503")
+				Expect(retries).To(Equal(1))
+				Expect(errorCount).To(Equal(0))
+
+				var stored int
+				err := testDB.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = ?", "retry-503").Scan(&stored)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stored).To(Equal(1))
+			})
+
+			It("should not retry a bad request", func() {
+				mock, attempts := failingMock(1, &v1.Error{Type: v1.ErrClient, Msg: "client error: 400"})
+
+				Expect(newTestSession(mock).executeQuery(context.Background(), retryInfo("retry-400", 2))).To(BeFalse())
+
+				Expect(attempts.Load()).To(Equal(int32(1)))
+				retries, errorCount := counts("retry-400")
+				Expect(retries).To(Equal(0))
+				Expect(errorCount).To(Equal(1))
+			})
+
+			It("should record a single error once retries are exhausted", func() {
+				mock, attempts := failingMock(10, &v1.Error{Type: v1.ErrServer, Msg: "server error: 502"})
+
+				Expect(newTestSession(mock).executeQuery(context.Background(), retryInfo("retry-exhausted", 2))).To(BeFalse())
+
+				Expect(attempts.Load()).To(Equal(int32(3)))
+				retries, errorCount := counts("retry-exhausted")
+				Expect(retries).To(Equal(2))
+				Expect(errorCount).To(Equal(1))
+			})
+
+			It("should record the details of every failed attempt", func() {
+				mock, _ := failingMock(10, &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"})
+				info := retryInfo("retry-details", 1)
+				info.QueryType = "instant"
+
+				Expect(newTestSession(mock).executeQuery(context.Background(), info)).To(BeFalse())
+
+				rows, err := testDB.Query(`
+					SELECT cluster_id, query_type, promquery, http_status, error_message, attempt, retried
+					FROM query_error_events WHERE kpi_id = ? ORDER BY attempt`, "retry-details")
+				Expect(err).NotTo(HaveOccurred())
+				defer func() { _ = rows.Close() }()
+
+				var retried []bool
+				for rows.Next() {
+					var (
+						storedClusterID       int64
+						queryType, query, msg string
+						httpStatus, attempt   int
+						attemptRetried        bool
+					)
+					Expect(rows.Scan(&storedClusterID, &queryType, &query, &httpStatus, &msg, &attempt, &attemptRetried)).To(Succeed())
+					Expect(storedClusterID).To(Equal(clusterID))
+					Expect(queryType).To(Equal("instant"))
+					Expect(query).To(Equal("up"))
+					Expect(httpStatus).To(Equal(503))
+					Expect(msg).To(ContainSubstring("server error: 503"))
+					Expect(attempt).To(Equal(len(retried) + 1))
+					retried = append(retried, attemptRetried)
+				}
+				Expect(rows.Err()).NotTo(HaveOccurred())
+				Expect(retried).To(Equal([]bool{true, false}))
+			})
+
+			It("should give every attempt its own timeout", func() {
+				var attempts atomic.Int32
+				mock := &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						if attempts.Add(1) == 1 {
+							<-ctx.Done()
+							return nil, nil, ctx.Err()
+						}
+						return model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1}}, nil, nil
+					},
+				}
+				info := retryInfo("retry-timeout", 1)
+				info.Timeout = 50 * time.Millisecond
+
+				Expect(newTestSession(mock).executeQuery(context.Background(), info)).To(BeTrue())
+				Expect(attempts.Load()).To(Equal(int32(2)))
+			})
+		})
+
+		Describe("collection runs", func() {
+			It("should attribute samples to the run and record its failure counts", func() {
+				var attempts atomic.Int32
+				mock := &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						if query == "broken" || attempts.Add(1) == 1 {
+							return nil, nil, &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}
+						}
+						return model.Vector{&model.Sample{Metric: model.Metric{"__name__": "up"}, Value: 1}}, nil, nil
+					},
+				}
+				session := newTestSession(mock)
+				Expect(session.StartRun(database.CollectionRun{ToolVersion: "v1.2.3"})).To(Succeed())
+
+				info := output.QueryInfo{QueryID: "run-ok", PromQuery: "up", MaxRetries: 1, RetryBackoff: time.Millisecond}
+				Expect(session.executeQuery(context.Background(), info)).To(BeTrue())
+				info = output.QueryInfo{QueryID: "run-broken", PromQuery: "broken"}
+				Expect(session.executeQuery(context.Background(), info)).To(BeFalse())
+				Expect(session.FinishRun("Duration completed")).To(Succeed())
+
+				var storedRunID sql.NullInt64
+				err := testDB.QueryRow("SELECT run_id FROM query_results WHERE kpi_id = ?", "run-ok").Scan(&storedRunID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(storedRunID.Int64).To(Equal(session.runID))
+
+				var (
+					version, reason string
+					failed, retries int
+				)
+				err = testDB.QueryRow(`
+					SELECT tool_version, shutdown_reason, failed_queries, retries
+					FROM collection_runs WHERE id = ? AND ended_at IS NOT NULL`, session.runID).Scan(&version, &reason, &failed, &retries)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version).To(Equal("v1.2.3"))
+				Expect(reason).To(Equal("Duration completed"))
+				Expect(failed).To(Equal(1))
+				Expect(retries).To(Equal(1))
+			})
+		})
+
+		Describe("threshold alerts", func() {
+			It("should record the alerts raised and resolved by stored samples", func() {
+				value := model.SampleValue(0.9)
+				mock := &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						return model.Vector{&model.Sample{Metric: model.Metric{"cpu": "0"}, Value: value, Timestamp: model.Now()}}, nil, nil
+					},
+				}
+				session := newTestSession(mock)
+				Expect(session.StartRun(database.CollectionRun{})).To(Succeed())
+				session.WatchThresholds(config.KPIs{Queries: []config.Query{
+					{ID: "cpu", PromQuery: "cpu", Thresholds: &config.Thresholds{Limits: map[string]float64{"max": 0.8}}},
+				}}, alerting.DefaultHysteresis)
+
+				info := output.QueryInfo{QueryID: "cpu", PromQuery: "cpu"}
+				for _, v := range []model.SampleValue{0.9, 0.95, 0.5} {
+					value = v
+					Expect(session.executeQuery(context.Background(), info)).To(BeTrue())
+				}
+
+				rows, err := testDB.Query("SELECT state, metric_value, threshold, run_id FROM alerts WHERE kpi_id = ? ORDER BY id", "cpu")
+				Expect(err).NotTo(HaveOccurred())
+				defer func() { _ = rows.Close() }()
+
+				var states []string
+				for rows.Next() {
+					var (
+						state, threshold string
+						metricValue      float64
+						runID            int64
+					)
+					Expect(rows.Scan(&state, &metricValue, &threshold, &runID)).To(Succeed())
+					Expect(threshold).To(Equal("max <= 0.8"))
+					Expect(runID).To(Equal(session.runID))
+					states = append(states, fmt.Sprintf("%s %g", state, metricValue))
+				}
+				Expect(rows.Err()).NotTo(HaveOccurred())
+				Expect(states).To(Equal([]string{"firing 0.9", "resolved 0.5"}))
+			})
+		})
+
+		Describe("RunQueries", func() {
+			slowMock := func() *mockPromAPI {
+				return &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						delay := 200 * time.Millisecond
+						if query == "hanging_query" {
+							delay = 10 * time.Second
+						}
+						select {
+						case <-ctx.Done():
+							return nil, nil, ctx.Err()
+						case <-time.After(delay):
+							return model.Vector{
+								&model.Sample{Metric: model.Metric{"q": model.LabelValue(query)}, Value: 1, Timestamp: model.Now()},
+							}, nil, nil
+						}
+					},
+				}
+			}
+
+			It("should run up to maxConcurrentQueries queries of a group in parallel", func() {
+				const maxConcurrent = 3
+				var inFlight, peak atomic.Int32
+				poolFull := make(chan struct{})
+				var closePoolFull sync.Once
+
+				// Each query holds its worker until the pool is full, so the peak
+				// reaches the limit deterministically and any overshoot is visible.
+				mock := &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						current := inFlight.Add(1)
+						defer inFlight.Add(-1)
+						for {
+							seen := peak.Load()
+							if current <= seen || peak.CompareAndSwap(seen, current) {
+								break
+							}
+						}
+						if current >= maxConcurrent {
+							closePoolFull.Do(func() { close(poolFull) })
+						}
+
+						select {
+						case <-poolFull:
+						case <-ctx.Done():
+							return nil, nil, ctx.Err()
+						}
+						return model.Vector{
+							&model.Sample{Metric: model.Metric{"q": model.LabelValue(query)}, Value: 1, Timestamp: model.Now()},
+						}, nil, nil
+					},
+				}
+
+				var queries []config.Query
+				for i := 1; i <= 10; i++ {
+					queries = append(queries, config.Query{ID: fmt.Sprintf("par-%d", i), PromQuery: fmt.Sprintf("q%d", i)})
+				}
+
+				session := newTestSession(mock)
+				session.maxConcurrentQueries = maxConcurrent
+				err := session.RunQueries(context.Background(), config.KPIs{Queries: queries}, 1, 1, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(peak.Load()).To(Equal(int32(maxConcurrent)))
+
+				var count int
+				err = testDB.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id LIKE 'par-%'").Scan(&count)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(10))
+			})
+
+			It("should apply per-KPI timeouts without failing the other queries", func() {
+				kpis := config.KPIs{Queries: []config.Query{
+					{ID: "fast-kpi", PromQuery: "q1"},
+					{ID: "hanging-kpi", PromQuery: "hanging_query", Timeout: &config.Duration{Duration: 50 * time.Millisecond}},
+				}}
+
+				err := newTestSession(slowMock()).RunQueries(context.Background(), kpis, 1, 1, 0)
+				Expect(err).To(MatchError("1 of 2 queries failed"))
+
+				count, err := sqliteDB.GetQueryErrorCount(testDB, clusterID, "hanging-kpi")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(1))
+
+				count, err = sqliteDB.GetQueryErrorCount(testDB, clusterID, "fast-kpi")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(0))
+			})
+
+			It("should cancel the retry backoff of a query without recording a failure", func() {
+				var attempts atomic.Int32
+				mock := &mockPromAPI{
+					queryFunc: func(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+						attempts.Add(1)
+						return nil, nil, &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}
+					},
+				}
+				session := newTestSession(mock)
+				session.retry = config.RetryPolicy{MaxRetries: 5, Backoff: time.Hour}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				kpis := config.KPIs{Queries: []config.Query{{ID: "cancelled-kpi", PromQuery: "up"}}}
+
+				start := time.Now()
+				Expect(session.RunQueries(ctx, kpis, 1, 1, 0)).To(Succeed())
+				Expect(time.Since(start)).To(BeNumerically("<", time.Minute))
+				Expect(attempts.Load()).To(Equal(int32(1)))
+
+				count, err := sqliteDB.GetQueryErrorCount(testDB, clusterID, "cancelled-kpi")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(count).To(Equal(0))
+			})
 		})
 
 		It("should execute range queries and flatten matrix results", func() {
@@ -518,7 +860,7 @@ var _ = Describe("Client", func() {
 				Since:        now.Add(-time.Hour),
 				Until:        now,
 			}
-			executeQuery(context.Background(), mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(context.Background(), info)
 
 			var count int
 			err := testDB.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = ?", "test-query-range").Scan(&count)
@@ -556,8 +898,8 @@ var _ = Describe("Client", func() {
 				Until:        now,
 			}
 
-			executeQuery(context.Background(), mock, testDB, sqliteDB, clusterID, info)
-			executeQuery(context.Background(), mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(context.Background(), info)
+			newTestSession(mock).executeQuery(context.Background(), info)
 
 			var count int
 			err := testDB.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = ?", "test-query-dedup").Scan(&count)
@@ -585,7 +927,7 @@ var _ = Describe("Client", func() {
 				Since:        now.Add(-time.Hour),
 				Until:        now,
 			}
-			executeQuery(context.Background(), mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(context.Background(), info)
 
 			var errorCount int
 			err := testDB.QueryRow("SELECT errors FROM query_errors WHERE kpi_id = ?", queryID).Scan(&errorCount)
@@ -625,7 +967,7 @@ var _ = Describe("Client", func() {
 				Since:        since,
 				Until:        until,
 			}
-			executeQuery(context.Background(), mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(context.Background(), info)
 
 			var count int
 			err := testDB.QueryRow("SELECT COUNT(*) FROM query_results WHERE kpi_id = ?", "test-query-since-until").Scan(&count)
@@ -661,7 +1003,7 @@ var _ = Describe("Client", func() {
 				SampleNumber: 1,
 				TotalSamples: 4,
 			}
-			executeQuery(context.Background(), mock, testDB, sqliteDB, clusterID, info)
+			newTestSession(mock).executeQuery(context.Background(), info)
 
 			Expect(queryCalled).To(BeTrue())
 			Expect(queryRangeCalled).To(BeFalse())
@@ -0,0 +1,124 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+const (
+	// DefaultMaxRetries is the default number of retries after a transient query failure
+	DefaultMaxRetries = 2
+
+	// DefaultRetryBackoff is the default delay before the first retry
+	DefaultRetryBackoff = time.Second
+
+	// maxRetryBackoff caps the exponential backoff between two attempts
+	maxRetryBackoff = 30 * time.Second
+)
+
+// retryableStatusCodes are the HTTP statuses that usually clear up on a later
+// attempt (rate limiting and overloaded or restarting Thanos queriers)
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// isRetryableError reports whether a failed query attempt is worth retrying:
+// query timeouts, network errors and retryable HTTP statuses. Invalid queries
+// and cancellation of the collection are never retried.
+func isRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// The attempt ran out of its per-query timeout
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr *promv1.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case promv1.ErrTimeout:
+			return true
+		case promv1.ErrServer, promv1.ErrClient:
+			return retryableStatusCodes[httpStatusOf(apiErr)]
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// httpStatusOfError returns the HTTP status of a failed query, or 0 if the
+// request never got an HTTP response (e.g. network errors and timeouts)
+func httpStatusOfError(err error) int {
+	var apiErr *promv1.Error
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	switch apiErr.Type {
+	case promv1.ErrServer, promv1.ErrClient:
+		return httpStatusOf(apiErr)
+	default:
+		return 0
+	}
+}
+
+// httpStatusOf extracts the HTTP status code from a client or server error
+// returned by the Prometheus API client ("server error: 503"), or 0 if unknown.
+func httpStatusOf(apiErr *promv1.Error) int {
+	idx := strings.LastIndex(apiErr.Msg, ": ")
+	if idx < 0 {
+		return 0
+	}
+	status, err := strconv.Atoi(apiErr.Msg[idx+2:])
+	if err != nil {
+		return 0
+	}
+	return status
+}
+
+// retryDelay returns how long to wait before the given retry (1-based):
+// the backoff doubles on every retry up to maxRetryBackoff, and a random
+// jitter of up to half the delay spreads out retries of concurrent queries.
+func retryDelay(backoff time.Duration, retry int) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+
+	delay := backoff
+	for i := 1; i < retry && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// sleepContext waits for the given delay, returning false if ctx is done first
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,53 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+var _ = Describe("isRetryableError", func() {
+	DescribeTable("classifies query errors",
+		func(err error, expected bool) {
+			Expect(isRetryableError(err)).To(Equal(expected))
+		},
+		Entry("nil", nil, false),
+		Entry("per-query timeout", fmt.Errorf("query: %w", context.DeadlineExceeded), true),
+		Entry("cancelled collection", context.Canceled, false),
+		Entry("Prometheus timeout", &v1.Error{Type: v1.ErrTimeout, Msg: "query timed out"}, true),
+		Entry("429 Too Many Requests", &v1.Error{Type: v1.ErrClient, Msg: "client error: 429"}, true),
+		Entry("503 Service Unavailable", &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}, true),
+		Entry("504 Gateway Timeout", &v1.Error{Type: v1.ErrServer, Msg: "server error: 504"}, true),
+		Entry("501 Not Implemented", &v1.Error{Type: v1.ErrServer, Msg: "server error: 501"}, false),
+		Entry("400 Bad Request", &v1.Error{Type: v1.ErrClient, Msg: "client error: 400"}, false),
+		Entry("invalid query", &v1.Error{Type: v1.ErrBadData, Msg: "parse error"}, false),
+		Entry("network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true),
+		Entry("other error", errors.New("boom"), false),
+	)
+})
+
+var _ = Describe("retryDelay", func() {
+	It("should double the backoff on every retry with up to half of it as jitter", func() {
+		for retry, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+			delay := retryDelay(time.Second, retry)
+			Expect(delay).To(BeNumerically(">=", expected/2))
+			Expect(delay).To(BeNumerically("<=", expected))
+		}
+	})
+
+	It("should cap the backoff", func() {
+		delay := retryDelay(time.Second, 20)
+		Expect(delay).To(BeNumerically(">=", maxRetryBackoff/2))
+		Expect(delay).To(BeNumerically("<=", maxRetryBackoff))
+	})
+
+	It("should not wait without a backoff", func() {
+		Expect(retryDelay(0, 3)).To(BeZero())
+	})
+})
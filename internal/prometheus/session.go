@@ -0,0 +1,213 @@
+package prometheus
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/alerting"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/config"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/sink"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Session owns the resources shared by every query of a collection run:
+// the database connection, the cluster record and the Prometheus API client.
+// It is created once per run, is safe for concurrent use by the frequency
+// goroutines, and must be closed when collection ends.
+type Session struct {
+	db        *sql.DB
+	dbImpl    database.Database
+	clusterID int64
+	v1api     promv1.API
+
+	// maxConcurrentQueries bounds the worker pool used by RunQueries
+	maxConcurrentQueries int
+	// retry is the default retry policy for transient query failures
+	retry config.RetryPolicy
+
+	// alerts checks stored samples against the KPI thresholds, nil without
+	// thresholds; webhook receives its alerts, nil without --alert-webhook
+	alerts  *alerting.Monitor
+	webhook *alerting.Webhook
+
+	// sinks receive the samples besides the database, nil without --sink or
+	// --remote-write-url; stored counts the samples written to the database
+	sinks       *sink.Fanout
+	stored      sink.Stats
+	clusterName string
+	clusterType string
+
+	// runID is the collection run samples are attributed to, 0 before StartRun
+	runID int64
+	// failedQueries and retries count the failures of the current collection run
+	failedQueries atomic.Int64
+	retries       atomic.Int64
+}
+
+// NewSession opens the database, registers the cluster and creates the
+// Prometheus client used for the rest of the collection run.
+func NewSession(flags config.InputFlags) (*Session, error) {
+	dbImpl, err := database.NewDatabase(flags.DatabaseType, flags.PostgresURL, flags.InsertBatchSize, flags.FileFormat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init database: %v", err)
+	}
+	db, err := dbImpl.InitDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to init database: %v", err)
+	}
+
+	clusterID, err := dbImpl.GetOrCreateCluster(db, flags.ClusterName, flags.ClusterType)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to get cluster ID: %v", err)
+	}
+
+	v1api, err := setupPromClient(flags.ThanosURL, flags.BearerToken, flags.InsecureTLS)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	log.Printf("Collection session opened (cluster %s, id=%d)", flags.ClusterName, clusterID)
+
+	var webhook *alerting.Webhook
+	if flags.AlertWebhookURL != "" {
+		webhook = alerting.NewWebhook(flags.AlertWebhookURL, flags.ClusterName)
+	}
+
+	specs := flags.Sinks
+	if flags.RemoteWriteURL != "" {
+		specs = append(specs, "remote-write:"+flags.RemoteWriteURL)
+	}
+	var sinks *sink.Fanout
+	if len(specs) > 0 {
+		sinks, err = sink.NewFanout(specs, dbImpl, flags.InsertBatchSize)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	maxConcurrent := flags.MaxConcurrentQueries
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrentQueries
+	}
+
+	return &Session{
+		db:                   db,
+		dbImpl:               dbImpl,
+		clusterID:            clusterID,
+		v1api:                v1api,
+		maxConcurrentQueries: maxConcurrent,
+		retry:                config.RetryPolicy{MaxRetries: flags.MaxRetries, Backoff: flags.RetryBackoff},
+		webhook:              webhook,
+		sinks:                sinks,
+		clusterName:          flags.ClusterName,
+		clusterType:          flags.ClusterType,
+	}, nil
+}
+
+// Close releases the sinks not closed by FinishRun and the database
+// connection held by the session.
+func (s *Session) Close() error {
+	log.Printf("Closing collection session")
+	if s.sinks != nil {
+		s.sinks.Close(database.CollectionRunResult{ShutdownReason: "Collection session closed"})
+	}
+	return s.db.Close()
+}
+
+// Prune applies the retention policy to the samples of the session's cluster
+func (s *Session) Prune(policy database.RetentionPolicy) (database.PruneResult, error) {
+	policy.ClusterID = s.clusterID
+	return s.dbImpl.PruneQueryResults(s.db, policy)
+}
+
+// RecordOverrun stores a sampling tick overrun for the session's cluster.
+func (s *Session) RecordOverrun(event database.OverrunEvent) error {
+	return s.dbImpl.RecordOverrun(s.db, s.clusterID, event)
+}
+
+// StartRun records the start of a collection run. Samples stored afterwards
+// RunID returns the ID of the collection run recorded by StartRun, 0 before it
+func (s *Session) RunID() int64 {
+	return s.runID
+}
+
+// are attributed to it. It must be called before any query is executed.
+func (s *Session) StartRun(run database.CollectionRun) error {
+	runID, err := s.dbImpl.StartCollectionRun(s.db, s.clusterID, run)
+	if err != nil {
+		return fmt.Errorf("failed to record collection run: %v", err)
+	}
+	s.runID = runID
+	log.Printf("Collection run %d started", runID)
+	return nil
+}
+
+// WatchThresholds enables live alerts on the thresholds of the KPIs: every
+// stored sample is checked against them. It must be called before any
+// query is executed.
+func (s *Session) WatchThresholds(kpis config.KPIs, hysteresis float64) {
+	s.alerts = alerting.NewMonitor(kpis, hysteresis)
+	if s.alerts != nil {
+		log.Printf("Watching KPI thresholds (hysteresis %g%%)", hysteresis)
+	}
+}
+
+// RecordDefinitions stores the KPI definitions the collection run was started with
+func (s *Session) RecordDefinitions(definitions []database.KPIDefinition) error {
+	if err := s.dbImpl.RecordKPIDefinitions(s.db, s.runID, definitions); err != nil {
+		return fmt.Errorf("failed to record KPI definitions: %v", err)
+	}
+	return nil
+}
+
+// StartSinks starts writing the samples of the collection run to the sinks,
+// after recording the run and its KPI definitions in each. A sink that
+// cannot be started is reported and skipped; it does not fail the run.
+func (s *Session) StartSinks(run database.CollectionRun, definitions []database.KPIDefinition) {
+	if s.sinks == nil {
+		return
+	}
+	s.sinks.Start(sink.Run{
+		ClusterName: s.clusterName,
+		ClusterType: s.clusterType,
+		Run:         run,
+		Definitions: definitions,
+	})
+}
+
+// FinishRun records the end of the collection run together with the number
+// of failed queries and retries seen by the session, in the database and in
+// the sinks, which are closed.
+func (s *Session) FinishRun(shutdownReason string) error {
+	if s.runID == 0 {
+		return nil
+	}
+	log.Printf("Collection run %d finished: %s", s.runID, shutdownReason)
+	result := database.CollectionRunResult{
+		ShutdownReason: shutdownReason,
+		FailedQueries:  s.failedQueries.Load(),
+		Retries:        s.retries.Load(),
+	}
+	if s.sinks != nil {
+		s.sinks.Close(result)
+	}
+	return s.dbImpl.FinishCollectionRun(s.db, s.runID, result)
+}
+
+// SinkSummary returns the samples written to the database and to each sink,
+// nil without sinks
+func (s *Session) SinkSummary() []output.SinkSummary {
+	if s.sinks == nil {
+		return nil
+	}
+	primary := s.stored.Summary(database.DisplayName(s.dbImpl) + " [primary]")
+	return append([]output.SinkSummary{primary}, s.sinks.Summary()...)
+}
@@ -0,0 +1,99 @@
+package remotewrite
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BufferDirName is the directory of the artifacts directory the requests
+// that could not be sent are buffered in
+const BufferDirName = "remote-write-buffer"
+
+// maxBufferBytes bounds the requests buffered on disk; the oldest are
+// dropped beyond it
+const maxBufferBytes = 256 << 20
+
+// bufferSuffix is the extension of the buffered request files
+const bufferSuffix = ".snappy"
+
+// diskBuffer keeps the remote-write requests that could not be sent, one
+// file per compressed request, named so that they sort oldest first. It
+// outlives the collection run: requests left at exit are sent by the next.
+type diskBuffer struct {
+	dir string
+	seq int
+}
+
+// add stores a compressed request
+func (b *diskBuffer) add(body []byte) error {
+	b.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), b.seq, bufferSuffix)
+	partial := filepath.Join(b.dir, name+".partial")
+	if err := os.WriteFile(partial, body, 0644); err != nil {
+		return fmt.Errorf("failed to buffer remote write request: %w", err)
+	}
+	if err := os.Rename(partial, filepath.Join(b.dir, name)); err != nil {
+		return fmt.Errorf("failed to buffer remote write request: %w", err)
+	}
+	return b.trim()
+}
+
+// pending returns the buffered request files, oldest first
+func (b *diskBuffer) pending() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), bufferSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// read returns a buffered request
+func (b *diskBuffer) read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(b.dir, name))
+}
+
+// remove deletes a buffered request once sent or dropped
+func (b *diskBuffer) remove(name string) error {
+	return os.Remove(filepath.Join(b.dir, name))
+}
+
+// trim drops the oldest requests beyond maxBufferBytes
+func (b *diskBuffer) trim() error {
+	names, err := b.pending()
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int64, len(names))
+	var total int64
+	for i, name := range names {
+		info, err := os.Stat(filepath.Join(b.dir, name))
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	for i := 0; total > maxBufferBytes && i < len(names)-1; i++ {
+		if err := b.remove(names[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+		log.Printf("Remote write buffer full, dropped request %s", names[i])
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package remotewrite
+
+import (
+	"sort"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// maxSamplesPerRequest bounds the samples of one remote-write request, as
+// receivers limit the size of a request
+const maxSamplesPerRequest = 5000
+
+// Labels added to every series
+const (
+	KPILabel     = "kpi_id"
+	ClusterLabel = "cluster"
+)
+
+// timeSeries converts the samples of a query result to remote-write series.
+// Results other than a vector or matrix hold no series.
+func timeSeries(kpiID, clusterName string, result model.Value) []prompb.TimeSeries {
+	var series []prompb.TimeSeries
+	switch v := result.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			series = append(series, prompb.TimeSeries{
+				Labels:  seriesLabels(kpiID, clusterName, sample.Metric),
+				Samples: []prompb.Sample{{Value: float64(sample.Value), Timestamp: int64(sample.Timestamp)}},
+			})
+		}
+	case model.Matrix:
+		for _, stream := range v {
+			if len(stream.Values) == 0 {
+				continue
+			}
+			samples := make([]prompb.Sample, len(stream.Values))
+			for i, pair := range stream.Values {
+				samples[i] = prompb.Sample{Value: float64(pair.Value), Timestamp: int64(pair.Timestamp)}
+			}
+			series = append(series, prompb.TimeSeries{
+				Labels:  seriesLabels(kpiID, clusterName, stream.Metric),
+				Samples: samples,
+			})
+		}
+	}
+	return series
+}
+
+// seriesLabels returns the labels of a series sorted by name, as remote write
+// requires. The series is named after its KPI; the labels of the query
+// result are kept under their database.ExportedLabelName.
+func seriesLabels(kpiID, clusterName string, metric model.Metric) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(metric)+3)
+	for name, value := range metric {
+		labels = append(labels, prompb.Label{Name: database.ExportedLabelName(string(name)), Value: string(value)})
+	}
+	labels = append(labels,
+		prompb.Label{Name: model.MetricNameLabel, Value: database.MetricName(kpiID)},
+		prompb.Label{Name: KPILabel, Value: kpiID},
+		prompb.Label{Name: ClusterLabel, Value: clusterName},
+	)
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+// writeRequests splits series into requests of at most maxSamplesPerRequest
+// samples. The samples of one series stay in time order.
+func writeRequests(series []prompb.TimeSeries) []prompb.WriteRequest {
+	var requests []prompb.WriteRequest
+	var current prompb.WriteRequest
+	samples := 0
+
+	for _, s := range series {
+		for len(s.Samples) > 0 {
+			if samples == maxSamplesPerRequest {
+				requests = append(requests, current)
+				current, samples = prompb.WriteRequest{}, 0
+			}
+			n := min(len(s.Samples), maxSamplesPerRequest-samples)
+			current.Timeseries = append(current.Timeseries, prompb.TimeSeries{Labels: s.Labels, Samples: s.Samples[:n]})
+			s.Samples = s.Samples[n:]
+			samples += n
+		}
+	}
+	if samples > 0 {
+		requests = append(requests, current)
+	}
+	return requests
+}
@@ -0,0 +1,183 @@
+// Package remotewrite sends collected KPI samples to a Prometheus
+// remote-write endpoint, e.g. of a central observability stack, alongside
+// the database. Requests that cannot be sent are buffered on disk and
+// retried.
+package remotewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// requestTimeout bounds each remote-write request, so an unreachable
+	// endpoint cannot hold up collection for long
+	requestTimeout = 10 * time.Second
+
+	// retryBackoff is how long requests are buffered without trying the
+	// endpoint after a failed request
+	retryBackoff = 30 * time.Second
+)
+
+// ErrBuffered is wrapped by the errors of requests that failed and were
+// buffered to be retried
+var ErrBuffered = errors.New("buffered for retry")
+
+// Writer sends query results to a remote-write endpoint. It is safe for
+// concurrent use; requests are sent one at a time, in order.
+type Writer struct {
+	url         string
+	clusterName string
+	client      *http.Client
+	buffer      *diskBuffer
+
+	mu sync.Mutex
+	// retryAt is when the endpoint is tried again after a failure
+	retryAt time.Time
+}
+
+// NewWriter returns a writer sending the samples of a cluster to url, which
+// buffers the requests it cannot send in bufferDir
+func NewWriter(url, clusterName, bufferDir string) (*Writer, error) {
+	if err := os.MkdirAll(bufferDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create remote write buffer: %w", err)
+	}
+	return &Writer{
+		url:         url,
+		clusterName: clusterName,
+		client:      &http.Client{Timeout: requestTimeout},
+		buffer:      &diskBuffer{dir: bufferDir},
+	}, nil
+}
+
+// Write sends the samples of a query result of a KPI, after the requests
+// buffered earlier. Requests that fail with a retryable error are buffered,
+// and an error is returned for the first failure after each backoff.
+func (w *Writer) Write(kpiID string, result model.Value) error {
+	var bodies [][]byte
+	for _, request := range writeRequests(timeSeries(kpiID, w.clusterName, result)) {
+		data, err := request.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to encode remote write request: %w", err)
+		}
+		bodies = append(bodies, snappy.Encode(nil, data))
+	}
+	if len(bodies) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if time.Now().Before(w.retryAt) {
+		return w.bufferAll(bodies)
+	}
+	if err := w.flush(); err != nil {
+		if bufferErr := w.bufferAll(bodies); bufferErr != nil {
+			return bufferErr
+		}
+		return err
+	}
+
+	for i, body := range bodies {
+		retryable, err := w.send(body)
+		if err == nil {
+			continue
+		}
+		if !retryable {
+			return fmt.Errorf("remote write of %s dropped: %w", kpiID, err)
+		}
+		w.retryAt = time.Now().Add(retryBackoff)
+		if bufferErr := w.bufferAll(bodies[i:]); bufferErr != nil {
+			return bufferErr
+		}
+		return fmt.Errorf("remote write failed, request %w: %w", ErrBuffered, err)
+	}
+	return nil
+}
+
+// Close sends the buffered requests, if the endpoint is reachable. Requests
+// still buffered are sent by the next writer of the same buffer directory.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		pending, _ := w.buffer.pending()
+		return fmt.Errorf("%d remote write request(s) left in %s for the next run: %w", len(pending), w.buffer.dir, err)
+	}
+	return nil
+}
+
+// flush sends the buffered requests, oldest first, until one fails with a
+// retryable error
+func (w *Writer) flush() error {
+	names, err := w.buffer.pending()
+	if err != nil {
+		return fmt.Errorf("failed to read remote write buffer: %w", err)
+	}
+
+	for _, name := range names {
+		body, err := w.buffer.read(name)
+		if err != nil {
+			return fmt.Errorf("failed to read remote write buffer: %w", err)
+		}
+		retryable, err := w.send(body)
+		if err != nil && retryable {
+			w.retryAt = time.Now().Add(retryBackoff)
+			return fmt.Errorf("remote write failed, %d request(s) %w: %w", len(names), ErrBuffered, err)
+		}
+		if err != nil {
+			log.Printf("Dropped buffered remote write request %s: %v", name, err)
+		}
+		if err := w.buffer.remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bufferAll stores requests on disk
+func (w *Writer) bufferAll(bodies [][]byte) error {
+	for _, body := range bodies {
+		if err := w.buffer.add(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts a compressed request and reports whether a failure is worth
+// retrying: network errors, HTTP 429 and 5xx. Other rejections, e.g. of
+// out-of-order samples, would fail again.
+func (w *Writer) send(body []byte) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "kpi-collector")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("remote write endpoint returned HTTP %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
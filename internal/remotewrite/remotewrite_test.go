@@ -0,0 +1,166 @@
+package remotewrite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// receiver is a remote-write endpoint recording the requests it accepts
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []prompb.WriteRequest
+}
+
+func newReceiver() *receiver {
+	r := &receiver{status: http.StatusNoContent}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer GinkgoRecover()
+		Expect(req.Header.Get("Content-Encoding")).To(Equal("snappy"))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(req.Header.Get("X-Prometheus-Remote-Write-Version")).To(Equal("0.1.0"))
+
+		body, err := io.ReadAll(req.Body)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := snappy.Decode(nil, body)
+		Expect(err).NotTo(HaveOccurred())
+		var request prompb.WriteRequest
+		Expect(request.Unmarshal(data)).To(Succeed())
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.status < 300 {
+			r.requests = append(r.requests, request)
+		}
+		w.WriteHeader(r.status)
+	}))
+	DeferCleanup(r.Close)
+	return r
+}
+
+func (r *receiver) respond(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() []prompb.WriteRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+var _ = Describe("Writer", func() {
+	var (
+		endpoint  *receiver
+		bufferDir string
+		writer    *Writer
+	)
+
+	vector := func(value float64) model.Vector {
+		return model.Vector{{
+			Metric:    model.Metric{"__name__": "node_cpu", "cpu": "0", "cluster": "prometheus-ha"},
+			Value:     model.SampleValue(value),
+			Timestamp: model.TimeFromUnix(1700000000),
+		}}
+	}
+	buffered := func() []string {
+		names, err := writer.buffer.pending()
+		Expect(err).NotTo(HaveOccurred())
+		return names
+	}
+
+	BeforeEach(func() {
+		endpoint = newReceiver()
+		bufferDir = GinkgoT().TempDir()
+
+		var err error
+		writer, err = NewWriter(endpoint.URL, "cluster-a", bufferDir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should send samples named after the KPI with kpi_id and cluster labels", func() {
+		Expect(writer.Write("node-cpu", vector(0.5))).To(Succeed())
+
+		requests := endpoint.received()
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Timeseries).To(Equal([]prompb.TimeSeries{{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "kpi_collector_node_cpu"},
+				{Name: "cluster", Value: "cluster-a"},
+				{Name: "cpu", Value: "0"},
+				{Name: "exported_cluster", Value: "prometheus-ha"},
+				{Name: "exported_name", Value: "node_cpu"},
+				{Name: "kpi_id", Value: "node-cpu"},
+			},
+			Samples: []prompb.Sample{{Value: 0.5, Timestamp: 1700000000000}},
+		}}))
+	})
+
+	It("should split large range results into several requests", func() {
+		values := make([]model.SamplePair, maxSamplesPerRequest+10)
+		for i := range values {
+			values[i] = model.SamplePair{Timestamp: model.Time(i * 1000), Value: model.SampleValue(i)}
+		}
+		Expect(writer.Write("node-cpu", model.Matrix{{Metric: model.Metric{"cpu": "0"}, Values: values}})).To(Succeed())
+
+		requests := endpoint.received()
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0].Timeseries[0].Samples).To(HaveLen(maxSamplesPerRequest))
+		Expect(requests[1].Timeseries[0].Samples).To(HaveLen(10))
+		Expect(requests[1].Timeseries[0].Samples[0].Timestamp).To(Equal(int64(maxSamplesPerRequest * 1000)))
+	})
+
+	It("should buffer samples while the endpoint fails and send them in order later", func() {
+		endpoint.respond(http.StatusServiceUnavailable)
+		Expect(writer.Write("node-cpu", vector(1))).To(MatchError(ContainSubstring("HTTP 503")))
+		// Within the backoff, the endpoint is not tried again
+		Expect(writer.Write("node-cpu", vector(2))).To(Succeed())
+		Expect(buffered()).To(HaveLen(2))
+
+		endpoint.respond(http.StatusNoContent)
+		Expect(writer.Close()).To(Succeed())
+		Expect(buffered()).To(BeEmpty())
+
+		requests := endpoint.received()
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0].Timeseries[0].Samples[0].Value).To(Equal(1.0))
+		Expect(requests[1].Timeseries[0].Samples[0].Value).To(Equal(2.0))
+	})
+
+	It("should leave the buffered samples for the next writer when the endpoint is down", func() {
+		endpoint.respond(http.StatusInternalServerError)
+		Expect(writer.Write("node-cpu", vector(1))).NotTo(Succeed())
+		Expect(writer.Close()).To(MatchError(ContainSubstring("1 remote write request(s) left")))
+
+		endpoint.respond(http.StatusNoContent)
+		next, err := NewWriter(endpoint.URL, "cluster-a", bufferDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next.Write("node-cpu", vector(2))).To(Succeed())
+		Expect(endpoint.received()).To(HaveLen(2))
+		Expect(os.ReadDir(bufferDir)).To(BeEmpty())
+	})
+
+	It("should drop samples rejected by the endpoint", func() {
+		endpoint.respond(http.StatusBadRequest)
+		Expect(writer.Write("node-cpu", vector(1))).To(MatchError(ContainSubstring("dropped")))
+		Expect(buffered()).To(BeEmpty())
+	})
+
+	It("should buffer samples while the endpoint is unreachable", func() {
+		endpoint.Close()
+		Expect(writer.Write("node-cpu", vector(1))).To(MatchError(ContainSubstring("buffered for retry")))
+		Expect(buffered()).To(HaveLen(1))
+	})
+})
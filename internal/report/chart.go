@@ -0,0 +1,171 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+)
+
+// Chart layout, in pixels
+const (
+	chartWidth      = 800
+	chartPlotHeight = 220
+	chartLeft       = 70 // room for the value axis labels
+	chartRight      = 20
+	chartTop        = 10
+	chartAxisHeight = 30 // room for the time axis labels
+	chartLegendLine = 18
+)
+
+const (
+	// maxChartSeries bounds the lines drawn on a chart; the statistics table
+	// still lists every series
+	maxChartSeries = 10
+	// maxChartPoints bounds the points drawn per line: longer series are
+	// reduced to the min and max of each bucket, which keeps their spikes
+	maxChartPoints = 500
+)
+
+// chartColors are the line colors, one per series
+var chartColors = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// chart draws the series as an SVG time-series chart with a legend. Every
+// text is escaped, so the SVG can be embedded in HTML as is. It contains no
+// blank line, so it can also be embedded in Markdown.
+func chart(series []Series) string {
+	drawn := series
+	if len(drawn) > maxChartSeries {
+		drawn = drawn[:maxChartSeries]
+	}
+
+	start, end, low, high, ok := chartBounds(drawn)
+	if !ok {
+		return ""
+	}
+
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	x := func(t time.Time) float64 {
+		if !end.After(start) {
+			return chartLeft + plotWidth/2
+		}
+		return chartLeft + plotWidth*float64(t.Sub(start))/float64(end.Sub(start))
+	}
+	y := func(value float64) float64 {
+		return chartTop + chartPlotHeight*(high-value)/(high-low)
+	}
+
+	height := chartTop + chartPlotHeight + chartAxisHeight + chartLegendLine*len(drawn)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %[1]d %[2]d" font-family="sans-serif" font-size="11">`+"\n",
+		chartWidth, height)
+
+	// Value axis with 5 grid lines
+	for i := 0; i <= 4; i++ {
+		value := low + (high-low)*float64(i)/4
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`+"\n",
+			chartLeft, y(value), chartWidth-chartRight, y(value))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n",
+			chartLeft-5, y(value), html.EscapeString(formatValue(value)))
+	}
+
+	// Time axis with the start, middle and end times
+	axisY := chartTop + chartPlotHeight + 18
+	times := []time.Time{start, start.Add(end.Sub(start) / 2), end}
+	anchors := []string{"start", "middle", "end"}
+	if !end.After(start) {
+		times, anchors = times[:1], []string{"middle"}
+	}
+	for i, t := range times {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="%s">%s</text>`+"\n",
+			x(t), axisY, anchors[i], html.EscapeString(t.UTC().Format("01-02 15:04:05")))
+	}
+
+	for i, s := range drawn {
+		color := chartColors[i%len(chartColors)]
+		points := chartPoints(s.Points)
+		if len(points) == 1 {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`+"\n", x(points[0].Time), y(points[0].Value), color)
+		} else {
+			coordinates := make([]string, len(points))
+			for j, p := range points {
+				coordinates[j] = fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Value))
+			}
+			fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`+"\n",
+				color, strings.Join(coordinates, " "))
+		}
+
+		legendY := chartTop + chartPlotHeight + chartAxisHeight + chartLegendLine*i + chartLegendLine/2
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="12" height="4" fill="%s"/>`+"\n", chartLeft, legendY-2, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`+"\n",
+			chartLeft+18, legendY, html.EscapeString(labelsString(s.Labels)))
+	}
+
+	b.WriteString("</svg>")
+	return b.String()
+}
+
+// undrawnSeries returns the number of series left out of their chart
+func undrawnSeries(series []Series) int {
+	return max(len(series)-maxChartSeries, 0)
+}
+
+// chartBounds returns the time and value ranges of the series, with some
+// room around a constant value, and false when they have no points
+func chartBounds(series []Series) (start, end time.Time, low, high float64, ok bool) {
+	low, high = math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, p := range s.Points {
+			if !ok || p.Time.Before(start) {
+				start = p.Time
+			}
+			if !ok || p.Time.After(end) {
+				end = p.Time
+			}
+			low, high = math.Min(low, p.Value), math.Max(high, p.Value)
+			ok = true
+		}
+	}
+
+	if ok && low == high {
+		margin := math.Max(math.Abs(low)*0.1, 1)
+		low, high = low-margin, high+margin
+	}
+	return start, end, low, high, ok
+}
+
+// chartPoints reduces the points to at most maxChartPoints, keeping the min
+// and max of each bucket in time order
+func chartPoints(points []Point) []Point {
+	if len(points) <= maxChartPoints {
+		return points
+	}
+
+	buckets := maxChartPoints / 2
+	reduced := make([]Point, 0, maxChartPoints)
+	for i := 0; i < buckets; i++ {
+		bucket := points[i*len(points)/buckets : (i+1)*len(points)/buckets]
+		low, high := bucket[0], bucket[0]
+		for _, p := range bucket[1:] {
+			if p.Value < low.Value {
+				low = p
+			}
+			if p.Value > high.Value {
+				high = p
+			}
+		}
+		first, second := low, high
+		if high.Time.Before(low.Time) {
+			first, second = high, low
+		}
+		reduced = append(reduced, first)
+		if second != first {
+			reduced = append(reduced, second)
+		}
+	}
+	return reduced
+}
@@ -0,0 +1,56 @@
+package report
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("chart", func() {
+	start := time.Unix(1700000000, 0)
+	points := func(values ...float64) []Point {
+		result := make([]Point, len(values))
+		for i, value := range values {
+			result[i] = Point{Time: start.Add(time.Duration(i) * time.Minute), Value: value}
+		}
+		return result
+	}
+
+	It("should draw a line and a legend entry per series", func() {
+		svg := chart([]Series{
+			{Labels: map[string]string{"cpu": "0"}, Points: points(1, 2, 3)},
+			{Labels: map[string]string{"cpu": "<1>"}, Points: points(3)},
+		})
+		Expect(svg).To(HavePrefix("<svg "))
+		Expect(svg).To(HaveSuffix("</svg>"))
+		Expect(strings.Count(svg, "<polyline")).To(Equal(1))
+		Expect(strings.Count(svg, "<circle")).To(Equal(1))
+		Expect(svg).To(ContainSubstring(`{cpu=&#34;&lt;1&gt;&#34;}`))
+		Expect(svg).To(ContainSubstring("11-14 22:13:20"))
+		Expect(svg).NotTo(ContainSubstring("\n\n"))
+	})
+
+	It("should not draw series without points", func() {
+		Expect(chart([]Series{{Labels: map[string]string{}}})).To(BeEmpty())
+	})
+
+	It("should keep the spikes of long series", func() {
+		values := make([]float64, 10*maxChartPoints)
+		values[1234] = 100
+		values[4321] = -100
+		reduced := chartPoints(points(values...))
+		Expect(len(reduced)).To(BeNumerically("<=", maxChartPoints))
+
+		var low, high float64
+		for i, p := range reduced {
+			low, high = min(low, p.Value), max(high, p.Value)
+			if i > 0 {
+				Expect(p.Time.Before(reduced[i-1].Time)).To(BeFalse())
+			}
+		}
+		Expect(low).To(Equal(-100.0))
+		Expect(high).To(Equal(100.0))
+	})
+})
@@ -0,0 +1,183 @@
+// Package report renders the stored data of a cluster or collection run as
+// a self-contained HTML or Markdown test report, with inline SVG charts.
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	reporttemplates "github.com/redhat-best-practices-for-k8s/kpi-collection-tool/report-templates"
+)
+
+// Format is the document format of a report
+type Format string
+
+const (
+	FormatHTML     Format = "html"
+	FormatMarkdown Format = "markdown"
+)
+
+// ParseFormat converts a string to a Format, returning an error if invalid
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "html", "":
+		return FormatHTML, nil
+	case "markdown", "md":
+		return FormatMarkdown, nil
+	default:
+		return "", fmt.Errorf("invalid report format %q: must be html or markdown", s)
+	}
+}
+
+// Data holds the contents of a test report
+type Data struct {
+	Scope       string // e.g. "run 7 (cluster prod)"
+	GeneratedAt time.Time
+	ToolVersion string
+
+	Runs        []output.RunRecord
+	Definitions []output.DefinitionRecord
+	KPIs        []KPI
+	Evaluation  *output.EvaluationReport // nil without a KPIs file with thresholds
+	Errors      []ErrorSummary
+}
+
+// KPI holds the series of one KPI, sorted by labels
+type KPI struct {
+	Name   string
+	Series []Series
+}
+
+// Series holds the samples of one KPI and label set, in time order
+type Series struct {
+	Labels map[string]string
+	Stats  output.ComparisonStats
+	Points []Point
+}
+
+// Point is one sample of a series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// ErrorSummary counts the failed queries and retries of a KPI
+type ErrorSummary struct {
+	KPIID   string
+	Errors  int
+	Retries int
+}
+
+// Render writes the report in the format
+func Render(w io.Writer, format Format, data Data) error {
+	name := reporttemplates.HTML
+	if format == FormatMarkdown {
+		name = reporttemplates.Markdown
+	}
+	content, err := reporttemplates.FS.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read the report template: %w", err)
+	}
+
+	funcs := map[string]any{
+		"labels":   labelsString,
+		"value":    formatValue,
+		"time":     formatTime,
+		"duration": formatSeconds,
+		"short":    shortHash,
+		"upper":    strings.ToUpper,
+		"undrawn":  undrawnSeries,
+	}
+
+	if format == FormatMarkdown {
+		funcs["chart"] = chart
+		funcs["cell"] = markdownEscape
+		funcs["code"] = markdownCodeEscape
+		tmpl, err := texttemplate.New(name).Funcs(funcs).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("failed to parse the report template: %w", err)
+		}
+		return tmpl.Execute(w, data)
+	}
+
+	funcs["chart"] = func(series []Series) htmltemplate.HTML {
+		// chart escapes every text it draws
+		return htmltemplate.HTML(chart(series))
+	}
+	tmpl, err := htmltemplate.New(name).Funcs(funcs).Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse the report template: %w", err)
+	}
+	return tmpl.Execute(w, data)
+}
+
+// labelsString formats labels as a PromQL selector, e.g. {cpu="2"}
+func labelsString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", key, labels[key])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// formatValue formats a sample value or statistic with 6 significant digits
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', 6, 64)
+}
+
+// formatTime formats a time in UTC, "-" for the zero time or nil
+func formatTime(t any) string {
+	switch v := t.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return "-"
+		}
+		return v.UTC().Format("2006-01-02 15:04:05 UTC")
+	case *time.Time:
+		if v == nil {
+			return "-"
+		}
+		return formatTime(*v)
+	default:
+		return "-"
+	}
+}
+
+// formatSeconds formats a frequency or duration, "-" for single runs
+func formatSeconds(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return (time.Duration(seconds * float64(time.Second))).String()
+}
+
+// shortHash abbreviates a definition hash
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// markdownEscape escapes the characters that would break a Markdown table cell
+func markdownEscape(value string) string {
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`, "\n", " ").Replace(value)
+}
+
+// markdownCodeEscape escapes a Markdown table cell rendered as a code span
+func markdownCodeEscape(value string) string {
+	return strings.NewReplacer("`", "'", "|", `\|`, "\n", " ").Replace(value)
+}
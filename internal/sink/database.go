@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/prometheus/common/model"
+)
+
+// databaseSink stores the samples in a SQLite or PostgreSQL database, under
+// a collection run of its own with the KPI definitions, as the primary
+// database does. Query errors, alerts and overruns stay in the primary.
+type databaseSink struct {
+	dbImpl    database.Database
+	db        *sql.DB
+	clusterID int64
+	runID     int64
+}
+
+func (s *databaseSink) Name() string {
+	return database.DisplayName(s.dbImpl)
+}
+
+func (s *databaseSink) Start(run Run) error {
+	// lib/pq waits for an unreachable server as long as the OS does
+	if d, ok := s.dbImpl.(*database.PostgresDB); ok {
+		timed := *d
+		timed.ConnectionURL = withConnectTimeout(d.ConnectionURL)
+		s.dbImpl = &timed
+	}
+
+	db, err := s.dbImpl.InitDB()
+	if err != nil {
+		return fmt.Errorf("failed to init database: %w", err)
+	}
+
+	clusterID, err := s.dbImpl.GetOrCreateCluster(db, run.ClusterName, run.ClusterType)
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("failed to get cluster ID: %w", err)
+	}
+	runID, err := s.dbImpl.StartCollectionRun(db, clusterID, run.Run)
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("failed to record collection run: %w", err)
+	}
+	if err := s.dbImpl.RecordKPIDefinitions(db, runID, run.Definitions); err != nil {
+		_ = db.Close()
+		return fmt.Errorf("failed to record KPI definitions: %w", err)
+	}
+
+	s.db, s.clusterID, s.runID = db, clusterID, runID
+	return nil
+}
+
+func (s *databaseSink) Write(kpiID string, result model.Value) error {
+	return s.dbImpl.StoreQueryResults(s.db, s.clusterID, s.runID, kpiID, result)
+}
+
+func (s *databaseSink) Close(result database.CollectionRunResult) error {
+	err := s.dbImpl.FinishCollectionRun(s.db, s.runID, result)
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// connectTimeout bounds connecting to a PostgreSQL sink
+const connectTimeout = 10 * time.Second
+
+// withConnectTimeout adds a connect timeout to a PostgreSQL connection URL
+// that has none
+func withConnectTimeout(connectionURL string) string {
+	parsed, err := url.Parse(connectionURL)
+	if err != nil || (parsed.Scheme != "postgres" && parsed.Scheme != "postgresql") {
+		return connectionURL
+	}
+	query := parsed.Query()
+	if query.Has("connect_timeout") {
+		return connectionURL
+	}
+	query.Set("connect_timeout", strconv.Itoa(int(connectTimeout.Seconds())))
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
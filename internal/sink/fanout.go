@@ -0,0 +1,372 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/remotewrite"
+
+	"github.com/prometheus/common/model"
+)
+
+// Status of a sink at the end of a collection run
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+// Stats counts the samples written to and lost by a sink. It is safe for
+// concurrent use.
+type Stats struct {
+	written atomic.Int64
+	failed  atomic.Int64
+
+	mu          sync.Mutex
+	failing     bool // whether the last write failed
+	degraded    bool // whether the sink failed to close
+	unavailable bool // whether the sink failed to start
+	lastErr     string
+}
+
+// Count records the outcome of writing samples. Samples buffered by the
+// sink to be retried count as written.
+func (s *Stats) Count(samples int64, err error) {
+	s.count(samples, err)
+}
+
+// count records the outcome of writing samples and reports whether the sink
+// started failing or recovered with it
+func (s *Stats) count(samples int64, err error) (changed bool) {
+	if err != nil && !errors.Is(err, remotewrite.ErrBuffered) {
+		s.failed.Add(samples)
+	} else {
+		s.written.Add(samples)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastErr = err.Error()
+	}
+	changed = s.failing != (err != nil)
+	s.failing = err != nil
+	return changed
+}
+
+// Summary returns the status of the sink for the shutdown summary
+func (s *Stats) Summary(name string) output.SinkSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := StatusOK
+	switch {
+	case s.unavailable:
+		status = StatusUnavailable
+	case s.degraded || s.failed.Load() > 0:
+		status = StatusDegraded
+	}
+	return output.SinkSummary{
+		Sink:      name,
+		Status:    status,
+		Written:   s.written.Load(),
+		Failed:    s.failed.Load(),
+		LastError: s.lastErr,
+	}
+}
+
+// setError records an error starting or closing the sink
+func (s *Stats) setError(err error, unavailable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err.Error()
+	if unavailable {
+		s.unavailable = true
+	} else {
+		s.degraded = true
+	}
+}
+
+// setStarted records that a sink which failed to start has started
+func (s *Stats) setStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = false
+}
+
+const (
+	// queueSize is the number of query results queued for each sink. Results
+	// written while the queue of a sink is full are counted as failed.
+	queueSize = 1000
+
+	// defaultRestartInterval is how long a sink that failed to start waits
+	// before it is started again
+	defaultRestartInterval = 30 * time.Second
+
+	// defaultCloseTimeout bounds how long Close waits for the sinks to write
+	// their queued results and close
+	defaultCloseTimeout = 30 * time.Second
+)
+
+// errQueueFull is counted for the results a sink is too slow to take
+var errQueueFull = errors.New("write queue full, sink too slow: samples dropped")
+
+// queuedWrite is a query result waiting to be written to a sink
+type queuedWrite struct {
+	kpiID   string
+	result  model.Value
+	samples int64
+}
+
+// trackedSink is a sink of a Fanout with its stats and the queue of results
+// its goroutine writes
+type trackedSink struct {
+	Sink
+	stats Stats
+
+	queue     chan queuedWrite
+	pending   atomic.Int64  // samples queued, not written yet
+	abandoned atomic.Bool   // whether Close gave up on the sink
+	done      chan struct{} // closed once the goroutine has closed the sink
+}
+
+// Fanout writes the samples of a collection run to several sinks. Each sink
+// is written by a goroutine of its own, from a bounded queue, so a slow or
+// failing sink neither holds up the query workers nor the other sinks: its
+// failures, and the results it could not keep up with, are counted and
+// reported for it alone. A sink that cannot be started is started again
+// every 30s; the samples of the meantime are counted as failed.
+type Fanout struct {
+	sinks []*trackedSink
+
+	restartInterval time.Duration
+	closeTimeout    time.Duration
+
+	// mu guards the queues against being closed during a write
+	mu      sync.RWMutex
+	running bool
+	closed  bool
+	result  database.CollectionRunResult // the end of the run, set by Close
+}
+
+// NewFanout returns the fan-out to the sinks of --sink arguments. The sinks
+// must differ from each other and from the primary database.
+func NewFanout(specs []string, primary database.Database, insertBatchSize int) (*Fanout, error) {
+	f := &Fanout{restartInterval: defaultRestartInterval, closeTimeout: defaultCloseTimeout}
+	var databases []database.Database
+	paths := make(map[string]bool)
+	remoteWrites := 0
+
+	for _, spec := range specs {
+		s, err := Parse(spec, insertBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		switch s := s.(type) {
+		case *databaseSink:
+			if database.SameDatabase(s.dbImpl, primary) {
+				return nil, fmt.Errorf("sink '%s' is the primary database (--db-type)", spec)
+			}
+			for _, other := range databases {
+				if database.SameDatabase(s.dbImpl, other) {
+					return nil, fmt.Errorf("sink '%s' is given twice", spec)
+				}
+			}
+			databases = append(databases, s.dbImpl)
+		case *jsonlSink:
+			path, err := filepath.Abs(s.path)
+			if err != nil {
+				return nil, err
+			}
+			if paths[path] {
+				return nil, fmt.Errorf("sink '%s' is given twice", spec)
+			}
+			paths[path] = true
+		case *remoteWriteSink:
+			// The requests of every endpoint would share the buffer directory
+			if remoteWrites++; remoteWrites > 1 {
+				return nil, fmt.Errorf("only one remote-write sink is supported")
+			}
+		}
+		f.sinks = append(f.sinks, &trackedSink{Sink: s})
+	}
+	return f, nil
+}
+
+// Start starts the goroutine of every sink, which starts the sink and then
+// writes the results queued for it
+func (f *Fanout) Start(run Run) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.running || f.closed {
+		return
+	}
+	f.running = true
+
+	for _, s := range f.sinks {
+		s.queue = make(chan queuedWrite, queueSize)
+		s.done = make(chan struct{})
+		go f.serve(s, run)
+	}
+}
+
+// serve starts a sink, retrying every restartInterval, writes the results
+// queued for it until the queue is closed, and closes the sink
+func (f *Fanout) serve(s *trackedSink, run Run) {
+	defer close(s.done)
+
+	for failures := 0; ; {
+		err := s.Start(run)
+		if err == nil {
+			if failures > 0 {
+				s.stats.setStarted()
+				fmt.Fprintf(os.Stderr, "Sink %s started\n", s.Name())
+			}
+			log.Printf("Writing samples to sink %s", s.Name())
+			break
+		}
+
+		s.stats.setError(err, true)
+		if failures++; failures == 1 {
+			fmt.Fprintf(os.Stderr, "Warning: sink %s is unavailable, retrying every %s: %v\n", s.Name(), f.restartInterval, err)
+		}
+		log.Printf("Sink %s unavailable (attempt %d): %v", s.Name(), failures, err)
+		if !dropUntil(s, time.After(f.restartInterval)) {
+			return
+		}
+	}
+
+	for w := range s.queue {
+		err := s.Write(w.kpiID, w.result)
+		if s.abandoned.Load() {
+			// Close counted the samples left as failed
+			return
+		}
+		f.report(s, w.kpiID, w.samples, err)
+		s.pending.Add(-w.samples)
+	}
+
+	if err := s.Sink.Close(f.result); err != nil {
+		s.stats.setError(err, false)
+		fmt.Fprintf(os.Stderr, "Warning: failed to close sink %s: %v\n", s.Name(), err)
+		log.Printf("Failed to close sink %s: %v", s.Name(), err)
+	}
+}
+
+// dropUntil counts the results queued for a sink that is not started as
+// failed until restart fires, and reports false when the queue is closed
+// first
+func dropUntil(s *trackedSink, restart <-chan time.Time) bool {
+	for {
+		select {
+		case w, ok := <-s.queue:
+			if !ok {
+				return false
+			}
+			s.pending.Add(-w.samples)
+			s.stats.failed.Add(w.samples)
+		case <-restart:
+			return true
+		}
+	}
+}
+
+// Write queues the samples of a query result of a KPI for every sink,
+// without waiting for them to be written
+func (f *Fanout) Write(kpiID string, result model.Value) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.running || f.closed {
+		return
+	}
+
+	samples := SampleCount(result)
+	for _, s := range f.sinks {
+		select {
+		case s.queue <- queuedWrite{kpiID: kpiID, result: result, samples: samples}:
+			s.pending.Add(samples)
+		default:
+			f.report(s, kpiID, samples, errQueueFull)
+		}
+	}
+}
+
+// report counts the outcome of writing samples to a sink. A sink that
+// starts failing, or recovers, is reported once; every failure is logged.
+func (f *Fanout) report(s *trackedSink, kpiID string, samples int64, err error) {
+	changed := s.stats.count(samples, err)
+	if err != nil {
+		log.Printf("[%s] Failed to write %d sample(s) to sink %s: %v", kpiID, samples, s.Name(), err)
+	}
+	switch {
+	case changed && err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: sink %s is failing: %v\n", s.Name(), err)
+	case changed:
+		fmt.Fprintf(os.Stderr, "Sink %s recovered\n", s.Name())
+		log.Printf("Sink %s recovered", s.Name())
+	}
+}
+
+// Close waits for every sink to write the results queued for it, then
+// records the end of the run in, and closes, the sinks. Sinks still busy
+// after the close timeout are given up on, their queued samples counted as
+// failed. It does nothing when called again.
+func (f *Fanout) Close(result database.CollectionRunResult) {
+	f.mu.Lock()
+	if !f.running || f.closed {
+		f.closed = true
+		f.mu.Unlock()
+		return
+	}
+	f.closed = true
+	f.result = result
+	for _, s := range f.sinks {
+		close(s.queue)
+	}
+	f.mu.Unlock()
+
+	deadline := time.NewTimer(f.closeTimeout)
+	defer deadline.Stop()
+	expired := false
+	for _, s := range f.sinks {
+		if !expired {
+			select {
+			case <-s.done:
+				continue
+			case <-deadline.C:
+				expired = true
+			}
+		}
+
+		// Once the timeout expired, every sink still busy is given up on
+		select {
+		case <-s.done:
+		default:
+			s.abandoned.Store(true)
+			pending := s.pending.Swap(0)
+			s.stats.failed.Add(pending)
+			err := fmt.Errorf("gave up after %s with %d sample(s) not written", f.closeTimeout, pending)
+			s.stats.setError(err, false)
+			fmt.Fprintf(os.Stderr, "Warning: sink %s did not finish: %v\n", s.Name(), err)
+			log.Printf("Sink %s did not finish: %v", s.Name(), err)
+		}
+	}
+}
+
+// Summary returns the status of every sink for the shutdown summary
+func (f *Fanout) Summary() []output.SinkSummary {
+	summaries := make([]output.SinkSummary, len(f.sinks))
+	for i, s := range f.sinks {
+		summaries[i] = s.stats.Summary(s.Name())
+	}
+	return summaries
+}
@@ -0,0 +1,223 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/output"
+)
+
+// failingSink is a sink whose first starts and writes fail with the given
+// errors, and whose writes wait for release when it is set
+type failingSink struct {
+	mu        sync.Mutex
+	startErrs []error
+	writeErrs []error
+	release   chan struct{}
+}
+
+func (s *failingSink) Name() string    { return "failing" }
+func (s *failingSink) Start(Run) error { return s.next(&s.startErrs) }
+func (s *failingSink) Write(string, model.Value) error {
+	if s.release != nil {
+		<-s.release
+	}
+	return s.next(&s.writeErrs)
+}
+func (s *failingSink) Close(database.CollectionRunResult) error { return nil }
+
+// next pops the next error of errs, nil once there is none
+func (s *failingSink) next(errs *[]error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+var _ = Describe("Parse", func() {
+	DescribeTable("parsing --sink arguments",
+		func(spec string, expected Sink, expectedErr string) {
+			s, err := Parse(spec, 100)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal(expected))
+		},
+		Entry("SQLite", "sqlite:/data/kpi.db",
+			&databaseSink{dbImpl: &database.SQLiteDB{Path: "/data/kpi.db", InsertBatchSize: 100}}, ""),
+		Entry("PostgreSQL", "postgres:postgresql://u:p@db/kpi",
+			&databaseSink{dbImpl: &database.PostgresDB{ConnectionURL: "postgresql://u:p@db/kpi", InsertBatchSize: 100}}, ""),
+		Entry("JSONL", "jsonl:/data/samples.jsonl", &jsonlSink{path: "/data/samples.jsonl"}, ""),
+		Entry("remote write", "remote-write:https://u:p@metrics.example.com/api/v1/write",
+			&remoteWriteSink{url: "https://u:p@metrics.example.com/api/v1/write", host: "metrics.example.com"}, ""),
+		Entry("remote write without scheme", "remote-write:metrics.example.com", nil, "requires an http:// or https:// URL"),
+		Entry("unknown scheme", "kafka:broker:9092", nil, "must be sqlite:<path>, postgres:<url>, jsonl:<path> or remote-write:<url>"),
+	)
+})
+
+var _ = Describe("Fanout", func() {
+	var tmpDir string
+
+	run := Run{
+		ClusterName: "lab",
+		ClusterType: "core",
+		Run:         database.CollectionRun{ToolVersion: "test"},
+		Definitions: []database.KPIDefinition{{KPIID: "cpu", PromQuery: "up", ResolvedPromQuery: "up", QueryType: "instant"}},
+	}
+	vector := model.Vector{
+		{Metric: model.Metric{"node": "a"}, Value: 0.5, Timestamp: model.TimeFromUnix(1700000000)},
+		{Metric: model.Metric{"node": "b"}, Value: 0.7, Timestamp: model.TimeFromUnix(1700000000)},
+	}
+
+	BeforeEach(func() {
+		tmpDir = GinkgoT().TempDir()
+	})
+
+	It("should reject sinks given twice or equal to the primary database", func() {
+		primary := &database.SQLiteDB{Path: filepath.Join(tmpDir, "kpi.db")}
+
+		_, err := NewFanout([]string{"sqlite:" + primary.Path}, primary, 0)
+		Expect(err).To(MatchError(ContainSubstring("is the primary database")))
+
+		_, err = NewFanout([]string{"jsonl:a.jsonl", "jsonl:./a.jsonl"}, primary, 0)
+		Expect(err).To(MatchError(ContainSubstring("given twice")))
+
+		_, err = NewFanout([]string{"remote-write:http://a/write", "remote-write:http://b/write"}, primary, 0)
+		Expect(err).To(MatchError("only one remote-write sink is supported"))
+	})
+
+	It("should write every sink independently of the others", func() {
+		backup := &database.SQLiteDB{Path: filepath.Join(tmpDir, "backup.db")}
+		jsonlPath := filepath.Join(tmpDir, "out", "samples.jsonl")
+		f, err := NewFanout([]string{"sqlite:" + backup.Path, "jsonl:" + jsonlPath}, database.NewSQLiteDB(), 0)
+		Expect(err).NotTo(HaveOccurred())
+		f.sinks = append(f.sinks,
+			&trackedSink{Sink: &failingSink{writeErrs: []error{errors.New("connection refused")}}},
+			&trackedSink{Sink: &failingSink{startErrs: []error{errors.New("no route to host")}}})
+
+		f.Start(run)
+		f.Write("cpu", vector)
+		f.Write("cpu", vector[:1])
+		f.Close(database.CollectionRunResult{ShutdownReason: "Duration completed"})
+		f.Close(database.CollectionRunResult{ShutdownReason: "Collection session closed"})
+
+		Expect(f.Summary()).To(Equal([]output.SinkSummary{
+			{Sink: "sqlite (" + backup.Path + ")", Status: StatusOK, Written: 3},
+			{Sink: "jsonl (" + jsonlPath + ")", Status: StatusOK, Written: 3},
+			{Sink: "failing", Status: StatusDegraded, Written: 1, Failed: 2, LastError: "connection refused"},
+			{Sink: "failing", Status: StatusUnavailable, Failed: 3, LastError: "no route to host"},
+		}))
+
+		db, err := backup.InitDB()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(db.Close)
+		var samples, definitions int
+		var reason string
+		Expect(db.QueryRow("SELECT COUNT(*) FROM query_results").Scan(&samples)).To(Succeed())
+		Expect(db.QueryRow("SELECT COUNT(*) FROM kpi_definitions").Scan(&definitions)).To(Succeed())
+		Expect(db.QueryRow("SELECT shutdown_reason FROM collection_runs").Scan(&reason)).To(Succeed())
+		Expect(samples).To(Equal(2)) // the repeated sample is deduplicated
+		Expect(definitions).To(Equal(1))
+		Expect(reason).To(Equal("Duration completed"))
+
+		file, err := os.Open(jsonlPath)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(file.Close)
+		var lines []database.FileSample
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var line database.FileSample
+			Expect(json.Unmarshal(scanner.Bytes(), &line)).To(Succeed())
+			lines = append(lines, line)
+		}
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0].ExecutionTime).NotTo(BeZero())
+		lines[0].ExecutionTime = time.Time{}
+		Expect(lines[0]).To(Equal(database.FileSample{
+			KPIID:     "cpu",
+			Cluster:   "lab",
+			Labels:    map[string]string{"node": "a"},
+			Value:     0.5,
+			Timestamp: model.TimeFromUnix(1700000000).Time().UTC(),
+		}))
+	})
+
+	It("should not wait for a slow sink, dropping the results it cannot keep up with", func() {
+		slow := &failingSink{release: make(chan struct{})}
+		f := &Fanout{sinks: []*trackedSink{{Sink: slow}}, restartInterval: time.Hour, closeTimeout: time.Minute}
+		f.Start(run)
+
+		// The sink takes the first result, then queueSize more wait for it
+		for i := 0; i < queueSize+3; i++ {
+			f.Write("cpu", vector)
+		}
+		Eventually(func() int64 { return f.Summary()[0].Failed }).Should(BeNumerically(">=", 4))
+
+		close(slow.release)
+		f.Close(database.CollectionRunResult{})
+		summary := f.Summary()[0]
+		Expect(summary.Written + summary.Failed).To(Equal(int64(2 * (queueSize + 3))))
+		Expect(summary.Status).To(Equal(StatusDegraded))
+		Expect(summary.LastError).To(Equal(errQueueFull.Error()))
+	})
+
+	It("should start a sink that failed to start again", func() {
+		late := &failingSink{startErrs: []error{errors.New("connection refused")}}
+		f := &Fanout{sinks: []*trackedSink{{Sink: late}}, restartInterval: 10 * time.Millisecond, closeTimeout: time.Minute}
+		f.Start(run)
+
+		Eventually(func() int64 {
+			f.Write("cpu", vector[:1])
+			return f.Summary()[0].Written
+		}).Should(BeNumerically(">", 0))
+		f.Close(database.CollectionRunResult{})
+
+		summary := f.Summary()[0]
+		Expect(summary.Status).To(Equal(StatusDegraded))
+		Expect(summary.Failed).To(BeNumerically(">", 0))
+		Expect(summary.LastError).To(Equal("connection refused"))
+	})
+
+	It("should give up on a sink still busy after the close timeout", func() {
+		stuck := &failingSink{release: make(chan struct{})}
+		DeferCleanup(func() { close(stuck.release) })
+		f := &Fanout{sinks: []*trackedSink{{Sink: stuck}}, restartInterval: time.Hour, closeTimeout: 50 * time.Millisecond}
+		f.Start(run)
+		f.Write("cpu", vector)
+		f.Write("cpu", vector)
+
+		f.Close(database.CollectionRunResult{})
+		summary := f.Summary()[0]
+		Expect(summary.Status).To(Equal(StatusDegraded))
+		Expect(summary.Failed).To(Equal(int64(4)))
+		Expect(summary.LastError).To(Equal("gave up after 50ms with 4 sample(s) not written"))
+	})
+})
+
+var _ = Describe("withConnectTimeout", func() {
+	DescribeTable("adding a connect timeout to PostgreSQL URLs",
+		func(connectionURL, expected string) {
+			Expect(withConnectTimeout(connectionURL)).To(Equal(expected))
+		},
+		Entry("without one", "postgresql://user:pass@db:5432/kpi?sslmode=disable",
+			"postgresql://user:pass@db:5432/kpi?connect_timeout=10&sslmode=disable"),
+		Entry("with one", "postgres://db/kpi?connect_timeout=3", "postgres://db/kpi?connect_timeout=3"),
+		Entry("in a key/value string", "host=db dbname=kpi", "host=db dbname=kpi"),
+	)
+})
@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+
+	"github.com/prometheus/common/model"
+)
+
+// jsonlSink appends the samples to a file, one JSON object per line as in
+// the files of a file database, for tools that cannot read the databases
+type jsonlSink struct {
+	path    string
+	cluster string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (s *jsonlSink) Name() string {
+	return fmt.Sprintf("jsonl (%s)", s.path)
+}
+
+func (s *jsonlSink) Start(run Run) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file, s.cluster = file, run.ClusterName
+	return nil
+}
+
+// Write appends the samples of a result in one write, so that the lines of
+// concurrent results do not interleave
+func (s *jsonlSink) Write(kpiID string, result model.Value) error {
+	var lines []byte
+	executionTime := time.Now().UTC()
+	appendSample := func(metric model.Metric, value model.SampleValue, timestamp model.Time) error {
+		labels := make(map[string]string, len(metric))
+		for name, value := range metric {
+			labels[string(name)] = string(value)
+		}
+		line, err := json.Marshal(database.FileSample{
+			KPIID:         kpiID,
+			Cluster:       s.cluster,
+			Labels:        labels,
+			Value:         float64(value),
+			Timestamp:     timestamp.Time().UTC(),
+			ExecutionTime: executionTime,
+		})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+		return nil
+	}
+
+	switch v := result.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			if err := appendSample(sample.Metric, sample.Value, sample.Timestamp); err != nil {
+				return err
+			}
+		}
+	case model.Matrix:
+		for _, stream := range v {
+			for _, pair := range stream.Values {
+				if err := appendSample(stream.Metric, pair.Value, pair.Timestamp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.file.Write(lines)
+	return err
+}
+
+func (s *jsonlSink) Close(database.CollectionRunResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
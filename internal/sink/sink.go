@@ -0,0 +1,123 @@
+// Package sink writes the samples of a collection run to additional storage
+// besides the primary database: other SQLite or PostgreSQL databases, a
+// JSONL file or a Prometheus remote-write endpoint. Every sink is written
+// independently, so an outage of one does not affect the others.
+package sink
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/database"
+	"github.com/redhat-best-practices-for-k8s/kpi-collection-tool/internal/remotewrite"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultJSONLFile is the file of a jsonl: sink without a path, in the
+// artifacts directory
+const DefaultJSONLFile = "samples.jsonl"
+
+// Sink receives the samples stored during a collection run. Write is called
+// concurrently by the query workers.
+type Sink interface {
+	// Name identifies the sink in messages, without credentials
+	Name() string
+	// Start prepares the sink before the first sample of the run
+	Start(run Run) error
+	// Write stores the samples of a query result of a KPI
+	Write(kpiID string, result model.Value) error
+	// Close records the end of the run and releases the sink
+	Close(result database.CollectionRunResult) error
+}
+
+// Run describes the collection run written to the sinks
+type Run struct {
+	ClusterName string
+	ClusterType string
+	Run         database.CollectionRun
+	Definitions []database.KPIDefinition
+}
+
+// Parse returns the sink of a --sink argument:
+//
+//	sqlite:<path>         a SQLite database file or artifacts directory
+//	postgres:<url>        a PostgreSQL database
+//	jsonl:<path>          a JSONL file, one sample per line
+//	remote-write:<url>    a Prometheus remote-write endpoint
+func Parse(spec string, insertBatchSize int) (Sink, error) {
+	switch {
+	case strings.HasPrefix(spec, "jsonl:"):
+		path := strings.TrimPrefix(spec, "jsonl:")
+		if path == "" {
+			path = filepath.Join(database.OutputDir, DefaultJSONLFile)
+		}
+		return &jsonlSink{path: path}, nil
+	case strings.HasPrefix(spec, "remote-write:"):
+		endpoint := strings.TrimPrefix(spec, "remote-write:")
+		parsed, err := url.Parse(endpoint)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid sink '%s': remote-write: requires an http:// or https:// URL", spec)
+		}
+		return &remoteWriteSink{url: endpoint, host: parsed.Host}, nil
+	}
+
+	dbImpl, err := database.ParseSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink '%s': must be sqlite:<path>, postgres:<url>, jsonl:<path> or remote-write:<url>", spec)
+	}
+	switch d := dbImpl.(type) {
+	case *database.SQLiteDB:
+		d.InsertBatchSize = insertBatchSize
+	case *database.PostgresDB:
+		d.InsertBatchSize = insertBatchSize
+	}
+	return &databaseSink{dbImpl: dbImpl}, nil
+}
+
+// SampleCount returns the number of samples of a query result
+func SampleCount(result model.Value) int64 {
+	switch v := result.(type) {
+	case model.Vector:
+		return int64(len(v))
+	case model.Matrix:
+		var count int64
+		for _, stream := range v {
+			count += int64(len(stream.Values))
+		}
+		return count
+	}
+	return 0
+}
+
+// remoteWriteSink sends the samples to a Prometheus remote-write endpoint,
+// buffering them in the artifacts directory while it is unreachable
+type remoteWriteSink struct {
+	url    string
+	host   string
+	writer *remotewrite.Writer
+}
+
+func (s *remoteWriteSink) Name() string {
+	return fmt.Sprintf("remote-write (%s)", s.host)
+}
+
+func (s *remoteWriteSink) Start(run Run) error {
+	writer, err := remotewrite.NewWriter(s.url, run.ClusterName,
+		filepath.Join(database.OutputDir, remotewrite.BufferDirName))
+	if err != nil {
+		return err
+	}
+	s.writer = writer
+	return nil
+}
+
+func (s *remoteWriteSink) Write(kpiID string, result model.Value) error {
+	return s.writer.Write(kpiID, result)
+}
+
+func (s *remoteWriteSink) Close(database.CollectionRunResult) error {
+	return s.writer.Close()
+}
@@ -0,0 +1,12 @@
+package reporttemplates
+
+import "embed"
+
+//go:embed report.html.tmpl report.md.tmpl
+var FS embed.FS
+
+// File names for easy reference
+const (
+	HTML     = "report.html.tmpl"
+	Markdown = "report.md.tmpl"
+)